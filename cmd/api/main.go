@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"github.com/joho/godotenv"
 	"log"
 	"net/http"
@@ -8,6 +9,7 @@ import (
 	"payment-gateway/db"
 	"payment-gateway/internal/api"
 	"payment-gateway/internal/kafka"
+	"payment-gateway/internal/util"
 )
 
 func init() {
@@ -36,20 +38,28 @@ func main() {
 
 	dbURL := "postgres://" + dbUser + ":" + dbPassword + "@" + dbHost + ":" + dbPort + "/" + dbName + "?sslmode=disable"
 
-	dbInst, err := db.New(dbURL)
+	// deadlines of the DB queries, gateway requests and Kafka publishes
+	timeouts := util.LoadTimeouts()
+
+	dbInst, err := db.New(dbURL, timeouts.DB)
 	if err != nil {
 		panic(err)
 	}
 
 	// Set up api endpoints
-	router := api.New(dbInst)
+	router := api.New(dbInst, timeouts)
 
 	// init kafka
-	kafkaInst := kafka.NewKafkaProducer(kafkaURL)
+	kafkaInst := kafka.NewKafkaProducer(kafkaURL, timeouts.Publish)
 
 	router.SetupServices(kafkaInst)
 	router.SetupRoutes()
 
+	// start background jobs (gateway health checks)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	router.RunBackgroundJobs(ctx)
+
 	// Start the server on port 8080
 	log.Println("Starting server on port 8090...")
 	if err = http.ListenAndServe(":8090", router.Router); err != nil {
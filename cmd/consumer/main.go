@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"payment-gateway/db"
+	"payment-gateway/internal/kafka"
+	"payment-gateway/internal/services/events"
+	"payment-gateway/internal/util"
+	"syscall"
+
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	err := godotenv.Load()
+	if err != nil {
+		log.Fatal("failed to load .env file")
+	}
+
+	// Read database configuration from environment variables
+	dbUser := os.Getenv("DB_USER")
+	dbPassword := os.Getenv("DB_PASSWORD")
+	dbName := os.Getenv("DB_NAME")
+	dbHost := os.Getenv("DB_HOST")
+	dbPort := os.Getenv("DB_PORT")
+	kafkaURL := os.Getenv("KAFKA_BROKER_URL")
+
+	// Validate required environment variables
+	if dbUser == "" || dbPassword == "" || dbName == "" || dbHost == "" || dbPort == "" {
+		log.Fatal("Database environment variables are not set properly in the .env file")
+	}
+	if kafkaURL == "" {
+		log.Fatal("KAFKA_BROKER_URL is not set in the .env file")
+	}
+
+	dbURL := "postgres://" + dbUser + ":" + dbPassword + "@" + dbHost + ":" + dbPort + "/" + dbName + "?sslmode=disable"
+
+	dbInst, err := db.New(dbURL, util.LoadTimeouts().DB)
+	if err != nil {
+		panic(err)
+	}
+
+	// stop consuming on SIGINT/SIGTERM, the message in flight is finished and committed before leaving the group
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	cfg := kafka.LoadConsumerConfig(kafkaURL)
+	consumer := kafka.NewKafkaConsumer(cfg, events.NewSvcEvents(dbInst).HandleTxEvent)
+
+	log.Printf("Starting consumer %s on topics %v...", cfg.GroupID, cfg.Topics)
+	if err = consumer.Run(ctx); err != nil {
+		log.Fatalf("consumer stopped: %v", err)
+	}
+	log.Println("Consumer stopped")
+}
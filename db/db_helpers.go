@@ -1,44 +1,127 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"payment-gateway/internal/models/common"
 	"payment-gateway/internal/models/postgres"
+	"payment-gateway/internal/retry"
 	"payment-gateway/internal/util"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
+var (
+	// ErrNotFound is wrapped by the errors returned when a looked up row does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrStatusConflict is wrapped by the errors returned when a conditional status update finds another status
+	ErrStatusConflict = errors.New("status conflict")
+	// ErrInsufficientFunds is wrapped by the errors returned when a posting requiring funds would overdraw its account
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	// ErrRefundExceedsAmount is wrapped by the errors returned when a refund would take the refunds of a tx over its
+	// amount
+	ErrRefundExceedsAmount = errors.New("refund exceeds the refundable amount")
+	// ErrDisputeExceedsAmount is wrapped by the errors returned when a dispute would take the refunds and disputes of
+	// a tx over its amount
+	ErrDisputeExceedsAmount = errors.New("dispute exceeds the disputable amount")
+)
+
+// connectPolicy retries the first connection for about a minute, e.g. while Postgres is still starting
+var connectPolicy = retry.Policy{
+	MaxAttempts:     8,
+	InitialInterval: time.Second,
+	MaxInterval:     15 * time.Second,
+	Multiplier:      2,
+	Jitter:          0.2,
+	MaxElapsedTime:  time.Minute,
+	OnRetry: func(attempt int, err error, delay time.Duration) {
+		log.Printf("database connection attempt %d failed, retrying in %s: %v", attempt, delay.Round(time.Millisecond), err)
+	},
+}
+
 type (
 	DB struct {
 		db *sql.DB
+		// timeout bounds every query, on top of the deadline of the caller's ctx
+		timeout time.Duration
 	}
 
 	Idb interface {
-		GetSupportedGatewaysByCountry(countryID int) ([]*common.Gateway, error)
-		CreateTransaction(tx *postgres.Transaction) error
-		UpdateTxStatus(txID int64, status string) error
+		GetSupportedGatewaysByCountry(ctx context.Context, countryID int) ([]*common.Gateway, error)
+		GetGatewayByID(ctx context.Context, gatewayID int) (*common.Gateway, error)
+		GetGateways(ctx context.Context) ([]*common.Gateway, error)
+		CreateTransaction(ctx context.Context, tx *postgres.Transaction, hold func(tx *postgres.Transaction) *postgres.JournalEntry) error
+		UpdateTxStatus(ctx context.Context, change *postgres.TransactionStatusChange, allowedFrom []string, event *postgres.OutboxEvent, entry *postgres.JournalEntry) error
+		UpdateTxGateway(ctx context.Context, tx *postgres.Transaction) error
+		CreateTxAttempt(ctx context.Context, attempt *postgres.TransactionAttempt) error
+		GetRoutingRules(ctx context.Context) ([]*common.RoutingRule, error)
+		CreateRoutingRule(ctx context.Context, rule *common.RoutingRule) error
+		GetUserSegment(ctx context.Context, userID int) (string, error)
+		GetGatewayFees(ctx context.Context, gatewayIDs []int) ([]*common.FeeSchedule, error)
+		GetCountryCurrencies(ctx context.Context, countryID int) ([]string, error)
+		ClaimIdempotencyKey(ctx context.Context, userID int, key, requestHash string, lockTimeout, ttl time.Duration) (bool, error)
+		GetIdempotencyKey(ctx context.Context, userID int, key string) (*postgres.IdempotencyKey, error)
+		CompleteIdempotencyKey(ctx context.Context, key *postgres.IdempotencyKey) error
+		DeleteIdempotencyKey(ctx context.Context, userID int, key string) error
+		GetPendingOutboxEvents(ctx context.Context, limit int) ([]*postgres.OutboxEvent, error)
+		MarkOutboxEventPublished(ctx context.Context, id int64) error
+		MarkOutboxEventFailed(ctx context.Context, id int64, reason string) error
+		WithAdvisoryLock(ctx context.Context, key int64, fn func() error) (bool, error)
+		RecordConsumedEvent(ctx context.Context, event *postgres.ConsumedEvent) (bool, error)
+		GetTransaction(ctx context.Context, id int64) (*postgres.Transaction, error)
+		ListTransactions(ctx context.Context, filter common.TransactionFilter) ([]*postgres.Transaction, error)
+		ClaimTransaction(ctx context.Context, id int64) (bool, error)
+		GetUnsentTransactions(ctx context.Context, createdBefore time.Time, afterID int64, limit int) ([]*postgres.Transaction, error)
+		ClaimInboxCallback(ctx context.Context, cb *postgres.InboxCallback, lockTimeout time.Duration) (bool, error)
+		ReclaimInboxCallback(ctx context.Context, id int64, lockTimeout time.Duration) (*postgres.InboxCallback, error)
+		CompleteInboxCallback(ctx context.Context, cb *postgres.InboxCallback) error
+		ListInboxCallbacks(ctx context.Context, filter common.CallbackFilter) ([]*postgres.InboxCallback, error)
+		CreateWebhookEndpoint(ctx context.Context, endpoint *common.WebhookEndpoint) error
+		GetWebhookEndpoints(ctx context.Context) ([]*common.WebhookEndpoint, error)
+		SetWebhookEndpointEnabled(ctx context.Context, id int, enabled bool, reason string) (*common.WebhookEndpoint, error)
+		ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*postgres.WebhookDelivery, error)
+		CompleteWebhookAttempt(ctx context.Context, delivery *postgres.WebhookDelivery, attempt *postgres.WebhookAttempt, disableAfter int) (bool, error)
+		GetWebhookDelivery(ctx context.Context, id int64) (*postgres.WebhookDelivery, error)
+		GetWebhookAttempts(ctx context.Context, deliveryID int64) ([]*postgres.WebhookAttempt, error)
+		ListWebhookDeliveries(ctx context.Context, filter common.WebhookDeliveryFilter) ([]*postgres.WebhookDelivery, error)
+		RedeliverWebhook(ctx context.Context, id int64) (*postgres.WebhookDelivery, error)
+		GetLedgerAccounts(ctx context.Context, ownerID int64, accountTypes ...string) ([]*postgres.LedgerAccount, error)
+		CheckLedger(ctx context.Context) (*common.LedgerCheck, error)
+		CreateRefund(ctx context.Context, refund *postgres.Refund, hold func(refund *postgres.Refund) *postgres.JournalEntry) error
+		UpdateRefundStatus(ctx context.Context, change *postgres.RefundStatusChange, allowedFrom []string, event *postgres.OutboxEvent, entry *postgres.JournalEntry) error
+		GetRefund(ctx context.Context, id int64) (*postgres.Refund, error)
+		GetRefunds(ctx context.Context, txID int64) ([]*postgres.Refund, error)
+		GetPendingRefunds(ctx context.Context, createdBefore time.Time, limit int) ([]*postgres.Refund, error)
+		CreateDispute(ctx context.Context, dispute *postgres.Dispute, event func(dispute *postgres.Dispute) (*postgres.OutboxEvent, error)) (bool, error)
+		UpdateDisputeStatus(ctx context.Context, change *postgres.DisputeStatusChange, allowedFrom []string, event *postgres.OutboxEvent, entry *postgres.JournalEntry) error
+		GetDispute(ctx context.Context, id int64) (*postgres.Dispute, error)
+		ListDisputes(ctx context.Context, filter common.DisputeFilter) ([]*postgres.Dispute, error)
+		GetDisputesDue(ctx context.Context, dueBefore, remindedBefore time.Time, limit int) ([]*postgres.Dispute, error)
+		MarkDisputeReminded(ctx context.Context, id int64, remindedBefore time.Time, event *postgres.OutboxEvent) error
 	}
 )
 
-func New(dsn string) (Idb, error) {
-	var d DB
-	err := util.RetryOperation(func() error {
+func New(dsn string, timeout time.Duration) (Idb, error) {
+	d := DB{timeout: timeout}
+	err := connectPolicy.Do(context.Background(), func(ctx context.Context) error {
 		dbInst, err := sql.Open("postgres", dsn)
 		if err != nil {
-			return err
+			// the DSN is invalid, retrying won't fix it
+			return retry.Permanent(err)
 		}
 
-		if err = dbInst.Ping(); err != nil {
+		if err = dbInst.PingContext(ctx); err != nil {
+			dbInst.Close()
 			return err
 		}
 		d.db = dbInst
 
 		return nil
-	}, 5)
+	})
 	if err != nil {
 		log.Printf("Could not connect to the database: %v\n", err)
 
@@ -48,54 +131,169 @@ func New(dsn string) (Idb, error) {
 	return &d, nil
 }
 
-func (d *DB) CreateTransaction(transaction *postgres.Transaction) error {
-	query := `INSERT INTO transactions (amount, type, status, gateway_id, country_id, user_id, created_at) 
-			  VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`
+// withTimeout derives the ctx of a query from the caller's ctx, bounded by the DB timeout
+func (d *DB) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return util.WithTimeout(ctx, d.timeout)
+}
+
+// CreateTransaction inserts the tx and the first entry of its status history. When hold is not nil, the entry it
+// builds for the inserted tx is posted in the same DB transaction, so a tx is never stored without its funds being
+// held; the returned error wraps ErrInsufficientFunds when they are not available.
+func (d *DB) CreateTransaction(ctx context.Context, transaction *postgres.Transaction, hold func(tx *postgres.Transaction) *postgres.JournalEntry) error {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
 
-	err := d.db.QueryRow(query, transaction.Amount, transaction.Type, transaction.Status, transaction.GatewayID, transaction.CountryID, transaction.UserID, time.Now()).Scan(&transaction.ID)
+	query := `INSERT INTO transactions (amount, currency, type, status, gateway_id, country_id, user_id, created_at) 
+			  VALUES ($1, $2, $3, $4, NULLIF($5, 0), $6, $7, $8) RETURNING id`
+
+	sqlTx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer sqlTx.Rollback()
+
+	now := time.Now()
+	err = sqlTx.QueryRowContext(ctx, query, transaction.Amount, transaction.Currency, transaction.Type, transaction.Status, transaction.GatewayID, transaction.CountryID, transaction.UserID, now).Scan(&transaction.ID)
 	if err != nil {
 		return fmt.Errorf("failed to insert transaction: %v", err)
 	}
-	return nil
-}
 
-func (d *DB) UpdateTxStatus(txId int64, status string) error {
-	query := `UPDATE transactions SET status = $1 WHERE id = $2`
-	_, err := d.db.Exec(query, status, txId)
+	err = insertStatusChange(ctx, sqlTx, &postgres.TransactionStatusChange{TransactionID: transaction.ID, ToStatus: transaction.Status, Reason: "created", CreatedAt: now})
 	if err != nil {
-		return fmt.Errorf("failed to update transaction status: %v", err)
+		return err
+	}
+
+	if hold != nil {
+		if err = insertJournalEntry(ctx, sqlTx, hold(transaction)); err != nil {
+			return err
+		}
+	}
+
+	if err = sqlTx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
 	}
+	transaction.CreatedAt = now
 
 	return nil
 }
 
-func (d *DB) GetSupportedGatewaysByCountry(countryId int) ([]*common.Gateway, error) {
+// UpdateTxStatus moves the tx to change.ToStatus only if its current status is one of allowedFrom, and records the
+// change in the status history along with the optional outbox event and ledger entry, all in one DB transaction.
+// change.FromStatus is set to the status found; when it is not allowed the returned error wraps ErrStatusConflict.
+func (d *DB) UpdateTxStatus(ctx context.Context, change *postgres.TransactionStatusChange, allowedFrom []string, event *postgres.OutboxEvent, entry *postgres.JournalEntry) error {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
 	query := `
-		SELECT g.id, g.name, g.data_format_supported, g.priority
-		FROM gateways g
-		INNER JOIN gateway_countries gc ON g.id = gc.gateway_id
-		WHERE gc.country_id = $1
-		ORDER BY g.name
+		UPDATE transactions t SET status = $1
+		FROM (SELECT id, status FROM transactions WHERE id = $2 FOR UPDATE) prev
+		WHERE t.id = prev.id AND prev.status = ANY($3)
+		RETURNING prev.status
 	`
 
-	rows, err := d.db.Query(query, countryId)
+	sqlTx, err := d.db.BeginTx(ctx, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch gateways for country %d: %v", countryId, err)
+		return fmt.Errorf("failed to begin transaction: %v", err)
 	}
-	defer rows.Close()
+	defer sqlTx.Rollback()
 
-	var gateways []*common.Gateway
-	for rows.Next() {
-		var gt common.Gateway
-		if err = rows.Scan(&gt.ID, &gt.Name, &gt.DataFormatSupported, &gt.Priority); err != nil {
-			return nil, fmt.Errorf("failed to scan gateway: %v", err)
+	err = sqlTx.QueryRowContext(ctx, query, change.ToStatus, change.TransactionID, pq.Array(allowedFrom)).Scan(&change.FromStatus)
+	if err == sql.ErrNoRows {
+		err = sqlTx.QueryRowContext(ctx, `SELECT status FROM transactions WHERE id = $1`, change.TransactionID).Scan(&change.FromStatus)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("transaction %d %w", change.TransactionID, ErrNotFound)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to fetch transaction status: %v", err)
+		}
+
+		return fmt.Errorf("transaction %d is %s: %w", change.TransactionID, change.FromStatus, ErrStatusConflict)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update transaction status: %v", err)
+	}
+
+	change.CreatedAt = time.Now()
+	if err = insertStatusChange(ctx, sqlTx, change); err != nil {
+		return err
+	}
+
+	if event != nil {
+		if err = insertOutboxEvent(ctx, sqlTx, event); err != nil {
+			return err
 		}
-		gateways = append(gateways, &gt)
 	}
 
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("failed to iterate over rows: %v", err)
+	if entry != nil {
+		if err = insertJournalEntry(ctx, sqlTx, entry); err != nil {
+			return err
+		}
+	}
+
+	if err = sqlTx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	return nil
+}
+
+func insertStatusChange(ctx context.Context, sqlTx *sql.Tx, change *postgres.TransactionStatusChange) error {
+	query := `INSERT INTO transaction_status_history (transaction_id, from_status, to_status, reason, created_at)
+			  VALUES ($1, NULLIF($2, ''), $3, $4, $5) RETURNING id`
+
+	err := sqlTx.QueryRowContext(ctx, query, change.TransactionID, change.FromStatus, change.ToStatus, change.Reason, change.CreatedAt).Scan(&change.ID)
+	if err != nil {
+		return fmt.Errorf("failed to insert transaction status history: %v", err)
+	}
+
+	return nil
+}
+
+func (d *DB) UpdateTxGateway(ctx context.Context, transaction *postgres.Transaction) error {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	query := `UPDATE transactions SET gateway_id = $1, provider_ref = $2, fee = $3 WHERE id = $4`
+	_, err := d.db.ExecContext(ctx, query, transaction.GatewayID, transaction.ProviderRef, transaction.Fee, transaction.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update transaction gateway: %v", err)
+	}
+
+	return nil
+}
+
+func (d *DB) CreateTxAttempt(ctx context.Context, attempt *postgres.TransactionAttempt) error {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	query := `INSERT INTO transaction_attempts (transaction_id, gateway_id, error, latency_ms, provider_ref, created_at) 
+			  VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`
+
+	err := d.db.QueryRowContext(ctx, query, attempt.TransactionID, attempt.GatewayID, attempt.Error, attempt.LatencyMs, attempt.ProviderRef, time.Now()).Scan(&attempt.ID)
+	if err != nil {
+		return fmt.Errorf("failed to insert transaction attempt: %v", err)
+	}
+	return nil
+}
+
+// GetCountryCurrencies returns the main currency of the country followed by its other allowed currencies
+func (d *DB) GetCountryCurrencies(ctx context.Context, countryId int) ([]string, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT currency, allowed_currencies FROM countries WHERE id = $1`
+
+	var (
+		currency string
+		allowed  []string
+	)
+	err := d.db.QueryRowContext(ctx, query, countryId).Scan(&currency, pq.Array(&allowed))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("country %d %w", countryId, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch currencies of country %d: %v", countryId, err)
 	}
 
-	return gateways, nil
+	return append([]string{currency}, allowed...), nil
 }
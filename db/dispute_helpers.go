@@ -0,0 +1,294 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"payment-gateway/internal/models/common"
+	"payment-gateway/internal/models/postgres"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const disputeColumns = `id, transaction_id, user_id, gateway_id, provider_ref, amount, currency, status, COALESCE(reason, ''),
+	COALESCE(evidence, ''), evidence_due_at, reminded_at, created_at, updated_at`
+
+// CreateDispute stores a dispute along with the outbox event returned by event, if any, in one DB transaction.
+// Disputes are unique per gateway and provider reference: when the gateway already reported the dispute nothing is
+// written, dispute is filled with the stored one and false is returned. The tx is locked meanwhile, like for refunds:
+// a new dispute must not take the completed refunds and the disputes not won of the tx over its amount, otherwise the
+// returned error wraps ErrDisputeExceedsAmount.
+func (d *DB) CreateDispute(ctx context.Context, dispute *postgres.Dispute, event func(dispute *postgres.Dispute) (*postgres.OutboxEvent, error)) (bool, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	sqlTx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer sqlTx.Rollback()
+
+	var amount int64
+	query := `SELECT amount FROM transactions WHERE id = $1 FOR UPDATE`
+	err = sqlTx.QueryRowContext(ctx, query, dispute.TransactionID).Scan(&amount)
+	if err == sql.ErrNoRows {
+		return false, fmt.Errorf("transaction %d %w", dispute.TransactionID, ErrNotFound)
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to fetch transaction: %v", err)
+	}
+
+	query = `SELECT ` + disputeColumns + ` FROM disputes WHERE gateway_id = $1 AND provider_ref = $2`
+	stored, err := scanDispute(sqlTx.QueryRowContext(ctx, query, dispute.GatewayID, dispute.ProviderRef))
+	if err == nil {
+		*dispute = *stored
+
+		return false, nil
+	}
+	if err != sql.ErrNoRows {
+		return false, fmt.Errorf("failed to fetch dispute: %v", err)
+	}
+
+	var taken int64
+	query = `
+		SELECT
+			(SELECT COALESCE(SUM(amount), 0) FROM refunds WHERE transaction_id = $1 AND status = $2) +
+			(SELECT COALESCE(SUM(amount), 0) FROM disputes WHERE transaction_id = $1 AND status <> $3)
+	`
+	err = sqlTx.QueryRowContext(ctx, query, dispute.TransactionID, postgres.RefundCompleted, postgres.DisputeWon).Scan(&taken)
+	if err != nil {
+		return false, fmt.Errorf("failed to sum refunds and disputes: %v", err)
+	}
+	if taken+dispute.Amount > amount {
+		return false, fmt.Errorf("transaction %d has %d of %d %s left to dispute: %w", dispute.TransactionID, amount-taken, amount, dispute.Currency, ErrDisputeExceedsAmount)
+	}
+
+	now := time.Now()
+	query = `
+		INSERT INTO disputes (transaction_id, user_id, gateway_id, provider_ref, amount, currency, status, reason,
+			evidence_due_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''), $9, $10, $10)
+		RETURNING id
+	`
+	err = sqlTx.QueryRowContext(ctx, query, dispute.TransactionID, dispute.UserID, dispute.GatewayID, dispute.ProviderRef,
+		dispute.Amount, dispute.Currency, dispute.Status, dispute.Reason, dispute.EvidenceDueAt, now).Scan(&dispute.ID)
+	if err != nil {
+		return false, fmt.Errorf("failed to insert dispute: %v", err)
+	}
+	dispute.CreatedAt, dispute.UpdatedAt = now, now
+
+	if event != nil {
+		e, err := event(dispute)
+		if err != nil {
+			return false, err
+		}
+		if err = insertOutboxEvent(ctx, sqlTx, e); err != nil {
+			return false, err
+		}
+	}
+
+	if err = sqlTx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	return true, nil
+}
+
+// UpdateDisputeStatus moves the dispute to change.ToStatus only if its current status is one of allowedFrom, and
+// writes the optional outbox event and ledger entry with it, all in one DB transaction. change.FromStatus is set to the
+// status found; when it is not allowed the returned error wraps ErrStatusConflict.
+func (d *DB) UpdateDisputeStatus(ctx context.Context, change *postgres.DisputeStatusChange, allowedFrom []string, event *postgres.OutboxEvent, entry *postgres.JournalEntry) error {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		UPDATE disputes d SET status = $1, evidence = COALESCE(NULLIF($2, ''), d.evidence), updated_at = $3
+		FROM (SELECT id, status FROM disputes WHERE id = $4 FOR UPDATE) prev
+		WHERE d.id = prev.id AND prev.status = ANY($5)
+		RETURNING prev.status
+	`
+
+	sqlTx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer sqlTx.Rollback()
+
+	err = sqlTx.QueryRowContext(ctx, query, change.ToStatus, change.Evidence, time.Now(), change.DisputeID,
+		pq.Array(allowedFrom)).Scan(&change.FromStatus)
+	if err == sql.ErrNoRows {
+		err = sqlTx.QueryRowContext(ctx, `SELECT status FROM disputes WHERE id = $1`, change.DisputeID).Scan(&change.FromStatus)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("dispute %d %w", change.DisputeID, ErrNotFound)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to fetch dispute status: %v", err)
+		}
+
+		return fmt.Errorf("dispute %d is %s: %w", change.DisputeID, change.FromStatus, ErrStatusConflict)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update dispute status: %v", err)
+	}
+
+	if event != nil {
+		if err = insertOutboxEvent(ctx, sqlTx, event); err != nil {
+			return err
+		}
+	}
+
+	if entry != nil {
+		if err = insertJournalEntry(ctx, sqlTx, entry); err != nil {
+			return err
+		}
+	}
+
+	if err = sqlTx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	return nil
+}
+
+// GetDispute fetches a dispute by ID
+func (d *DB) GetDispute(ctx context.Context, id int64) (*postgres.Dispute, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT ` + disputeColumns + ` FROM disputes WHERE id = $1`
+
+	dispute, err := scanDispute(d.db.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("dispute %d %w", id, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch dispute: %v", err)
+	}
+
+	return dispute, nil
+}
+
+// ListDisputes returns the disputes matching the filter, newest first
+func (d *DB) ListDisputes(ctx context.Context, filter common.DisputeFilter) ([]*postgres.Dispute, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	var conds []string
+	var args []interface{}
+	where := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+
+	if filter.TransactionID != 0 {
+		where("transaction_id = $%d", filter.TransactionID)
+	}
+	if filter.GatewayID != 0 {
+		where("gateway_id = $%d", filter.GatewayID)
+	}
+	if filter.Status != "" {
+		where("status = $%d", filter.Status)
+	}
+	if filter.BeforeID != 0 {
+		where("id < $%d", filter.BeforeID)
+	}
+
+	query := `SELECT ` + disputeColumns + ` FROM disputes`
+	if len(conds) > 0 {
+		query += ` WHERE ` + strings.Join(conds, " AND ")
+	}
+	args = append(args, filter.Limit)
+	query += fmt.Sprintf(` ORDER BY id DESC LIMIT $%d`, len(args))
+
+	return d.queryDisputes(ctx, query, args...)
+}
+
+// GetDisputesDue returns up to limit opened disputes whose evidence is due between now and dueBefore and that were
+// not reminded of since remindedBefore, soonest due first
+func (d *DB) GetDisputesDue(ctx context.Context, dueBefore, remindedBefore time.Time, limit int) ([]*postgres.Dispute, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT ` + disputeColumns + `
+		FROM disputes
+		WHERE status = $1 AND evidence_due_at > $2 AND evidence_due_at <= $3 AND (reminded_at IS NULL OR reminded_at <= $4)
+		ORDER BY evidence_due_at
+		LIMIT $5
+	`
+
+	return d.queryDisputes(ctx, query, postgres.DisputeOpened, time.Now(), dueBefore, remindedBefore, limit)
+}
+
+// MarkDisputeReminded records that a reminder of the dispute was sent and writes its outbox event, in one DB
+// transaction. The dispute must still be opened and not reminded of since remindedBefore, otherwise the returned
+// error wraps ErrStatusConflict, so that concurrent instances don't both send the reminder.
+func (d *DB) MarkDisputeReminded(ctx context.Context, id int64, remindedBefore time.Time, event *postgres.OutboxEvent) error {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	sqlTx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer sqlTx.Rollback()
+
+	query := `
+		UPDATE disputes SET reminded_at = $1
+		WHERE id = $2 AND status = $3 AND (reminded_at IS NULL OR reminded_at <= $4)
+	`
+	res, err := sqlTx.ExecContext(ctx, query, time.Now(), id, postgres.DisputeOpened, remindedBefore)
+	if err != nil {
+		return fmt.Errorf("failed to mark dispute reminded: %v", err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("dispute %d was reminded of or answered meanwhile: %w", id, ErrStatusConflict)
+	}
+
+	if err = insertOutboxEvent(ctx, sqlTx, event); err != nil {
+		return err
+	}
+
+	if err = sqlTx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	return nil
+}
+
+// queryDisputes runs a query selecting disputeColumns
+func (d *DB) queryDisputes(ctx context.Context, query string, args ...interface{}) ([]*postgres.Dispute, error) {
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query disputes: %v", err)
+	}
+	defer rows.Close()
+
+	var disputes []*postgres.Dispute
+	for rows.Next() {
+		dispute, err := scanDispute(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan dispute: %v", err)
+		}
+		disputes = append(disputes, dispute)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate disputes: %v", err)
+	}
+
+	return disputes, nil
+}
+
+func scanDispute(row scanner) (*postgres.Dispute, error) {
+	var d postgres.Dispute
+	err := row.Scan(&d.ID, &d.TransactionID, &d.UserID, &d.GatewayID, &d.ProviderRef, &d.Amount, &d.Currency, &d.Status,
+		&d.Reason, &d.Evidence, &d.EvidenceDueAt, &d.RemindedAt, &d.CreatedAt, &d.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &d, nil
+}
@@ -0,0 +1,73 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"os"
+	"payment-gateway/internal/models/postgres"
+	"testing"
+	"time"
+)
+
+// newTestDB migrates an empty database with db/init.sql and connects to it, see newMigrationDB
+func newTestDB(t *testing.T) Idb {
+	t.Helper()
+
+	conn, dsn := newMigrationDB(t)
+
+	schema, err := os.ReadFile("init.sql")
+	if err != nil {
+		t.Fatalf("failed to read init.sql: %v", err)
+	}
+	if _, err = conn.Exec(string(schema)); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+
+	d, err := New(dsn, 5*time.Second)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+
+	return d
+}
+
+func TestCreateDispute_Cap(t *testing.T) {
+	d := newTestDB(t).(*DB)
+	ctx := context.Background()
+
+	// a completed deposit of 100.50 EUR of which 40.00 was refunded
+	seed := `
+		INSERT INTO transactions (id, amount, currency, type, status, gateway_id, country_id, user_id)
+		VALUES (1, 10050, 'EUR', 'deposit', 'completed', 3, 1, 7);
+		INSERT INTO refunds (transaction_id, user_id, gateway_id, amount, currency, status)
+		VALUES (1, 7, 3, 4000, 'EUR', 'completed');
+	`
+	if _, err := d.db.Exec(seed); err != nil {
+		t.Fatalf("failed to seed the deposit: %v", err)
+	}
+
+	dispute := func(providerRef string, amount int64) *postgres.Dispute {
+		return &postgres.Dispute{TransactionID: 1, UserID: 7, GatewayID: 3, ProviderRef: providerRef, Amount: amount,
+			Currency: "EUR", Status: postgres.DisputeOpened, EvidenceDueAt: time.Now().Add(time.Hour)}
+	}
+
+	if _, err := d.CreateDispute(ctx, dispute("dp-1", 6100), nil); !errors.Is(err, ErrDisputeExceedsAmount) {
+		t.Errorf("expected a dispute beyond the amount left once refunded to be rejected, got %v", err)
+	}
+	if created, err := d.CreateDispute(ctx, dispute("dp-1", 6050), nil); err != nil || !created {
+		t.Fatalf("expected the rest of the deposit to be disputable, got %v: %v", created, err)
+	}
+
+	// the dispute takes what was left, it is reported again but no other dispute fits
+	if created, err := d.CreateDispute(ctx, dispute("dp-1", 6050), nil); err != nil || created {
+		t.Errorf("expected a repeated dispute to be found, got %v: %v", created, err)
+	}
+	if _, err := d.CreateDispute(ctx, dispute("dp-2", 1), nil); !errors.Is(err, ErrDisputeExceedsAmount) {
+		t.Errorf("expected a second dispute to be rejected, got %v", err)
+	}
+
+	// nor is a refund of the disputed amount
+	if err := d.CreateRefund(ctx, &postgres.Refund{TransactionID: 1, Status: postgres.RefundPending}, nil); !errors.Is(err, ErrRefundExceedsAmount) {
+		t.Errorf("expected a refund of the disputed amount to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"payment-gateway/internal/models/common"
+
+	"github.com/lib/pq"
+)
+
+// gatewayColumns are the columns read by scanGateway, g being the alias of the gateways table
+const gatewayColumns = `g.id, g.name, g.data_format_supported, COALESCE(g.endpoint_url, ''), COALESCE(g.health_check_url, ''),
+		COALESCE(g.priority, 0), g.supported_currencies, COALESCE(g.callback_secret, ''), g.callback_allowed_ips`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanGateway scans the gatewayColumns of a row followed by the extra columns
+func scanGateway(row scanner, extra ...interface{}) (*common.Gateway, error) {
+	var gt common.Gateway
+	dest := []interface{}{&gt.ID, &gt.Name, &gt.DataFormatSupported, &gt.EndpointURL, &gt.HealthCheckURL, &gt.Priority,
+		pq.Array(&gt.SupportedCurrencies), &gt.CallbackSecret, pq.Array(&gt.CallbackAllowedIPs)}
+
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+
+	return &gt, nil
+}
+
+func (d *DB) GetSupportedGatewaysByCountry(ctx context.Context, countryId int) ([]*common.Gateway, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT ` + gatewayColumns + `, gc.weight, gc.country_id
+		FROM gateways g
+		INNER JOIN gateway_countries gc ON g.id = gc.gateway_id
+		WHERE gc.country_id = $1
+		ORDER BY g.priority, g.id
+	`
+
+	rows, err := d.db.QueryContext(ctx, query, countryId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch gateways for country %d: %v", countryId, err)
+	}
+	defer rows.Close()
+
+	var gateways []*common.Gateway
+	for rows.Next() {
+		var weight, countryID int
+		gt, err := scanGateway(rows, &weight, &countryID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan gateway: %v", err)
+		}
+		gt.Weight, gt.CountryID = weight, countryID
+		gateways = append(gateways, gt)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over rows: %v", err)
+	}
+
+	return gateways, nil
+}
+
+func (d *DB) GetGatewayByID(ctx context.Context, gatewayId int) (*common.Gateway, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT ` + gatewayColumns + `
+		FROM gateways g
+		WHERE g.id = $1
+	`
+
+	gt, err := scanGateway(d.db.QueryRowContext(ctx, query, gatewayId))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("gateway %d %w", gatewayId, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch gateway %d: %v", gatewayId, err)
+	}
+
+	return gt, nil
+}
+
+func (d *DB) GetGateways(ctx context.Context) ([]*common.Gateway, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT ` + gatewayColumns + `
+		FROM gateways g
+		ORDER BY g.id
+	`
+
+	rows, err := d.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch gateways: %v", err)
+	}
+	defer rows.Close()
+
+	var gateways []*common.Gateway
+	for rows.Next() {
+		gt, err := scanGateway(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan gateway: %v", err)
+		}
+		gateways = append(gateways, gt)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over rows: %v", err)
+	}
+
+	return gateways, nil
+}
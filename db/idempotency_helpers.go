@@ -0,0 +1,94 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"payment-gateway/internal/models/postgres"
+	"time"
+)
+
+// ClaimIdempotencyKey stores the key of the user as in flight and reports whether the caller owns it. Keys are unique
+// per user, the same key sent for two users is two keys. A key is also handed over when its request has been in
+// flight for longer than lockTimeout (the process handling it most likely died) or when its response is older than
+// ttl.
+func (d *DB) ClaimIdempotencyKey(ctx context.Context, userID int, key, requestHash string, lockTimeout, ttl time.Duration) (bool, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		INSERT INTO idempotency_keys (user_id, key, request_hash, created_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, key) DO UPDATE
+		SET request_hash = EXCLUDED.request_hash, created_at = EXCLUDED.created_at,
+		    status_code = NULL, content_type = NULL, response = NULL, completed_at = NULL
+		WHERE (idempotency_keys.completed_at IS NULL AND idempotency_keys.created_at < $5)
+		   OR idempotency_keys.completed_at < $6
+		RETURNING key
+	`
+
+	now := time.Now()
+	var claimed string
+	err := d.db.QueryRowContext(ctx, query, userID, key, requestHash, now, now.Add(-lockTimeout), now.Add(-ttl)).Scan(&claimed)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to claim idempotency key: %v", err)
+	}
+
+	return true, nil
+}
+
+func (d *DB) GetIdempotencyKey(ctx context.Context, userID int, key string) (*postgres.IdempotencyKey, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT user_id, key, request_hash, COALESCE(status_code, 0), COALESCE(content_type, ''), response, created_at, completed_at
+		FROM idempotency_keys
+		WHERE user_id = $1 AND key = $2
+	`
+
+	var k postgres.IdempotencyKey
+	err := d.db.QueryRowContext(ctx, query, userID, key).Scan(&k.UserID, &k.Key, &k.RequestHash, &k.StatusCode, &k.ContentType, &k.Response, &k.CreatedAt, &k.CompletedAt)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("idempotency key %q %w", key, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch idempotency key: %v", err)
+	}
+
+	return &k, nil
+}
+
+// CompleteIdempotencyKey stores the response of the request owning the key
+func (d *DB) CompleteIdempotencyKey(ctx context.Context, key *postgres.IdempotencyKey) error {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	query := `UPDATE idempotency_keys SET status_code = $1, content_type = $2, response = $3, completed_at = $4
+			  WHERE user_id = $5 AND key = $6 AND request_hash = $7`
+
+	now := time.Now()
+	_, err := d.db.ExecContext(ctx, query, key.StatusCode, key.ContentType, key.Response, now, key.UserID, key.Key, key.RequestHash)
+	if err != nil {
+		return fmt.Errorf("failed to complete idempotency key: %v", err)
+	}
+	key.CompletedAt = &now
+
+	return nil
+}
+
+// DeleteIdempotencyKey releases a key of the user whose request was not processed
+func (d *DB) DeleteIdempotencyKey(ctx context.Context, userID int, key string) error {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	_, err := d.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE user_id = $1 AND key = $2`, userID, key)
+	if err != nil {
+		return fmt.Errorf("failed to delete idempotency key: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,170 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"payment-gateway/internal/models/common"
+	"payment-gateway/internal/models/postgres"
+	"strings"
+	"time"
+)
+
+const inboxColumns = `id, gateway_id, event_id, transaction_id, COALESCE(provider_status, ''), COALESCE(status, ''),
+	payload, result, COALESCE(error, ''), attempts, received_at, updated_at, processed_at`
+
+// ClaimInboxCallback stores a callback as received and reports whether the caller should apply it. A callback
+// already in the inbox (same gateway and event ID) is only handed over again when it failed, or when it has been
+// received for longer than lockTimeout (the process applying it most likely died); otherwise cb is filled with the
+// stored callback.
+func (d *DB) ClaimInboxCallback(ctx context.Context, cb *postgres.InboxCallback, lockTimeout time.Duration) (bool, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		INSERT INTO callback_inbox (gateway_id, event_id, transaction_id, provider_status, status, payload, result, received_at, updated_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), $6, $7, $8, $8)
+		ON CONFLICT (gateway_id, event_id) DO UPDATE
+		SET result = EXCLUDED.result, attempts = callback_inbox.attempts + 1, error = NULL, updated_at = EXCLUDED.updated_at
+		WHERE callback_inbox.result = $9
+		   OR (callback_inbox.result = EXCLUDED.result AND callback_inbox.updated_at < $10)
+		RETURNING ` + inboxColumns
+
+	now := time.Now()
+	stored, err := scanInboxCallback(d.db.QueryRowContext(ctx, query, cb.GatewayID, cb.EventID, cb.TransactionID,
+		cb.ProviderStatus, cb.Status, cb.Payload, postgres.CallbackReceived, now, postgres.CallbackFailed, now.Add(-lockTimeout)))
+	if err == sql.ErrNoRows {
+		query = `SELECT ` + inboxColumns + ` FROM callback_inbox WHERE gateway_id = $1 AND event_id = $2`
+		if stored, err = scanInboxCallback(d.db.QueryRowContext(ctx, query, cb.GatewayID, cb.EventID)); err != nil {
+			return false, fmt.Errorf("failed to fetch inbox callback: %v", err)
+		}
+		*cb = *stored
+
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to store inbox callback: %v", err)
+	}
+	*cb = *stored
+
+	return true, nil
+}
+
+// ReclaimInboxCallback marks a stored callback as received again so it can be re-applied, unless it is being applied
+// for less than lockTimeout
+func (d *DB) ReclaimInboxCallback(ctx context.Context, id int64, lockTimeout time.Duration) (*postgres.InboxCallback, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		UPDATE callback_inbox
+		SET result = $2, attempts = attempts + 1, error = NULL, updated_at = $3
+		WHERE id = $1 AND NOT (result = $2 AND updated_at >= $4)
+		RETURNING ` + inboxColumns
+
+	now := time.Now()
+	cb, err := scanInboxCallback(d.db.QueryRowContext(ctx, query, id, postgres.CallbackReceived, now, now.Add(-lockTimeout)))
+	if err == sql.ErrNoRows {
+		var exists bool
+		if err = d.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM callback_inbox WHERE id = $1)`, id).Scan(&exists); err != nil {
+			return nil, fmt.Errorf("failed to fetch inbox callback: %v", err)
+		}
+		if !exists {
+			return nil, fmt.Errorf("callback %d %w", id, ErrNotFound)
+		}
+
+		return nil, fmt.Errorf("callback %d is being processed: %w", id, ErrStatusConflict)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to reclaim inbox callback: %v", err)
+	}
+
+	return cb, nil
+}
+
+// CompleteInboxCallback stores the outcome of applying a callback along with what was parsed from it
+func (d *DB) CompleteInboxCallback(ctx context.Context, cb *postgres.InboxCallback) error {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		UPDATE callback_inbox
+		SET transaction_id = $2, provider_status = NULLIF($3, ''), status = NULLIF($4, ''), result = $5,
+		    error = NULLIF($6, ''), updated_at = $7, processed_at = $7
+		WHERE id = $1
+	`
+
+	now := time.Now()
+	_, err := d.db.ExecContext(ctx, query, cb.ID, cb.TransactionID, cb.ProviderStatus, cb.Status, cb.Result, cb.Error, now)
+	if err != nil {
+		return fmt.Errorf("failed to complete inbox callback %d: %v", cb.ID, err)
+	}
+	cb.UpdatedAt, cb.ProcessedAt = now, &now
+
+	return nil
+}
+
+// ListInboxCallbacks returns up to filter.Limit callbacks matching the filter, newest first
+func (d *DB) ListInboxCallbacks(ctx context.Context, filter common.CallbackFilter) ([]*postgres.InboxCallback, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	var conds []string
+	var args []interface{}
+	where := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+
+	if filter.GatewayID != 0 {
+		where("gateway_id = $%d", filter.GatewayID)
+	}
+	if filter.TransactionID != 0 {
+		where("transaction_id = $%d", filter.TransactionID)
+	}
+	if filter.Result != "" {
+		where("result = $%d", filter.Result)
+	}
+	if filter.BeforeID != 0 {
+		where("id < $%d", filter.BeforeID)
+	}
+
+	query := `SELECT ` + inboxColumns + ` FROM callback_inbox`
+	if len(conds) > 0 {
+		query += ` WHERE ` + strings.Join(conds, " AND ")
+	}
+	args = append(args, filter.Limit)
+	query += fmt.Sprintf(` ORDER BY id DESC LIMIT $%d`, len(args))
+
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query inbox callbacks: %v", err)
+	}
+	defer rows.Close()
+
+	var callbacks []*postgres.InboxCallback
+	for rows.Next() {
+		cb, err := scanInboxCallback(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan inbox callback: %v", err)
+		}
+		callbacks = append(callbacks, cb)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read inbox callbacks: %v", err)
+	}
+
+	return callbacks, nil
+}
+
+func scanInboxCallback(row scanner) (*postgres.InboxCallback, error) {
+	var cb postgres.InboxCallback
+	err := row.Scan(&cb.ID, &cb.GatewayID, &cb.EventID, &cb.TransactionID, &cb.ProviderStatus, &cb.Status, &cb.Payload,
+		&cb.Result, &cb.Error, &cb.Attempts, &cb.ReceivedAt, &cb.UpdatedAt, &cb.ProcessedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cb, nil
+}
@@ -67,3 +67,462 @@ BEGIN
         );
     END IF;
 END $$;
+
+ALTER TABLE gateways ADD COLUMN IF NOT EXISTS endpoint_url VARCHAR(2048);
+ALTER TABLE gateways ADD COLUMN IF NOT EXISTS health_check_url VARCHAR(2048);
+
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS provider_ref VARCHAR(255);
+
+DO $$ 
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'transaction_attempts') THEN
+        CREATE TABLE transaction_attempts (
+            id BIGSERIAL PRIMARY KEY,
+            transaction_id INT NOT NULL,
+            gateway_id INT NOT NULL,
+            error TEXT,
+            latency_ms BIGINT NOT NULL,
+            provider_ref VARCHAR(255),
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        );
+        CREATE INDEX idx_transaction_attempts_transaction_id ON transaction_attempts (transaction_id);
+    END IF;
+END $$;
+
+ALTER TABLE gateway_countries ADD COLUMN IF NOT EXISTS weight INT NOT NULL DEFAULT 100;
+ALTER TABLE transactions ALTER COLUMN gateway_id DROP NOT NULL;
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS segment VARCHAR(50);
+
+DO $$ 
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'routing_rules') THEN
+        CREATE TABLE routing_rules (
+            id SERIAL PRIMARY KEY,
+            name VARCHAR(255) NOT NULL,
+            priority INT NOT NULL,
+            country_id INT,
+            currencies TEXT[],
+            min_amount BIGINT,
+            max_amount BIGINT,
+            transaction_type VARCHAR(50),
+            user_segments TEXT[],
+            gateway_ids INT[] NOT NULL,
+            enabled BOOLEAN NOT NULL DEFAULT TRUE,
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        );
+    END IF;
+END $$;
+
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS fee DECIMAL(10, 2);
+
+DO $$ 
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'gateway_fees') THEN
+        CREATE TABLE gateway_fees (
+            id SERIAL PRIMARY KEY,
+            gateway_id INT NOT NULL,
+            currency CHAR(3),
+            transaction_type VARCHAR(50),
+            fixed_fee BIGINT NOT NULL DEFAULT 0,
+            percentage_fee DECIMAL(7, 4) NOT NULL DEFAULT 0,
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            UNIQUE (gateway_id, currency, transaction_type)
+        );
+    END IF;
+END $$;
+
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS currency CHAR(3);
+-- transactions stored before they had a currency were in the currency of their country
+UPDATE transactions t SET currency = c.currency FROM countries c WHERE c.id = t.country_id AND t.currency IS NULL;
+ALTER TABLE transactions ALTER COLUMN currency SET NOT NULL;
+ALTER TABLE countries ADD COLUMN IF NOT EXISTS allowed_currencies TEXT[];
+ALTER TABLE gateways ADD COLUMN IF NOT EXISTS supported_currencies TEXT[];
+
+-- amounts of transactions are stored as integers in the minor units of their currency (e.g. cents)
+CREATE OR REPLACE FUNCTION currency_exponent(code TEXT) RETURNS INT AS $$
+    SELECT CASE
+        WHEN code IN ('BIF', 'CLP', 'DJF', 'GNF', 'ISK', 'JPY', 'KMF', 'KRW', 'PYG', 'RWF', 'UGX', 'UYI', 'VND', 'VUV', 'XAF', 'XOF', 'XPF') THEN 0
+        WHEN code IN ('BHD', 'IQD', 'JOD', 'KWD', 'LYD', 'OMR', 'TND') THEN 3
+        WHEN code IN ('CLF', 'UYW') THEN 4
+        ELSE 2
+    END
+$$ LANGUAGE SQL IMMUTABLE;
+
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'transactions' AND column_name = 'amount' AND data_type = 'numeric') THEN
+        ALTER TABLE transactions ALTER COLUMN amount TYPE BIGINT USING ROUND(amount * 10 ^ currency_exponent(currency))::BIGINT;
+    END IF;
+
+    IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'transactions' AND column_name = 'fee' AND data_type = 'numeric') THEN
+        ALTER TABLE transactions ALTER COLUMN fee TYPE BIGINT USING ROUND(fee * 10 ^ currency_exponent(currency))::BIGINT;
+    END IF;
+
+    -- a rule listing several currencies is converted with the exponent of its first one, a rule or a fee schedule
+    -- without a currency with the default exponent of 2
+    IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'routing_rules' AND column_name = 'min_amount' AND data_type = 'numeric') THEN
+        ALTER TABLE routing_rules ALTER COLUMN min_amount TYPE BIGINT USING ROUND(min_amount * 10 ^ currency_exponent(COALESCE(currencies[1], '')))::BIGINT;
+    END IF;
+
+    IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'routing_rules' AND column_name = 'max_amount' AND data_type = 'numeric') THEN
+        ALTER TABLE routing_rules ALTER COLUMN max_amount TYPE BIGINT USING ROUND(max_amount * 10 ^ currency_exponent(COALESCE(currencies[1], '')))::BIGINT;
+    END IF;
+
+    IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'gateway_fees' AND column_name = 'fixed_fee' AND data_type = 'numeric') THEN
+        ALTER TABLE gateway_fees ALTER COLUMN fixed_fee TYPE BIGINT USING ROUND(fixed_fee * 10 ^ currency_exponent(COALESCE(currency, '')))::BIGINT;
+    END IF;
+END $$;
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'idempotency_keys') THEN
+        CREATE TABLE idempotency_keys (
+            key VARCHAR(255) PRIMARY KEY,
+            request_hash CHAR(64) NOT NULL,
+            status_code INT,
+            content_type VARCHAR(255),
+            response BYTEA,
+            created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+            completed_at TIMESTAMP
+        );
+    END IF;
+END $$;
+
+-- idempotency keys are unique per user, so that clients of different users can't collide on a key or be replayed the
+-- response of another user. Keys stored before were global, they are kept as keys of no user.
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS user_id INT NOT NULL DEFAULT 0;
+
+DO $$
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1 FROM information_schema.key_column_usage
+        WHERE table_name = 'idempotency_keys' AND constraint_name = 'idempotency_keys_pkey' AND column_name = 'user_id'
+    ) THEN
+        ALTER TABLE idempotency_keys DROP CONSTRAINT idempotency_keys_pkey;
+        ALTER TABLE idempotency_keys ADD PRIMARY KEY (user_id, key);
+    END IF;
+END $$;
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'transaction_status_history') THEN
+        CREATE TABLE transaction_status_history (
+            id BIGSERIAL PRIMARY KEY,
+            transaction_id INT NOT NULL,
+            from_status VARCHAR(50),
+            to_status VARCHAR(50) NOT NULL,
+            reason TEXT NOT NULL DEFAULT '',
+            created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+        );
+        CREATE INDEX idx_transaction_status_history_transaction_id ON transaction_status_history (transaction_id);
+    END IF;
+END $$;
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'outbox_events') THEN
+        CREATE TABLE outbox_events (
+            id BIGSERIAL PRIMARY KEY,
+            aggregate_id BIGINT NOT NULL,
+            event_type VARCHAR(100) NOT NULL,
+            payload BYTEA NOT NULL,
+            content_type VARCHAR(255) NOT NULL,
+            attempts INT NOT NULL DEFAULT 0,
+            last_error TEXT,
+            created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+            published_at TIMESTAMP
+        );
+        CREATE INDEX idx_outbox_events_pending ON outbox_events (id) WHERE published_at IS NULL;
+    END IF;
+END $$;
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'consumed_events') THEN
+        CREATE TABLE consumed_events (
+            event_id BIGINT PRIMARY KEY,
+            transaction_id INT NOT NULL,
+            event_type VARCHAR(100) NOT NULL,
+            status VARCHAR(50) NOT NULL,
+            payload BYTEA NOT NULL,
+            occurred_at TIMESTAMP NOT NULL,
+            consumed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+        );
+        CREATE INDEX idx_consumed_events_transaction_id ON consumed_events (transaction_id);
+    END IF;
+END $$;
+
+-- transaction listing: keyset pagination on (created_at, id) or (amount, id), the most selective filters lead
+CREATE INDEX IF NOT EXISTS idx_transactions_created_at ON transactions (created_at, id);
+-- amounts are only sorted within a currency
+DROP INDEX IF EXISTS idx_transactions_amount;
+CREATE INDEX IF NOT EXISTS idx_transactions_currency_amount ON transactions (currency, amount, id);
+CREATE INDEX IF NOT EXISTS idx_transactions_user_id ON transactions (user_id, created_at, id);
+CREATE INDEX IF NOT EXISTS idx_transactions_status ON transactions (status, created_at, id);
+CREATE INDEX IF NOT EXISTS idx_transactions_gateway_id ON transactions (gateway_id, created_at, id);
+
+-- Shared secret signing the callbacks of a gateway and the IPs/CIDRs allowed to post them, no restriction when empty
+ALTER TABLE gateways ADD COLUMN IF NOT EXISTS callback_secret TEXT;
+ALTER TABLE gateways ADD COLUMN IF NOT EXISTS callback_allowed_ips TEXT[];
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'callback_inbox') THEN
+        CREATE TABLE callback_inbox (
+            id BIGSERIAL PRIMARY KEY,
+            gateway_id INT NOT NULL REFERENCES gateways(id),
+            event_id VARCHAR(255) NOT NULL,
+            transaction_id INT,
+            provider_status VARCHAR(100),
+            status VARCHAR(50),
+            payload BYTEA NOT NULL,
+            result VARCHAR(20) NOT NULL,
+            error TEXT,
+            attempts INT NOT NULL DEFAULT 1,
+            received_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+            updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+            processed_at TIMESTAMP,
+            UNIQUE (gateway_id, event_id)
+        );
+        CREATE INDEX idx_callback_inbox_result ON callback_inbox (result, id);
+        CREATE INDEX idx_callback_inbox_transaction_id ON callback_inbox (transaction_id);
+    END IF;
+END $$;
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'webhook_endpoints') THEN
+        CREATE TABLE webhook_endpoints (
+            id SERIAL PRIMARY KEY,
+            url VARCHAR(2048) NOT NULL,
+            secret TEXT NOT NULL,
+            event_types TEXT[] NOT NULL DEFAULT '{}',
+            enabled BOOLEAN NOT NULL DEFAULT TRUE,
+            consecutive_failures INT NOT NULL DEFAULT 0,
+            disabled_reason TEXT,
+            disabled_at TIMESTAMP,
+            created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+        );
+    END IF;
+END $$;
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'webhook_deliveries') THEN
+        CREATE TABLE webhook_deliveries (
+            id BIGSERIAL PRIMARY KEY,
+            endpoint_id INT NOT NULL REFERENCES webhook_endpoints(id),
+            event_id BIGINT NOT NULL REFERENCES outbox_events(id),
+            transaction_id INT NOT NULL,
+            event_type VARCHAR(100) NOT NULL,
+            payload BYTEA NOT NULL,
+            content_type VARCHAR(255) NOT NULL,
+            status VARCHAR(20) NOT NULL,
+            attempts INT NOT NULL DEFAULT 0,
+            last_error TEXT,
+            next_attempt_at TIMESTAMP NOT NULL,
+            created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+            delivered_at TIMESTAMP,
+            UNIQUE (endpoint_id, event_id)
+        );
+        CREATE INDEX idx_webhook_deliveries_pending ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+        CREATE INDEX idx_webhook_deliveries_endpoint_id ON webhook_deliveries (endpoint_id, id);
+        CREATE INDEX idx_webhook_deliveries_transaction_id ON webhook_deliveries (transaction_id);
+    END IF;
+END $$;
+
+-- webhook endpoints are notified of the transactions of their owner. Endpoints registered before they had one were
+-- notified of the transactions of every user, they are disabled and have to be registered again with an owner.
+ALTER TABLE webhook_endpoints ADD COLUMN IF NOT EXISTS owner_id INT;
+UPDATE webhook_endpoints SET enabled = FALSE, disabled_reason = 'registered without an owner', disabled_at = CURRENT_TIMESTAMP
+WHERE owner_id IS NULL AND enabled;
+CREATE INDEX IF NOT EXISTS idx_webhook_endpoints_owner_id ON webhook_endpoints (owner_id) WHERE enabled;
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'webhook_attempts') THEN
+        CREATE TABLE webhook_attempts (
+            id BIGSERIAL PRIMARY KEY,
+            delivery_id BIGINT NOT NULL REFERENCES webhook_deliveries(id),
+            attempt INT NOT NULL,
+            status_code INT,
+            error TEXT,
+            duration_ms BIGINT NOT NULL,
+            created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+        );
+        CREATE INDEX idx_webhook_attempts_delivery_id ON webhook_attempts (delivery_id, id);
+    END IF;
+END $$;
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'ledger_accounts') THEN
+        CREATE TABLE ledger_accounts (
+            id BIGSERIAL PRIMARY KEY,
+            type VARCHAR(50) NOT NULL,
+            owner_id BIGINT NOT NULL,
+            currency CHAR(3) NOT NULL,
+            balance BIGINT NOT NULL DEFAULT 0,
+            created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+            UNIQUE (type, owner_id, currency)
+        );
+
+        CREATE TABLE journal_entries (
+            id BIGSERIAL PRIMARY KEY,
+            reference VARCHAR(255) NOT NULL UNIQUE,
+            transaction_id INT,
+            currency CHAR(3) NOT NULL,
+            description TEXT NOT NULL,
+            created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+        );
+        CREATE INDEX idx_journal_entries_transaction_id ON journal_entries (transaction_id);
+
+        CREATE TABLE ledger_postings (
+            id BIGSERIAL PRIMARY KEY,
+            entry_id BIGINT NOT NULL REFERENCES journal_entries(id),
+            account_id BIGINT NOT NULL REFERENCES ledger_accounts(id),
+            amount BIGINT NOT NULL CHECK (amount <> 0)
+        );
+        CREATE INDEX idx_ledger_postings_entry_id ON ledger_postings (entry_id);
+        CREATE INDEX idx_ledger_postings_account_id ON ledger_postings (account_id);
+
+        -- transactions completed before the ledger existed are posted as they would be now. Those without a gateway
+        -- have no counterpart account to post to, and are left out rather than posted unbalanced.
+        INSERT INTO ledger_accounts (type, owner_id, currency)
+        SELECT DISTINCT a.type, a.owner_id, t.currency
+        FROM transactions t
+        CROSS JOIN LATERAL (VALUES ('user', t.user_id), ('gateway', t.gateway_id), ('fees', 0)) a(type, owner_id)
+        WHERE t.status = 'completed' AND t.gateway_id IS NOT NULL;
+
+        INSERT INTO journal_entries (reference, transaction_id, currency, description, created_at)
+        SELECT 'transaction:' || id || ':completed', id, currency, type || ' ' || id || ' completed', COALESCE(created_at, CURRENT_TIMESTAMP)
+        FROM transactions
+        WHERE status = 'completed' AND gateway_id IS NOT NULL;
+
+        INSERT INTO ledger_postings (entry_id, account_id, amount)
+        SELECT e.id, a.id, p.amount
+        FROM journal_entries e
+        JOIN transactions t ON t.id = e.transaction_id
+        CROSS JOIN LATERAL (VALUES
+            ('user', t.user_id, CASE WHEN t.type = 'deposit' THEN t.amount ELSE -t.amount END),
+            ('gateway', t.gateway_id, CASE WHEN t.type = 'deposit' THEN COALESCE(t.fee, 0) - t.amount ELSE t.amount + COALESCE(t.fee, 0) END),
+            ('fees', 0, -COALESCE(t.fee, 0))
+        ) p(type, owner_id, amount)
+        JOIN ledger_accounts a ON a.type = p.type AND a.owner_id = p.owner_id AND a.currency = t.currency
+        WHERE p.amount <> 0;
+
+        UPDATE ledger_accounts a SET balance = p.balance
+        FROM (SELECT account_id, SUM(amount) AS balance FROM ledger_postings GROUP BY account_id) p
+        WHERE a.id = p.account_id;
+    END IF;
+END $$;
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'refunds') THEN
+        CREATE TABLE refunds (
+            id BIGSERIAL PRIMARY KEY,
+            transaction_id INT NOT NULL REFERENCES transactions(id),
+            user_id INT NOT NULL,
+            gateway_id INT NOT NULL,
+            amount BIGINT NOT NULL CHECK (amount > 0),
+            currency CHAR(3) NOT NULL,
+            status VARCHAR(50) NOT NULL,
+            reason TEXT,
+            provider_ref VARCHAR(255),
+            created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+            updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+        );
+        CREATE INDEX idx_refunds_transaction_id ON refunds (transaction_id, id);
+    END IF;
+END $$;
+
+-- refunds still pending long after they were created were never sent to their gateway
+CREATE INDEX IF NOT EXISTS idx_refunds_pending ON refunds (created_at, id) WHERE status = 'pending';
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'disputes') THEN
+        CREATE TABLE disputes (
+            id BIGSERIAL PRIMARY KEY,
+            transaction_id INT NOT NULL REFERENCES transactions(id),
+            user_id INT NOT NULL,
+            gateway_id INT NOT NULL,
+            provider_ref VARCHAR(255) NOT NULL,
+            amount BIGINT NOT NULL CHECK (amount > 0),
+            currency CHAR(3) NOT NULL,
+            status VARCHAR(50) NOT NULL,
+            reason TEXT,
+            evidence TEXT,
+            evidence_due_at TIMESTAMP NOT NULL,
+            reminded_at TIMESTAMP,
+            created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+            updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+            UNIQUE (gateway_id, provider_ref)
+        );
+        CREATE INDEX idx_disputes_transaction_id ON disputes (transaction_id, id);
+        CREATE INDEX idx_disputes_due ON disputes (evidence_due_at) WHERE status = 'opened';
+    END IF;
+END $$;
+
+-- withdrawals in flight before their amount was held hold it now, as they would have when created, so that it is
+-- released if they don't complete. The hold is posted even if it overdraws the user, the withdrawal was accepted.
+INSERT INTO ledger_accounts (type, owner_id, currency)
+SELECT DISTINCT a.type, t.user_id, t.currency
+FROM transactions t
+CROSS JOIN (VALUES ('user'), ('user_hold')) a(type)
+WHERE t.type = 'withdrawal' AND t.status IN ('pending', 'processing') AND t.amount > 0
+ON CONFLICT (type, owner_id, currency) DO NOTHING;
+
+WITH entries AS (
+    INSERT INTO journal_entries (reference, transaction_id, currency, description, created_at)
+    SELECT 'transaction:' || id || ':hold', id, currency, type || ' ' || id || ' hold', COALESCE(created_at, CURRENT_TIMESTAMP)
+    FROM transactions
+    WHERE type = 'withdrawal' AND status IN ('pending', 'processing') AND amount > 0
+    ON CONFLICT (reference) DO NOTHING
+    RETURNING id, transaction_id
+), postings AS (
+    INSERT INTO ledger_postings (entry_id, account_id, amount)
+    SELECT e.id, a.id, p.amount
+    FROM entries e
+    JOIN transactions t ON t.id = e.transaction_id
+    CROSS JOIN LATERAL (VALUES ('user', -t.amount), ('user_hold', t.amount)) p(type, amount)
+    JOIN ledger_accounts a ON a.type = p.type AND a.owner_id = t.user_id AND a.currency = t.currency
+    RETURNING account_id, amount
+)
+UPDATE ledger_accounts a SET balance = a.balance + p.amount
+FROM (SELECT account_id, SUM(amount) AS amount FROM postings GROUP BY account_id) p
+WHERE a.id = p.account_id;
+
+-- journal entries and their postings are the history of the ledger, they are never changed: mistakes are corrected by
+-- posting a reversing entry
+CREATE OR REPLACE FUNCTION ledger_immutable() RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION '% of % is not allowed, the ledger is append only', TG_OP, TG_TABLE_NAME;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS journal_entries_immutable ON journal_entries;
+CREATE TRIGGER journal_entries_immutable BEFORE UPDATE OR DELETE ON journal_entries
+    FOR EACH ROW EXECUTE FUNCTION ledger_immutable();
+
+DROP TRIGGER IF EXISTS ledger_postings_immutable ON ledger_postings;
+CREATE TRIGGER ledger_postings_immutable BEFORE UPDATE OR DELETE ON ledger_postings
+    FOR EACH ROW EXECUTE FUNCTION ledger_immutable();
+
+-- a worker claims a pending tx by setting submitted_at before sending it, so a tx queued more than once, e.g. again on
+-- startup, is sent once. Pending txs with attempts were being sent before the claim existed and are claimed already.
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'transactions' AND column_name = 'submitted_at') THEN
+        ALTER TABLE transactions ADD COLUMN submitted_at TIMESTAMP;
+
+        UPDATE transactions t SET submitted_at = COALESCE(t.created_at, CURRENT_TIMESTAMP)
+        WHERE t.status = 'pending' AND EXISTS (SELECT 1 FROM transaction_attempts a WHERE a.transaction_id = t.id);
+    END IF;
+END $$;
+
+CREATE INDEX IF NOT EXISTS idx_transactions_unsent ON transactions (id) WHERE status = 'pending' AND submitted_at IS NULL;
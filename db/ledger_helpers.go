@@ -0,0 +1,238 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"payment-gateway/internal/models/common"
+	"payment-gateway/internal/models/postgres"
+	"sort"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// insertJournalEntry writes a balanced entry and adds its postings to the balances of their accounts, creating the
+// accounts on first use. Writing an entry whose reference already exists is a no-op, so an entry is posted once.
+func insertJournalEntry(ctx context.Context, sqlTx *sql.Tx, entry *postgres.JournalEntry) error {
+	var sum int64
+	for _, p := range entry.Postings {
+		sum += p.Amount
+	}
+	if len(entry.Postings) < 2 || sum != 0 {
+		return fmt.Errorf("journal entry %s is unbalanced", entry.Reference)
+	}
+
+	entry.CreatedAt = time.Now()
+	query := `
+		INSERT INTO journal_entries (reference, transaction_id, currency, description, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (reference) DO NOTHING
+		RETURNING id
+	`
+
+	err := sqlTx.QueryRowContext(ctx, query, entry.Reference, entry.TransactionID, entry.Currency, entry.Description, entry.CreatedAt).Scan(&entry.ID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to insert journal entry: %v", err)
+	}
+
+	// accounts are always locked in the same order, so that concurrent entries cannot deadlock
+	postings := append([]*postgres.Posting(nil), entry.Postings...)
+	sort.Slice(postings, func(i, j int) bool {
+		if postings[i].AccountType != postings[j].AccountType {
+			return postings[i].AccountType < postings[j].AccountType
+		}
+		return postings[i].OwnerID < postings[j].OwnerID
+	})
+
+	for _, p := range postings {
+		p.EntryID = entry.ID
+		if err = updateLedgerAccount(ctx, sqlTx, entry, p); err != nil {
+			return err
+		}
+
+		query = `INSERT INTO ledger_postings (entry_id, account_id, amount) VALUES ($1, $2, $3) RETURNING id`
+		if err = sqlTx.QueryRowContext(ctx, query, p.EntryID, p.AccountID, p.Amount).Scan(&p.ID); err != nil {
+			return fmt.Errorf("failed to insert ledger posting: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// updateLedgerAccount adds the amount of the posting to the balance of its account and sets p.AccountID. The account is
+// created on first use, unless the posting requires funds: the balance is then only updated if it covers the amount.
+// The update locks the account until the DB transaction ends, so concurrent postings cannot both spend the same funds.
+func updateLedgerAccount(ctx context.Context, sqlTx *sql.Tx, entry *postgres.JournalEntry, p *postgres.Posting) error {
+	if p.RequireFunds {
+		query := `
+			UPDATE ledger_accounts SET balance = balance + $4
+			WHERE type = $1 AND owner_id = $2 AND currency = $3 AND balance + $4 >= 0
+			RETURNING id
+		`
+		err := sqlTx.QueryRowContext(ctx, query, p.AccountType, p.OwnerID, entry.Currency, p.Amount).Scan(&p.AccountID)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("%s account %d has less than %d %s available: %w", p.AccountType, p.OwnerID, -p.Amount, entry.Currency, ErrInsufficientFunds)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to update ledger account: %v", err)
+		}
+
+		return nil
+	}
+
+	query := `
+		INSERT INTO ledger_accounts (type, owner_id, currency, balance, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (type, owner_id, currency) DO UPDATE SET balance = ledger_accounts.balance + EXCLUDED.balance
+		RETURNING id
+	`
+	err := sqlTx.QueryRowContext(ctx, query, p.AccountType, p.OwnerID, entry.Currency, p.Amount, entry.CreatedAt).Scan(&p.AccountID)
+	if err != nil {
+		return fmt.Errorf("failed to update ledger account: %v", err)
+	}
+
+	return nil
+}
+
+// GetLedgerAccounts returns the accounts of an owner of the given types, ordered by currency
+func (d *DB) GetLedgerAccounts(ctx context.Context, ownerID int64, accountTypes ...string) ([]*postgres.LedgerAccount, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT id, type, owner_id, currency, balance, created_at
+		FROM ledger_accounts
+		WHERE owner_id = $1 AND type = ANY($2)
+		ORDER BY currency, type
+	`
+
+	rows, err := d.db.QueryContext(ctx, query, ownerID, pq.Array(accountTypes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query ledger accounts: %v", err)
+	}
+	defer rows.Close()
+
+	var accounts []*postgres.LedgerAccount
+	for rows.Next() {
+		var a postgres.LedgerAccount
+		if err = rows.Scan(&a.ID, &a.Type, &a.OwnerID, &a.Currency, &a.Balance, &a.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan ledger account: %v", err)
+		}
+		accounts = append(accounts, &a)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate ledger accounts: %v", err)
+	}
+
+	return accounts, nil
+}
+
+// CheckLedger looks for entries and currencies whose postings don't sum to zero, and accounts whose balance is not
+// the sum of their postings. It reads a single snapshot of the ledger, entries being written meanwhile are ignored.
+func (d *DB) CheckLedger(ctx context.Context) (*common.LedgerCheck, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	sqlTx, err := d.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer sqlTx.Rollback()
+
+	check := &common.LedgerCheck{UnbalancedCurrencies: map[string]int64{}, CheckedAt: time.Now()}
+
+	if err = sqlTx.QueryRowContext(ctx, `SELECT COUNT(*) FROM journal_entries`).Scan(&check.Entries); err != nil {
+		return nil, fmt.Errorf("failed to count journal entries: %v", err)
+	}
+
+	queries := []struct {
+		query string
+		dest  *[]int64
+	}{
+		{
+			query: `
+				SELECT e.id
+				FROM journal_entries e
+				LEFT JOIN ledger_postings p ON p.entry_id = e.id
+				LEFT JOIN ledger_accounts a ON a.id = p.account_id
+				GROUP BY e.id
+				HAVING COALESCE(SUM(p.amount), 0) <> 0 OR COUNT(p.id) < 2 OR bool_or(a.currency <> e.currency)
+				ORDER BY e.id
+			`,
+			dest: &check.UnbalancedEntries,
+		},
+		{
+			query: `
+				SELECT a.id
+				FROM ledger_accounts a
+				LEFT JOIN ledger_postings p ON p.account_id = a.id
+				GROUP BY a.id
+				HAVING a.balance <> COALESCE(SUM(p.amount), 0)
+				ORDER BY a.id
+			`,
+			dest: &check.BalanceMismatches,
+		},
+	}
+
+	for _, q := range queries {
+		*q.dest = []int64{}
+		if err = scanIDs(ctx, sqlTx, q.query, q.dest); err != nil {
+			return nil, err
+		}
+	}
+
+	rows, err := sqlTx.QueryContext(ctx, `
+		SELECT a.currency, SUM(p.amount)
+		FROM ledger_postings p
+		JOIN ledger_accounts a ON a.id = p.account_id
+		GROUP BY a.currency
+		HAVING SUM(p.amount) <> 0
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sum ledger postings: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var currency string
+		var sum int64
+		if err = rows.Scan(&currency, &sum); err != nil {
+			return nil, fmt.Errorf("failed to scan ledger sum: %v", err)
+		}
+		check.UnbalancedCurrencies[currency] = sum
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate ledger sums: %v", err)
+	}
+
+	return check, nil
+}
+
+// scanIDs appends the IDs returned by query to dest
+func scanIDs(ctx context.Context, sqlTx *sql.Tx, query string, dest *[]int64) error {
+	rows, err := sqlTx.QueryContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to check ledger: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			return fmt.Errorf("failed to scan ledger check: %v", err)
+		}
+		*dest = append(*dest, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("failed to iterate ledger check: %v", err)
+	}
+
+	return nil
+}
@@ -1,25 +1,267 @@
 package db
 
 import (
+	"context"
 	"payment-gateway/internal/models/common"
 	"payment-gateway/internal/models/postgres"
+	"time"
 )
 
 // MockDB implements the DB interface for testing
 type MockDB struct {
-	GetSupportedGatewaysByCountryFunc func(countryID int) ([]*common.Gateway, error)
-	CreateTransactionFunc             func(tx *postgres.Transaction) error
-	UpdateTxStatusFunc                func(txID int64, status string) error
+	GetSupportedGatewaysByCountryFunc func(ctx context.Context, countryID int) ([]*common.Gateway, error)
+	GetGatewayByIDFunc                func(ctx context.Context, gatewayID int) (*common.Gateway, error)
+	GetGatewaysFunc                   func(ctx context.Context) ([]*common.Gateway, error)
+	CreateTransactionFunc             func(ctx context.Context, tx *postgres.Transaction, hold func(tx *postgres.Transaction) *postgres.JournalEntry) error
+	UpdateTxStatusFunc                func(ctx context.Context, change *postgres.TransactionStatusChange, allowedFrom []string, event *postgres.OutboxEvent, entry *postgres.JournalEntry) error
+	UpdateTxGatewayFunc               func(ctx context.Context, tx *postgres.Transaction) error
+	CreateTxAttemptFunc               func(ctx context.Context, attempt *postgres.TransactionAttempt) error
+	GetRoutingRulesFunc               func(ctx context.Context) ([]*common.RoutingRule, error)
+	CreateRoutingRuleFunc             func(ctx context.Context, rule *common.RoutingRule) error
+	GetUserSegmentFunc                func(ctx context.Context, userID int) (string, error)
+	GetGatewayFeesFunc                func(ctx context.Context, gatewayIDs []int) ([]*common.FeeSchedule, error)
+	GetCountryCurrenciesFunc          func(ctx context.Context, countryID int) ([]string, error)
+	ClaimIdempotencyKeyFunc           func(ctx context.Context, userID int, key, requestHash string, lockTimeout, ttl time.Duration) (bool, error)
+	GetIdempotencyKeyFunc             func(ctx context.Context, userID int, key string) (*postgres.IdempotencyKey, error)
+	CompleteIdempotencyKeyFunc        func(ctx context.Context, key *postgres.IdempotencyKey) error
+	DeleteIdempotencyKeyFunc          func(ctx context.Context, userID int, key string) error
+	GetPendingOutboxEventsFunc        func(ctx context.Context, limit int) ([]*postgres.OutboxEvent, error)
+	MarkOutboxEventPublishedFunc      func(ctx context.Context, id int64) error
+	MarkOutboxEventFailedFunc         func(ctx context.Context, id int64, reason string) error
+	WithAdvisoryLockFunc              func(ctx context.Context, key int64, fn func() error) (bool, error)
+	RecordConsumedEventFunc           func(ctx context.Context, event *postgres.ConsumedEvent) (bool, error)
+	GetTransactionFunc                func(ctx context.Context, id int64) (*postgres.Transaction, error)
+	ListTransactionsFunc              func(ctx context.Context, filter common.TransactionFilter) ([]*postgres.Transaction, error)
+	ClaimTransactionFunc              func(ctx context.Context, id int64) (bool, error)
+	GetUnsentTransactionsFunc         func(ctx context.Context, createdBefore time.Time, afterID int64, limit int) ([]*postgres.Transaction, error)
+	ClaimInboxCallbackFunc            func(ctx context.Context, cb *postgres.InboxCallback, lockTimeout time.Duration) (bool, error)
+	ReclaimInboxCallbackFunc          func(ctx context.Context, id int64, lockTimeout time.Duration) (*postgres.InboxCallback, error)
+	CompleteInboxCallbackFunc         func(ctx context.Context, cb *postgres.InboxCallback) error
+	ListInboxCallbacksFunc            func(ctx context.Context, filter common.CallbackFilter) ([]*postgres.InboxCallback, error)
+	CreateWebhookEndpointFunc         func(ctx context.Context, endpoint *common.WebhookEndpoint) error
+	GetWebhookEndpointsFunc           func(ctx context.Context) ([]*common.WebhookEndpoint, error)
+	SetWebhookEndpointEnabledFunc     func(ctx context.Context, id int, enabled bool, reason string) (*common.WebhookEndpoint, error)
+	ClaimWebhookDeliveriesFunc        func(ctx context.Context, limit int, lease time.Duration) ([]*postgres.WebhookDelivery, error)
+	CompleteWebhookAttemptFunc        func(ctx context.Context, delivery *postgres.WebhookDelivery, attempt *postgres.WebhookAttempt, disableAfter int) (bool, error)
+	GetWebhookDeliveryFunc            func(ctx context.Context, id int64) (*postgres.WebhookDelivery, error)
+	GetWebhookAttemptsFunc            func(ctx context.Context, deliveryID int64) ([]*postgres.WebhookAttempt, error)
+	ListWebhookDeliveriesFunc         func(ctx context.Context, filter common.WebhookDeliveryFilter) ([]*postgres.WebhookDelivery, error)
+	RedeliverWebhookFunc              func(ctx context.Context, id int64) (*postgres.WebhookDelivery, error)
+	GetLedgerAccountsFunc             func(ctx context.Context, ownerID int64, accountTypes ...string) ([]*postgres.LedgerAccount, error)
+	CheckLedgerFunc                   func(ctx context.Context) (*common.LedgerCheck, error)
+	CreateRefundFunc                  func(ctx context.Context, refund *postgres.Refund, hold func(refund *postgres.Refund) *postgres.JournalEntry) error
+	UpdateRefundStatusFunc            func(ctx context.Context, change *postgres.RefundStatusChange, allowedFrom []string, event *postgres.OutboxEvent, entry *postgres.JournalEntry) error
+	GetRefundFunc                     func(ctx context.Context, id int64) (*postgres.Refund, error)
+	GetRefundsFunc                    func(ctx context.Context, txID int64) ([]*postgres.Refund, error)
+	GetPendingRefundsFunc             func(ctx context.Context, createdBefore time.Time, limit int) ([]*postgres.Refund, error)
+	CreateDisputeFunc                 func(ctx context.Context, dispute *postgres.Dispute, event func(dispute *postgres.Dispute) (*postgres.OutboxEvent, error)) (bool, error)
+	UpdateDisputeStatusFunc           func(ctx context.Context, change *postgres.DisputeStatusChange, allowedFrom []string, event *postgres.OutboxEvent, entry *postgres.JournalEntry) error
+	GetDisputeFunc                    func(ctx context.Context, id int64) (*postgres.Dispute, error)
+	ListDisputesFunc                  func(ctx context.Context, filter common.DisputeFilter) ([]*postgres.Dispute, error)
+	GetDisputesDueFunc                func(ctx context.Context, dueBefore, remindedBefore time.Time, limit int) ([]*postgres.Dispute, error)
+	MarkDisputeRemindedFunc           func(ctx context.Context, id int64, remindedBefore time.Time, event *postgres.OutboxEvent) error
 }
 
-func (m *MockDB) GetSupportedGatewaysByCountry(countryID int) ([]*common.Gateway, error) {
-	return m.GetSupportedGatewaysByCountryFunc(countryID)
+func (m *MockDB) GetSupportedGatewaysByCountry(ctx context.Context, countryID int) ([]*common.Gateway, error) {
+	return m.GetSupportedGatewaysByCountryFunc(ctx, countryID)
 }
 
-func (m *MockDB) CreateTransaction(tx *postgres.Transaction) error {
-	return m.CreateTransactionFunc(tx)
+func (m *MockDB) GetGatewayByID(ctx context.Context, gatewayID int) (*common.Gateway, error) {
+	return m.GetGatewayByIDFunc(ctx, gatewayID)
 }
 
-func (m *MockDB) UpdateTxStatus(txID int64, status string) error {
-	return m.UpdateTxStatusFunc(txID, status)
+func (m *MockDB) GetGateways(ctx context.Context) ([]*common.Gateway, error) {
+	return m.GetGatewaysFunc(ctx)
+}
+
+func (m *MockDB) CreateTransaction(ctx context.Context, tx *postgres.Transaction, hold func(tx *postgres.Transaction) *postgres.JournalEntry) error {
+	return m.CreateTransactionFunc(ctx, tx, hold)
+}
+
+func (m *MockDB) UpdateTxStatus(ctx context.Context, change *postgres.TransactionStatusChange, allowedFrom []string, event *postgres.OutboxEvent, entry *postgres.JournalEntry) error {
+	return m.UpdateTxStatusFunc(ctx, change, allowedFrom, event, entry)
+}
+
+func (m *MockDB) UpdateTxGateway(ctx context.Context, tx *postgres.Transaction) error {
+	return m.UpdateTxGatewayFunc(ctx, tx)
+}
+
+func (m *MockDB) CreateTxAttempt(ctx context.Context, attempt *postgres.TransactionAttempt) error {
+	return m.CreateTxAttemptFunc(ctx, attempt)
+}
+
+func (m *MockDB) GetRoutingRules(ctx context.Context) ([]*common.RoutingRule, error) {
+	return m.GetRoutingRulesFunc(ctx)
+}
+
+func (m *MockDB) CreateRoutingRule(ctx context.Context, rule *common.RoutingRule) error {
+	return m.CreateRoutingRuleFunc(ctx, rule)
+}
+
+func (m *MockDB) GetUserSegment(ctx context.Context, userID int) (string, error) {
+	return m.GetUserSegmentFunc(ctx, userID)
+}
+
+func (m *MockDB) GetGatewayFees(ctx context.Context, gatewayIDs []int) ([]*common.FeeSchedule, error) {
+	return m.GetGatewayFeesFunc(ctx, gatewayIDs)
+}
+
+func (m *MockDB) GetCountryCurrencies(ctx context.Context, countryID int) ([]string, error) {
+	return m.GetCountryCurrenciesFunc(ctx, countryID)
+}
+
+func (m *MockDB) ClaimIdempotencyKey(ctx context.Context, userID int, key, requestHash string, lockTimeout, ttl time.Duration) (bool, error) {
+	return m.ClaimIdempotencyKeyFunc(ctx, userID, key, requestHash, lockTimeout, ttl)
+}
+
+func (m *MockDB) GetIdempotencyKey(ctx context.Context, userID int, key string) (*postgres.IdempotencyKey, error) {
+	return m.GetIdempotencyKeyFunc(ctx, userID, key)
+}
+
+func (m *MockDB) CompleteIdempotencyKey(ctx context.Context, key *postgres.IdempotencyKey) error {
+	return m.CompleteIdempotencyKeyFunc(ctx, key)
+}
+
+func (m *MockDB) DeleteIdempotencyKey(ctx context.Context, userID int, key string) error {
+	return m.DeleteIdempotencyKeyFunc(ctx, userID, key)
+}
+
+func (m *MockDB) GetPendingOutboxEvents(ctx context.Context, limit int) ([]*postgres.OutboxEvent, error) {
+	return m.GetPendingOutboxEventsFunc(ctx, limit)
+}
+
+func (m *MockDB) MarkOutboxEventPublished(ctx context.Context, id int64) error {
+	return m.MarkOutboxEventPublishedFunc(ctx, id)
+}
+
+func (m *MockDB) MarkOutboxEventFailed(ctx context.Context, id int64, reason string) error {
+	return m.MarkOutboxEventFailedFunc(ctx, id, reason)
+}
+
+func (m *MockDB) WithAdvisoryLock(ctx context.Context, key int64, fn func() error) (bool, error) {
+	return m.WithAdvisoryLockFunc(ctx, key, fn)
+}
+
+func (m *MockDB) RecordConsumedEvent(ctx context.Context, event *postgres.ConsumedEvent) (bool, error) {
+	return m.RecordConsumedEventFunc(ctx, event)
+}
+
+func (m *MockDB) GetTransaction(ctx context.Context, id int64) (*postgres.Transaction, error) {
+	return m.GetTransactionFunc(ctx, id)
+}
+
+func (m *MockDB) ListTransactions(ctx context.Context, filter common.TransactionFilter) ([]*postgres.Transaction, error) {
+	return m.ListTransactionsFunc(ctx, filter)
+}
+
+func (m *MockDB) ClaimTransaction(ctx context.Context, id int64) (bool, error) {
+	return m.ClaimTransactionFunc(ctx, id)
+}
+
+func (m *MockDB) GetUnsentTransactions(ctx context.Context, createdBefore time.Time, afterID int64, limit int) ([]*postgres.Transaction, error) {
+	return m.GetUnsentTransactionsFunc(ctx, createdBefore, afterID, limit)
+}
+
+func (m *MockDB) ClaimInboxCallback(ctx context.Context, cb *postgres.InboxCallback, lockTimeout time.Duration) (bool, error) {
+	return m.ClaimInboxCallbackFunc(ctx, cb, lockTimeout)
+}
+
+func (m *MockDB) ReclaimInboxCallback(ctx context.Context, id int64, lockTimeout time.Duration) (*postgres.InboxCallback, error) {
+	return m.ReclaimInboxCallbackFunc(ctx, id, lockTimeout)
+}
+
+func (m *MockDB) CompleteInboxCallback(ctx context.Context, cb *postgres.InboxCallback) error {
+	return m.CompleteInboxCallbackFunc(ctx, cb)
+}
+
+func (m *MockDB) ListInboxCallbacks(ctx context.Context, filter common.CallbackFilter) ([]*postgres.InboxCallback, error) {
+	return m.ListInboxCallbacksFunc(ctx, filter)
+}
+
+func (m *MockDB) CreateWebhookEndpoint(ctx context.Context, endpoint *common.WebhookEndpoint) error {
+	return m.CreateWebhookEndpointFunc(ctx, endpoint)
+}
+
+func (m *MockDB) GetWebhookEndpoints(ctx context.Context) ([]*common.WebhookEndpoint, error) {
+	return m.GetWebhookEndpointsFunc(ctx)
+}
+
+func (m *MockDB) SetWebhookEndpointEnabled(ctx context.Context, id int, enabled bool, reason string) (*common.WebhookEndpoint, error) {
+	return m.SetWebhookEndpointEnabledFunc(ctx, id, enabled, reason)
+}
+
+func (m *MockDB) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*postgres.WebhookDelivery, error) {
+	return m.ClaimWebhookDeliveriesFunc(ctx, limit, lease)
+}
+
+func (m *MockDB) CompleteWebhookAttempt(ctx context.Context, delivery *postgres.WebhookDelivery, attempt *postgres.WebhookAttempt, disableAfter int) (bool, error) {
+	return m.CompleteWebhookAttemptFunc(ctx, delivery, attempt, disableAfter)
+}
+
+func (m *MockDB) GetWebhookDelivery(ctx context.Context, id int64) (*postgres.WebhookDelivery, error) {
+	return m.GetWebhookDeliveryFunc(ctx, id)
+}
+
+func (m *MockDB) GetWebhookAttempts(ctx context.Context, deliveryID int64) ([]*postgres.WebhookAttempt, error) {
+	return m.GetWebhookAttemptsFunc(ctx, deliveryID)
+}
+
+func (m *MockDB) ListWebhookDeliveries(ctx context.Context, filter common.WebhookDeliveryFilter) ([]*postgres.WebhookDelivery, error) {
+	return m.ListWebhookDeliveriesFunc(ctx, filter)
+}
+
+func (m *MockDB) RedeliverWebhook(ctx context.Context, id int64) (*postgres.WebhookDelivery, error) {
+	return m.RedeliverWebhookFunc(ctx, id)
+}
+
+func (m *MockDB) GetLedgerAccounts(ctx context.Context, ownerID int64, accountTypes ...string) ([]*postgres.LedgerAccount, error) {
+	return m.GetLedgerAccountsFunc(ctx, ownerID, accountTypes...)
+}
+
+func (m *MockDB) CheckLedger(ctx context.Context) (*common.LedgerCheck, error) {
+	return m.CheckLedgerFunc(ctx)
+}
+
+func (m *MockDB) CreateRefund(ctx context.Context, refund *postgres.Refund, hold func(refund *postgres.Refund) *postgres.JournalEntry) error {
+	return m.CreateRefundFunc(ctx, refund, hold)
+}
+
+func (m *MockDB) UpdateRefundStatus(ctx context.Context, change *postgres.RefundStatusChange, allowedFrom []string, event *postgres.OutboxEvent, entry *postgres.JournalEntry) error {
+	return m.UpdateRefundStatusFunc(ctx, change, allowedFrom, event, entry)
+}
+
+func (m *MockDB) GetRefund(ctx context.Context, id int64) (*postgres.Refund, error) {
+	return m.GetRefundFunc(ctx, id)
+}
+
+func (m *MockDB) GetRefunds(ctx context.Context, txID int64) ([]*postgres.Refund, error) {
+	return m.GetRefundsFunc(ctx, txID)
+}
+
+func (m *MockDB) GetPendingRefunds(ctx context.Context, createdBefore time.Time, limit int) ([]*postgres.Refund, error) {
+	return m.GetPendingRefundsFunc(ctx, createdBefore, limit)
+}
+
+func (m *MockDB) CreateDispute(ctx context.Context, dispute *postgres.Dispute, event func(dispute *postgres.Dispute) (*postgres.OutboxEvent, error)) (bool, error) {
+	return m.CreateDisputeFunc(ctx, dispute, event)
+}
+
+func (m *MockDB) UpdateDisputeStatus(ctx context.Context, change *postgres.DisputeStatusChange, allowedFrom []string, event *postgres.OutboxEvent, entry *postgres.JournalEntry) error {
+	return m.UpdateDisputeStatusFunc(ctx, change, allowedFrom, event, entry)
+}
+
+func (m *MockDB) GetDispute(ctx context.Context, id int64) (*postgres.Dispute, error) {
+	return m.GetDisputeFunc(ctx, id)
+}
+
+func (m *MockDB) ListDisputes(ctx context.Context, filter common.DisputeFilter) ([]*postgres.Dispute, error) {
+	return m.ListDisputesFunc(ctx, filter)
+}
+
+func (m *MockDB) GetDisputesDue(ctx context.Context, dueBefore, remindedBefore time.Time, limit int) ([]*postgres.Dispute, error) {
+	return m.GetDisputesDueFunc(ctx, dueBefore, remindedBefore, limit)
+}
+
+func (m *MockDB) MarkDisputeReminded(ctx context.Context, id int64, remindedBefore time.Time, event *postgres.OutboxEvent) error {
+	return m.MarkDisputeRemindedFunc(ctx, id, remindedBefore, event)
 }
@@ -0,0 +1,146 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"payment-gateway/internal/models/postgres"
+	"time"
+)
+
+// Advisory lock keys, held by the background jobs that must run on a single instance at a time
+const (
+	OutboxRelayLock int64 = 1001
+)
+
+func insertOutboxEvent(ctx context.Context, sqlTx *sql.Tx, event *postgres.OutboxEvent) error {
+	query := `INSERT INTO outbox_events (aggregate_id, event_type, payload, content_type, created_at)
+			  VALUES ($1, $2, $3, $4, $5) RETURNING id`
+
+	event.CreatedAt = time.Now()
+	err := sqlTx.QueryRowContext(ctx, query, event.AggregateID, event.EventType, event.Payload, event.ContentType, event.CreatedAt).Scan(&event.ID)
+	if err != nil {
+		return fmt.Errorf("failed to insert outbox event: %v", err)
+	}
+
+	return enqueueWebhookDeliveries(ctx, sqlTx, event)
+}
+
+// GetPendingOutboxEvents returns the oldest unpublished events, in the order they were written
+func (d *DB) GetPendingOutboxEvents(ctx context.Context, limit int) ([]*postgres.OutboxEvent, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT id, aggregate_id, event_type, payload, content_type, attempts, COALESCE(last_error, ''), created_at
+		FROM outbox_events
+		WHERE published_at IS NULL
+		ORDER BY id
+		LIMIT $1
+	`
+
+	rows, err := d.db.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch outbox events: %v", err)
+	}
+	defer rows.Close()
+
+	var events []*postgres.OutboxEvent
+	for rows.Next() {
+		var e postgres.OutboxEvent
+		if err = rows.Scan(&e.ID, &e.AggregateID, &e.EventType, &e.Payload, &e.ContentType, &e.Attempts, &e.LastError, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan outbox event: %v", err)
+		}
+		events = append(events, &e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over rows: %v", err)
+	}
+
+	return events, nil
+}
+
+func (d *DB) MarkOutboxEventPublished(ctx context.Context, id int64) error {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	query := `UPDATE outbox_events SET published_at = $1, attempts = attempts + 1 WHERE id = $2`
+	_, err := d.db.ExecContext(ctx, query, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("failed to mark outbox event as published: %v", err)
+	}
+
+	return nil
+}
+
+func (d *DB) MarkOutboxEventFailed(ctx context.Context, id int64, reason string) error {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	query := `UPDATE outbox_events SET attempts = attempts + 1, last_error = $1 WHERE id = $2`
+	_, err := d.db.ExecContext(ctx, query, reason, id)
+	if err != nil {
+		return fmt.Errorf("failed to mark outbox event as failed: %v", err)
+	}
+
+	return nil
+}
+
+// WithAdvisoryLock runs fn while holding the Postgres advisory lock key, and reports false without running it when
+// another session holds the lock. The lock is taken on a dedicated connection, since it belongs to the session. Only
+// taking and releasing the lock are bounded by the DB timeout, fn may hold it longer.
+func (d *DB) WithAdvisoryLock(ctx context.Context, key int64, fn func() error) (bool, error) {
+	conn, err := d.db.Conn(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to get connection: %v", err)
+	}
+	defer conn.Close()
+
+	lockCtx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	var locked bool
+	if err = conn.QueryRowContext(lockCtx, `SELECT pg_try_advisory_lock($1)`, key).Scan(&locked); err != nil {
+		return false, fmt.Errorf("failed to take advisory lock %d: %v", key, err)
+	}
+	if !locked {
+		return false, nil
+	}
+
+	defer func() {
+		// released even when ctx is cancelled, the lock would otherwise stay with the pooled connection
+		unlockCtx, cancel := d.withTimeout(context.Background())
+		defer cancel()
+
+		if _, err := conn.ExecContext(unlockCtx, `SELECT pg_advisory_unlock($1)`, key); err != nil {
+			log.Printf("failed to release advisory lock %d: %v", key, err)
+		}
+	}()
+
+	return true, fn()
+}
+
+// RecordConsumedEvent stores an event handled by the consumer and reports false when it was already stored, which
+// happens when Kafka redelivers it or the relay published it twice
+func (d *DB) RecordConsumedEvent(ctx context.Context, event *postgres.ConsumedEvent) (bool, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	query := `INSERT INTO consumed_events (event_id, transaction_id, event_type, status, payload, occurred_at, consumed_at)
+			  VALUES ($1, $2, $3, $4, $5, $6, $7) ON CONFLICT (event_id) DO NOTHING`
+
+	event.ConsumedAt = time.Now()
+	res, err := d.db.ExecContext(ctx, query, event.EventID, event.TransactionID, event.EventType, event.Status, event.Payload, event.OccurredAt, event.ConsumedAt)
+	if err != nil {
+		return false, fmt.Errorf("failed to insert consumed event: %v", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to insert consumed event: %v", err)
+	}
+
+	return n == 1, nil
+}
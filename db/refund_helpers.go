@@ -0,0 +1,226 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"payment-gateway/internal/models/postgres"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const refundColumns = `id, transaction_id, user_id, gateway_id, amount, currency, status, COALESCE(reason, ''),
+	COALESCE(provider_ref, ''), created_at, updated_at`
+
+// CreateRefund stores a refund of refund.TransactionID along with the entry returned by hold, if any, in one DB
+// transaction. The tx is locked meanwhile, so concurrent refunds cannot together refund more than its amount: the
+// refunds of a tx that did not fail, along with its disputes not won, must not exceed its amount, otherwise the
+// returned error wraps ErrRefundExceedsAmount. A refund without an amount refunds what is left. The user, gateway and currency of the
+// refund are those of the tx.
+func (d *DB) CreateRefund(ctx context.Context, refund *postgres.Refund, hold func(refund *postgres.Refund) *postgres.JournalEntry) error {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	sqlTx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer sqlTx.Rollback()
+
+	var amount int64
+	query := `SELECT amount, currency, user_id, COALESCE(gateway_id, 0) FROM transactions WHERE id = $1 FOR UPDATE`
+	err = sqlTx.QueryRowContext(ctx, query, refund.TransactionID).Scan(&amount, &refund.Currency, &refund.UserID, &refund.GatewayID)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("transaction %d %w", refund.TransactionID, ErrNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch transaction: %v", err)
+	}
+
+	// the amount a dispute took back from the user cannot be refunded as well
+	var refunded int64
+	query = `
+		SELECT
+			(SELECT COALESCE(SUM(amount), 0) FROM refunds WHERE transaction_id = $1 AND status <> $2) +
+			(SELECT COALESCE(SUM(amount), 0) FROM disputes WHERE transaction_id = $1 AND status <> $3)
+	`
+	if err = sqlTx.QueryRowContext(ctx, query, refund.TransactionID, postgres.RefundFailed, postgres.DisputeWon).Scan(&refunded); err != nil {
+		return fmt.Errorf("failed to sum refunds and disputes: %v", err)
+	}
+
+	if refund.Amount == 0 {
+		refund.Amount = amount - refunded
+	}
+	if refund.Amount <= 0 || refunded+refund.Amount > amount {
+		return fmt.Errorf("transaction %d has %d of %d %s left to refund: %w", refund.TransactionID, amount-refunded, amount, refund.Currency, ErrRefundExceedsAmount)
+	}
+
+	now := time.Now()
+	query = `
+		INSERT INTO refunds (transaction_id, user_id, gateway_id, amount, currency, status, reason, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), $8, $8)
+		RETURNING id
+	`
+	err = sqlTx.QueryRowContext(ctx, query, refund.TransactionID, refund.UserID, refund.GatewayID, refund.Amount, refund.Currency,
+		refund.Status, refund.Reason, now).Scan(&refund.ID)
+	if err != nil {
+		return fmt.Errorf("failed to insert refund: %v", err)
+	}
+
+	if hold != nil {
+		if err = insertJournalEntry(ctx, sqlTx, hold(refund)); err != nil {
+			return err
+		}
+	}
+
+	if err = sqlTx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	refund.CreatedAt, refund.UpdatedAt = now, now
+
+	return nil
+}
+
+// UpdateRefundStatus moves the refund to change.ToStatus only if its current status is one of allowedFrom, and writes
+// the optional outbox event and ledger entry with it, all in one DB transaction. change.FromStatus is set to the status
+// found; when it is not allowed the returned error wraps ErrStatusConflict.
+func (d *DB) UpdateRefundStatus(ctx context.Context, change *postgres.RefundStatusChange, allowedFrom []string, event *postgres.OutboxEvent, entry *postgres.JournalEntry) error {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		UPDATE refunds r SET status = $1, reason = NULLIF($2, ''), provider_ref = COALESCE(NULLIF($3, ''), r.provider_ref), updated_at = $4
+		FROM (SELECT id, status FROM refunds WHERE id = $5 FOR UPDATE) prev
+		WHERE r.id = prev.id AND prev.status = ANY($6)
+		RETURNING prev.status
+	`
+
+	sqlTx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer sqlTx.Rollback()
+
+	err = sqlTx.QueryRowContext(ctx, query, change.ToStatus, change.Reason, change.ProviderRef, time.Now(), change.RefundID,
+		pq.Array(allowedFrom)).Scan(&change.FromStatus)
+	if err == sql.ErrNoRows {
+		err = sqlTx.QueryRowContext(ctx, `SELECT status FROM refunds WHERE id = $1`, change.RefundID).Scan(&change.FromStatus)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("refund %d %w", change.RefundID, ErrNotFound)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to fetch refund status: %v", err)
+		}
+
+		return fmt.Errorf("refund %d is %s: %w", change.RefundID, change.FromStatus, ErrStatusConflict)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update refund status: %v", err)
+	}
+
+	if event != nil {
+		if err = insertOutboxEvent(ctx, sqlTx, event); err != nil {
+			return err
+		}
+	}
+
+	if entry != nil {
+		if err = insertJournalEntry(ctx, sqlTx, entry); err != nil {
+			return err
+		}
+	}
+
+	if err = sqlTx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	return nil
+}
+
+// GetRefund fetches a refund by ID
+func (d *DB) GetRefund(ctx context.Context, id int64) (*postgres.Refund, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT ` + refundColumns + ` FROM refunds WHERE id = $1`
+
+	refund, err := scanRefund(d.db.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("refund %d %w", id, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch refund: %v", err)
+	}
+
+	return refund, nil
+}
+
+// GetRefunds returns the refunds of a tx, oldest first
+func (d *DB) GetRefunds(ctx context.Context, txID int64) ([]*postgres.Refund, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT ` + refundColumns + ` FROM refunds WHERE transaction_id = $1 ORDER BY id`
+
+	rows, err := d.db.QueryContext(ctx, query, txID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query refunds: %v", err)
+	}
+	defer rows.Close()
+
+	var refunds []*postgres.Refund
+	for rows.Next() {
+		refund, err := scanRefund(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan refund: %v", err)
+		}
+		refunds = append(refunds, refund)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate refunds: %v", err)
+	}
+
+	return refunds, nil
+}
+
+// GetPendingRefunds returns up to limit refunds created before createdBefore and still pending, oldest first
+func (d *DB) GetPendingRefunds(ctx context.Context, createdBefore time.Time, limit int) ([]*postgres.Refund, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT ` + refundColumns + ` FROM refunds WHERE status = $1 AND created_at < $2 ORDER BY created_at, id LIMIT $3`
+
+	rows, err := d.db.QueryContext(ctx, query, postgres.RefundPending, createdBefore, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query pending refunds: %v", err)
+	}
+	defer rows.Close()
+
+	var refunds []*postgres.Refund
+	for rows.Next() {
+		refund, err := scanRefund(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan refund: %v", err)
+		}
+		refunds = append(refunds, refund)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate refunds: %v", err)
+	}
+
+	return refunds, nil
+}
+
+func scanRefund(row scanner) (*postgres.Refund, error) {
+	var r postgres.Refund
+	err := row.Scan(&r.ID, &r.TransactionID, &r.UserID, &r.GatewayID, &r.Amount, &r.Currency, &r.Status, &r.Reason,
+		&r.ProviderRef, &r.CreatedAt, &r.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
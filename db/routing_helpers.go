@@ -0,0 +1,141 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"payment-gateway/internal/models/common"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func (d *DB) GetRoutingRules(ctx context.Context) ([]*common.RoutingRule, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT id, name, priority, country_id, currencies, min_amount, max_amount, transaction_type, user_segments, gateway_ids, enabled
+		FROM routing_rules
+		WHERE enabled
+		ORDER BY priority, id
+	`
+
+	rows, err := d.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch routing rules: %v", err)
+	}
+	defer rows.Close()
+
+	var rules []*common.RoutingRule
+	for rows.Next() {
+		var (
+			rule                 common.RoutingRule
+			countryID            sql.NullInt64
+			minAmount, maxAmount sql.NullInt64
+			txType               sql.NullString
+			gatewayIDs           pq.Int64Array
+		)
+
+		err = rows.Scan(&rule.ID, &rule.Name, &rule.Priority, &countryID, pq.Array(&rule.Currencies), &minAmount, &maxAmount,
+			&txType, pq.Array(&rule.UserSegments), &gatewayIDs, &rule.Enabled)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan routing rule: %v", err)
+		}
+
+		rule.CountryID = int(countryID.Int64)
+		rule.TransactionType = txType.String
+		if minAmount.Valid {
+			rule.MinAmount = &minAmount.Int64
+		}
+		if maxAmount.Valid {
+			rule.MaxAmount = &maxAmount.Int64
+		}
+		for _, id := range gatewayIDs {
+			rule.GatewayIDs = append(rule.GatewayIDs, int(id))
+		}
+
+		rules = append(rules, &rule)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over rows: %v", err)
+	}
+
+	return rules, nil
+}
+
+func (d *DB) CreateRoutingRule(ctx context.Context, rule *common.RoutingRule) error {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	query := `INSERT INTO routing_rules (name, priority, country_id, currencies, min_amount, max_amount, transaction_type, user_segments, gateway_ids, enabled, created_at, updated_at)
+			  VALUES ($1, $2, NULLIF($3, 0), $4, $5, $6, NULLIF($7, ''), $8, $9, $10, $11, $11) RETURNING id`
+
+	gatewayIDs := make(pq.Int64Array, 0, len(rule.GatewayIDs))
+	for _, id := range rule.GatewayIDs {
+		gatewayIDs = append(gatewayIDs, int64(id))
+	}
+
+	err := d.db.QueryRowContext(ctx, query, rule.Name, rule.Priority, rule.CountryID, pq.Array(rule.Currencies), rule.MinAmount, rule.MaxAmount,
+		rule.TransactionType, pq.Array(rule.UserSegments), gatewayIDs, rule.Enabled, time.Now()).Scan(&rule.ID)
+	if err != nil {
+		return fmt.Errorf("failed to insert routing rule: %v", err)
+	}
+	return nil
+}
+
+func (d *DB) GetUserSegment(ctx context.Context, userId int) (string, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT COALESCE(segment, '') FROM users WHERE id = $1`
+
+	var segment string
+	err := d.db.QueryRowContext(ctx, query, userId).Scan(&segment)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch segment of user %d: %v", userId, err)
+	}
+
+	return segment, nil
+}
+
+func (d *DB) GetGatewayFees(ctx context.Context, gatewayIds []int) ([]*common.FeeSchedule, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT gateway_id, COALESCE(currency, ''), COALESCE(transaction_type, ''), fixed_fee, percentage_fee
+		FROM gateway_fees
+		WHERE gateway_id = ANY($1)
+	`
+
+	ids := make(pq.Int64Array, 0, len(gatewayIds))
+	for _, id := range gatewayIds {
+		ids = append(ids, int64(id))
+	}
+
+	rows, err := d.db.QueryContext(ctx, query, ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch gateway fees: %v", err)
+	}
+	defer rows.Close()
+
+	var fees []*common.FeeSchedule
+	for rows.Next() {
+		var fee common.FeeSchedule
+		if err = rows.Scan(&fee.GatewayID, &fee.Currency, &fee.TransactionType, &fee.FixedFee, &fee.PercentageFee); err != nil {
+			return nil, fmt.Errorf("failed to scan gateway fee: %v", err)
+		}
+		fees = append(fees, &fee)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over rows: %v", err)
+	}
+
+	return fees, nil
+}
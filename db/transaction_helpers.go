@@ -0,0 +1,181 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"payment-gateway/internal/models/common"
+	"payment-gateway/internal/models/postgres"
+	"strings"
+	"time"
+)
+
+const transactionColumns = `id, amount, currency, type, status, user_id, COALESCE(gateway_id, 0), country_id,
+	COALESCE(provider_ref, ''), COALESCE(fee, 0), created_at`
+
+// GetTransaction fetches a tx by ID
+func (d *DB) GetTransaction(ctx context.Context, id int64) (*postgres.Transaction, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT ` + transactionColumns + ` FROM transactions WHERE id = $1`
+
+	tx, err := scanTransaction(d.db.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("transaction %d %w", id, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch transaction: %v", err)
+	}
+
+	return tx, nil
+}
+
+// ListTransactions returns up to filter.Limit txs matching the filter. Pages are read with keyset pagination on the
+// sort column and the ID, so listing stays cheap and stable however deep the page is.
+func (d *DB) ListTransactions(ctx context.Context, filter common.TransactionFilter) ([]*postgres.Transaction, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	var conds []string
+	var args []interface{}
+	where := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+
+	if filter.UserID != 0 {
+		where("user_id = $%d", filter.UserID)
+	}
+	if filter.Status != "" {
+		where("status = $%d", filter.Status)
+	}
+	if filter.Type != "" {
+		where("type = $%d", filter.Type)
+	}
+	if filter.GatewayID != 0 {
+		where("gateway_id = $%d", filter.GatewayID)
+	}
+	if filter.CountryID != 0 {
+		where("country_id = $%d", filter.CountryID)
+	}
+	if filter.Currency != "" {
+		where("currency = $%d", filter.Currency)
+	}
+	if filter.From != nil {
+		where("created_at >= $%d", *filter.From)
+	}
+	if filter.To != nil {
+		where("created_at < $%d", *filter.To)
+	}
+
+	// the sort column and order are validated by the caller, they are never taken from the request as is
+	column, order, cmp := "created_at", "DESC", "<"
+	if filter.SortBy == "amount" {
+		column = "amount"
+	}
+	if filter.SortOrder == "asc" {
+		order, cmp = "ASC", ">"
+	}
+
+	if filter.After != nil {
+		var value interface{} = filter.After.CreatedAt
+		if column == "amount" {
+			value = filter.After.Amount
+		}
+		args = append(args, value, filter.After.ID)
+		conds = append(conds, fmt.Sprintf("(%s, id) %s ($%d, $%d)", column, cmp, len(args)-1, len(args)))
+	}
+
+	query := `SELECT ` + transactionColumns + ` FROM transactions`
+	if len(conds) > 0 {
+		query += ` WHERE ` + strings.Join(conds, " AND ")
+	}
+	args = append(args, filter.Limit)
+	query += fmt.Sprintf(` ORDER BY %s %s, id %s LIMIT $%d`, column, order, order, len(args))
+
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query transactions: %v", err)
+	}
+	defer rows.Close()
+
+	var txs []*postgres.Transaction
+	for rows.Next() {
+		tx, err := scanTransaction(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan transaction: %v", err)
+		}
+		txs = append(txs, tx)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read transactions: %v", err)
+	}
+
+	return txs, nil
+}
+
+// ClaimTransaction marks a pending tx as being sent to its gateways, it reports false when the tx is no longer pending or
+// was already claimed, e.g. by a worker of another instance that queued it too
+func (d *DB) ClaimTransaction(ctx context.Context, id int64) (bool, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	query := `UPDATE transactions SET submitted_at = $1 WHERE id = $2 AND status = 'pending' AND submitted_at IS NULL`
+
+	res, err := d.db.ExecContext(ctx, query, time.Now(), id)
+	if err != nil {
+		return false, fmt.Errorf("failed to claim transaction %d: %v", id, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to claim transaction %d: %v", id, err)
+	}
+
+	return n == 1, nil
+}
+
+// GetUnsentTransactions returns up to limit pending txs created before createdBefore that no worker claimed yet, by
+// ascending ID after afterID
+func (d *DB) GetUnsentTransactions(ctx context.Context, createdBefore time.Time, afterID int64, limit int) ([]*postgres.Transaction, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT ` + transactionColumns + ` FROM transactions
+		WHERE status = 'pending' AND submitted_at IS NULL AND created_at < $1 AND id > $2
+		ORDER BY id LIMIT $3`
+
+	rows, err := d.db.QueryContext(ctx, query, createdBefore, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query unsent transactions: %v", err)
+	}
+	defer rows.Close()
+
+	var txs []*postgres.Transaction
+	for rows.Next() {
+		tx, err := scanTransaction(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan transaction: %v", err)
+		}
+		txs = append(txs, tx)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read transactions: %v", err)
+	}
+
+	return txs, nil
+}
+
+func scanTransaction(row scanner) (*postgres.Transaction, error) {
+	var tx postgres.Transaction
+	err := row.Scan(&tx.ID, &tx.Amount, &tx.Currency, &tx.Type, &tx.Status, &tx.UserID, &tx.GatewayID, &tx.CountryID,
+		&tx.ProviderRef, &tx.Fee, &tx.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tx, nil
+}
@@ -0,0 +1,352 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"payment-gateway/internal/models/common"
+	"payment-gateway/internal/models/postgres"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const webhookEndpointColumns = `id, COALESCE(owner_id, 0), url, secret, event_types, enabled, consecutive_failures, COALESCE(disabled_reason, ''),
+	disabled_at, created_at`
+
+const webhookDeliveryColumns = `id, endpoint_id, event_id, transaction_id, event_type, payload, content_type, status,
+	attempts, COALESCE(last_error, ''), next_attempt_at, created_at, delivered_at`
+
+// enqueueWebhookDeliveries queues the outbox event for every enabled webhook endpoint of the tx's user subscribed to
+// its type, in the DB transaction writing the event so that no status change is missed
+func enqueueWebhookDeliveries(ctx context.Context, sqlTx *sql.Tx, event *postgres.OutboxEvent) error {
+	query := `
+		INSERT INTO webhook_deliveries (endpoint_id, event_id, transaction_id, event_type, payload, content_type, status, next_attempt_at, created_at)
+		SELECT we.id, $1, $2, $3, $4, $5, $6, $7, $7
+		FROM webhook_endpoints we
+		INNER JOIN transactions t ON t.id = $2
+		WHERE we.enabled AND we.owner_id = t.user_id AND (cardinality(we.event_types) = 0 OR $3 = ANY(we.event_types))
+	`
+
+	_, err := sqlTx.ExecContext(ctx, query, event.ID, event.AggregateID, event.EventType, event.Payload, event.ContentType,
+		postgres.WebhookPending, event.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to queue webhook deliveries: %v", err)
+	}
+
+	return nil
+}
+
+func (d *DB) CreateWebhookEndpoint(ctx context.Context, endpoint *common.WebhookEndpoint) error {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		INSERT INTO webhook_endpoints (owner_id, url, secret, event_types, enabled, created_at)
+		VALUES ($1, $2, $3, COALESCE($4, '{}'::TEXT[]), TRUE, $5)
+		RETURNING id
+	`
+
+	now := time.Now()
+	err := d.db.QueryRowContext(ctx, query, endpoint.OwnerID, endpoint.URL, endpoint.Secret, pq.Array(endpoint.EventTypes), now).Scan(&endpoint.ID)
+	if err != nil {
+		return fmt.Errorf("failed to insert webhook endpoint: %v", err)
+	}
+	endpoint.Enabled, endpoint.CreatedAt = true, now
+
+	return nil
+}
+
+// GetWebhookEndpoints returns all the webhook endpoints, secrets included
+func (d *DB) GetWebhookEndpoints(ctx context.Context) ([]*common.WebhookEndpoint, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(ctx, `SELECT `+webhookEndpointColumns+` FROM webhook_endpoints ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query webhook endpoints: %v", err)
+	}
+	defer rows.Close()
+
+	var endpoints []*common.WebhookEndpoint
+	for rows.Next() {
+		endpoint, err := scanWebhookEndpoint(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan webhook endpoint: %v", err)
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read webhook endpoints: %v", err)
+	}
+
+	return endpoints, nil
+}
+
+// SetWebhookEndpointEnabled enables or disables an endpoint. Enabling it clears its failures, the deliveries left
+// pending while it was disabled are then sent.
+func (d *DB) SetWebhookEndpointEnabled(ctx context.Context, id int, enabled bool, reason string) (*common.WebhookEndpoint, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		UPDATE webhook_endpoints SET enabled = TRUE, consecutive_failures = 0, disabled_reason = NULL, disabled_at = NULL
+		WHERE id = $1
+		RETURNING ` + webhookEndpointColumns
+	args := []interface{}{id}
+	if !enabled {
+		query = `
+			UPDATE webhook_endpoints SET enabled = FALSE, disabled_reason = $2, disabled_at = $3
+			WHERE id = $1
+			RETURNING ` + webhookEndpointColumns
+		args = append(args, reason, time.Now())
+	}
+
+	endpoint, err := scanWebhookEndpoint(d.db.QueryRowContext(ctx, query, args...))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("webhook endpoint %d %w", id, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update webhook endpoint: %v", err)
+	}
+
+	return endpoint, nil
+}
+
+// ClaimWebhookDeliveries returns up to limit pending deliveries due now whose endpoint is enabled, and pushes their
+// next attempt back by lease so that no other dispatcher picks them up while they are being sent. A delivery whose
+// dispatcher died is picked up again once the lease expires.
+func (d *DB) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*postgres.WebhookDelivery, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		UPDATE webhook_deliveries SET next_attempt_at = $2
+		WHERE id IN (
+			SELECT wd.id
+			FROM webhook_deliveries wd
+			INNER JOIN webhook_endpoints we ON we.id = wd.endpoint_id
+			WHERE wd.status = $3 AND wd.next_attempt_at <= $1 AND we.enabled
+			ORDER BY wd.next_attempt_at, wd.id
+			LIMIT $4
+			FOR UPDATE OF wd SKIP LOCKED
+		)
+		RETURNING ` + webhookDeliveryColumns
+
+	now := time.Now()
+	rows, err := d.db.QueryContext(ctx, query, now, now.Add(lease), postgres.WebhookPending, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim webhook deliveries: %v", err)
+	}
+	defer rows.Close()
+
+	return scanWebhookDeliveries(rows)
+}
+
+// CompleteWebhookAttempt records an attempt of a delivery along with the new state of the delivery, and counts the
+// consecutive failures of its endpoint. The endpoint is disabled once it failed disableAfter times in a row, which
+// is reported by the returned bool.
+func (d *DB) CompleteWebhookAttempt(ctx context.Context, delivery *postgres.WebhookDelivery, attempt *postgres.WebhookAttempt, disableAfter int) (bool, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	sqlTx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer sqlTx.Rollback()
+
+	attempt.CreatedAt = time.Now()
+	query := `INSERT INTO webhook_attempts (delivery_id, attempt, status_code, error, duration_ms, created_at)
+			  VALUES ($1, $2, NULLIF($3, 0), NULLIF($4, ''), $5, $6) RETURNING id`
+	err = sqlTx.QueryRowContext(ctx, query, delivery.ID, attempt.Attempt, attempt.StatusCode, attempt.Error, attempt.DurationMs, attempt.CreatedAt).Scan(&attempt.ID)
+	if err != nil {
+		return false, fmt.Errorf("failed to insert webhook attempt: %v", err)
+	}
+
+	query = `UPDATE webhook_deliveries SET status = $2, attempts = $3, last_error = NULLIF($4, ''), next_attempt_at = $5, delivered_at = $6 WHERE id = $1`
+	_, err = sqlTx.ExecContext(ctx, query, delivery.ID, delivery.Status, delivery.Attempts, delivery.LastError, delivery.NextAttemptAt, delivery.DeliveredAt)
+	if err != nil {
+		return false, fmt.Errorf("failed to update webhook delivery: %v", err)
+	}
+
+	disabled := false
+	if attempt.Error == "" {
+		_, err = sqlTx.ExecContext(ctx, `UPDATE webhook_endpoints SET consecutive_failures = 0 WHERE id = $1`, delivery.EndpointID)
+	} else {
+		query = `
+			UPDATE webhook_endpoints
+			SET consecutive_failures = consecutive_failures + 1,
+			    enabled = enabled AND ($2 <= 0 OR consecutive_failures + 1 < $2),
+			    disabled_reason = CASE WHEN enabled AND $2 > 0 AND consecutive_failures + 1 >= $2 THEN $3 ELSE disabled_reason END,
+			    disabled_at = CASE WHEN enabled AND $2 > 0 AND consecutive_failures + 1 >= $2 THEN $4 ELSE disabled_at END
+			WHERE id = $1
+			RETURNING COALESCE(disabled_at = $4, FALSE)
+		`
+		reason := fmt.Sprintf("disabled after %d consecutive failures, last: %s", disableAfter, attempt.Error)
+		err = sqlTx.QueryRowContext(ctx, query, delivery.EndpointID, disableAfter, reason, attempt.CreatedAt).Scan(&disabled)
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to update webhook endpoint: %v", err)
+	}
+
+	if err = sqlTx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	return disabled, nil
+}
+
+func (d *DB) GetWebhookDelivery(ctx context.Context, id int64) (*postgres.WebhookDelivery, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT ` + webhookDeliveryColumns + ` FROM webhook_deliveries WHERE id = $1`
+
+	delivery, err := scanWebhookDelivery(d.db.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("webhook delivery %d %w", id, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch webhook delivery: %v", err)
+	}
+
+	return delivery, nil
+}
+
+// GetWebhookAttempts returns the attempts of a delivery, oldest first
+func (d *DB) GetWebhookAttempts(ctx context.Context, deliveryID int64) ([]*postgres.WebhookAttempt, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT id, delivery_id, attempt, COALESCE(status_code, 0), COALESCE(error, ''), duration_ms, created_at
+		FROM webhook_attempts
+		WHERE delivery_id = $1
+		ORDER BY id
+	`
+
+	rows, err := d.db.QueryContext(ctx, query, deliveryID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query webhook attempts: %v", err)
+	}
+	defer rows.Close()
+
+	var attempts []*postgres.WebhookAttempt
+	for rows.Next() {
+		var a postgres.WebhookAttempt
+		if err = rows.Scan(&a.ID, &a.DeliveryID, &a.Attempt, &a.StatusCode, &a.Error, &a.DurationMs, &a.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan webhook attempt: %v", err)
+		}
+		attempts = append(attempts, &a)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read webhook attempts: %v", err)
+	}
+
+	return attempts, nil
+}
+
+// ListWebhookDeliveries returns up to filter.Limit deliveries matching the filter, newest first
+func (d *DB) ListWebhookDeliveries(ctx context.Context, filter common.WebhookDeliveryFilter) ([]*postgres.WebhookDelivery, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	var conds []string
+	var args []interface{}
+	where := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+
+	if filter.EndpointID != 0 {
+		where("endpoint_id = $%d", filter.EndpointID)
+	}
+	if filter.TransactionID != 0 {
+		where("transaction_id = $%d", filter.TransactionID)
+	}
+	if filter.Status != "" {
+		where("status = $%d", filter.Status)
+	}
+	if filter.BeforeID != 0 {
+		where("id < $%d", filter.BeforeID)
+	}
+
+	query := `SELECT ` + webhookDeliveryColumns + ` FROM webhook_deliveries`
+	if len(conds) > 0 {
+		query += ` WHERE ` + strings.Join(conds, " AND ")
+	}
+	args = append(args, filter.Limit)
+	query += fmt.Sprintf(` ORDER BY id DESC LIMIT $%d`, len(args))
+
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query webhook deliveries: %v", err)
+	}
+	defer rows.Close()
+
+	return scanWebhookDeliveries(rows)
+}
+
+// RedeliverWebhook queues a delivery again with a fresh set of attempts, whatever its status
+func (d *DB) RedeliverWebhook(ctx context.Context, id int64) (*postgres.WebhookDelivery, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		UPDATE webhook_deliveries SET status = $2, attempts = 0, last_error = NULL, next_attempt_at = $3, delivered_at = NULL
+		WHERE id = $1
+		RETURNING ` + webhookDeliveryColumns
+
+	delivery, err := scanWebhookDelivery(d.db.QueryRowContext(ctx, query, id, postgres.WebhookPending, time.Now()))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("webhook delivery %d %w", id, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to queue webhook delivery again: %v", err)
+	}
+
+	return delivery, nil
+}
+
+func scanWebhookEndpoint(row scanner) (*common.WebhookEndpoint, error) {
+	var e common.WebhookEndpoint
+	err := row.Scan(&e.ID, &e.OwnerID, &e.URL, &e.Secret, pq.Array(&e.EventTypes), &e.Enabled, &e.ConsecutiveFailures,
+		&e.DisabledReason, &e.DisabledAt, &e.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &e, nil
+}
+
+func scanWebhookDelivery(row scanner) (*postgres.WebhookDelivery, error) {
+	var wd postgres.WebhookDelivery
+	err := row.Scan(&wd.ID, &wd.EndpointID, &wd.EventID, &wd.TransactionID, &wd.EventType, &wd.Payload, &wd.ContentType,
+		&wd.Status, &wd.Attempts, &wd.LastError, &wd.NextAttemptAt, &wd.CreatedAt, &wd.DeliveredAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &wd, nil
+}
+
+func scanWebhookDeliveries(rows *sql.Rows) ([]*postgres.WebhookDelivery, error) {
+	var deliveries []*postgres.WebhookDelivery
+	for rows.Next() {
+		delivery, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan webhook delivery: %v", err)
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read webhook deliveries: %v", err)
+	}
+
+	return deliveries, nil
+}
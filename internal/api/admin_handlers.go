@@ -0,0 +1,31 @@
+package api
+
+import (
+	"net/http"
+	"payment-gateway/internal/models/response"
+	"payment-gateway/internal/util"
+)
+
+// GatewayHealthHandler returns the cached health status and recent status transitions of the gateways
+// Sample Request (GET /admin/gateways/health)
+func (a *API) GatewayHealthHandler(w http.ResponseWriter, r *http.Request) {
+	util.SendEncodedResponse(w, response.APIResponse{
+		StatusCode: http.StatusOK,
+		Message:    "gateway health",
+		Data: map[string]interface{}{
+			"gateways": a.svc.ISvcGateway.GatewayHealth(),
+		},
+	}, http.StatusOK)
+}
+
+// GatewayBreakersHandler returns the circuit breaker state of the gateways with their recent error rate and latency
+// Sample Request (GET /admin/gateways/breakers)
+func (a *API) GatewayBreakersHandler(w http.ResponseWriter, r *http.Request) {
+	util.SendEncodedResponse(w, response.APIResponse{
+		StatusCode: http.StatusOK,
+		Message:    "gateway circuit breakers",
+		Data: map[string]interface{}{
+			"gateways": a.svc.ISvcGateway.GatewayBreakers(),
+		},
+	}, http.StatusOK)
+}
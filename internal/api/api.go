@@ -1,33 +1,96 @@
 package api
 
 import (
+	"context"
 	"net/http"
 	"payment-gateway/db"
 	"payment-gateway/internal/kafka"
 	"payment-gateway/internal/services"
+	"payment-gateway/internal/services/dispute"
 	"payment-gateway/internal/services/gateway"
+	"payment-gateway/internal/services/inbox"
+	"payment-gateway/internal/services/ledger"
+	"payment-gateway/internal/services/outbox"
 	"payment-gateway/internal/services/tx"
+	"payment-gateway/internal/services/webhook"
+	"payment-gateway/internal/util"
 
 	"github.com/gorilla/mux"
 )
 
 type API struct {
-	Router *mux.Router
-	db     db.Idb
-	svc    services.Service
+	Router      *mux.Router
+	db          db.Idb
+	svc         services.Service
+	idempotency IdempotencyConfig
+	auth        AuthConfig
+	timeouts    util.Timeouts
 }
 
-func New(dbInst db.Idb) *API {
-	return &API{db: dbInst, Router: mux.NewRouter()}
+func New(dbInst db.Idb, timeouts util.Timeouts) *API {
+	return &API{db: dbInst, Router: mux.NewRouter(), idempotency: LoadIdempotencyConfig(), auth: LoadAuthConfig(),
+		timeouts: timeouts}
 }
 
 func (a *API) SetupServices(kafkaProducer kafka.IProducer) {
-	a.svc.ISvcGateway = gateway.NewSvcGateway(a.db)
-	a.svc.ISvcTx = tx.NewSvcTx(a.db, kafkaProducer)
+	a.svc.ISvcGateway = gateway.NewSvcGateway(a.db, a.timeouts.Gateway)
+	a.svc.ISvcTx = tx.NewSvcTx(a.db, tx.LoadPoolConfig())
+	a.svc.ISvcOutbox = outbox.NewSvcOutbox(a.db, kafkaProducer, outbox.LoadRelayConfig())
+	a.svc.ISvcDispute = dispute.NewSvcDispute(a.db, dispute.LoadDisputeConfig())
+	a.svc.ISvcInbox = inbox.NewSvcInbox(a.db, a.svc.ISvcGateway, a.svc.ISvcTx, a.svc.ISvcDispute, inbox.LoadInboxConfig())
+	a.svc.ISvcWebhook = webhook.NewSvcWebhook(a.db, webhook.LoadWebhookConfig())
+	a.svc.ISvcLedger = ledger.NewSvcLedger(a.db, ledger.LoadLedgerConfig())
 }
 
 func (a *API) SetupRoutes() {
-	a.Router.Handle("/deposit", http.HandlerFunc(a.DepositHandler)).Methods("POST")
-	a.Router.Handle("/withdrawal", http.HandlerFunc(a.WithdrawalHandler)).Methods("POST")
-	a.Router.Handle("/call_back", http.HandlerFunc(a.CallBackHandler)).Methods("GET")
+	a.Router.Handle("/deposit", a.idempotent(requestUser, a.DepositHandler)).Methods("POST")
+	a.Router.Handle("/withdrawal", a.idempotent(requestUser, a.WithdrawalHandler)).Methods("POST")
+	a.Router.Handle("/call_back/{gateway_id}", http.HandlerFunc(a.GatewayCallBackHandler)).Methods("POST")
+	// the unsigned callback predates gateway signatures, it is only kept for setups still relying on it
+	if util.GetEnvBool("CALLBACK_ALLOW_UNSIGNED", false) {
+		a.Router.Handle("/call_back", http.HandlerFunc(a.CallBackHandler)).Methods("GET")
+	}
+	a.Router.Handle("/transactions", http.HandlerFunc(a.ListTransactionsHandler)).Methods("GET")
+	a.Router.Handle("/transactions/{id}", http.HandlerFunc(a.GetTransactionHandler)).Methods("GET")
+	a.Router.Handle("/transactions/{id}/cancel", a.idempotent(a.transactionUser, a.CancelTransactionHandler)).Methods("POST")
+	a.Router.Handle("/transactions/{id}/refunds", a.idempotent(a.transactionUser, a.CreateRefundHandler)).Methods("POST")
+	a.Router.Handle("/transactions/{id}/refunds", http.HandlerFunc(a.RefundsHandler)).Methods("GET")
+	a.Router.Handle("/users/{id}/balances", http.HandlerFunc(a.BalancesHandler)).Methods("GET")
+
+	// disputes
+	a.Router.Handle("/disputes", http.HandlerFunc(a.DisputesHandler)).Methods("GET")
+	a.Router.Handle("/disputes/import", http.HandlerFunc(a.ImportDisputesHandler)).Methods("POST")
+	a.Router.Handle("/disputes/{id}", http.HandlerFunc(a.DisputeHandler)).Methods("GET")
+	a.Router.Handle("/disputes/{id}/evidence", http.HandlerFunc(a.SubmitDisputeEvidenceHandler)).Methods("POST")
+
+	// routing
+	a.Router.Handle("/routing/dry_run", http.HandlerFunc(a.RoutingDryRunHandler)).Methods("POST")
+	a.Router.Handle("/routing/rules", http.HandlerFunc(a.RoutingRulesHandler)).Methods("GET")
+	a.Router.Handle("/routing/rules", http.HandlerFunc(a.CreateRoutingRuleHandler)).Methods("POST")
+
+	// webhooks
+	a.Router.Handle("/webhooks/endpoints", a.admin(a.WebhookEndpointsHandler)).Methods("GET")
+	a.Router.Handle("/webhooks/endpoints", a.admin(a.CreateWebhookEndpointHandler)).Methods("POST")
+	a.Router.Handle("/webhooks/endpoints/{id}/enable", a.admin(a.setWebhookEndpointEnabledHandler(true))).Methods("POST")
+	a.Router.Handle("/webhooks/endpoints/{id}/disable", a.admin(a.setWebhookEndpointEnabledHandler(false))).Methods("POST")
+	a.Router.Handle("/webhooks/deliveries", a.admin(a.WebhookDeliveriesHandler)).Methods("GET")
+	a.Router.Handle("/webhooks/deliveries/{id}", a.admin(a.WebhookDeliveryHandler)).Methods("GET")
+	a.Router.Handle("/webhooks/deliveries/{id}/redeliver", a.admin(a.RedeliverWebhookHandler)).Methods("POST")
+
+	// admin endpoints
+	a.Router.Handle("/admin/gateways/health", http.HandlerFunc(a.GatewayHealthHandler)).Methods("GET")
+	a.Router.Handle("/admin/gateways/breakers", http.HandlerFunc(a.GatewayBreakersHandler)).Methods("GET")
+	a.Router.Handle("/admin/callbacks", http.HandlerFunc(a.CallbacksHandler)).Methods("GET")
+	a.Router.Handle("/admin/callbacks/{id}/reprocess", http.HandlerFunc(a.ReprocessCallbackHandler)).Methods("POST")
+	a.Router.Handle("/admin/ledger/check", http.HandlerFunc(a.LedgerCheckHandler)).Methods("GET")
+}
+
+// RunBackgroundJobs starts the services' background loops, they stop when ctx is cancelled
+func (a *API) RunBackgroundJobs(ctx context.Context) {
+	go a.svc.ISvcGateway.MonitorHealth(ctx)
+	go a.svc.ISvcTx.RunWorkers(ctx, a.svc.ISvcGateway)
+	go a.svc.ISvcOutbox.RelayOutbox(ctx)
+	go a.svc.ISvcWebhook.RunDispatcher(ctx)
+	go a.svc.ISvcLedger.RunLedgerChecker(ctx)
+	go a.svc.ISvcDispute.RunDisputeReminders(ctx)
 }
@@ -0,0 +1,18 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newMockPSP starts a stand-in provider that accepts every transaction
+func newMockPSP(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"psp-1","status":"accepted"}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
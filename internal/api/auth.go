@@ -0,0 +1,51 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"payment-gateway/internal/util"
+	"strings"
+)
+
+// AuthConfig holds the API keys of the operators allowed on the admin routes
+type AuthConfig struct {
+	// AdminAPIKeys are sent as "Authorization: Bearer <key>", several keys can be valid at once so they can be rotated.
+	// Without keys the admin routes are refused to everyone.
+	AdminAPIKeys []string
+}
+
+// LoadAuthConfig reads the admin API keys from the environment, ADMIN_API_KEYS is a comma separated list
+func LoadAuthConfig() AuthConfig {
+	var keys []string
+	for _, key := range strings.Split(util.GetEnvString("ADMIN_API_KEYS", ""), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+
+	return AuthConfig{AdminAPIKeys: keys}
+}
+
+// admin only lets through the requests bearing one of the admin API keys, the others are answered with 401
+func (a *API) admin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || !a.auth.isAdminKey(key) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			http.Error(w, "a valid admin API key is required", http.StatusUnauthorized)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// isAdminKey compares key to every admin key in constant time, so that a key can't be guessed from response times
+func (c AuthConfig) isAdminKey(key string) bool {
+	valid := 0
+	for _, adminKey := range c.AdminAPIKeys {
+		valid |= subtle.ConstantTimeCompare([]byte(key), []byte(adminKey))
+	}
+
+	return key != "" && valid == 1
+}
@@ -0,0 +1,187 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"payment-gateway/db"
+	"payment-gateway/internal/models/common"
+	"payment-gateway/internal/models/postgres"
+	"payment-gateway/internal/models/response"
+	"payment-gateway/internal/services/dispute"
+	"payment-gateway/internal/services/gateway"
+	"payment-gateway/internal/util"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	callbackSignatureHeader = "X-Signature"
+	callbackTimestampHeader = "X-Timestamp"
+	callbackEventIDHeader   = "X-Event-ID"
+	maxCallbackBytes        = 1 << 20
+)
+
+// GatewayCallBackHandler handles the status updates posted by a gateway in its own format (JSON or SOAP/XML). The
+// callback must be signed with the gateway's secret and come from one of its allowed IPs. Callbacks are stored in
+// the inbox and deduplicated by the provider's event ID, duplicates are acknowledged without being applied again.
+// Sample Request (POST /call_back/1):
+//
+//	X-Timestamp: 1700000000
+//	X-Signature: sha256=<hex HMAC-SHA256 of "1700000000.<body>">
+//
+//	{
+//	    "event_id": "evt-1",
+//	    "id": "rest-123",
+//	    "reference": "101",
+//	    "status": "succeeded"
+//	}
+func (a *API) GatewayCallBackHandler(w http.ResponseWriter, r *http.Request) {
+	gatewayID, err := strconv.Atoi(mux.Vars(r)["gateway_id"])
+	if err != nil || gatewayID <= 0 {
+		http.Error(w, "invalid gateway id", http.StatusBadRequest)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxCallbackBytes))
+	if err != nil {
+		sendBodyError(w, err)
+		return
+	}
+
+	cb, duplicate, err := a.svc.ISvcInbox.ReceiveCallback(r.Context(), gatewayID, r.Header.Get(callbackEventIDHeader), gateway.CallbackRequest{
+		Body:      body,
+		Signature: r.Header.Get(callbackSignatureHeader),
+		Timestamp: r.Header.Get(callbackTimestampHeader),
+		RemoteIP:  remoteIP(r),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), callbackErrorStatus(err))
+		return
+	}
+
+	message := "callback processed"
+	if duplicate {
+		message = "duplicate callback ignored"
+	}
+
+	util.SendEncodedResponse(w, response.APIResponse{
+		StatusCode: http.StatusOK,
+		Message:    message,
+		Data: map[string]interface{}{
+			"callback_id":     cb.ID,
+			"transaction_id":  cb.TransactionID,
+			"status":          cb.Status,
+			"provider_status": cb.ProviderStatus,
+			"result":          cb.Result,
+			"duplicate":       duplicate,
+		},
+	}, http.StatusOK)
+}
+
+// CallbacksHandler lists the callbacks of the inbox, newest first, to find the ones to reprocess
+// Sample Request (GET /admin/callbacks?result=failed&gateway_id=1&limit=50)
+func (a *API) CallbacksHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := common.CallbackFilter{Result: q.Get("result")}
+	var gatewayID, limit int64
+
+	switch filter.Result {
+	case "", postgres.CallbackReceived, postgres.CallbackProcessed, postgres.CallbackRejected, postgres.CallbackFailed:
+	default:
+		http.Error(w, "invalid result, must be received, processed, rejected or failed", http.StatusBadRequest)
+		return
+	}
+
+	for name, dest := range map[string]*int64{"gateway_id": &gatewayID, "transaction_id": &filter.TransactionID, "before_id": &filter.BeforeID, "limit": &limit} {
+		if v := q.Get(name); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid %s, must be an integer", name), http.StatusBadRequest)
+				return
+			}
+			*dest = n
+		}
+	}
+	filter.GatewayID, filter.Limit = int(gatewayID), int(limit)
+
+	callbacks, err := a.svc.ISvcInbox.InboxCallbacks(r.Context(), filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	list := []response.Callback{}
+	for _, cb := range callbacks {
+		list = append(list, response.NewCallback(cb))
+	}
+
+	util.SendEncodedResponse(w, response.APIResponse{
+		StatusCode: http.StatusOK,
+		Message:    "callbacks",
+		Data: map[string]interface{}{
+			"callbacks": list,
+		},
+	}, http.StatusOK)
+}
+
+// ReprocessCallbackHandler parses and applies a stored callback again, the outcome is in the result of the returned
+// callback
+// Sample Request (POST /admin/callbacks/12/reprocess)
+func (a *API) ReprocessCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "invalid callback id", http.StatusBadRequest)
+		return
+	}
+
+	cb, err := a.svc.ISvcInbox.ReprocessCallback(r.Context(), id)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, db.ErrNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, db.ErrStatusConflict):
+			status = http.StatusConflict
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	util.SendEncodedResponse(w, response.APIResponse{
+		StatusCode: http.StatusOK,
+		Message:    "callback reprocessed",
+		Data: map[string]interface{}{
+			"callback": response.NewCallback(cb),
+		},
+	}, http.StatusOK)
+}
+
+// callbackErrorStatus maps an error returned while receiving a callback to an HTTP status code
+func callbackErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, gateway.ErrCallbackUnauthorized):
+		return http.StatusUnauthorized
+	case errors.Is(err, gateway.ErrCallbackForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, gateway.ErrInvalidCallback):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, dispute.ErrInvalidDispute), errors.Is(err, dispute.ErrIllegalTransition):
+		return disputeErrorStatus(err)
+	default:
+		return txErrorStatus(err)
+	}
+}
+
+// remoteIP returns the IP the request was received from
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
@@ -0,0 +1,260 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"payment-gateway/db"
+	"payment-gateway/internal/kafka"
+	"payment-gateway/internal/models/common"
+	"payment-gateway/internal/models/postgres"
+	"payment-gateway/internal/models/response"
+	"payment-gateway/internal/services/gateway"
+	"payment-gateway/internal/util"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newCallbackMockDB keeps txs of gateway 1 and the callback inbox in memory
+func newCallbackMockDB(statuses map[int64]string) *db.MockDB {
+	var inbox []*postgres.InboxCallback
+
+	return &db.MockDB{
+		GetGatewayByIDFunc: func(ctx context.Context, gatewayID int) (*common.Gateway, error) {
+			if gatewayID != 1 {
+				return nil, fmt.Errorf("gateway %d %w", gatewayID, db.ErrNotFound)
+			}
+			return &common.Gateway{ID: 1, Name: "soap", DataFormatSupported: "text/xml", CallbackSecret: "s3cret",
+				CallbackAllowedIPs: []string{"192.0.2.0/24"}}, nil
+		},
+		GetTransactionFunc: func(ctx context.Context, id int64) (*postgres.Transaction, error) {
+			status, ok := statuses[id]
+			if !ok {
+				return nil, fmt.Errorf("transaction %d %w", id, db.ErrNotFound)
+			}
+			return &postgres.Transaction{ID: id, Type: "deposit", Amount: 1000, Currency: "EUR", GatewayID: 1, Status: status}, nil
+		},
+		UpdateTxStatusFunc: func(ctx context.Context, change *postgres.TransactionStatusChange, allowedFrom []string, event *postgres.OutboxEvent, entry *postgres.JournalEntry) error {
+			change.FromStatus = statuses[change.TransactionID]
+			for _, s := range allowedFrom {
+				if s == change.FromStatus {
+					statuses[change.TransactionID] = change.ToStatus
+					return nil
+				}
+			}
+			return fmt.Errorf("transaction %d is %s: %w", change.TransactionID, change.FromStatus, db.ErrStatusConflict)
+		},
+		ClaimInboxCallbackFunc: func(ctx context.Context, cb *postgres.InboxCallback, lockTimeout time.Duration) (bool, error) {
+			for _, stored := range inbox {
+				if stored.GatewayID == cb.GatewayID && stored.EventID == cb.EventID {
+					if stored.Result != postgres.CallbackFailed {
+						*cb = *stored
+						return false, nil
+					}
+					stored.Result, stored.Attempts = postgres.CallbackReceived, stored.Attempts+1
+					*cb = *stored
+					return true, nil
+				}
+			}
+			cb.ID, cb.Result, cb.Attempts = int64(len(inbox)+1), postgres.CallbackReceived, 1
+			stored := *cb
+			inbox = append(inbox, &stored)
+			return true, nil
+		},
+		ReclaimInboxCallbackFunc: func(ctx context.Context, id int64, lockTimeout time.Duration) (*postgres.InboxCallback, error) {
+			if id <= 0 || id > int64(len(inbox)) {
+				return nil, fmt.Errorf("callback %d %w", id, db.ErrNotFound)
+			}
+			stored := inbox[id-1]
+			if stored.Result == postgres.CallbackReceived {
+				return nil, fmt.Errorf("callback %d is being processed: %w", id, db.ErrStatusConflict)
+			}
+			stored.Result, stored.Attempts = postgres.CallbackReceived, stored.Attempts+1
+			cb := *stored
+			return &cb, nil
+		},
+		CompleteInboxCallbackFunc: func(ctx context.Context, cb *postgres.InboxCallback) error {
+			stored := *cb
+			inbox[cb.ID-1] = &stored
+			return nil
+		},
+		ListInboxCallbacksFunc: func(ctx context.Context, filter common.CallbackFilter) ([]*postgres.InboxCallback, error) {
+			var callbacks []*postgres.InboxCallback
+			for i := len(inbox) - 1; i >= 0 && len(callbacks) < filter.Limit; i-- {
+				if filter.Result == "" || inbox[i].Result == filter.Result {
+					callbacks = append(callbacks, inbox[i])
+				}
+			}
+			return callbacks, nil
+		},
+	}
+}
+
+func newCallbackAPI(statuses map[int64]string) *API {
+	a := New(newCallbackMockDB(statuses), util.Timeouts{})
+	a.SetupServices(&kafka.MockKafkaProducer{})
+	a.SetupRoutes()
+
+	return a
+}
+
+func soapNotification(eventID, reference, status string) string {
+	return `<Envelope><Body><TransactionNotification><EventId>` + eventID + `</EventId><Reference>` + reference +
+		`</Reference><ProviderReference>soap-9</ProviderReference><Status>` + status + `</Status></TransactionNotification></Body></Envelope>`
+}
+
+func soapDisputeNotification(eventID, reference, status, amount, currency string) string {
+	return `<Envelope><Body><DisputeNotification><EventId>` + eventID + `</EventId><Reference>` + reference +
+		`</Reference><DisputeReference>cb-1</DisputeReference><Status>` + status + `</Status><Amount>` + amount +
+		`</Amount><Currency>` + currency + `</Currency><ReasonCode>fraud</ReasonCode></DisputeNotification></Body></Envelope>`
+}
+
+func postCallback(a *API, path, body, secret, remoteAddr string) *httptest.ResponseRecorder {
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/xml")
+	req.Header.Set(callbackTimestampHeader, now)
+	req.Header.Set(callbackSignatureHeader, "sha256="+gateway.SignCallback(secret, now, []byte(body)))
+	if remoteAddr != "" {
+		req.RemoteAddr = remoteAddr
+	}
+	rec := httptest.NewRecorder()
+	a.Router.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func TestGatewayCallBackHandler(t *testing.T) {
+	statuses := map[int64]string{101: "processing"}
+	a := newCallbackAPI(statuses)
+
+	tests := []struct {
+		name          string
+		path          string
+		body          string
+		secret        string
+		remoteAddr    string
+		wantStatus    int
+		wantDuplicate bool
+	}{
+		{name: "settled", path: "/call_back/1", body: soapNotification("evt-1", "101", "SETTLED"), secret: "s3cret", wantStatus: http.StatusOK},
+		{name: "duplicate", path: "/call_back/1", body: soapNotification("evt-1", "101", "SETTLED"), secret: "s3cret", wantStatus: http.StatusOK, wantDuplicate: true},
+		{name: "same status again", path: "/call_back/1", body: soapNotification("evt-2", "101", "SETTLED"), secret: "s3cret", wantStatus: http.StatusOK},
+		{name: "illegal transition", path: "/call_back/1", body: soapNotification("evt-3", "101", "PENDING"), secret: "s3cret", wantStatus: http.StatusConflict},
+		{name: "rejected duplicate", path: "/call_back/1", body: soapNotification("evt-3", "101", "PENDING"), secret: "s3cret", wantStatus: http.StatusOK, wantDuplicate: true},
+		{name: "unknown tx", path: "/call_back/1", body: soapNotification("evt-4", "7", "SETTLED"), secret: "s3cret", wantStatus: http.StatusNotFound},
+		{name: "invalid dispute", path: "/call_back/1", body: soapDisputeNotification("evt-7", "101", "OPEN", "10.00", "USD"), secret: "s3cret", wantStatus: http.StatusUnprocessableEntity},
+		{name: "unknown status", path: "/call_back/1", body: soapNotification("evt-5", "101", "LOST"), secret: "s3cret", wantStatus: http.StatusUnprocessableEntity},
+		{name: "bad signature", path: "/call_back/1", body: soapNotification("evt-6", "101", "SETTLED"), secret: "guess", wantStatus: http.StatusUnauthorized},
+		{name: "ip not allowed", path: "/call_back/1", body: soapNotification("evt-6", "101", "SETTLED"), secret: "s3cret", remoteAddr: "203.0.113.9:4000", wantStatus: http.StatusForbidden},
+		{name: "unknown gateway", path: "/call_back/9", body: soapNotification("evt-6", "101", "SETTLED"), secret: "s3cret", wantStatus: http.StatusNotFound},
+		{name: "invalid gateway", path: "/call_back/abc", body: soapNotification("evt-6", "101", "SETTLED"), secret: "s3cret", wantStatus: http.StatusBadRequest},
+		{name: "too large", path: "/call_back/1", body: soapNotification("evt-6", "101", "SETTLED") + strings.Repeat(" ", maxCallbackBytes), secret: "s3cret", wantStatus: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := postCallback(a, tt.path, tt.body, tt.secret, tt.remoteAddr)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+
+			if rec.Code == http.StatusOK {
+				var res struct {
+					Data struct {
+						Duplicate bool `json:"duplicate"`
+					} `json:"data"`
+				}
+				if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+					t.Fatalf("failed to decode response: %v", err)
+				}
+				if res.Data.Duplicate != tt.wantDuplicate {
+					t.Errorf("expected duplicate %v, got %v", tt.wantDuplicate, res.Data.Duplicate)
+				}
+			}
+		})
+	}
+
+	if statuses[101] != "completed" {
+		t.Errorf("expected tx to be completed, got %s", statuses[101])
+	}
+}
+
+func TestReprocessCallbackHandler(t *testing.T) {
+	statuses := map[int64]string{}
+	a := newCallbackAPI(statuses)
+
+	// the callback arrives before the tx is known
+	if rec := postCallback(a, "/call_back/1", soapNotification("evt-1", "7", "SETTLED"), "s3cret", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected the unknown tx to be rejected, got %d", rec.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/callbacks?result=rejected", nil)
+	rec := httptest.NewRecorder()
+	a.Router.ServeHTTP(rec, req)
+
+	var list struct {
+		Data struct {
+			Callbacks []response.Callback `json:"callbacks"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil || len(list.Data.Callbacks) != 1 {
+		t.Fatalf("expected the rejected callback to be listed, got %s", rec.Body.String())
+	}
+	if cb := list.Data.Callbacks[0]; cb.EventID != "evt-1" || cb.Error == "" || cb.Payload == "" {
+		t.Errorf("unexpected callback: %+v", cb)
+	}
+
+	statuses[7] = "processing"
+	reprocess := func(path string) (int, response.Callback) {
+		rec := httptest.NewRecorder()
+		a.Router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, nil))
+
+		var res struct {
+			Data struct {
+				Callback response.Callback `json:"callback"`
+			} `json:"data"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &res)
+
+		return rec.Code, res.Data.Callback
+	}
+
+	code, cb := reprocess(fmt.Sprintf("/admin/callbacks/%d/reprocess", list.Data.Callbacks[0].ID))
+	if code != http.StatusOK || cb.Result != postgres.CallbackProcessed || cb.Attempts != 2 {
+		t.Fatalf("expected the callback to be processed, got %d %+v", code, cb)
+	}
+	if statuses[7] != "completed" {
+		t.Errorf("expected tx to be completed, got %s", statuses[7])
+	}
+
+	if code, _ = reprocess("/admin/callbacks/9/reprocess"); code != http.StatusNotFound {
+		t.Errorf("expected an unknown callback to be not found, got %d", code)
+	}
+
+	rec = httptest.NewRecorder()
+	a.Router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/callbacks?result=lost", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected an invalid result filter to be rejected, got %d", rec.Code)
+	}
+}
+
+func TestUnsignedCallBackRoute(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/call_back?tx_id=abc&status=completed", nil)
+	rec := httptest.NewRecorder()
+	newCallbackAPI(nil).Router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed && rec.Code != http.StatusNotFound {
+		t.Errorf("expected the unsigned callback not to be routed by default, got %d", rec.Code)
+	}
+
+	t.Setenv("CALLBACK_ALLOW_UNSIGNED", "true")
+	rec = httptest.NewRecorder()
+	newCallbackAPI(nil).Router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected the unsigned callback to be routed when allowed, got %d", rec.Code)
+	}
+}
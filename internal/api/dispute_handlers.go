@@ -0,0 +1,166 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"payment-gateway/db"
+	"payment-gateway/internal/models/common"
+	"payment-gateway/internal/models/request"
+	"payment-gateway/internal/models/response"
+	"payment-gateway/internal/services/dispute"
+	"payment-gateway/internal/util"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// maxDisputeFileBytes caps the size of an imported dispute file
+const maxDisputeFileBytes = 10 << 20
+
+// DisputesHandler lists disputes, newest first
+// Sample Request (GET /disputes?status=opened&transaction_id=101&limit=50)
+func (a *API) DisputesHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := common.DisputeFilter{Status: q.Get("status")}
+	var gatewayID, limit int64
+
+	for name, dest := range map[string]*int64{"gateway_id": &gatewayID, "transaction_id": &filter.TransactionID, "before_id": &filter.BeforeID, "limit": &limit} {
+		if v := q.Get(name); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid %s, must be an integer", name), http.StatusBadRequest)
+				return
+			}
+			*dest = n
+		}
+	}
+	filter.GatewayID, filter.Limit = int(gatewayID), int(limit)
+
+	disputes, err := a.svc.ISvcDispute.Disputes(r.Context(), filter)
+	if err != nil {
+		http.Error(w, err.Error(), disputeErrorStatus(err))
+		return
+	}
+
+	list := []response.Dispute{}
+	for _, d := range disputes {
+		list = append(list, response.NewDispute(d))
+	}
+
+	util.SendEncodedResponse(w, response.APIResponse{
+		StatusCode: http.StatusOK,
+		Message:    "disputes",
+		Data: map[string]interface{}{
+			"disputes": list,
+		},
+	}, http.StatusOK)
+}
+
+// DisputeHandler returns a dispute
+// Sample Request (GET /disputes/3)
+func (a *API) DisputeHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "invalid dispute id", http.StatusBadRequest)
+		return
+	}
+
+	d, err := a.svc.ISvcDispute.Dispute(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), disputeErrorStatus(err))
+		return
+	}
+
+	util.SendEncodedResponse(w, response.APIResponse{
+		StatusCode: http.StatusOK,
+		Message:    "dispute",
+		Data: map[string]interface{}{
+			"dispute": response.NewDispute(d),
+		},
+	}, http.StatusOK)
+}
+
+// SubmitDisputeEvidenceHandler records the evidence contesting an opened dispute
+// Sample Request (POST /disputes/3/evidence):
+//
+//	{
+//	    "evidence": "delivered on 2024-01-03, signed receipt at https://merchant.example.com/receipts/101"
+//	}
+func (a *API) SubmitDisputeEvidenceHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "invalid dispute id", http.StatusBadRequest)
+		return
+	}
+
+	var req request.DisputeEvidence
+	if err = util.DecodeRequest(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	d, err := a.svc.ISvcDispute.SubmitDisputeEvidence(r.Context(), id, req.Evidence)
+	if err != nil {
+		http.Error(w, err.Error(), disputeErrorStatus(err))
+		return
+	}
+
+	util.SendEncodedResponse(w, response.APIResponse{
+		StatusCode: http.StatusOK,
+		Message:    "evidence submitted",
+		Data: map[string]interface{}{
+			"dispute": response.NewDispute(d),
+		},
+	}, http.StatusOK)
+}
+
+// ImportDisputesHandler applies a CSV file of disputes, e.g. a gateway's dispute report, and returns the outcome of
+// every line
+// Sample Request (POST /disputes/import, Content-Type: text/csv):
+//
+//	gateway_id,transaction_id,dispute_id,status,amount,currency,reason,evidence_due_by
+//	3,101,dp-1,opened,10.50,EUR,fraudulent,2024-01-08T00:00:00Z
+func (a *API) ImportDisputesHandler(w http.ResponseWriter, r *http.Request) {
+	results, err := a.svc.ISvcDispute.ImportDisputes(r.Context(), http.MaxBytesReader(w, r.Body, maxDisputeFileBytes))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		sendBodyError(w, err)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), disputeErrorStatus(err))
+		return
+	}
+
+	var failed int
+	for _, result := range results {
+		if result.Error != "" {
+			failed++
+		}
+	}
+
+	util.SendEncodedResponse(w, response.APIResponse{
+		StatusCode: http.StatusOK,
+		Message:    "disputes imported",
+		Data: map[string]interface{}{
+			"imported": len(results) - failed,
+			"failed":   failed,
+			"results":  results,
+		},
+	}, http.StatusOK)
+}
+
+// disputeErrorStatus maps an error returned by the dispute service to an HTTP status code
+func disputeErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, dispute.ErrInvalidDispute):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, dispute.ErrIllegalTransition):
+		return http.StatusConflict
+	case errors.Is(err, db.ErrNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"payment-gateway/db"
+	"payment-gateway/internal/kafka"
+	"payment-gateway/internal/models/common"
+	"payment-gateway/internal/models/postgres"
+	"payment-gateway/internal/util"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDisputeHandlers(t *testing.T) {
+	disputes := map[int64]*postgres.Dispute{
+		1: {ID: 1, TransactionID: 1, UserID: 7, GatewayID: 3, ProviderRef: "dp-1", Amount: 10050, Currency: "EUR", Status: postgres.DisputeOpened, EvidenceDueAt: time.Now().Add(time.Hour)},
+		2: {ID: 2, TransactionID: 1, UserID: 7, GatewayID: 3, ProviderRef: "dp-2", Amount: 500, Currency: "EUR", Status: postgres.DisputeLost, EvidenceDueAt: time.Now()},
+	}
+
+	a := New(&db.MockDB{
+		GetTransactionFunc: func(ctx context.Context, id int64) (*postgres.Transaction, error) {
+			if id != 1 {
+				return nil, fmt.Errorf("transaction %d %w", id, db.ErrNotFound)
+			}
+			return &postgres.Transaction{ID: 1, Type: "deposit", Amount: 10050, Currency: "EUR", UserID: 7, GatewayID: 3, Status: "completed"}, nil
+		},
+		GetDisputeFunc: func(ctx context.Context, id int64) (*postgres.Dispute, error) {
+			d, ok := disputes[id]
+			if !ok {
+				return nil, fmt.Errorf("dispute %d %w", id, db.ErrNotFound)
+			}
+			return d, nil
+		},
+		ListDisputesFunc: func(ctx context.Context, filter common.DisputeFilter) ([]*postgres.Dispute, error) {
+			if filter.Status != postgres.DisputeLost {
+				return nil, nil
+			}
+			return []*postgres.Dispute{disputes[2]}, nil
+		},
+		CreateDisputeFunc: func(ctx context.Context, dispute *postgres.Dispute, event func(*postgres.Dispute) (*postgres.OutboxEvent, error)) (bool, error) {
+			dispute.ID = 3
+			_, err := event(dispute)
+			return err == nil, err
+		},
+	}, util.Timeouts{})
+	a.SetupServices(&kafka.MockKafkaProducer{})
+	a.SetupRoutes()
+
+	file := "gateway_id,transaction_id,dispute_id,status\n3,1,dp-3,opened\n3,9,dp-4,opened\n"
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		contentType string
+		body        string
+		code        int
+		contains    string
+	}{
+		{"list", http.MethodGet, "/disputes?status=lost", "", "", http.StatusOK, `"disputes":[{"id":2,"transaction_id":1,"gateway_id":3,"provider_ref":"dp-2","status":"lost","amount":5.00`},
+		{"empty list", http.MethodGet, "/disputes", "", "", http.StatusOK, `"disputes":[]`},
+		{"invalid filter", http.MethodGet, "/disputes?limit=x", "", "", http.StatusBadRequest, "invalid limit"},
+		{"get", http.MethodGet, "/disputes/1", "", "", http.StatusOK, `"provider_ref":"dp-1"`},
+		{"get unknown", http.MethodGet, "/disputes/9", "", "", http.StatusNotFound, ""},
+		{"invalid id", http.MethodGet, "/disputes/x", "", "", http.StatusBadRequest, "invalid dispute id"},
+		{"evidence of a lost dispute", http.MethodPost, "/disputes/2/evidence", "application/json", `{"evidence": "receipt"}`, http.StatusConflict, ""},
+		{"empty evidence", http.MethodPost, "/disputes/1/evidence", "application/json", `{"evidence": ""}`, http.StatusUnprocessableEntity, ""},
+		{"import", http.MethodPost, "/disputes/import", "text/csv", file, http.StatusOK, `"failed":1,"imported":1`},
+		{"import without header", http.MethodPost, "/disputes/import", "text/csv", "", http.StatusUnprocessableEntity, "empty"},
+		{"import too large", http.MethodPost, "/disputes/import", "text/csv", file + strings.Repeat(" ", maxDisputeFileBytes), http.StatusRequestEntityTooLarge, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			rec := httptest.NewRecorder()
+			a.Router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.code {
+				t.Fatalf("expected %d, got %d: %s", tt.code, rec.Code, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), tt.contains) {
+				t.Errorf("expected %s in %s", tt.contains, rec.Body.String())
+			}
+		})
+	}
+}
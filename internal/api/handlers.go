@@ -1,8 +1,13 @@
 package api
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"payment-gateway/db"
 	"payment-gateway/internal/models/request"
+	"payment-gateway/internal/models/response"
+	"payment-gateway/internal/services/tx"
 	"payment-gateway/internal/util"
 	"strconv"
 	"strings"
@@ -26,19 +31,14 @@ func (a *API) DepositHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// process the deposit request
-	response, err := a.svc.ISvcTx.ProcessTransaction(req, a.svc.ISvcGateway, "deposit")
+	response, err := a.svc.ISvcTx.ProcessTransaction(r.Context(), req, a.svc.ISvcGateway, "deposit")
 	if err != nil {
-		if strings.Contains(err.Error(), "no gateways available for the specified country") {
-			http.Error(w, err.Error(), http.StatusGatewayTimeout)
-
-			return
-		}
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		sendTxError(w, err)
 		return
 	}
 
 	// encode and send the response
-	util.SendEncodedResponse(w, response, http.StatusOK)
+	util.SendEncodedResponse(w, response, response.StatusCode)
 }
 
 // WithdrawalHandler handles withdrawal requests (feel free to update how user is passed to the request)
@@ -59,23 +59,18 @@ func (a *API) WithdrawalHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// process the withdrawal request
-	response, err := a.svc.ISvcTx.ProcessTransaction(req, a.svc.ISvcGateway, "withdrawal")
+	response, err := a.svc.ISvcTx.ProcessTransaction(r.Context(), req, a.svc.ISvcGateway, "withdrawal")
 	if err != nil {
-		if strings.Contains(err.Error(), "no gateways available for the specified country") {
-			http.Error(w, err.Error(), http.StatusGatewayTimeout)
-
-			return
-		}
-
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		sendTxError(w, err)
 		return
 	}
 
 	// encode and send the response
-	util.SendEncodedResponse(w, response, http.StatusOK)
+	util.SendEncodedResponse(w, response, response.StatusCode)
 }
 
-// CallBackHandler handles updating of transaction status via callback
+// CallBackHandler handles updating of transaction status via an unsigned callback, it is only routed when
+// CALLBACK_ALLOW_UNSIGNED is set
 // Sample Request (GET /call_back?tx_id=101&status=completed)
 func (a *API) CallBackHandler(w http.ResponseWriter, r *http.Request) {
 	txIdStr := r.URL.Query().Get("tx_id")
@@ -87,6 +82,66 @@ func (a *API) CallBackHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	status := r.URL.Query().Get("status")
 
-	a.svc.ISvcTx.ProcessCallBack(txIdInt, status)
+	if err = a.svc.ISvcTx.ProcessCallBack(r.Context(), txIdInt, status); err != nil {
+		http.Error(w, err.Error(), txErrorStatus(err))
+		return
+	}
+
+	util.SendEncodedResponse(w, response.APIResponse{
+		StatusCode: http.StatusOK,
+		Message:    "callback processed",
+		Data: map[string]interface{}{
+			"transaction_id": txIdInt,
+			"status":         status,
+		},
+	}, http.StatusOK)
+}
+
+// sendBodyError answers a request whose body could not be read, with 413 when the body exceeds its limit
+func sendBodyError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, fmt.Sprintf("request body exceeds %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+		return
+	}
 
+	http.Error(w, "failed to read request body", http.StatusBadRequest)
+}
+
+// sendTxError answers with the status matching a tx service error, asking the client to retry later when the
+// service is saturated
+func sendTxError(w http.ResponseWriter, err error) {
+	var routingErr *tx.RoutingError
+	if errors.As(err, &routingErr) {
+		markCreated(w)
+	}
+
+	status := txErrorStatus(err)
+	if status == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", "1")
+	}
+
+	http.Error(w, err.Error(), status)
+}
+
+// txErrorStatus maps an error returned by the tx service to an HTTP status code
+func txErrorStatus(err error) int {
+	var validationErr *tx.ValidationError
+	var transitionErr *tx.IllegalTransitionError
+	switch {
+	case errors.As(err, &validationErr):
+		return http.StatusUnprocessableEntity
+	case errors.As(err, &transitionErr):
+		return http.StatusConflict
+	case errors.Is(err, db.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, tx.ErrQueueFull):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, tx.ErrVoidRefused):
+		return http.StatusBadGateway
+	case strings.Contains(err.Error(), "no gateways available for the specified country"):
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
 }
@@ -0,0 +1,227 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"payment-gateway/db"
+	"payment-gateway/internal/models/postgres"
+	"payment-gateway/internal/models/request"
+	"payment-gateway/internal/util"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	idempotencyKeyHeader      = "Idempotency-Key"
+	idempotentReplayedHeader  = "Idempotent-Replayed"
+	maxIdempotencyKeyLength   = 255
+	maxIdempotentRequestBytes = 1 << 20
+)
+
+type (
+	// IdempotencyConfig controls how long idempotency keys are honoured
+	IdempotencyConfig struct {
+		// TTL is how long the response of a key is replayed, after that the key can be reused
+		TTL time.Duration
+		// LockTimeout is how long a key stays in flight before another request may take it over
+		LockTimeout time.Duration
+	}
+
+	// bufferedResponse holds the response of a handler so it can be stored before being sent
+	bufferedResponse struct {
+		header      http.Header
+		statusCode  int
+		wroteHeader bool
+		body        bytes.Buffer
+		// created is set by markCreated
+		created bool
+	}
+)
+
+// LoadIdempotencyConfig reads the idempotency key settings from the environment
+func LoadIdempotencyConfig() IdempotencyConfig {
+	return IdempotencyConfig{
+		TTL:         util.GetEnvDuration("IDEMPOTENCY_KEY_TTL", 24*time.Hour),
+		LockTimeout: util.GetEnvDuration("IDEMPOTENCY_LOCK_TIMEOUT", 5*time.Minute),
+	}
+}
+
+// idempotencyScope returns the user a request is made for, idempotency keys are unique per user
+type idempotencyScope func(r *http.Request, body []byte) (int, error)
+
+// idempotent makes next honour the Idempotency-Key header: the response of the first request made with a key for a
+// user is stored and replayed to the requests repeating it. Reusing a key with another request is rejected with 422
+// and repeating a request still in flight with 409. Requests without the header are passed through.
+func (a *API) idempotent(scope idempotencyScope, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+
+		if len(key) > maxIdempotencyKeyLength {
+			http.Error(w, "idempotency key is too long", http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentRequestBytes))
+		if err != nil {
+			sendBodyError(w, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		userID, err := scope(r, body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		hash := requestHash(r, body)
+
+		claimed, err := a.db.ClaimIdempotencyKey(r.Context(), userID, key, hash, a.idempotency.LockTimeout, a.idempotency.TTL)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if !claimed {
+			a.replay(r.Context(), w, userID, key, hash)
+			return
+		}
+
+		res := &bufferedResponse{header: make(http.Header), statusCode: http.StatusOK}
+		next(res, r)
+
+		// the outcome is stored even if the client went away, the key would stay in flight otherwise. A server error
+		// that created nothing, e.g. a saturated service or a failed write, is not an outcome: the key is released so
+		// the client can retry with it.
+		if res.statusCode >= http.StatusInternalServerError && !res.created {
+			err = a.db.DeleteIdempotencyKey(context.Background(), userID, key)
+		} else {
+			err = a.db.CompleteIdempotencyKey(context.Background(), &postgres.IdempotencyKey{
+				UserID:      userID,
+				Key:         key,
+				RequestHash: hash,
+				StatusCode:  res.statusCode,
+				ContentType: res.header.Get("Content-Type"),
+				Response:    res.body.Bytes(),
+			})
+		}
+		if err != nil {
+			log.Printf("failed to store the response of idempotency key %q of user %d: %v", key, userID, err)
+		}
+
+		for name, values := range res.header {
+			w.Header()[name] = values
+		}
+		w.WriteHeader(res.statusCode)
+		w.Write(res.body.Bytes())
+	}
+}
+
+// requestUser scopes the key of a new tx to the user_id of its body. A body that can't be decoded is scoped to no
+// user, the handler rejects it.
+func requestUser(r *http.Request, body []byte) (int, error) {
+	var req request.Transaction
+	decode := r.Clone(r.Context())
+	decode.Body = io.NopCloser(bytes.NewReader(body))
+	if err := util.DecodeRequest(decode, &req); err != nil {
+		return 0, nil
+	}
+
+	return req.UserID, nil
+}
+
+// transactionUser scopes the key of a request on a tx to the user of the tx. An unknown tx is scoped to no user, the
+// handler answers 404.
+func (a *API) transactionUser(r *http.Request, body []byte) (int, error) {
+	txID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		return 0, nil
+	}
+
+	tx, err := a.db.GetTransaction(r.Context(), txID)
+	if errors.Is(err, db.ErrNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return tx.UserID, nil
+}
+
+// markCreated tells idempotent that the request created a tx even though it answers with a server error, its
+// response is then stored and replayed like any other
+func markCreated(w http.ResponseWriter) {
+	if res, ok := w.(*bufferedResponse); ok {
+		res.created = true
+	}
+}
+
+// replay answers a request whose idempotency key is already taken
+func (a *API) replay(ctx context.Context, w http.ResponseWriter, userID int, key, hash string) {
+	stored, err := a.db.GetIdempotencyKey(ctx, userID, key)
+	if errors.Is(err, db.ErrNotFound) {
+		// the key was released between the claim and the lookup, the client may simply retry
+		http.Error(w, "a request with this idempotency key is in progress", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if stored.RequestHash != hash {
+		http.Error(w, "idempotency key was already used with a different request", http.StatusUnprocessableEntity)
+		return
+	}
+
+	if stored.CompletedAt == nil {
+		http.Error(w, "a request with this idempotency key is in progress", http.StatusConflict)
+		return
+	}
+
+	if stored.ContentType != "" {
+		w.Header().Set("Content-Type", stored.ContentType)
+	}
+	w.Header().Set(idempotentReplayedHeader, "true")
+	w.WriteHeader(stored.StatusCode)
+	w.Write(stored.Response)
+}
+
+// requestHash fingerprints the request so a key reused for another endpoint or body can be told apart
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+	h.Write(body)
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+// WriteHeader keeps the first status code, like http.ResponseWriter does
+func (b *bufferedResponse) WriteHeader(statusCode int) {
+	if b.wroteHeader {
+		return
+	}
+	b.statusCode = statusCode
+	b.wroteHeader = true
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	b.wroteHeader = true
+	return b.body.Write(p)
+}
@@ -0,0 +1,219 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"payment-gateway/db"
+	"payment-gateway/internal/kafka"
+	"payment-gateway/internal/models/common"
+	"payment-gateway/internal/models/postgres"
+	"payment-gateway/internal/util"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newIdempotentMockDB returns a mock DB processing deposits and keeping idempotency keys in memory, txCount counts the
+// transactions created
+func newIdempotentMockDB(t *testing.T, txCount *int) *db.MockDB {
+	psp := newMockPSP(t)
+
+	var mu sync.Mutex
+	keys := make(map[string]*postgres.IdempotencyKey)
+	keyOf := func(userID int, key string) string {
+		return fmt.Sprintf("%d/%s", userID, key)
+	}
+
+	return &db.MockDB{
+		GetCountryCurrenciesFunc: func(ctx context.Context, countryID int) ([]string, error) {
+			return []string{"USD"}, nil
+		},
+		GetSupportedGatewaysByCountryFunc: func(ctx context.Context, countryID int) ([]*common.Gateway, error) {
+			return []*common.Gateway{{ID: 1, Name: "Mock Gateway", Priority: 1}}, nil
+		},
+		GetRoutingRulesFunc: func(ctx context.Context) ([]*common.RoutingRule, error) {
+			return nil, nil
+		},
+		GetGatewayFeesFunc: func(ctx context.Context, gatewayIDs []int) ([]*common.FeeSchedule, error) {
+			return nil, nil
+		},
+		GetGatewayByIDFunc: func(ctx context.Context, gatewayID int) (*common.Gateway, error) {
+			return &common.Gateway{ID: gatewayID, Name: "Mock Gateway", DataFormatSupported: "application/json", EndpointURL: psp.URL}, nil
+		},
+		CreateTransactionFunc: func(ctx context.Context, tx *postgres.Transaction, hold func(tx *postgres.Transaction) *postgres.JournalEntry) error {
+			*txCount++
+			tx.ID = int64(*txCount)
+			return nil
+		},
+		UpdateTxStatusFunc: func(ctx context.Context, change *postgres.TransactionStatusChange, allowedFrom []string, event *postgres.OutboxEvent, entry *postgres.JournalEntry) error {
+			return nil
+		},
+		UpdateTxGatewayFunc: func(ctx context.Context, tx *postgres.Transaction) error { return nil },
+		CreateTxAttemptFunc: func(ctx context.Context, attempt *postgres.TransactionAttempt) error { return nil },
+		ClaimIdempotencyKeyFunc: func(ctx context.Context, userID int, key, requestHash string, lockTimeout, ttl time.Duration) (bool, error) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			if _, ok := keys[keyOf(userID, key)]; ok {
+				return false, nil
+			}
+			keys[keyOf(userID, key)] = &postgres.IdempotencyKey{UserID: userID, Key: key, RequestHash: requestHash, CreatedAt: time.Now()}
+			return true, nil
+		},
+		GetIdempotencyKeyFunc: func(ctx context.Context, userID int, key string) (*postgres.IdempotencyKey, error) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			k, ok := keys[keyOf(userID, key)]
+			if !ok {
+				return nil, fmt.Errorf("idempotency key %q %w", key, db.ErrNotFound)
+			}
+			stored := *k
+			return &stored, nil
+		},
+		CompleteIdempotencyKeyFunc: func(ctx context.Context, key *postgres.IdempotencyKey) error {
+			mu.Lock()
+			defer mu.Unlock()
+
+			now := time.Now()
+			key.CompletedAt = &now
+			keys[keyOf(key.UserID, key.Key)] = key
+			return nil
+		},
+		DeleteIdempotencyKeyFunc: func(ctx context.Context, userID int, key string) error {
+			mu.Lock()
+			defer mu.Unlock()
+
+			delete(keys, keyOf(userID, key))
+			return nil
+		},
+	}
+}
+
+func TestIdempotentDeposit(t *testing.T) {
+	var txCount int
+	mockDB := newIdempotentMockDB(t, &txCount)
+
+	a := New(mockDB, util.Timeouts{})
+	a.SetupServices(&kafka.MockKafkaProducer{})
+	handler := a.idempotent(requestUser, a.DepositHandler)
+
+	deposit := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/deposit", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(idempotencyKeyHeader, key)
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	body := `{"amount":100.00,"user_id":1,"country_id":840,"currency":"USD"}`
+
+	first := deposit("key-1", body)
+	if first.Code != http.StatusAccepted {
+		t.Fatalf("expected status code %d, got %d: %s", http.StatusAccepted, first.Code, first.Body.String())
+	}
+
+	// a retry with the same key and body gets the stored response without creating a second tx
+	replay := deposit("key-1", body)
+	if replay.Code != http.StatusAccepted || replay.Body.String() != first.Body.String() {
+		t.Errorf("expected the first response to be replayed, got %d: %s", replay.Code, replay.Body.String())
+	}
+	if replay.Header().Get(idempotentReplayedHeader) != "true" {
+		t.Errorf("expected the replayed header to be set")
+	}
+	if txCount != 1 {
+		t.Errorf("expected a single tx to be created, got %d", txCount)
+	}
+
+	// reusing the key with another body is rejected
+	if rr := deposit("key-1", `{"amount":200.00,"user_id":1,"country_id":840,"currency":"USD"}`); rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status code %d for a different body, got %d", http.StatusUnprocessableEntity, rr.Code)
+	}
+
+	// a duplicate of a request still in flight is rejected
+	if _, err := mockDB.ClaimIdempotencyKey(context.Background(), 1, "key-2", requestHash(httptest.NewRequest(http.MethodPost, "/deposit", nil), []byte(body)), time.Minute, time.Hour); err != nil {
+		t.Fatalf("failed to claim key: %v", err)
+	}
+	if rr := deposit("key-2", body); rr.Code != http.StatusConflict {
+		t.Errorf("expected status code %d for an in-flight key, got %d", http.StatusConflict, rr.Code)
+	}
+
+	// keys are unique per user, another user's request with the same key is a new request
+	if rr := deposit("key-1", `{"amount":100.00,"user_id":2,"country_id":840,"currency":"USD"}`); rr.Code != http.StatusAccepted || txCount != 2 {
+		t.Errorf("expected a tx for another user with the same key, got %d and %d txs", rr.Code, txCount)
+	}
+
+	// a body over the limit is refused rather than cut short
+	if rr := deposit("key-3", body+strings.Repeat(" ", maxIdempotentRequestBytes)); rr.Code != http.StatusRequestEntityTooLarge || txCount != 2 {
+		t.Errorf("expected status code %d for an oversized body, got %d", http.StatusRequestEntityTooLarge, rr.Code)
+	}
+
+	// requests without a key are not deduplicated
+	req := httptest.NewRequest(http.MethodPost, "/deposit", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if txCount != 3 {
+		t.Errorf("expected another tx without idempotency key, got %d", txCount)
+	}
+}
+
+func TestIdempotentDepositServerError(t *testing.T) {
+	var txCount int
+	mockDB := newIdempotentMockDB(t, &txCount)
+
+	// the first write fails, nothing is created
+	createTransaction := mockDB.CreateTransactionFunc
+	failures := 1
+	mockDB.CreateTransactionFunc = func(ctx context.Context, tx *postgres.Transaction, hold func(tx *postgres.Transaction) *postgres.JournalEntry) error {
+		if failures > 0 {
+			failures--
+			return errors.New("connection reset")
+		}
+		return createTransaction(ctx, tx, hold)
+	}
+
+	a := New(mockDB, util.Timeouts{})
+	a.SetupServices(&kafka.MockKafkaProducer{})
+	handler := a.idempotent(requestUser, a.DepositHandler)
+
+	deposit := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/deposit", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(idempotencyKeyHeader, key)
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	body := `{"amount":100.00,"user_id":1,"country_id":840,"currency":"USD"}`
+	if rr := deposit("key-1", body); rr.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status code %d, got %d: %s", http.StatusInternalServerError, rr.Code, rr.Body.String())
+	}
+
+	// the key was released, the retry is processed instead of being replayed the error
+	if rr := deposit("key-1", body); rr.Code != http.StatusAccepted || txCount != 1 {
+		t.Errorf("expected the retry to create the tx, got %d and %d txs", rr.Code, txCount)
+	}
+
+	// a tx failed for lack of gateway exists, its error is replayed rather than creating another tx
+	mockDB.GetSupportedGatewaysByCountryFunc = func(ctx context.Context, countryID int) ([]*common.Gateway, error) {
+		return nil, nil
+	}
+	first := deposit("key-2", body)
+	if first.Code < http.StatusInternalServerError {
+		t.Fatalf("expected a server error without gateway, got %d: %s", first.Code, first.Body.String())
+	}
+	if rr := deposit("key-2", body); rr.Code != first.Code || rr.Header().Get(idempotentReplayedHeader) != "true" || txCount != 2 {
+		t.Errorf("expected the error to be replayed, got %d and %d txs", rr.Code, txCount)
+	}
+}
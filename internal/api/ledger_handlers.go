@@ -0,0 +1,60 @@
+package api
+
+import (
+	"net/http"
+	"payment-gateway/internal/models/response"
+	"payment-gateway/internal/util"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// BalancesHandler returns the ledger balances of a user, one per currency, in major units: the available funds, the
+// funds held for withdrawals in flight and their total. A user without completed txs has no balance.
+// Sample Request (GET /users/1/balances)
+func (a *API) BalancesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || userID <= 0 {
+		http.Error(w, "invalid user id", http.StatusBadRequest)
+		return
+	}
+
+	accounts, err := a.svc.ISvcLedger.Balances(r.Context(), userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	util.SendEncodedResponse(w, response.APIResponse{
+		StatusCode: http.StatusOK,
+		Message:    "balances",
+		Data: map[string]interface{}{
+			"user_id":  userID,
+			"balances": response.NewBalances(accounts),
+		},
+	}, http.StatusOK)
+}
+
+// LedgerCheckHandler checks the consistency of the ledger, 200 when it is consistent and 409 with the discrepancies
+// otherwise
+// Sample Request (GET /admin/ledger/check)
+func (a *API) LedgerCheckHandler(w http.ResponseWriter, r *http.Request) {
+	check, err := a.svc.ISvcLedger.CheckLedger(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	status, message := http.StatusOK, "ledger is consistent"
+	if !check.Consistent() {
+		status, message = http.StatusConflict, "ledger is inconsistent"
+	}
+
+	util.SendEncodedResponse(w, response.APIResponse{
+		StatusCode: status,
+		Message:    message,
+		Data: map[string]interface{}{
+			"check": check,
+		},
+	}, status)
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"payment-gateway/db"
+	"payment-gateway/internal/kafka"
+	"payment-gateway/internal/models/common"
+	"payment-gateway/internal/models/postgres"
+	"payment-gateway/internal/util"
+	"strings"
+	"testing"
+)
+
+func TestBalancesHandler(t *testing.T) {
+	a := New(&db.MockDB{
+		GetLedgerAccountsFunc: func(ctx context.Context, ownerID int64, accountTypes ...string) ([]*postgres.LedgerAccount, error) {
+			if ownerID != 7 {
+				return nil, nil
+			}
+			return []*postgres.LedgerAccount{
+				{Type: "user", OwnerID: ownerID, Currency: "EUR", Balance: 10050},
+				{Type: "user_hold", OwnerID: ownerID, Currency: "EUR", Balance: 2000},
+				{Type: "user", OwnerID: ownerID, Currency: "JPY", Balance: -500},
+			}, nil
+		},
+	}, util.Timeouts{})
+	a.SetupServices(&kafka.MockKafkaProducer{})
+	a.SetupRoutes()
+
+	tests := []struct {
+		path     string
+		code     int
+		contains string
+	}{
+		{"/users/7/balances", http.StatusOK, `"balances":[{"currency":"EUR","available":100.50,"held":20.00,"balance":120.50},{"currency":"JPY","available":-500,"held":0,"balance":-500}]`},
+		{"/users/8/balances", http.StatusOK, `"balances":[]`},
+		{"/users/x/balances", http.StatusBadRequest, "invalid user id"},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		a.Router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+		if rec.Code != tt.code || !strings.Contains(rec.Body.String(), tt.contains) {
+			t.Errorf("%s: expected %d with %s, got %d: %s", tt.path, tt.code, tt.contains, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestLedgerCheckHandler(t *testing.T) {
+	check := &common.LedgerCheck{Entries: 3, UnbalancedCurrencies: map[string]int64{}}
+	a := New(&db.MockDB{
+		CheckLedgerFunc: func(ctx context.Context) (*common.LedgerCheck, error) {
+			return check, nil
+		},
+	}, util.Timeouts{})
+	a.SetupServices(&kafka.MockKafkaProducer{})
+	a.SetupRoutes()
+
+	rec := httptest.NewRecorder()
+	a.Router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/ledger/check", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected a consistent ledger to be 200, got %d", rec.Code)
+	}
+
+	check.UnbalancedEntries = []int64{2}
+	rec = httptest.NewRecorder()
+	a.Router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/ledger/check", nil))
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), `"unbalanced_entries":[2]`) {
+		t.Errorf("expected an inconsistent ledger to be 409, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"net/http"
+	"payment-gateway/internal/models/request"
+	"payment-gateway/internal/models/response"
+	"payment-gateway/internal/util"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// CreateRefundHandler refunds a completed deposit through the gateway that processed it, in full when the amount is
+// omitted. The refund is answered 202 while pending, a worker sends it to the gateway and its outcome is then reported
+// by the gateway's callbacks.
+// Sample Request (POST /transactions/101/refunds):
+//
+//	{
+//	    "amount": 25.00,
+//	    "reason": "damaged item"
+//	}
+func (a *API) CreateRefundHandler(w http.ResponseWriter, r *http.Request) {
+	txID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil || txID <= 0 {
+		http.Error(w, "invalid transaction id", http.StatusBadRequest)
+		return
+	}
+
+	var req request.Refund
+	if err = util.DecodeRequest(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	refund, err := a.svc.ISvcTx.CreateRefund(r.Context(), txID, req, a.svc.ISvcGateway)
+	if err != nil {
+		sendTxError(w, err)
+		return
+	}
+
+	util.SendEncodedResponse(w, response.APIResponse{
+		StatusCode: http.StatusAccepted,
+		Message:    "refund accepted for processing",
+		Data: map[string]interface{}{
+			"refund": response.NewRefund(refund),
+		},
+	}, http.StatusAccepted)
+}
+
+// RefundsHandler lists the refunds of a tx, oldest first
+// Sample Request (GET /transactions/101/refunds)
+func (a *API) RefundsHandler(w http.ResponseWriter, r *http.Request) {
+	txID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil || txID <= 0 {
+		http.Error(w, "invalid transaction id", http.StatusBadRequest)
+		return
+	}
+
+	refunds, err := a.svc.ISvcTx.Refunds(r.Context(), txID)
+	if err != nil {
+		http.Error(w, err.Error(), txErrorStatus(err))
+		return
+	}
+
+	list := []response.Refund{}
+	for _, refund := range refunds {
+		list = append(list, response.NewRefund(refund))
+	}
+
+	util.SendEncodedResponse(w, response.APIResponse{
+		StatusCode: http.StatusOK,
+		Message:    "refunds",
+		Data: map[string]interface{}{
+			"transaction_id": txID,
+			"refunds":        list,
+		},
+	}, http.StatusOK)
+}
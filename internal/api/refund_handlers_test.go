@@ -0,0 +1,75 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"payment-gateway/db"
+	"payment-gateway/internal/kafka"
+	"payment-gateway/internal/models/postgres"
+	"payment-gateway/internal/util"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRefundHandlers(t *testing.T) {
+	txs := map[int64]*postgres.Transaction{
+		1: {ID: 1, Type: "deposit", Amount: 10050, Currency: "EUR", UserID: 7, GatewayID: 3, Status: "refunded"},
+		2: {ID: 2, Type: "deposit", Amount: 10050, Currency: "EUR", UserID: 7, GatewayID: 3, Status: "processing"},
+		3: {ID: 3, Type: "withdrawal", Amount: 5000, Currency: "EUR", UserID: 7, GatewayID: 3, Status: "completed"},
+	}
+
+	a := New(&db.MockDB{
+		GetTransactionFunc: func(ctx context.Context, id int64) (*postgres.Transaction, error) {
+			tx, ok := txs[id]
+			if !ok {
+				return nil, fmt.Errorf("transaction %d %w", id, db.ErrNotFound)
+			}
+			return tx, nil
+		},
+		GetRefundsFunc: func(ctx context.Context, txID int64) ([]*postgres.Refund, error) {
+			if txID != 1 {
+				return nil, nil
+			}
+			return []*postgres.Refund{{ID: 5, TransactionID: 1, Amount: 10050, Currency: "EUR", Status: postgres.RefundCompleted, CreatedAt: time.Now(), UpdatedAt: time.Now()}}, nil
+		},
+	}, util.Timeouts{})
+	a.SetupServices(&kafka.MockKafkaProducer{})
+	a.SetupRoutes()
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		code     int
+		contains string
+	}{
+		{"list", http.MethodGet, "/transactions/1/refunds", "", http.StatusOK, `"refunds":[{"id":5,"transaction_id":1,"status":"completed","amount":100.50,"currency":"EUR"`},
+		{"list without refunds", http.MethodGet, "/transactions/2/refunds", "", http.StatusOK, `"refunds":[]`},
+		{"list of unknown tx", http.MethodGet, "/transactions/9/refunds", "", http.StatusNotFound, ""},
+		{"refund of a refunded tx", http.MethodPost, "/transactions/1/refunds", `{}`, http.StatusConflict, ""},
+		{"refund of a processing tx", http.MethodPost, "/transactions/2/refunds", `{"amount": 10.00}`, http.StatusConflict, ""},
+		{"refund of a withdrawal", http.MethodPost, "/transactions/3/refunds", `{}`, http.StatusUnprocessableEntity, "only deposits"},
+		{"refund of unknown tx", http.MethodPost, "/transactions/9/refunds", `{}`, http.StatusNotFound, ""},
+		{"invalid id", http.MethodPost, "/transactions/x/refunds", `{}`, http.StatusBadRequest, "invalid transaction id"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			a.Router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.code {
+				t.Fatalf("expected %d, got %d: %s", tt.code, rec.Code, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), tt.contains) {
+				t.Errorf("expected %s in %s", tt.contains, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"payment-gateway/internal/models/common"
+	"payment-gateway/internal/models/request"
+	"payment-gateway/internal/models/response"
+	"payment-gateway/internal/money"
+	"payment-gateway/internal/util"
+	"strings"
+)
+
+// RoutingDryRunHandler explains which routing rule and gateways would be selected for a tx, without processing it
+// Sample Request (POST /routing/dry_run):
+//
+//	{
+//	    "amount": 100.00,
+//	    "user_id": 1,
+//	    "country_id": 1,
+//	    "currency": "EUR",
+//	    "type": "withdrawal"
+//	}
+func (a *API) RoutingDryRunHandler(w http.ResponseWriter, r *http.Request) {
+	var req request.RoutingDryRun
+
+	if err := util.DecodeRequest(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	currency := money.NormalizeCurrency(req.Currency)
+	amount, err := money.FromDecimal(req.Amount, currency)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid amount: %v", err), http.StatusUnprocessableEntity)
+		return
+	}
+
+	explanation, err := a.svc.ISvcGateway.ExplainRoute(r.Context(), common.RouteRequest{
+		CountryID:       req.CountryID,
+		Currency:        currency,
+		Amount:          amount.Amount,
+		TransactionType: req.Type,
+		UserID:          req.UserID,
+		RoutingKey:      "dry-run",
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "no gateways available for the specified country") {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	util.SendEncodedResponse(w, response.APIResponse{
+		StatusCode: http.StatusOK,
+		Message:    "routing dry run",
+		Data: map[string]interface{}{
+			"explanation": explanation,
+		},
+	}, http.StatusOK)
+}
+
+// RoutingRulesHandler lists the enabled routing rules in evaluation order
+// Sample Request (GET /routing/rules)
+func (a *API) RoutingRulesHandler(w http.ResponseWriter, r *http.Request) {
+	rules, err := a.svc.ISvcGateway.RoutingRules(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	util.SendEncodedResponse(w, response.APIResponse{
+		StatusCode: http.StatusOK,
+		Message:    "routing rules",
+		Data: map[string]interface{}{
+			"rules": rules,
+		},
+	}, http.StatusOK)
+}
+
+// CreateRoutingRuleHandler stores a new routing rule
+// Sample Request (POST /routing/rules):
+//
+//	{
+//	    "name": "EUR/GBP withdrawals",
+//	    "priority": 10,
+//	    "currencies": ["EUR", "GBP"],
+//	    "min_amount": 100000,
+//	    "transaction_type": "withdrawal",
+//	    "gateway_ids": [3, 1],
+//	    "enabled": true
+//	}
+func (a *API) CreateRoutingRuleHandler(w http.ResponseWriter, r *http.Request) {
+	var rule common.RoutingRule
+
+	if err := util.DecodeRequest(r, &rule); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := a.svc.ISvcGateway.CreateRoutingRule(r.Context(), &rule); err != nil {
+		if strings.HasPrefix(err.Error(), "invalid rule") {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	util.SendEncodedResponse(w, response.APIResponse{
+		StatusCode: http.StatusCreated,
+		Message:    "routing rule created",
+		Data: map[string]interface{}{
+			"rule": rule,
+		},
+	}, http.StatusCreated)
+}
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"payment-gateway/internal/models/common"
+	"payment-gateway/internal/models/request"
+	"payment-gateway/internal/models/response"
+	"payment-gateway/internal/services/tx"
+	"payment-gateway/internal/util"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// GetTransactionHandler returns a single tx, as JSON or XML depending on the Accept header
+// Sample Request (GET /transactions/101)
+func (a *API) GetTransactionHandler(w http.ResponseWriter, r *http.Request) {
+	if !util.NegotiateResponse(w, r) {
+		http.Error(w, "none of the accepted content types is supported", http.StatusNotAcceptable)
+		return
+	}
+
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "invalid transaction id", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := a.svc.ISvcTx.GetTransaction(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), txErrorStatus(err))
+		return
+	}
+
+	util.SendEncodedResponse(w, response.NewTransaction(tx), http.StatusOK)
+}
+
+// ListTransactionsHandler lists the txs matching the query filters, newest first unless sorted otherwise. The
+// next_cursor of a page is passed as the cursor parameter to get the next one, along with the same filters and sort.
+// Sample Request (GET /transactions?user_id=1&status=completed&from=2024-01-01T00:00:00Z&sort=amount&order=asc&limit=20)
+func (a *API) ListTransactionsHandler(w http.ResponseWriter, r *http.Request) {
+	if !util.NegotiateResponse(w, r) {
+		http.Error(w, "none of the accepted content types is supported", http.StatusNotAcceptable)
+		return
+	}
+
+	filter, err := parseTransactionFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := a.svc.ISvcTx.ListTransactions(r.Context(), filter, r.URL.Query().Get("cursor"))
+	if err != nil {
+		http.Error(w, err.Error(), txErrorStatus(err))
+		return
+	}
+
+	list := response.TransactionList{Transactions: []response.Transaction{}, NextCursor: page.NextCursor}
+	for _, tx := range page.Transactions {
+		list.Transactions = append(list.Transactions, response.NewTransaction(tx))
+	}
+
+	util.SendEncodedResponse(w, list, http.StatusOK)
+}
+
+// CancelTransactionHandler cancels a tx whose status still allows it, voiding it at the gateway that accepted it. The
+// tx is answered 202, still processing, when the void is retried in the background. The body, and its reason, are
+// optional.
+// Sample Request (POST /transactions/101/cancel):
+//
+//	{
+//	    "reason": "changed my mind"
+//	}
+func (a *API) CancelTransactionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "invalid transaction id", http.StatusBadRequest)
+		return
+	}
+
+	var req request.Cancellation
+	if r.ContentLength != 0 {
+		if err = util.DecodeRequest(r, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	transaction, err := a.svc.ISvcTx.CancelTransaction(r.Context(), id, req.Reason, a.svc.ISvcGateway)
+	if err != nil {
+		sendTxError(w, err)
+		return
+	}
+
+	status, message := http.StatusOK, "transaction cancelled"
+	if transaction.Status != tx.StatusCancelled {
+		status, message = http.StatusAccepted, "transaction being voided by the gateway"
+	}
+
+	util.SendEncodedResponse(w, response.APIResponse{
+		StatusCode: status,
+		Message:    message,
+		Data: map[string]interface{}{
+			"transaction": response.NewTransaction(transaction),
+		},
+	}, status)
+}
+
+// parseTransactionFilter reads the list filters from the query string, the values are validated by the tx service
+func parseTransactionFilter(r *http.Request) (common.TransactionFilter, error) {
+	q := r.URL.Query()
+	filter := common.TransactionFilter{
+		Status:    q.Get("status"),
+		Type:      q.Get("type"),
+		Currency:  q.Get("currency"),
+		SortBy:    q.Get("sort"),
+		SortOrder: q.Get("order"),
+	}
+
+	ints := []struct {
+		name string
+		dest *int
+	}{
+		{"user_id", &filter.UserID},
+		{"gateway_id", &filter.GatewayID},
+		{"country_id", &filter.CountryID},
+		{"limit", &filter.Limit},
+	}
+	for _, param := range ints {
+		if v := q.Get(param.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return filter, fmt.Errorf("invalid %s, must be an integer", param.name)
+			}
+			*param.dest = n
+		}
+	}
+
+	times := []struct {
+		name string
+		dest **time.Time
+	}{
+		{"from", &filter.From},
+		{"to", &filter.To},
+	}
+	for _, param := range times {
+		if v := q.Get(param.name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return filter, fmt.Errorf("invalid %s, must be an RFC 3339 date", param.name)
+			}
+			*param.dest = &t
+		}
+	}
+
+	return filter, nil
+}
@@ -0,0 +1,186 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"payment-gateway/db"
+	"payment-gateway/internal/kafka"
+	"payment-gateway/internal/models/common"
+	"payment-gateway/internal/models/postgres"
+	"payment-gateway/internal/models/response"
+	"payment-gateway/internal/util"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTransactionsAPI(t *testing.T, listed *common.TransactionFilter) *API {
+	t.Helper()
+
+	stored := &postgres.Transaction{ID: 101, Type: "deposit", Status: "completed", Amount: 10050, Currency: "EUR", Fee: 25,
+		UserID: 1, GatewayID: 2, CountryID: 276, CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+	a := New(&db.MockDB{
+		GetTransactionFunc: func(ctx context.Context, id int64) (*postgres.Transaction, error) {
+			if id != stored.ID {
+				return nil, fmt.Errorf("transaction %d %w", id, db.ErrNotFound)
+			}
+			return stored, nil
+		},
+		ListTransactionsFunc: func(ctx context.Context, filter common.TransactionFilter) ([]*postgres.Transaction, error) {
+			*listed = filter
+			return []*postgres.Transaction{stored}, nil
+		},
+	}, util.Timeouts{})
+	a.SetupServices(&kafka.MockKafkaProducer{})
+	a.SetupRoutes()
+
+	return a
+}
+
+func TestGetTransactionHandler(t *testing.T) {
+	var listed common.TransactionFilter
+	a := newTransactionsAPI(t, &listed)
+
+	tests := []struct {
+		name       string
+		path       string
+		accept     string
+		wantStatus int
+		wantType   string
+	}{
+		{name: "json by default", path: "/transactions/101", wantStatus: http.StatusOK, wantType: "application/json"},
+		{name: "xml when accepted", path: "/transactions/101", accept: "application/xml, application/json;q=0.5", wantStatus: http.StatusOK, wantType: "application/xml"},
+		{name: "not found", path: "/transactions/7", wantStatus: http.StatusNotFound},
+		{name: "invalid id", path: "/transactions/abc", wantStatus: http.StatusBadRequest},
+		{name: "unsupported type", path: "/transactions/101", accept: "text/csv", wantStatus: http.StatusNotAcceptable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rr := httptest.NewRecorder()
+			a.Router.ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, rr.Code, rr.Body.String())
+			}
+			if tt.wantType == "" {
+				return
+			}
+			if ct := rr.Header().Get("Content-Type"); ct != tt.wantType {
+				t.Fatalf("expected content type %s, got %s", tt.wantType, ct)
+			}
+
+			var tx response.Transaction
+			decode := json.Unmarshal
+			if strings.Contains(tt.wantType, "xml") {
+				decode = xml.Unmarshal
+			}
+			if err := decode(rr.Body.Bytes(), &tx); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if tx.ID != 101 || tx.Amount != "100.50" || tx.Fee != "0.25" || tx.Currency != "EUR" {
+				t.Fatalf("unexpected transaction: %+v", tx)
+			}
+		})
+	}
+}
+
+func TestListTransactionsHandler(t *testing.T) {
+	var listed common.TransactionFilter
+	a := newTransactionsAPI(t, &listed)
+
+	req := httptest.NewRequest(http.MethodGet, "/transactions?user_id=1&status=completed&currency=eur&from=2024-01-01T00:00:00Z&sort=amount&order=asc&limit=1", nil)
+	rr := httptest.NewRecorder()
+	a.Router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if listed.UserID != 1 || listed.Status != "completed" || listed.Currency != "EUR" || listed.From == nil ||
+		listed.SortBy != "amount" || listed.SortOrder != "asc" {
+		t.Fatalf("unexpected filter: %+v", listed)
+	}
+
+	var list response.TransactionList
+	if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(list.Transactions) != 1 || list.NextCursor != "" {
+		t.Fatalf("expected a single page with one tx, got %+v", list)
+	}
+
+	for _, query := range []string{"user_id=abc", "from=yesterday"} {
+		rr = httptest.NewRecorder()
+		a.Router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/transactions?"+query, nil))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400 for %s, got %d", query, rr.Code)
+		}
+	}
+
+	rr = httptest.NewRecorder()
+	a.Router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/transactions?status=done", nil))
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status 422 for an unknown status, got %d", rr.Code)
+	}
+}
+
+func TestCancelTransactionHandler(t *testing.T) {
+	statuses := map[int64]string{1: "pending", 2: "completed"}
+
+	a := New(&db.MockDB{
+		GetTransactionFunc: func(ctx context.Context, id int64) (*postgres.Transaction, error) {
+			status, ok := statuses[id]
+			if !ok {
+				return nil, fmt.Errorf("transaction %d %w", id, db.ErrNotFound)
+			}
+			return &postgres.Transaction{ID: id, Type: "withdrawal", Amount: 5000, Currency: "EUR", UserID: 7, Status: status}, nil
+		},
+		UpdateTxStatusFunc: func(ctx context.Context, change *postgres.TransactionStatusChange, allowedFrom []string, event *postgres.OutboxEvent, entry *postgres.JournalEntry) error {
+			change.FromStatus = statuses[change.TransactionID]
+			statuses[change.TransactionID] = change.ToStatus
+			return nil
+		},
+	}, util.Timeouts{})
+	a.SetupServices(&kafka.MockKafkaProducer{})
+	a.SetupRoutes()
+
+	tests := []struct {
+		name     string
+		path     string
+		body     string
+		code     int
+		contains string
+	}{
+		{"pending tx", "/transactions/1/cancel", `{"reason": "changed my mind"}`, http.StatusOK, `"status":"cancelled"`},
+		{"cancelled tx", "/transactions/1/cancel", "", http.StatusConflict, "from cancelled to cancelled"},
+		{"completed tx", "/transactions/2/cancel", "", http.StatusConflict, "from completed to cancelled"},
+		{"unknown tx", "/transactions/9/cancel", "", http.StatusNotFound, ""},
+		{"invalid body", "/transactions/2/cancel", `{"reason":`, http.StatusBadRequest, ""},
+		{"invalid id", "/transactions/x/cancel", "", http.StatusBadRequest, "invalid transaction id"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			a.Router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.code {
+				t.Fatalf("expected %d, got %d: %s", tt.code, rec.Code, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), tt.contains) {
+				t.Errorf("expected %s in %s", tt.contains, rec.Body.String())
+			}
+		})
+	}
+}
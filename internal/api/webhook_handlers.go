@@ -0,0 +1,223 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"payment-gateway/db"
+	"payment-gateway/internal/models/common"
+	"payment-gateway/internal/models/postgres"
+	"payment-gateway/internal/models/response"
+	"payment-gateway/internal/services/webhook"
+	"payment-gateway/internal/util"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// CreateWebhookEndpointHandler registers an endpoint notified of the status changes of the transactions of its owner,
+// a user. Without event types the endpoint receives every event. The secret signing the webhooks is generated when
+// omitted and is only returned in this response.
+// Sample Request (POST /webhooks/endpoints):
+//
+//	{
+//	    "owner_id": 42,
+//	    "url": "https://merchant.example.com/webhooks",
+//	    "event_types": ["transaction.completed", "transaction.failed"]
+//	}
+func (a *API) CreateWebhookEndpointHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		OwnerID    int      `json:"owner_id"`
+		URL        string   `json:"url"`
+		Secret     string   `json:"secret"`
+		EventTypes []string `json:"event_types"`
+	}
+
+	if err := util.DecodeRequest(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	endpoint := common.WebhookEndpoint{OwnerID: req.OwnerID, URL: req.URL, Secret: req.Secret, EventTypes: req.EventTypes}
+	if err := a.svc.ISvcWebhook.RegisterWebhookEndpoint(r.Context(), &endpoint); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, webhook.ErrInvalidEndpoint) {
+			status = http.StatusUnprocessableEntity
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	util.SendEncodedResponse(w, response.APIResponse{
+		StatusCode: http.StatusCreated,
+		Message:    "webhook endpoint created",
+		Data: map[string]interface{}{
+			"endpoint": endpoint,
+			"secret":   endpoint.Secret,
+		},
+	}, http.StatusCreated)
+}
+
+// WebhookEndpointsHandler lists the webhook endpoints, the disabled ones included
+// Sample Request (GET /webhooks/endpoints)
+func (a *API) WebhookEndpointsHandler(w http.ResponseWriter, r *http.Request) {
+	endpoints, err := a.svc.ISvcWebhook.WebhookEndpoints(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if endpoints == nil {
+		endpoints = []*common.WebhookEndpoint{}
+	}
+
+	util.SendEncodedResponse(w, response.APIResponse{
+		StatusCode: http.StatusOK,
+		Message:    "webhook endpoints",
+		Data: map[string]interface{}{
+			"endpoints": endpoints,
+		},
+	}, http.StatusOK)
+}
+
+// setWebhookEndpointEnabledHandler enables or disables an endpoint. Enabling an endpoint resets its failure count,
+// the deliveries still pending are sent again.
+// Sample Request (POST /webhooks/endpoints/3/enable)
+func (a *API) setWebhookEndpointEnabledHandler(enabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil || id <= 0 {
+			http.Error(w, "invalid webhook endpoint id", http.StatusBadRequest)
+			return
+		}
+
+		endpoint, err := a.svc.ISvcWebhook.SetWebhookEndpointEnabled(r.Context(), id, enabled)
+		if err != nil {
+			http.Error(w, err.Error(), webhookErrorStatus(err))
+			return
+		}
+
+		message := "webhook endpoint disabled"
+		if enabled {
+			message = "webhook endpoint enabled"
+		}
+
+		util.SendEncodedResponse(w, response.APIResponse{
+			StatusCode: http.StatusOK,
+			Message:    message,
+			Data: map[string]interface{}{
+				"endpoint": endpoint,
+			},
+		}, http.StatusOK)
+	}
+}
+
+// WebhookDeliveriesHandler lists the webhook deliveries, newest first
+// Sample Request (GET /webhooks/deliveries?status=failed&endpoint_id=3&limit=50)
+func (a *API) WebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := common.WebhookDeliveryFilter{Status: q.Get("status")}
+	var endpointID, limit int64
+
+	switch filter.Status {
+	case "", postgres.WebhookPending, postgres.WebhookDelivered, postgres.WebhookFailed:
+	default:
+		http.Error(w, "invalid status, must be pending, delivered or failed", http.StatusBadRequest)
+		return
+	}
+
+	for name, dest := range map[string]*int64{"endpoint_id": &endpointID, "transaction_id": &filter.TransactionID, "before_id": &filter.BeforeID, "limit": &limit} {
+		if v := q.Get(name); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid %s, must be an integer", name), http.StatusBadRequest)
+				return
+			}
+			*dest = n
+		}
+	}
+	filter.EndpointID, filter.Limit = int(endpointID), int(limit)
+
+	deliveries, err := a.svc.ISvcWebhook.WebhookDeliveries(r.Context(), filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	list := []response.WebhookDelivery{}
+	for _, delivery := range deliveries {
+		list = append(list, response.NewWebhookDelivery(delivery))
+	}
+
+	util.SendEncodedResponse(w, response.APIResponse{
+		StatusCode: http.StatusOK,
+		Message:    "webhook deliveries",
+		Data: map[string]interface{}{
+			"deliveries": list,
+		},
+	}, http.StatusOK)
+}
+
+// WebhookDeliveryHandler returns a webhook delivery along with the log of its attempts
+// Sample Request (GET /webhooks/deliveries/42)
+func (a *API) WebhookDeliveryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "invalid webhook delivery id", http.StatusBadRequest)
+		return
+	}
+
+	delivery, attempts, err := a.svc.ISvcWebhook.WebhookDelivery(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), webhookErrorStatus(err))
+		return
+	}
+
+	entries := []response.WebhookAttempt{}
+	for _, attempt := range attempts {
+		entries = append(entries, response.NewWebhookAttempt(attempt))
+	}
+
+	util.SendEncodedResponse(w, response.APIResponse{
+		StatusCode: http.StatusOK,
+		Message:    "webhook delivery",
+		Data: map[string]interface{}{
+			"delivery": response.NewWebhookDelivery(delivery),
+			"attempts": entries,
+		},
+	}, http.StatusOK)
+}
+
+// RedeliverWebhookHandler queues a webhook delivery again with a fresh set of attempts, e.g. once the merchant fixed
+// an endpoint the delivery failed on
+// Sample Request (POST /webhooks/deliveries/42/redeliver)
+func (a *API) RedeliverWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "invalid webhook delivery id", http.StatusBadRequest)
+		return
+	}
+
+	delivery, err := a.svc.ISvcWebhook.RedeliverWebhook(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), webhookErrorStatus(err))
+		return
+	}
+
+	util.SendEncodedResponse(w, response.APIResponse{
+		StatusCode: http.StatusAccepted,
+		Message:    "webhook delivery queued",
+		Data: map[string]interface{}{
+			"delivery": response.NewWebhookDelivery(delivery),
+		},
+	}, http.StatusAccepted)
+}
+
+// webhookErrorStatus maps an error returned by the webhook service to an HTTP status code
+func webhookErrorStatus(err error) int {
+	if errors.Is(err, db.ErrNotFound) {
+		return http.StatusNotFound
+	}
+
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"payment-gateway/db"
+	"payment-gateway/internal/kafka"
+	"payment-gateway/internal/models/common"
+	"payment-gateway/internal/models/postgres"
+	"payment-gateway/internal/util"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testAdminKey = "admin-key-0123456789"
+
+// newWebhookAPI keeps the webhook endpoints in memory and serves delivery 7, which failed
+func newWebhookAPI() *API {
+	var endpoints []*common.WebhookEndpoint
+	failed := &postgres.WebhookDelivery{ID: 7, EndpointID: 1, EventID: 70, TransactionID: 42, EventType: "transaction.completed",
+		Status: postgres.WebhookFailed, Attempts: 10, LastError: "endpoint answered 500", CreatedAt: time.Now()}
+
+	a := New(&db.MockDB{
+		CreateWebhookEndpointFunc: func(ctx context.Context, endpoint *common.WebhookEndpoint) error {
+			endpoint.ID, endpoint.Enabled = len(endpoints)+1, true
+			endpoints = append(endpoints, endpoint)
+			return nil
+		},
+		GetWebhookEndpointsFunc: func(ctx context.Context) ([]*common.WebhookEndpoint, error) {
+			return endpoints, nil
+		},
+		GetWebhookDeliveryFunc: func(ctx context.Context, id int64) (*postgres.WebhookDelivery, error) {
+			if id != failed.ID {
+				return nil, fmt.Errorf("webhook delivery %d %w", id, db.ErrNotFound)
+			}
+			return failed, nil
+		},
+		GetWebhookAttemptsFunc: func(ctx context.Context, deliveryID int64) ([]*postgres.WebhookAttempt, error) {
+			return []*postgres.WebhookAttempt{{DeliveryID: deliveryID, Attempt: 1, StatusCode: 500, Error: "endpoint answered 500"}}, nil
+		},
+		RedeliverWebhookFunc: func(ctx context.Context, id int64) (*postgres.WebhookDelivery, error) {
+			if id != failed.ID {
+				return nil, fmt.Errorf("webhook delivery %d %w", id, db.ErrNotFound)
+			}
+			redelivered := *failed
+			redelivered.Status, redelivered.Attempts, redelivered.LastError = postgres.WebhookPending, 0, ""
+			return &redelivered, nil
+		},
+	}, util.Timeouts{})
+	a.auth = AuthConfig{AdminAPIKeys: []string{testAdminKey}}
+	a.SetupServices(&kafka.MockKafkaProducer{})
+	a.SetupRoutes()
+
+	return a
+}
+
+func serveWebhookRequest(a *API, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+testAdminKey)
+	rec := httptest.NewRecorder()
+	a.Router.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func TestCreateWebhookEndpointHandler(t *testing.T) {
+	a := newWebhookAPI()
+
+	rec := serveWebhookRequest(a, http.MethodPost, "/webhooks/endpoints", `{"owner_id": 42, "url": "https://merchant.example.com/hooks", "event_types": ["transaction.completed"]}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var res struct {
+		Data struct {
+			Endpoint map[string]interface{} `json:"endpoint"`
+			Secret   string                 `json:"secret"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if res.Data.Secret == "" || res.Data.Endpoint["id"] != float64(1) {
+		t.Errorf("expected the endpoint and its secret, got %s", rec.Body.String())
+	}
+
+	// the secret is only returned on creation
+	rec = serveWebhookRequest(a, http.MethodGet, "/webhooks/endpoints", "")
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), res.Data.Secret) {
+		t.Errorf("expected the endpoints without their secret, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = serveWebhookRequest(a, http.MethodPost, "/webhooks/endpoints", `{"owner_id": 42, "url": "https://merchant.example.com", "event_types": ["payout.sent"]}`)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422 for an unknown event type, got %d", rec.Code)
+	}
+
+	rec = serveWebhookRequest(a, http.MethodPost, "/webhooks/endpoints", `{"owner_id": 42, "url": "http://169.254.169.254/latest/meta-data"}`)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422 for a link-local address, got %d", rec.Code)
+	}
+}
+
+func TestWebhookRoutesRequireAdminKey(t *testing.T) {
+	a := newWebhookAPI()
+
+	for _, authorization := range []string{"", "Bearer wrong-key", testAdminKey} {
+		req := httptest.NewRequest(http.MethodPost, "/webhooks/endpoints", strings.NewReader(`{"owner_id": 42, "url": "https://merchant.example.com/hooks"}`))
+		req.Header.Set("Content-Type", "application/json")
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		rec := httptest.NewRecorder()
+		a.Router.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("expected 401 for authorization %q, got %d", authorization, rec.Code)
+		}
+	}
+
+	if endpoints, _ := a.db.GetWebhookEndpoints(context.Background()); len(endpoints) != 0 {
+		t.Errorf("expected no endpoint to be registered, got %d", len(endpoints))
+	}
+}
+
+func TestWebhookDeliveryHandlers(t *testing.T) {
+	a := newWebhookAPI()
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		code     int
+		contains string
+	}{
+		{"delivery with attempts", http.MethodGet, "/webhooks/deliveries/7", http.StatusOK, `"status_code":500`},
+		{"unknown delivery", http.MethodGet, "/webhooks/deliveries/8", http.StatusNotFound, ""},
+		{"redeliver", http.MethodPost, "/webhooks/deliveries/7/redeliver", http.StatusAccepted, `"status":"pending"`},
+		{"redeliver unknown delivery", http.MethodPost, "/webhooks/deliveries/8/redeliver", http.StatusNotFound, ""},
+		{"invalid id", http.MethodPost, "/webhooks/deliveries/x/redeliver", http.StatusBadRequest, ""},
+		{"invalid status filter", http.MethodGet, "/webhooks/deliveries?status=lost", http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serveWebhookRequest(a, tt.method, tt.path, "")
+			if rec.Code != tt.code {
+				t.Fatalf("expected %d, got %d: %s", tt.code, rec.Code, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), tt.contains) {
+				t.Errorf("expected %s in %s", tt.contains, rec.Body.String())
+			}
+		})
+	}
+}
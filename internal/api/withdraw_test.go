@@ -2,6 +2,7 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
@@ -17,7 +18,7 @@ func TestWithdrawHandler(t *testing.T) {
 
 	// Mock request payload
 	withdrawRequest := request.Transaction{
-		Amount:    50.0,
+		Amount:    "50.00",
 		UserID:    1,
 		CountryID: 840,
 		Currency:  "USD",
@@ -40,18 +41,39 @@ func TestWithdrawHandler(t *testing.T) {
 	// Create a response recorder
 	rr := httptest.NewRecorder()
 
+	// Stand-in provider accepting the transaction
+	psp := newMockPSP(t)
+
 	// Call the handler
 	a := API{db: &db.MockDB{
-		GetSupportedGatewaysByCountryFunc: func(countryID int) ([]*common.Gateway, error) {
+		GetCountryCurrenciesFunc: func(ctx context.Context, countryID int) ([]string, error) {
+			return []string{"USD"}, nil
+		},
+		GetSupportedGatewaysByCountryFunc: func(ctx context.Context, countryID int) ([]*common.Gateway, error) {
 			return []*common.Gateway{
 				{ID: 1, Name: "Mock Gateway", Priority: 1},
 			}, nil
 		},
-		CreateTransactionFunc: func(tx *postgres.Transaction) error {
+		GetRoutingRulesFunc: func(ctx context.Context) ([]*common.RoutingRule, error) {
+			return nil, nil
+		},
+		GetGatewayFeesFunc: func(ctx context.Context, gatewayIDs []int) ([]*common.FeeSchedule, error) {
+			return nil, nil
+		},
+		GetGatewayByIDFunc: func(ctx context.Context, gatewayID int) (*common.Gateway, error) {
+			return &common.Gateway{ID: gatewayID, Name: "Mock Gateway", DataFormatSupported: "application/json", EndpointURL: psp.URL}, nil
+		},
+		CreateTransactionFunc: func(ctx context.Context, tx *postgres.Transaction, hold func(tx *postgres.Transaction) *postgres.JournalEntry) error {
 			tx.ID = 12345
 			return nil
 		},
-		UpdateTxStatusFunc: func(txID int64, status string) error {
+		UpdateTxStatusFunc: func(ctx context.Context, change *postgres.TransactionStatusChange, allowedFrom []string, event *postgres.OutboxEvent, entry *postgres.JournalEntry) error {
+			return nil
+		},
+		UpdateTxGatewayFunc: func(ctx context.Context, tx *postgres.Transaction) error {
+			return nil
+		},
+		CreateTxAttemptFunc: func(ctx context.Context, attempt *postgres.TransactionAttempt) error {
 			return nil
 		},
 	}}
@@ -60,8 +82,8 @@ func TestWithdrawHandler(t *testing.T) {
 	handler.ServeHTTP(rr, req)
 
 	// Assert response status code
-	if rr.Code != http.StatusOK {
-		t.Errorf("Expected status code %d, got %d", http.StatusOK, rr.Code)
+	if rr.Code != http.StatusAccepted {
+		t.Errorf("Expected status code %d, got %d", http.StatusAccepted, rr.Code)
 	}
 
 	// Assert response body
@@ -71,7 +93,7 @@ func TestWithdrawHandler(t *testing.T) {
 		t.Fatalf("Failed to unmarshal response body: %v", err)
 	}
 
-	if response["message"] != "tx under processing" {
-		t.Errorf("Expected message 'Transaction processed successfully', got %s", response["message"])
+	if response["message"] != "tx accepted for processing" {
+		t.Errorf("Expected message 'tx accepted for processing', got %s", response["message"])
 	}
 }
@@ -0,0 +1,203 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"payment-gateway/internal/retry"
+	"payment-gateway/internal/util"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// ErrPoisonMessage is wrapped by handler errors for messages that will never be processed (e.g. they can't be
+// parsed), such messages go to the dead-letter topic without being retried. Any other error is transient, the
+// message is retried until it is handled.
+var ErrPoisonMessage = errors.New("poison message")
+
+// Headers set on the messages sent to the dead-letter topic
+const (
+	HeaderDLQError     = "dlq_error"
+	HeaderDLQTopic     = "dlq_source_topic"
+	HeaderDLQPartition = "dlq_source_partition"
+	HeaderDLQOffset    = "dlq_source_offset"
+	HeaderDLQAttempts  = "dlq_attempts"
+)
+
+type (
+	// ConsumerConfig controls the consumer group and how failed messages are retried
+	ConsumerConfig struct {
+		Brokers  []string
+		GroupID  string
+		Topics   []string
+		DLQTopic string
+		// RetryBackoff is the delay before the second try of a message, it doubles after every failure up to
+		// MaxRetryBackoff
+		RetryBackoff    time.Duration
+		MaxRetryBackoff time.Duration
+	}
+
+	// Handler processes a single message. It must be idempotent since messages are delivered at least once.
+	Handler func(ctx context.Context, msg kafka.Message) error
+
+	// Consumer reads the transaction topics as part of a consumer group. Offsets are committed manually once a
+	// message was handled or dead-lettered, so a crash or a rebalance redelivers the uncommitted messages.
+	Consumer struct {
+		cfg     ConsumerConfig
+		reader  messageReader
+		dlq     messageWriter
+		handler Handler
+	}
+
+	messageReader interface {
+		FetchMessage(ctx context.Context) (kafka.Message, error)
+		CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+		Close() error
+	}
+
+	messageWriter interface {
+		WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+		Close() error
+	}
+)
+
+// LoadConsumerConfig reads the consumer settings from the environment
+func LoadConsumerConfig(kafkaURL string) ConsumerConfig {
+	return ConsumerConfig{
+		Brokers:         strings.Split(kafkaURL, ","),
+		GroupID:         util.GetEnvString("KAFKA_CONSUMER_GROUP", "payment-gateway"),
+		Topics:          strings.Split(util.GetEnvString("KAFKA_CONSUMER_TOPICS", "transactions.json,transactions.soap"), ","),
+		DLQTopic:        util.GetEnvString("KAFKA_DLQ_TOPIC", "transactions.dlq"),
+		RetryBackoff:    util.GetEnvDuration("KAFKA_CONSUMER_RETRY_BACKOFF", time.Second),
+		MaxRetryBackoff: util.GetEnvDuration("KAFKA_CONSUMER_MAX_RETRY_BACKOFF", time.Minute),
+	}
+}
+
+// NewKafkaConsumer creates a consumer group member reading the configured topics
+func NewKafkaConsumer(cfg ConsumerConfig, handler Handler) *Consumer {
+	return &Consumer{
+		cfg: cfg,
+		reader: kafka.NewReader(kafka.ReaderConfig{
+			Brokers:     cfg.Brokers,
+			GroupID:     cfg.GroupID,
+			GroupTopics: cfg.Topics,
+			StartOffset: kafka.FirstOffset,
+			// offsets are only committed explicitly, after the message was handled
+			CommitInterval:        0,
+			WatchPartitionChanges: true,
+		}),
+		dlq: &kafka.Writer{
+			Addr:                   kafka.TCP(cfg.Brokers...),
+			Topic:                  cfg.DLQTopic,
+			Balancer:               &kafka.Hash{},
+			AllowAutoTopicCreation: true,
+		},
+		handler: handler,
+	}
+}
+
+// Run consumes messages until ctx is cancelled. The attempt running when ctx is cancelled is finished, and the message
+// committed if it succeeded, before the consumer leaves the group, so the partitions are handed over cleanly. A
+// message still failing is left uncommitted for the next owner of its partition.
+func (c *Consumer) Run(ctx context.Context) error {
+	defer c.close()
+
+	for {
+		msg, err := c.reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return fmt.Errorf("failed to fetch message: %v", err)
+		}
+
+		if err = c.handle(ctx, msg); err != nil {
+			if ctx.Err() != nil {
+				log.Printf("stopped retrying offset %d of %s/%d: %v", msg.Offset, msg.Topic, msg.Partition, err)
+				return nil
+			}
+			return err
+		}
+
+		if err = c.reader.CommitMessages(context.Background(), msg); err != nil {
+			// the partition was most likely reassigned, the new owner gets the message again
+			log.Printf("failed to commit offset %d of %s/%d: %v", msg.Offset, msg.Topic, msg.Partition, err)
+		}
+	}
+}
+
+// handle runs the handler until it succeeds and moves poison messages to the dead-letter topic. Transient errors
+// are retried with exponential backoff, capped at MaxRetryBackoff, for as long as it takes: skipping the message
+// would lose it, so the partition waits. An error is returned when ctx is cancelled while waiting for the next try,
+// or when a poison message could not be dead-lettered.
+func (c *Consumer) handle(ctx context.Context, msg kafka.Message) error {
+	attempts := 0
+	policy := retry.Policy{
+		InitialInterval: c.cfg.RetryBackoff,
+		MaxInterval:     c.cfg.MaxRetryBackoff,
+		Multiplier:      2,
+		Jitter:          0.2,
+		Retryable: func(err error) bool {
+			return !errors.Is(err, ErrPoisonMessage)
+		},
+		OnRetry: func(attempt int, err error, delay time.Duration) {
+			log.Printf("attempt %d to handle offset %d of %s/%d failed, retrying in %s: %v", attempt, msg.Offset, msg.Topic,
+				msg.Partition, delay.Round(time.Millisecond), err)
+		},
+		OnDone: func(n int, err error) {
+			attempts = n
+		},
+	}
+
+	// an attempt is finished even if ctx gets cancelled meanwhile, only the wait for the next one is interrupted
+	err := policy.Do(ctx, func(context.Context) error {
+		return c.handler(context.Background(), msg)
+	})
+	if err == nil || !errors.Is(err, ErrPoisonMessage) {
+		return err
+	}
+
+	log.Printf("moving offset %d of %s/%d to %s: %v", msg.Offset, msg.Topic, msg.Partition, c.cfg.DLQTopic, err)
+
+	dead := kafka.Message{
+		Key:   msg.Key,
+		Value: msg.Value,
+		Headers: append(append([]kafka.Header(nil), msg.Headers...),
+			kafka.Header{Key: HeaderDLQError, Value: []byte(err.Error())},
+			kafka.Header{Key: HeaderDLQTopic, Value: []byte(msg.Topic)},
+			kafka.Header{Key: HeaderDLQPartition, Value: []byte(strconv.Itoa(msg.Partition))},
+			kafka.Header{Key: HeaderDLQOffset, Value: []byte(strconv.FormatInt(msg.Offset, 10))},
+			kafka.Header{Key: HeaderDLQAttempts, Value: []byte(strconv.Itoa(attempts))},
+		),
+	}
+	if dlqErr := c.dlq.WriteMessages(context.Background(), dead); dlqErr != nil {
+		return fmt.Errorf("failed to dead-letter offset %d of %s/%d: %v", msg.Offset, msg.Topic, msg.Partition, dlqErr)
+	}
+
+	return nil
+}
+
+func (c *Consumer) close() {
+	if err := c.reader.Close(); err != nil {
+		log.Printf("failed to close kafka reader: %v", err)
+	}
+	if err := c.dlq.Close(); err != nil {
+		log.Printf("failed to close dead-letter writer: %v", err)
+	}
+}
+
+// Header returns the value of a message header, empty when it is missing
+func Header(msg kafka.Message, key string) string {
+	for _, h := range msg.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,193 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// fakeReader serves msgs once, then blocks until ctx is cancelled
+type fakeReader struct {
+	msgs      []kafka.Message
+	committed []int64
+	cancel    context.CancelFunc
+}
+
+func (r *fakeReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	if len(r.msgs) == 0 {
+		r.cancel()
+		<-ctx.Done()
+		return kafka.Message{}, ctx.Err()
+	}
+
+	msg := r.msgs[0]
+	r.msgs = r.msgs[1:]
+	return msg, nil
+}
+
+func (r *fakeReader) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	for _, msg := range msgs {
+		r.committed = append(r.committed, msg.Offset)
+	}
+	return nil
+}
+
+func (r *fakeReader) Close() error {
+	return nil
+}
+
+type fakeWriter struct {
+	written []kafka.Message
+	err     error
+}
+
+func (w *fakeWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.written = append(w.written, msgs...)
+	return nil
+}
+
+func (w *fakeWriter) Close() error {
+	return nil
+}
+
+func runConsumer(t *testing.T, msgs []kafka.Message, dlq *fakeWriter, handler Handler) (*fakeReader, error) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reader := &fakeReader{msgs: msgs, cancel: cancel}
+	c := &Consumer{
+		cfg:     ConsumerConfig{DLQTopic: "transactions.dlq"},
+		reader:  reader,
+		dlq:     dlq,
+		handler: handler,
+	}
+
+	return reader, c.Run(ctx)
+}
+
+func TestConsumer_RetriesThenCommits(t *testing.T) {
+	attempts := map[int64]int{}
+	dlq := &fakeWriter{}
+	msgs := []kafka.Message{{Topic: "transactions.json", Offset: 1}, {Topic: "transactions.json", Offset: 2}}
+
+	// transient errors are retried for as long as they last, the message is never dead-lettered
+	reader, err := runConsumer(t, msgs, dlq, func(ctx context.Context, msg kafka.Message) error {
+		attempts[msg.Offset]++
+		if msg.Offset == 2 && attempts[msg.Offset] < 20 {
+			return errors.New("db unavailable")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(reader.committed) != 2 || reader.committed[0] != 1 || reader.committed[1] != 2 {
+		t.Fatalf("expected offsets 1 and 2 to be committed in order, got %v", reader.committed)
+	}
+	if attempts[2] != 20 || len(dlq.written) != 0 {
+		t.Fatalf("expected offset 2 to succeed on the 20th attempt, got %d attempts and %d dead-lettered", attempts[2], len(dlq.written))
+	}
+}
+
+func TestConsumer_StopsRetryingOnShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	attempts := 0
+	dlq := &fakeWriter{}
+	reader := &fakeReader{msgs: []kafka.Message{{Topic: "transactions.json", Offset: 4}}, cancel: cancel}
+	c := &Consumer{
+		// the wait for the next try would outlast the test if it ignored ctx
+		cfg:    ConsumerConfig{DLQTopic: "transactions.dlq", RetryBackoff: time.Hour, MaxRetryBackoff: time.Hour},
+		reader: reader,
+		dlq:    dlq,
+		handler: func(ctx context.Context, msg kafka.Message) error {
+			attempts++
+			cancel()
+			return errors.New("db unavailable")
+		},
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- c.Run(ctx) }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the consumer to stop waiting for the next try on shutdown")
+	}
+
+	if attempts != 1 || len(reader.committed) != 0 || len(dlq.written) != 0 {
+		t.Fatalf("expected the message to be left uncommitted for the next owner, got %d attempts, %v committed and %d dead-lettered",
+			attempts, reader.committed, len(dlq.written))
+	}
+}
+
+func TestConsumer_DeadLetters(t *testing.T) {
+	tests := []struct {
+		name         string
+		err          error
+		wantAttempts int
+	}{
+		{name: "poison message", err: fmt.Errorf("bad json: %w", ErrPoisonMessage), wantAttempts: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			dlq := &fakeWriter{}
+			msgs := []kafka.Message{{Topic: "transactions.json", Partition: 2, Offset: 7, Key: []byte("10")}}
+
+			reader, err := runConsumer(t, msgs, dlq, func(ctx context.Context, msg kafka.Message) error {
+				attempts++
+				return tt.err
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if attempts != tt.wantAttempts {
+				t.Fatalf("expected %d attempts, got %d", tt.wantAttempts, attempts)
+			}
+			if len(dlq.written) != 1 || string(dlq.written[0].Key) != "10" {
+				t.Fatalf("expected the message to be dead-lettered with its key, got %v", dlq.written)
+			}
+			dead := dlq.written[0]
+			if Header(dead, HeaderDLQTopic) != "transactions.json" || Header(dead, HeaderDLQPartition) != "2" ||
+				Header(dead, HeaderDLQOffset) != "7" || Header(dead, HeaderDLQError) != tt.err.Error() {
+				t.Fatalf("unexpected dead-letter headers: %v", dead.Headers)
+			}
+			if len(reader.committed) != 1 || reader.committed[0] != 7 {
+				t.Fatalf("expected the dead-lettered offset to be committed, got %v", reader.committed)
+			}
+		})
+	}
+}
+
+func TestConsumer_StopsWhenDeadLetteringFails(t *testing.T) {
+	dlq := &fakeWriter{err: errors.New("broker unavailable")}
+	msgs := []kafka.Message{{Topic: "transactions.json", Offset: 3}}
+
+	reader, err := runConsumer(t, msgs, dlq, func(ctx context.Context, msg kafka.Message) error {
+		return ErrPoisonMessage
+	})
+	if err == nil {
+		t.Fatal("expected an error when the dead-letter topic is unavailable")
+	}
+	if len(reader.committed) != 0 {
+		t.Fatalf("expected nothing to be committed, got %v", reader.committed)
+	}
+}
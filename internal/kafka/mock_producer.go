@@ -2,18 +2,19 @@ package kafka
 
 import (
 	"context"
+	"fmt"
 	"github.com/segmentio/kafka-go"
 	"log"
+	"payment-gateway/internal/models/postgres"
 )
 
 type MockKafkaProducer struct {
 	Messages []kafka.Message // Captures the sent messages
 }
 
-// PubTx simulates sending messages to Kafka
-func (p *MockKafkaProducer) PubTx(ctx context.Context, txId int64, msg []byte, format string) error {
-	log.Printf("Mock Kafka Producer sent message: %s\n", string(msg))
-	p.Messages = append(p.Messages, kafka.Message{})
+// PubEvent simulates publishing an outbox event to Kafka
+func (p *MockKafkaProducer) PubEvent(ctx context.Context, event *postgres.OutboxEvent) error {
+	p.Messages = append(p.Messages, kafka.Message{Key: []byte(fmt.Sprintf("%d", event.AggregateID)), Value: event.Payload})
 	return nil
 }
 
@@ -2,15 +2,27 @@ package kafka
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"payment-gateway/internal/models/postgres"
+	"payment-gateway/internal/retry"
 	"payment-gateway/internal/util"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/segmentio/kafka-go"
 	"github.com/sony/gobreaker"
 )
 
+// Headers set on the published events
+const (
+	HeaderEventID     = "event_id"
+	HeaderEventType   = "event_type"
+	HeaderContentType = "content_type"
+)
+
 // CircuitBreaker configuration for Kafka publisher
 var cb = gobreaker.NewCircuitBreaker(gobreaker.Settings{
 	Name:        "KafkaPublisher",
@@ -19,60 +31,76 @@ var cb = gobreaker.NewCircuitBreaker(gobreaker.Settings{
 	Timeout:     3 * time.Second, // Timeout for an operation
 })
 
+// publishPolicy retries a publish on broker errors, within the publish timeout. An open breaker is
+// returned straight away, the caller retries later.
+var publishPolicy = retry.Policy{
+	MaxAttempts:     5,
+	InitialInterval: 100 * time.Millisecond,
+	MaxInterval:     2 * time.Second,
+	Multiplier:      2,
+	Jitter:          0.2,
+	Retryable:       isRetryablePublishError,
+	OnRetry: func(attempt int, err error, delay time.Duration) {
+		log.Printf("kafka publish attempt %d failed, retrying in %s: %v", attempt, delay.Round(time.Millisecond), err)
+	},
+}
+
 type (
 	IProducer interface {
-		PubTx(ctx context.Context, txId int64, msg []byte, format string) error
+		PubEvent(ctx context.Context, event *postgres.OutboxEvent) error
 		Close() error
 	}
 
 	Producer struct {
 		writer *kafka.Writer
+		// timeout bounds a publish, retries included, on top of the deadline of the caller's ctx
+		timeout time.Duration
 	}
 )
 
 // NewKafkaProducer creates a new Kafka producer
-func NewKafkaProducer(kafkaURL string) IProducer {
+func NewKafkaProducer(kafkaURL string, timeout time.Duration) IProducer {
 	return &Producer{
+		timeout: timeout,
 		writer: &kafka.Writer{
-			Addr:                   kafka.TCP(kafkaURL),
-			Balancer:               &kafka.LeastBytes{},
+			Addr: kafka.TCP(kafkaURL),
+			// messages of a tx share a key, hashing it keeps them on one partition and so in order
+			Balancer:               &kafka.Hash{},
 			AllowAutoTopicCreation: true,
 			BatchTimeout:           10 * time.Millisecond,
 		},
 	}
 }
 
-// PubTx publishes a message to the Kafka topic
-func (p Producer) PubTx(ctx context.Context, txId int64, msg []byte, format string) error {
+// PubEvent publishes an outbox event to the topic of its aggregate keyed by the aggregate, the event ID and type travel
+// as headers so consumers can deduplicate and route events without parsing them
+func (p Producer) PubEvent(ctx context.Context, event *postgres.OutboxEvent) error {
 	if p.writer == nil {
 		return fmt.Errorf("kafka writer is not initialized")
 	}
 
-	// Circuit breaker logic for Kafka publishing
-	return util.RetryOperation(func() error {
-		return PubTxWithCB(func() error {
-			topic, err := GetTopic(format)
-			if err != nil {
-				return err
-			}
-
-			kafkaMessage := kafka.Message{
-				Key:   []byte(fmt.Sprintf("%d", txId)),
-				Value: msg,
-				Topic: topic,
-			}
-
-			err = p.writer.WriteMessages(ctx, kafkaMessage)
-			if err != nil {
-				log.Printf("failed to publish msg to Kafka: %v", err)
-				return err
-			}
+	topic, err := GetTopic(event.EventType, event.ContentType)
+	if err != nil {
+		return err
+	}
 
-			log.Println("message successfully published to Kafka on topic " + string(topic))
+	ctx, cancel := util.WithTimeout(ctx, p.timeout)
+	defer cancel()
 
-			return nil
+	return publishPolicy.Do(ctx, func(ctx context.Context) error {
+		return PubTxWithCB(func() error {
+			return p.writer.WriteMessages(ctx, kafka.Message{
+				Topic: topic,
+				Key:   []byte(strconv.FormatInt(event.AggregateID, 10)),
+				Value: event.Payload,
+				Headers: []kafka.Header{
+					{Key: HeaderEventID, Value: []byte(strconv.FormatInt(event.ID, 10))},
+					{Key: HeaderEventType, Value: []byte(event.EventType)},
+					{Key: HeaderContentType, Value: []byte(event.ContentType)},
+				},
+			})
 		})
-	}, 5)
+	})
 }
 
 // PubTxWithCB uses a circuit breaker to manage Kafka publishing
@@ -83,13 +111,30 @@ func PubTxWithCB(op func() error) error {
 	return err
 }
 
-// GetTopic returns the appropriate Kafka topic based on the data format
-func GetTopic(dataFormat string) (string, error) {
+func isRetryablePublishError(err error) bool {
+	return !errors.Is(err, gobreaker.ErrOpenState) && !errors.Is(err, gobreaker.ErrTooManyRequests)
+}
+
+// GetTopic returns the Kafka topic of an event based on its aggregate, the prefix of its type (e.g. refund.completed
+// goes to refunds.*), and its data format
+func GetTopic(eventType, dataFormat string) (string, error) {
+	var topic string
+	switch aggregate, _, _ := strings.Cut(eventType, "."); aggregate {
+	case "transaction":
+		topic = "transactions"
+	case "refund":
+		topic = "refunds"
+	case "dispute":
+		topic = "disputes"
+	default:
+		return "", fmt.Errorf("unsupported event type: %s", eventType)
+	}
+
 	switch dataFormat {
 	case "application/json":
-		return "transactions.json", nil
+		return topic + ".json", nil
 	case "text/xml", "application/xml":
-		return "transactions.soap", nil
+		return topic + ".soap", nil
 	default:
 		return "", fmt.Errorf("unsupported data format: %s", dataFormat)
 	}
@@ -0,0 +1,25 @@
+package kafka
+
+import "testing"
+
+func TestGetTopic(t *testing.T) {
+	tests := []struct {
+		eventType, dataFormat string
+		want                  string
+		wantErr               bool
+	}{
+		{eventType: "transaction.completed", dataFormat: "application/json", want: "transactions.json"},
+		{eventType: "transaction.failed", dataFormat: "text/xml", want: "transactions.soap"},
+		{eventType: "refund.completed", dataFormat: "application/json", want: "refunds.json"},
+		{eventType: "dispute.opened", dataFormat: "application/xml", want: "disputes.soap"},
+		{eventType: "payout.completed", dataFormat: "application/json", wantErr: true},
+		{eventType: "transaction.completed", dataFormat: "text/plain", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := GetTopic(tt.eventType, tt.dataFormat)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("GetTopic(%q, %q) = %q, %v, want %q", tt.eventType, tt.dataFormat, got, err, tt.want)
+		}
+	}
+}
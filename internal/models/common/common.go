@@ -1,11 +1,257 @@
 package common
 
+import (
+	"payment-gateway/internal/models/postgres"
+	"payment-gateway/internal/money"
+	"time"
+)
+
 type (
 	Gateway struct {
 		ID                  int    `json:"id"`
 		Name                string `json:"name"`
 		DataFormatSupported string
-		Priority            int `json:"priority"`
-		CountryID           int `json:"country_id"`
+		EndpointURL         string `json:"endpoint_url"`
+		HealthCheckURL      string `json:"health_check_url"`
+		Priority            int    `json:"priority"`
+		Weight              int    `json:"weight"`
+		CountryID           int    `json:"country_id"`
+
+		// SupportedCurrencies are the currencies the gateway accepts, empty meaning any
+		SupportedCurrencies []string `json:"supported_currencies,omitempty"`
+
+		// CallbackSecret signs the callbacks posted by the gateway, it is never serialized
+		CallbackSecret string `json:"-"`
+		// CallbackAllowedIPs are the IPs or CIDRs the gateway posts callbacks from, empty meaning any
+		CallbackAllowedIPs []string `json:"callback_allowed_ips,omitempty"`
+
+		// ExpectedFee is the fee, in minor units, the gateway would charge for the tx being routed, nil when it has no
+		// fee schedule
+		ExpectedFee *int64 `json:"expected_fee,omitempty"`
+	}
+
+	// FeeSchedule is what a gateway charges per tx, a fixed amount in minor units of the tx currency plus a
+	// percentage of the tx amount. Empty currency or transaction type apply to any.
+	FeeSchedule struct {
+		GatewayID       int           `json:"gateway_id"`
+		Currency        string        `json:"currency,omitempty"`
+		TransactionType string        `json:"transaction_type,omitempty"`
+		FixedFee        int64         `json:"fixed_fee"`
+		PercentageFee   money.Decimal `json:"percentage_fee"`
+	}
+
+	// RouteRequest holds what gateway selection knows about the tx being routed, the amount is in minor units
+	RouteRequest struct {
+		CountryID       int    `json:"country_id"`
+		Currency        string `json:"currency"`
+		Amount          int64  `json:"amount"`
+		TransactionType string `json:"transaction_type"`
+		UserID          int    `json:"user_id"`
+		RoutingKey      string `json:"routing_key"`
+	}
+
+	// RoutingRule maps the txs matching all its conditions to an ordered list of gateways.
+	// Empty conditions match any tx, amounts are in minor units of the tx currency.
+	RoutingRule struct {
+		ID              int      `json:"id"`
+		Name            string   `json:"name"`
+		Priority        int      `json:"priority"`
+		CountryID       int      `json:"country_id,omitempty"`
+		Currencies      []string `json:"currencies,omitempty"`
+		MinAmount       *int64   `json:"min_amount,omitempty"`
+		MaxAmount       *int64   `json:"max_amount,omitempty"`
+		TransactionType string   `json:"transaction_type,omitempty"`
+		UserSegments    []string `json:"user_segments,omitempty"`
+		GatewayIDs      []int    `json:"gateway_ids"`
+		Enabled         bool     `json:"enabled"`
+	}
+
+	// RouteExplanation describes how gateways were selected for a route request
+	RouteExplanation struct {
+		Strategy    string            `json:"strategy"`
+		MatchedRule *RoutingRule      `json:"matched_rule"`
+		UserSegment string            `json:"user_segment,omitempty"`
+		Rules       []RuleEvaluation  `json:"rules"`
+		Candidates  []*Gateway        `json:"candidates"`
+		Excluded    []ExcludedGateway `json:"excluded,omitempty"`
+	}
+
+	// RuleEvaluation tells whether a routing rule matched and why not
+	RuleEvaluation struct {
+		RuleID  int    `json:"rule_id"`
+		Name    string `json:"name"`
+		Matched bool   `json:"matched"`
+		Reason  string `json:"reason,omitempty"`
+	}
+
+	// ExcludedGateway is a gateway left out of the candidates
+	ExcludedGateway struct {
+		GatewayID int    `json:"gateway_id"`
+		Reason    string `json:"reason"`
+	}
+
+	// GatewayHealth is the cached result of the active health checks for a gateway
+	GatewayHealth struct {
+		GatewayID            int                       `json:"gateway_id"`
+		GatewayName          string                    `json:"gateway_name"`
+		Status               string                    `json:"status"`
+		ConsecutiveSuccesses int                       `json:"consecutive_successes"`
+		ConsecutiveFailures  int                       `json:"consecutive_failures"`
+		LastError            string                    `json:"last_error,omitempty"`
+		LastCheckedAt        time.Time                 `json:"last_checked_at"`
+		Transitions          []GatewayHealthTransition `json:"transitions"`
+	}
+
+	// GatewayBreaker is the circuit breaker state of a gateway with the stats of its sliding window
+	GatewayBreaker struct {
+		GatewayID   int     `json:"gateway_id"`
+		GatewayName string  `json:"gateway_name"`
+		State       string  `json:"state"`
+		Requests    int     `json:"requests"`
+		ErrorRate   float64 `json:"error_rate"`
+		TimeoutRate float64 `json:"timeout_rate"`
+		P95Latency  int64   `json:"p95_latency_ms"`
+	}
+
+	// GatewayHealthTransition records a change of a gateway's health status
+	GatewayHealthTransition struct {
+		From string    `json:"from"`
+		To   string    `json:"to"`
+		At   time.Time `json:"at"`
+	}
+
+	// TransactionFilter selects the txs to list, zero values don't filter. From is inclusive and To exclusive. Txs
+	// are sorted by SortBy (created_at or amount) then ID, in SortOrder, and listed after the After cursor if set.
+	TransactionFilter struct {
+		UserID    int
+		Status    string
+		Type      string
+		GatewayID int
+		CountryID int
+		Currency  string
+		From      *time.Time
+		To        *time.Time
+		SortBy    string
+		SortOrder string
+		After     *TransactionCursor
+		Limit     int
+	}
+
+	// TransactionCursor is the position of the last tx of a page, CreatedAt or Amount is set depending on the sort
+	TransactionCursor struct {
+		ID        int64     `json:"id"`
+		CreatedAt time.Time `json:"created_at"`
+		Amount    int64     `json:"amount,omitempty"`
+	}
+
+	// WebhookEndpoint is a URL notified of the status changes of the txs of its owner, the user OwnerID, EventTypes
+	// (e.g. transaction.completed) being empty for all of them. Endpoints that keep failing are disabled with a reason.
+	WebhookEndpoint struct {
+		ID                  int        `json:"id"`
+		OwnerID             int        `json:"owner_id"`
+		URL                 string     `json:"url"`
+		Secret              string     `json:"-"`
+		EventTypes          []string   `json:"event_types"`
+		Enabled             bool       `json:"enabled"`
+		ConsecutiveFailures int        `json:"consecutive_failures"`
+		DisabledReason      string     `json:"disabled_reason,omitempty"`
+		DisabledAt          *time.Time `json:"disabled_at,omitempty"`
+		CreatedAt           time.Time  `json:"created_at"`
+	}
+
+	// WebhookDeliveryFilter selects the webhook deliveries to list, newest first, zero values don't filter. BeforeID
+	// pages through older deliveries.
+	WebhookDeliveryFilter struct {
+		EndpointID    int
+		TransactionID int64
+		Status        string
+		BeforeID      int64
+		Limit         int
+	}
+
+	// DisputeFilter selects the disputes to list, newest first, zero values don't filter. BeforeID pages through older
+	// disputes.
+	DisputeFilter struct {
+		TransactionID int64
+		GatewayID     int
+		Status        string
+		BeforeID      int64
+		Limit         int
+	}
+
+	// CallbackFilter selects the inbox callbacks to list, newest first, zero values don't filter. BeforeID pages
+	// through older callbacks.
+	CallbackFilter struct {
+		GatewayID     int
+		TransactionID int64
+		Result        string
+		BeforeID      int64
+		Limit         int
+	}
+
+	// TransactionEvent is published to Kafka whenever a tx changes status, Transaction is the tx as it was at that
+	// time when the change was made by the tx service
+	TransactionEvent struct {
+		EventType     string                `json:"event_type" xml:"event_type"`
+		TransactionID int64                 `json:"transaction_id" xml:"transaction_id"`
+		Status        string                `json:"status" xml:"status"`
+		Reason        string                `json:"reason" xml:"reason"`
+		OccurredAt    time.Time             `json:"occurred_at" xml:"occurred_at"`
+		Transaction   *postgres.Transaction `json:"transaction,omitempty" xml:"transaction,omitempty"`
+	}
+
+	// RefundEvent is published to Kafka whenever a refund changes status, keyed by its tx like the events of the tx.
+	// Refund is the refund as it was at that time.
+	RefundEvent struct {
+		EventType     string           `json:"event_type" xml:"event_type"`
+		TransactionID int64            `json:"transaction_id" xml:"transaction_id"`
+		RefundID      int64            `json:"refund_id" xml:"refund_id"`
+		Status        string           `json:"status" xml:"status"`
+		Reason        string           `json:"reason" xml:"reason"`
+		OccurredAt    time.Time        `json:"occurred_at" xml:"occurred_at"`
+		Refund        *postgres.Refund `json:"refund,omitempty" xml:"refund,omitempty"`
+	}
+
+	// DisputeEvent is published to Kafka whenever a dispute changes status or its evidence is due soon, keyed by its
+	// tx like the events of the tx. Dispute is the dispute as it was at that time.
+	DisputeEvent struct {
+		EventType     string            `json:"event_type" xml:"event_type"`
+		TransactionID int64             `json:"transaction_id" xml:"transaction_id"`
+		DisputeID     int64             `json:"dispute_id" xml:"dispute_id"`
+		Status        string            `json:"status" xml:"status"`
+		Reason        string            `json:"reason" xml:"reason"`
+		OccurredAt    time.Time         `json:"occurred_at" xml:"occurred_at"`
+		Dispute       *postgres.Dispute `json:"dispute,omitempty" xml:"dispute,omitempty"`
+	}
+
+	// DisputeImportResult is the outcome of a line of a dispute file, DisputeID is set once the line was applied
+	DisputeImportResult struct {
+		Line      int    `json:"line"`
+		DisputeID int64  `json:"dispute_id,omitempty"`
+		Status    string `json:"status,omitempty"`
+		Error     string `json:"error,omitempty"`
+	}
+
+	// LedgerCheck is the outcome of a consistency check of the ledger, the ledger is consistent when every list is
+	// empty
+	LedgerCheck struct {
+		Entries int64 `json:"entries"`
+		// UnbalancedEntries are the entries whose postings don't sum to zero
+		UnbalancedEntries []int64 `json:"unbalanced_entries"`
+		// UnbalancedCurrencies are the currencies whose postings don't sum to zero, with their sum in minor units
+		UnbalancedCurrencies map[string]int64 `json:"unbalanced_currencies"`
+		// BalanceMismatches are the accounts whose balance is not the sum of their postings
+		BalanceMismatches []int64   `json:"balance_mismatches"`
+		CheckedAt         time.Time `json:"checked_at"`
 	}
 )
+
+// Money returns the amount of the tx being routed
+func (r RouteRequest) Money() money.Money {
+	return money.New(r.Amount, r.Currency)
+}
+
+// Consistent tells whether the check found no discrepancy
+func (c LedgerCheck) Consistent() bool {
+	return len(c.UnbalancedEntries) == 0 && len(c.UnbalancedCurrencies) == 0 && len(c.BalanceMismatches) == 0
+}
@@ -1,6 +1,9 @@
 package postgres
 
-import "time"
+import (
+	"payment-gateway/internal/money"
+	"time"
+)
 
 type (
 	User struct {
@@ -16,6 +19,9 @@ type (
 		ID                  int
 		Name                string
 		DataFormatSupported string    `db:"data_format_supported"`
+		EndpointURL         string    `db:"endpoint_url"`
+		HealthCheckURL      string    `db:"health_check_url"`
+		SupportedCurrencies []string  `db:"supported_currencies"`
 		CreatedAt           time.Time `db:"created_at"`
 		UpdatedAt           time.Time `db:"updated_at"`
 	}
@@ -24,18 +30,264 @@ type (
 		ID        int
 		Name      string
 		Code      string
+		Currency  string
 		CreatedAt time.Time `db:"created_at"`
 		UpdatedAt time.Time `db:"updated_at"`
 	}
 
+	// Transaction amounts (Amount, Fee) are in minor units of Currency
 	Transaction struct {
+		ID          int64
+		Amount      int64
+		Currency    string
+		Type        string
+		Status      string
+		UserID      int       `db:"user_id"`
+		GatewayID   int       `db:"gateway_id"`
+		CountryID   int       `db:"country_id"`
+		ProviderRef string    `db:"provider_ref"`
+		Fee         int64     `db:"fee"`
+		CreatedAt   time.Time `db:"created_at"`
+	}
+
+	TransactionAttempt struct {
+		ID            int64
+		TransactionID int64     `db:"transaction_id"`
+		GatewayID     int       `db:"gateway_id"`
+		Error         string    `db:"error"`
+		LatencyMs     int64     `db:"latency_ms"`
+		ProviderRef   string    `db:"provider_ref"`
+		CreatedAt     time.Time `db:"created_at"`
+	}
+
+	// TransactionStatusChange is an entry of the status history of a tx, FromStatus is empty for the initial status
+	TransactionStatusChange struct {
+		ID            int64
+		TransactionID int64     `db:"transaction_id"`
+		FromStatus    string    `db:"from_status"`
+		ToStatus      string    `db:"to_status"`
+		Reason        string    `db:"reason"`
+		CreatedAt     time.Time `db:"created_at"`
+	}
+
+	// OutboxEvent is an event waiting to be published to Kafka, written in the same DB transaction as the change it
+	// describes. AggregateID is the Kafka message key, events of an aggregate are published in ID order.
+	OutboxEvent struct {
+		ID          int64
+		AggregateID int64      `db:"aggregate_id"`
+		EventType   string     `db:"event_type"`
+		Payload     []byte     `db:"payload"`
+		ContentType string     `db:"content_type"`
+		Attempts    int        `db:"attempts"`
+		LastError   string     `db:"last_error"`
+		CreatedAt   time.Time  `db:"created_at"`
+		PublishedAt *time.Time `db:"published_at"`
+	}
+
+	// ConsumedEvent is a tx event handled by the Kafka consumer, EventID is the ID of the outbox event it came from
+	ConsumedEvent struct {
+		EventID       int64     `db:"event_id"`
+		TransactionID int64     `db:"transaction_id"`
+		EventType     string    `db:"event_type"`
+		Status        string    `db:"status"`
+		Payload       []byte    `db:"payload"`
+		OccurredAt    time.Time `db:"occurred_at"`
+		ConsumedAt    time.Time `db:"consumed_at"`
+	}
+
+	// IdempotencyKey is a client supplied key, unique per user, and the response of the first request made with it,
+	// the response is empty while that request is in flight
+	IdempotencyKey struct {
+		UserID      int `db:"user_id"`
+		Key         string
+		RequestHash string     `db:"request_hash"`
+		StatusCode  int        `db:"status_code"`
+		ContentType string     `db:"content_type"`
+		Response    []byte     `db:"response"`
+		CreatedAt   time.Time  `db:"created_at"`
+		CompletedAt *time.Time `db:"completed_at"`
+	}
+
+	// InboxCallback is a raw callback received from a gateway, deduplicated by the provider's event ID. Result tells
+	// whether it was applied to its tx, TransactionID, ProviderStatus and Status are only set once it was parsed.
+	InboxCallback struct {
+		ID             int64
+		GatewayID      int        `db:"gateway_id"`
+		EventID        string     `db:"event_id"`
+		TransactionID  *int64     `db:"transaction_id"`
+		ProviderStatus string     `db:"provider_status"`
+		Status         string     `db:"status"`
+		Payload        []byte     `db:"payload"`
+		Result         string     `db:"result"`
+		Error          string     `db:"error"`
+		Attempts       int        `db:"attempts"`
+		ReceivedAt     time.Time  `db:"received_at"`
+		UpdatedAt      time.Time  `db:"updated_at"`
+		ProcessedAt    *time.Time `db:"processed_at"`
+	}
+
+	// WebhookDelivery is an event queued for a webhook endpoint, EventID being the outbox event it was queued with.
+	// It is retried until it is delivered or runs out of attempts.
+	WebhookDelivery struct {
+		ID            int64
+		EndpointID    int        `db:"endpoint_id"`
+		EventID       int64      `db:"event_id"`
+		TransactionID int64      `db:"transaction_id"`
+		EventType     string     `db:"event_type"`
+		Payload       []byte     `db:"payload"`
+		ContentType   string     `db:"content_type"`
+		Status        string     `db:"status"`
+		Attempts      int        `db:"attempts"`
+		LastError     string     `db:"last_error"`
+		NextAttemptAt time.Time  `db:"next_attempt_at"`
+		CreatedAt     time.Time  `db:"created_at"`
+		DeliveredAt   *time.Time `db:"delivered_at"`
+	}
+
+	// WebhookAttempt is a try of a webhook delivery, StatusCode is 0 when no response was received
+	WebhookAttempt struct {
+		ID         int64
+		DeliveryID int64     `db:"delivery_id"`
+		Attempt    int       `db:"attempt"`
+		StatusCode int       `db:"status_code"`
+		Error      string    `db:"error"`
+		DurationMs int64     `db:"duration_ms"`
+		CreatedAt  time.Time `db:"created_at"`
+	}
+
+	// Refund gives back part or all of a completed deposit through the gateway that processed it. UserID, GatewayID
+	// and Currency are those of the tx.
+	Refund struct {
+		ID            int64
+		TransactionID int64     `db:"transaction_id"`
+		UserID        int       `db:"user_id"`
+		GatewayID     int       `db:"gateway_id"`
+		Amount        int64     `db:"amount"`
+		Currency      string    `db:"currency"`
+		Status        string    `db:"status"`
+		Reason        string    `db:"reason"`
+		ProviderRef   string    `db:"provider_ref"`
+		CreatedAt     time.Time `db:"created_at"`
+		UpdatedAt     time.Time `db:"updated_at"`
+	}
+
+	// Dispute is a chargeback of a tx raised by the payer with their bank and reported by the gateway that processed
+	// the tx, identified by the gateway's ProviderRef. UserID and Currency are those of the tx, evidence contesting it
+	// is due by EvidenceDueAt.
+	Dispute struct {
+		ID            int64
+		TransactionID int64      `db:"transaction_id"`
+		UserID        int        `db:"user_id"`
+		GatewayID     int        `db:"gateway_id"`
+		ProviderRef   string     `db:"provider_ref"`
+		Amount        int64      `db:"amount"`
+		Currency      string     `db:"currency"`
+		Status        string     `db:"status"`
+		Reason        string     `db:"reason"`
+		Evidence      string     `db:"evidence"`
+		EvidenceDueAt time.Time  `db:"evidence_due_at"`
+		RemindedAt    *time.Time `db:"reminded_at"`
+		CreatedAt     time.Time  `db:"created_at"`
+		UpdatedAt     time.Time  `db:"updated_at"`
+	}
+
+	// DisputeStatusChange moves a dispute to ToStatus, Evidence is set on the dispute when not empty
+	DisputeStatusChange struct {
+		DisputeID  int64
+		FromStatus string
+		ToStatus   string
+		Evidence   string
+	}
+
+	// RefundStatusChange moves a refund to ToStatus, ProviderRef is set on the refund when not empty
+	RefundStatusChange struct {
+		RefundID    int64
+		FromStatus  string
+		ToStatus    string
+		Reason      string
+		ProviderRef string
+	}
+
+	// LedgerAccount is an account of the ledger, identified by its type, owner (user or gateway ID, 0 for system
+	// accounts) and currency. Balance is the sum of its postings in minor units, kept up to date as they are written.
+	LedgerAccount struct {
 		ID        int64
-		Amount    float64
-		Type      string
-		Status    string
-		UserID    int       `db:"user_id"`
-		GatewayID int       `db:"gateway_id"`
-		CountryID int       `db:"country_id"`
+		Type      string    `db:"type"`
+		OwnerID   int64     `db:"owner_id"`
+		Currency  string    `db:"currency"`
+		Balance   int64     `db:"balance"`
 		CreatedAt time.Time `db:"created_at"`
 	}
+
+	// JournalEntry is an immutable set of postings in a single currency that sum to zero. Reference is unique, an entry
+	// is written once however many times it is posted.
+	JournalEntry struct {
+		ID            int64
+		Reference     string     `db:"reference"`
+		TransactionID *int64     `db:"transaction_id"`
+		Currency      string     `db:"currency"`
+		Description   string     `db:"description"`
+		Postings      []*Posting `db:"-"`
+		CreatedAt     time.Time  `db:"created_at"`
+	}
+
+	// Posting adds Amount, in minor units, to the balance of the account of AccountType and OwnerID in the currency of
+	// its entry. When RequireFunds is set the account must exist and its balance must cover the amount.
+	Posting struct {
+		ID           int64
+		EntryID      int64  `db:"entry_id"`
+		AccountID    int64  `db:"account_id"`
+		AccountType  string `db:"-"`
+		OwnerID      int64  `db:"-"`
+		Amount       int64  `db:"amount"`
+		RequireFunds bool   `db:"-"`
+	}
 )
+
+// Results of an inbox callback: received while being applied, processed once applied, rejected when it cannot be
+// applied (unknown tx, illegal transition, unparseable body) and failed on errors worth retrying
+const (
+	CallbackReceived  = "received"
+	CallbackProcessed = "processed"
+	CallbackRejected  = "rejected"
+	CallbackFailed    = "failed"
+)
+
+// Statuses of a webhook delivery: pending until it is delivered, failed once it ran out of attempts
+const (
+	WebhookPending   = "pending"
+	WebhookDelivered = "delivered"
+	WebhookFailed    = "failed"
+)
+
+// Statuses of a refund: pending until the gateway accepts it, processing until the gateway reports it completed or
+// failed
+const (
+	RefundPending    = "pending"
+	RefundProcessing = "processing"
+	RefundCompleted  = "completed"
+	RefundFailed     = "failed"
+)
+
+// Statuses of a dispute: opened until evidence is submitted, then won or lost as ruled by the payer's bank
+const (
+	DisputeOpened            = "opened"
+	DisputeEvidenceSubmitted = "evidence_submitted"
+	DisputeWon               = "won"
+	DisputeLost              = "lost"
+)
+
+// Money returns the amount of the tx
+func (t Transaction) Money() money.Money {
+	return money.New(t.Amount, t.Currency)
+}
+
+// Money returns the amount of the refund
+func (r Refund) Money() money.Money {
+	return money.New(r.Amount, r.Currency)
+}
+
+// Money returns the disputed amount
+func (d Dispute) Money() money.Money {
+	return money.New(d.Amount, d.Currency)
+}
@@ -1,12 +1,36 @@
 package request
 
+import "payment-gateway/internal/money"
+
 type (
-	// Transaction is a standard request structure for the transactions
+	// Transaction is a standard request structure for the transactions, the amount is in major units (e.g. 100.50)
 	Transaction struct {
-		Amount    float64 `json:"amount" xml:"amount"`
-		UserID    int     `json:"user_id" xml:"user_id"`
-		GatewayID int     `json:"gateway_id" xml:"gateway_id"`
-		CountryID int     `json:"country_id" xml:"country_id"`
-		Currency  string  `json:"currency" xml:"currency"`
+		Amount    money.Decimal `json:"amount" xml:"amount"`
+		UserID    int           `json:"user_id" xml:"user_id"`
+		GatewayID int           `json:"gateway_id" xml:"gateway_id"`
+		CountryID int           `json:"country_id" xml:"country_id"`
+		Currency  string        `json:"currency" xml:"currency"`
+	}
+
+	// Refund is a refund of a completed deposit, the amount is in major units and defaults to what is left to refund
+	Refund struct {
+		Amount money.Decimal `json:"amount" xml:"amount"`
+		Reason string        `json:"reason" xml:"reason"`
+	}
+
+	// Cancellation is the cancellation of a tx, the reason is optional
+	Cancellation struct {
+		Reason string `json:"reason" xml:"reason"`
+	}
+
+	// DisputeEvidence is the evidence contesting a dispute, e.g. a description of the delivery and links to documents
+	DisputeEvidence struct {
+		Evidence string `json:"evidence" xml:"evidence"`
+	}
+
+	// RoutingDryRun is a tx to run gateway selection for, without processing it
+	RoutingDryRun struct {
+		Transaction
+		Type string `json:"type" xml:"type"`
 	}
 )
@@ -0,0 +1,40 @@
+package response
+
+import (
+	"payment-gateway/internal/models/postgres"
+	"time"
+)
+
+// Callback is a callback of the inbox as returned by the API, Payload is the raw body sent by the gateway
+type Callback struct {
+	ID             int64      `json:"id"`
+	GatewayID      int        `json:"gateway_id"`
+	EventID        string     `json:"event_id"`
+	TransactionID  *int64     `json:"transaction_id,omitempty"`
+	ProviderStatus string     `json:"provider_status,omitempty"`
+	Status         string     `json:"status,omitempty"`
+	Result         string     `json:"result"`
+	Error          string     `json:"error,omitempty"`
+	Attempts       int        `json:"attempts"`
+	Payload        string     `json:"payload"`
+	ReceivedAt     time.Time  `json:"received_at"`
+	ProcessedAt    *time.Time `json:"processed_at,omitempty"`
+}
+
+// NewCallback converts a stored inbox callback to its API representation
+func NewCallback(cb *postgres.InboxCallback) Callback {
+	return Callback{
+		ID:             cb.ID,
+		GatewayID:      cb.GatewayID,
+		EventID:        cb.EventID,
+		TransactionID:  cb.TransactionID,
+		ProviderStatus: cb.ProviderStatus,
+		Status:         cb.Status,
+		Result:         cb.Result,
+		Error:          cb.Error,
+		Attempts:       cb.Attempts,
+		Payload:        string(cb.Payload),
+		ReceivedAt:     cb.ReceivedAt,
+		ProcessedAt:    cb.ProcessedAt,
+	}
+}
@@ -0,0 +1,41 @@
+package response
+
+import (
+	"payment-gateway/internal/models/postgres"
+	"payment-gateway/internal/money"
+	"time"
+)
+
+// Dispute is a dispute as returned by the API, the amount is in major units
+type Dispute struct {
+	ID            int64         `json:"id"`
+	TransactionID int64         `json:"transaction_id"`
+	GatewayID     int           `json:"gateway_id"`
+	ProviderRef   string        `json:"provider_ref"`
+	Status        string        `json:"status"`
+	Amount        money.Decimal `json:"amount"`
+	Currency      string        `json:"currency"`
+	Reason        string        `json:"reason,omitempty"`
+	Evidence      string        `json:"evidence,omitempty"`
+	EvidenceDueAt time.Time     `json:"evidence_due_at"`
+	CreatedAt     time.Time     `json:"created_at"`
+	UpdatedAt     time.Time     `json:"updated_at"`
+}
+
+// NewDispute converts a stored dispute to its API representation
+func NewDispute(dispute *postgres.Dispute) Dispute {
+	return Dispute{
+		ID:            dispute.ID,
+		TransactionID: dispute.TransactionID,
+		GatewayID:     dispute.GatewayID,
+		ProviderRef:   dispute.ProviderRef,
+		Status:        dispute.Status,
+		Amount:        dispute.Money().Decimal(),
+		Currency:      dispute.Currency,
+		Reason:        dispute.Reason,
+		Evidence:      dispute.Evidence,
+		EvidenceDueAt: dispute.EvidenceDueAt,
+		CreatedAt:     dispute.CreatedAt,
+		UpdatedAt:     dispute.UpdatedAt,
+	}
+}
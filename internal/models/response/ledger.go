@@ -0,0 +1,51 @@
+package response
+
+import (
+	"payment-gateway/internal/models/postgres"
+	"payment-gateway/internal/money"
+)
+
+// Balance is the balance of a user in a currency as returned by the API, in major units. Available is what the user
+// can withdraw, held what is held for withdrawals in flight and balance their sum.
+type Balance struct {
+	Currency  string        `json:"currency"`
+	Available money.Decimal `json:"available"`
+	Held      money.Decimal `json:"held"`
+	Balance   money.Decimal `json:"balance"`
+}
+
+// NewBalances groups the available and held funds accounts of a user into one balance per currency, in the order of
+// the accounts
+func NewBalances(accounts []*postgres.LedgerAccount) []Balance {
+	type amounts struct{ available, held int64 }
+
+	var currencies []string
+	byCurrency := map[string]*amounts{}
+	for _, account := range accounts {
+		a, ok := byCurrency[account.Currency]
+		if !ok {
+			a = &amounts{}
+			byCurrency[account.Currency] = a
+			currencies = append(currencies, account.Currency)
+		}
+
+		if account.Type == "user_hold" {
+			a.held += account.Balance
+		} else {
+			a.available += account.Balance
+		}
+	}
+
+	balances := make([]Balance, 0, len(currencies))
+	for _, currency := range currencies {
+		a := byCurrency[currency]
+		balances = append(balances, Balance{
+			Currency:  currency,
+			Available: money.New(a.available, currency).Decimal(),
+			Held:      money.New(a.held, currency).Decimal(),
+			Balance:   money.New(a.available+a.held, currency).Decimal(),
+		})
+	}
+
+	return balances
+}
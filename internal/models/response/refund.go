@@ -0,0 +1,35 @@
+package response
+
+import (
+	"payment-gateway/internal/models/postgres"
+	"payment-gateway/internal/money"
+	"time"
+)
+
+// Refund is a refund as returned by the API, the amount is in major units
+type Refund struct {
+	ID            int64         `json:"id"`
+	TransactionID int64         `json:"transaction_id"`
+	Status        string        `json:"status"`
+	Amount        money.Decimal `json:"amount"`
+	Currency      string        `json:"currency"`
+	Reason        string        `json:"reason,omitempty"`
+	ProviderRef   string        `json:"provider_ref,omitempty"`
+	CreatedAt     time.Time     `json:"created_at"`
+	UpdatedAt     time.Time     `json:"updated_at"`
+}
+
+// NewRefund converts a stored refund to its API representation
+func NewRefund(refund *postgres.Refund) Refund {
+	return Refund{
+		ID:            refund.ID,
+		TransactionID: refund.TransactionID,
+		Status:        refund.Status,
+		Amount:        refund.Money().Decimal(),
+		Currency:      refund.Currency,
+		Reason:        refund.Reason,
+		ProviderRef:   refund.ProviderRef,
+		CreatedAt:     refund.CreatedAt,
+		UpdatedAt:     refund.UpdatedAt,
+	}
+}
@@ -0,0 +1,51 @@
+package response
+
+import (
+	"encoding/xml"
+	"payment-gateway/internal/models/postgres"
+	"payment-gateway/internal/money"
+	"time"
+)
+
+type (
+	// Transaction is a tx as returned by the API, amounts are in major units (e.g. 100.50)
+	Transaction struct {
+		XMLName     xml.Name      `json:"-" xml:"transaction"`
+		ID          int64         `json:"id" xml:"id"`
+		Type        string        `json:"type" xml:"type"`
+		Status      string        `json:"status" xml:"status"`
+		Amount      money.Decimal `json:"amount" xml:"amount"`
+		Currency    string        `json:"currency" xml:"currency"`
+		Fee         money.Decimal `json:"fee" xml:"fee"`
+		UserID      int           `json:"user_id" xml:"user_id"`
+		GatewayID   int           `json:"gateway_id,omitempty" xml:"gateway_id,omitempty"`
+		CountryID   int           `json:"country_id" xml:"country_id"`
+		ProviderRef string        `json:"provider_ref,omitempty" xml:"provider_ref,omitempty"`
+		CreatedAt   time.Time     `json:"created_at" xml:"created_at"`
+	}
+
+	// TransactionList is a page of txs, NextCursor is passed as the cursor of the next request and is empty on the
+	// last page
+	TransactionList struct {
+		XMLName      xml.Name      `json:"-" xml:"transactions"`
+		Transactions []Transaction `json:"transactions" xml:"transaction"`
+		NextCursor   string        `json:"next_cursor,omitempty" xml:"next_cursor,omitempty"`
+	}
+)
+
+// NewTransaction converts a stored tx to its API representation
+func NewTransaction(tx *postgres.Transaction) Transaction {
+	return Transaction{
+		ID:          tx.ID,
+		Type:        tx.Type,
+		Status:      tx.Status,
+		Amount:      tx.Money().Decimal(),
+		Currency:    tx.Currency,
+		Fee:         money.New(tx.Fee, tx.Currency).Decimal(),
+		UserID:      tx.UserID,
+		GatewayID:   tx.GatewayID,
+		CountryID:   tx.CountryID,
+		ProviderRef: tx.ProviderRef,
+		CreatedAt:   tx.CreatedAt,
+	}
+}
@@ -0,0 +1,69 @@
+package response
+
+import (
+	"payment-gateway/internal/models/postgres"
+	"time"
+)
+
+type (
+	// WebhookDelivery is a webhook delivery as returned by the API, Payload is the body sent to the endpoint
+	WebhookDelivery struct {
+		ID            int64      `json:"id"`
+		EndpointID    int        `json:"endpoint_id"`
+		EventID       int64      `json:"event_id"`
+		TransactionID int64      `json:"transaction_id"`
+		EventType     string     `json:"event_type"`
+		Status        string     `json:"status"`
+		Attempts      int        `json:"attempts"`
+		LastError     string     `json:"last_error,omitempty"`
+		NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+		Payload       string     `json:"payload"`
+		CreatedAt     time.Time  `json:"created_at"`
+		DeliveredAt   *time.Time `json:"delivered_at,omitempty"`
+	}
+
+	// WebhookAttempt is an entry of the delivery log of a webhook
+	WebhookAttempt struct {
+		Attempt    int       `json:"attempt"`
+		StatusCode int       `json:"status_code,omitempty"`
+		Error      string    `json:"error,omitempty"`
+		DurationMs int64     `json:"duration_ms"`
+		CreatedAt  time.Time `json:"created_at"`
+	}
+)
+
+// NewWebhookDelivery converts a stored webhook delivery to its API representation, the next attempt is only set
+// while the delivery is pending
+func NewWebhookDelivery(d *postgres.WebhookDelivery) WebhookDelivery {
+	delivery := WebhookDelivery{
+		ID:            d.ID,
+		EndpointID:    d.EndpointID,
+		EventID:       d.EventID,
+		TransactionID: d.TransactionID,
+		EventType:     d.EventType,
+		Status:        d.Status,
+		Attempts:      d.Attempts,
+		LastError:     d.LastError,
+		Payload:       string(d.Payload),
+		CreatedAt:     d.CreatedAt,
+		DeliveredAt:   d.DeliveredAt,
+	}
+
+	if d.Status == postgres.WebhookPending {
+		next := d.NextAttemptAt
+		delivery.NextAttemptAt = &next
+	}
+
+	return delivery
+}
+
+// NewWebhookAttempt converts a stored webhook attempt to its API representation
+func NewWebhookAttempt(a *postgres.WebhookAttempt) WebhookAttempt {
+	return WebhookAttempt{
+		Attempt:    a.Attempt,
+		StatusCode: a.StatusCode,
+		Error:      a.Error,
+		DurationMs: a.DurationMs,
+		CreatedAt:  a.CreatedAt,
+	}
+}
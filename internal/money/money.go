@@ -0,0 +1,193 @@
+package money
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+type (
+	// Money is an exact amount expressed in the minor units of its currency (e.g. cents)
+	Money struct {
+		Amount   int64  `json:"amount" xml:"amount"`
+		Currency string `json:"currency" xml:"currency"`
+	}
+
+	// Decimal is an exact decimal number kept as its literal (e.g. "100.50"), used for amounts in major units
+	// coming from requests or stored as DECIMAL columns. It is encoded as a JSON number.
+	Decimal string
+)
+
+// New creates a Money from an amount already in minor units
+func New(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+// FromDecimal converts an amount in major units to Money, rejecting amounts with more decimal places than the
+// currency allows or not fitting in 64 bits
+func FromDecimal(d Decimal, currency string) (Money, error) {
+	exp, ok := Exponent(currency)
+	if !ok {
+		return Money{}, fmt.Errorf("unknown currency %q", currency)
+	}
+
+	r, err := d.Rat()
+	if err != nil {
+		return Money{}, err
+	}
+
+	minor := new(big.Rat).Mul(r, scale(exp))
+	if !minor.IsInt() {
+		return Money{}, fmt.Errorf("amount %s has more than %d decimal places allowed for %s", d, exp, currency)
+	}
+
+	if !minor.Num().IsInt64() {
+		return Money{}, fmt.Errorf("amount %s is too large", d)
+	}
+
+	return Money{Amount: minor.Num().Int64(), Currency: currency}, nil
+}
+
+// RoundToMinor converts an amount in major units to the minor units of the currency, rounding half away from zero
+func RoundToMinor(r *big.Rat, currency string) int64 {
+	exp, _ := Exponent(currency)
+	minor := new(big.Rat).Mul(r, scale(exp))
+
+	q, m := new(big.Int).QuoRem(minor.Num(), minor.Denom(), new(big.Int))
+	if new(big.Int).Mul(m.Abs(m), big.NewInt(2)).Cmp(minor.Denom()) >= 0 {
+		if minor.Sign() < 0 {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+
+	return q.Int64()
+}
+
+// Rat returns the amount in major units
+func (m Money) Rat() *big.Rat {
+	exp, _ := Exponent(m.Currency)
+	return new(big.Rat).Quo(new(big.Rat).SetInt64(m.Amount), scale(exp))
+}
+
+// Decimal returns the amount in major units with exactly the currency's number of decimal places
+func (m Money) Decimal() Decimal {
+	exp, _ := Exponent(m.Currency)
+	return Decimal(m.Rat().FloatString(exp))
+}
+
+// Cmp compares the amount with a decimal in major units, returning -1, 0 or +1
+func (m Money) Cmp(d Decimal) (int, error) {
+	r, err := d.Rat()
+	if err != nil {
+		return 0, err
+	}
+
+	return m.Rat().Cmp(r), nil
+}
+
+func (m Money) String() string {
+	return string(m.Decimal()) + " " + m.Currency
+}
+
+// Rat parses the decimal
+func (d Decimal) Rat() (*big.Rat, error) {
+	s := strings.TrimSpace(string(d))
+	if s == "" {
+		return nil, errors.New("amount is required")
+	}
+
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return nil, fmt.Errorf("invalid amount %q", s)
+	}
+
+	return r, nil
+}
+
+// MarshalJSON encodes the decimal as a JSON number
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	if d == "" {
+		return []byte("null"), nil
+	}
+
+	if _, err := d.Rat(); err != nil {
+		return nil, err
+	}
+
+	return []byte(d), nil
+}
+
+// UnmarshalJSON accepts a JSON number or a string holding a number, keeping its exact literal
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return fmt.Errorf("invalid amount %s", data)
+	}
+
+	*d = Decimal(n)
+	if _, err := d.Rat(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UnmarshalXML reads the decimal from the element's text
+func (d *Decimal) UnmarshalXML(dec *xml.Decoder, start xml.StartElement) error {
+	var s string
+	if err := dec.DecodeElement(&s, &start); err != nil {
+		return err
+	}
+
+	*d = Decimal(strings.TrimSpace(s))
+	if _, err := d.Rat(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Scan reads a DECIMAL column
+func (d *Decimal) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*d = ""
+	case []byte:
+		*d = Decimal(v)
+	case string:
+		*d = Decimal(v)
+	case int64:
+		*d = Decimal(fmt.Sprintf("%d", v))
+	case float64:
+		*d = Decimal(strconv.FormatFloat(v, 'f', -1, 64))
+	default:
+		return fmt.Errorf("cannot scan %T into a decimal", src)
+	}
+
+	return nil
+}
+
+// Value writes the decimal to a DECIMAL column
+func (d Decimal) Value() (driver.Value, error) {
+	if d == "" {
+		return nil, nil
+	}
+
+	return string(d), nil
+}
+
+// scale returns 10^exp
+func scale(exp int) *big.Rat {
+	return new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(exp)), nil))
+}
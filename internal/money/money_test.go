@@ -0,0 +1,95 @@
+package money
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"math/big"
+	"testing"
+)
+
+func TestFromDecimal(t *testing.T) {
+	cases := []struct {
+		amount   Decimal
+		currency string
+		want     int64
+		wantErr  bool
+	}{
+		{"100.50", "USD", 10050, false},
+		{"0.1", "USD", 10, false},
+		{"1000", "JPY", 1000, false},
+		{"1.234", "KWD", 1234, false},
+		{"10.001", "USD", 0, true},
+		{"10.5", "JPY", 0, true},
+		{"abc", "USD", 0, true},
+		{"1", "XYZ", 0, true},
+		{"99999999999999999999", "USD", 0, true},
+	}
+
+	for _, c := range cases {
+		got, err := FromDecimal(c.amount, c.currency)
+		if (err != nil) != c.wantErr {
+			t.Errorf("FromDecimal(%s, %s) error = %v, want error %v", c.amount, c.currency, err, c.wantErr)
+			continue
+		}
+		if !c.wantErr && got.Amount != c.want {
+			t.Errorf("FromDecimal(%s, %s) = %d, want %d", c.amount, c.currency, got.Amount, c.want)
+		}
+	}
+}
+
+func TestRoundToMinor(t *testing.T) {
+	cases := []struct {
+		rat      *big.Rat
+		currency string
+		want     int64
+	}{
+		{big.NewRat(3205, 1000), "USD", 321},
+		{big.NewRat(3204, 1000), "USD", 320},
+		{big.NewRat(-3205, 1000), "USD", -321},
+		{big.NewRat(1, 2), "JPY", 1},
+		{big.NewRat(1, 3), "USD", 33},
+	}
+
+	for _, c := range cases {
+		if got := RoundToMinor(c.rat, c.currency); got != c.want {
+			t.Errorf("RoundToMinor(%s, %s) = %d, want %d", c.rat.FloatString(4), c.currency, got, c.want)
+		}
+	}
+}
+
+func TestMoney_Decimal(t *testing.T) {
+	if d := New(10050, "USD").Decimal(); d != "100.50" {
+		t.Errorf("expected 100.50, got %s", d)
+	}
+	if d := New(1000, "JPY").Decimal(); d != "1000" {
+		t.Errorf("expected 1000, got %s", d)
+	}
+	if c, _ := New(10050, "USD").Cmp("100.5"); c != 0 {
+		t.Errorf("expected 100.50 USD to equal 100.5, got %d", c)
+	}
+}
+
+func TestDecimal_Unmarshal(t *testing.T) {
+	var req struct {
+		Amount Decimal `json:"amount" xml:"amount"`
+	}
+
+	for _, body := range []string{`{"amount":0.1}`, `{"amount":"0.1"}`} {
+		if err := json.Unmarshal([]byte(body), &req); err != nil || req.Amount != "0.1" {
+			t.Errorf("unexpected decode of %s: %q, %v", body, req.Amount, err)
+		}
+	}
+
+	if err := json.Unmarshal([]byte(`{"amount":"ten"}`), &req); err == nil {
+		t.Errorf("expected an error for a non numeric amount")
+	}
+
+	if err := xml.Unmarshal([]byte(`<tx><amount> 12.34 </amount></tx>`), &req); err != nil || req.Amount != "12.34" {
+		t.Errorf("unexpected XML decode: %q, %v", req.Amount, err)
+	}
+
+	out, err := json.Marshal(req)
+	if err != nil || string(out) != `{"amount":12.34}` {
+		t.Errorf("unexpected encode: %s, %v", out, err)
+	}
+}
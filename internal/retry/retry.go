@@ -0,0 +1,156 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+type (
+	// Policy describes how an operation is retried: exponential backoff with jitter, bounded by a number of attempts
+	// and by the total time spent. The zero value of a field disables the corresponding bound.
+	Policy struct {
+		// MaxAttempts is the total number of tries, including the first one
+		MaxAttempts int
+		// InitialInterval is the delay before the second try, it grows by Multiplier after every failure
+		InitialInterval time.Duration
+		MaxInterval     time.Duration
+		Multiplier      float64
+		// Jitter randomizes every delay by +/- Jitter*delay (0 to 1), so clients retrying together spread out
+		Jitter float64
+		// MaxElapsedTime stops retrying once the next try would start after it
+		MaxElapsedTime time.Duration
+		// Retryable classifies the errors worth retrying, all errors are retried when nil. Errors marked with
+		// Permanent and context.Canceled are never retried.
+		Retryable func(err error) bool
+		// OnRetry is called before waiting for the next try, e.g. to log or count retries
+		OnRetry func(attempt int, err error, delay time.Duration)
+		// OnDone is called once with the number of tries made and the final error, nil on success
+		OnDone func(attempts int, err error)
+	}
+
+	// Error is returned when an operation still fails after its last try, it wraps the last error
+	Error struct {
+		Attempts int
+		Elapsed  time.Duration
+		Err      error
+	}
+
+	permanentError struct {
+		err error
+	}
+)
+
+// randFloat is replaced in tests to make the jitter deterministic
+var randFloat = rand.Float64
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("operation failed after %d attempts in %s: %v", e.Attempts, e.Elapsed.Round(time.Millisecond), e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks err as not worth retrying, whatever the policy's classifier says
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether err was marked with Permanent
+func IsPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
+
+// Do runs op until it succeeds, returns a non-retryable error, or the policy gives up. It stops waiting as soon as ctx
+// is cancelled. Non-retryable errors are returned as is, otherwise the last error is wrapped in an *Error.
+func (p Policy) Do(ctx context.Context, op func(ctx context.Context) error) error {
+	start := time.Now()
+
+	attempt := 0
+	for {
+		attempt++
+
+		err := op(ctx)
+		if err == nil {
+			p.done(attempt, nil)
+			return nil
+		}
+
+		if !p.retryable(err) {
+			p.done(attempt, err)
+			return err
+		}
+
+		delay := p.Backoff(attempt)
+		elapsed := time.Since(start)
+		if (p.MaxAttempts > 0 && attempt >= p.MaxAttempts) || (p.MaxElapsedTime > 0 && elapsed+delay > p.MaxElapsedTime) {
+			err = &Error{Attempts: attempt, Elapsed: elapsed, Err: err}
+			p.done(attempt, err)
+			return err
+		}
+
+		if p.OnRetry != nil {
+			p.OnRetry(attempt, err, delay)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			err = &Error{Attempts: attempt, Elapsed: time.Since(start), Err: fmt.Errorf("%w (retry cancelled: %w)", err, ctx.Err())}
+			p.done(attempt, err)
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// Backoff returns the delay to wait after the given failed attempt (starting at 1), jitter included
+func (p Policy) Backoff(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+
+	delay := float64(p.InitialInterval) * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxInterval > 0 && delay > float64(p.MaxInterval) {
+		delay = float64(p.MaxInterval)
+	}
+
+	if p.Jitter > 0 {
+		delay += delay * p.Jitter * (2*randFloat() - 1)
+	}
+
+	return time.Duration(delay)
+}
+
+func (p Policy) retryable(err error) bool {
+	if IsPermanent(err) || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	return p.Retryable == nil || p.Retryable(err)
+}
+
+func (p Policy) done(attempts int, err error) {
+	if p.OnDone != nil {
+		p.OnDone(attempts, err)
+	}
+}
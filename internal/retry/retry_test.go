@@ -0,0 +1,137 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	defer func(f func() float64) { randFloat = f }(randFloat)
+	randFloat = func() float64 { return 0.5 }
+
+	p := Policy{InitialInterval: time.Second, MaxInterval: 10 * time.Second, Multiplier: 2}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second}
+	for i, w := range want {
+		if got := p.Backoff(i + 1); got != w {
+			t.Errorf("attempt %d: expected %s, got %s", i+1, w, got)
+		}
+	}
+
+	p.Jitter = 0.5
+	randFloat = func() float64 { return 1 }
+	if got := p.Backoff(1); got != 1500*time.Millisecond {
+		t.Errorf("expected the jitter to add up to half the delay, got %s", got)
+	}
+	randFloat = func() float64 { return 0 }
+	if got := p.Backoff(1); got != 500*time.Millisecond {
+		t.Errorf("expected the jitter to remove up to half the delay, got %s", got)
+	}
+}
+
+func TestDo(t *testing.T) {
+	errTransient := errors.New("transient")
+	errFatal := errors.New("fatal")
+
+	tests := []struct {
+		name         string
+		failures     []error
+		retryable    func(err error) bool
+		wantAttempts int
+		wantErr      error
+		wantWrapped  bool
+	}{
+		{name: "succeeds after retries", failures: []error{errTransient, errTransient}, wantAttempts: 3},
+		{name: "gives up after max attempts", failures: []error{errTransient, errTransient, errTransient, errTransient}, wantAttempts: 3, wantErr: errTransient, wantWrapped: true},
+		{name: "permanent error", failures: []error{Permanent(errFatal)}, wantAttempts: 1, wantErr: errFatal},
+		{
+			name:         "classified as not retryable",
+			failures:     []error{errTransient, errFatal},
+			retryable:    func(err error) bool { return !errors.Is(err, errFatal) },
+			wantAttempts: 2,
+			wantErr:      errFatal,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var retries, doneAttempts int
+			p := Policy{
+				MaxAttempts:     3,
+				InitialInterval: time.Millisecond,
+				Multiplier:      2,
+				Retryable:       tt.retryable,
+				OnRetry:         func(attempt int, err error, delay time.Duration) { retries++ },
+				OnDone:          func(attempts int, err error) { doneAttempts = attempts },
+			}
+
+			attempts := 0
+			err := p.Do(context.Background(), func(ctx context.Context) error {
+				attempts++
+				if attempts <= len(tt.failures) {
+					return tt.failures[attempts-1]
+				}
+				return nil
+			})
+
+			if attempts != tt.wantAttempts || doneAttempts != tt.wantAttempts || retries != tt.wantAttempts-1 {
+				t.Fatalf("expected %d attempts, got %d (done hook %d, retry hook %d)", tt.wantAttempts, attempts, doneAttempts, retries)
+			}
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected the last error %v to be wrapped, got %v", tt.wantErr, err)
+			}
+			var retryErr *Error
+			if errors.As(err, &retryErr) != tt.wantWrapped {
+				t.Fatalf("expected *Error to be returned: %t, got %v", tt.wantWrapped, err)
+			}
+		})
+	}
+}
+
+func TestDo_MaxElapsedTime(t *testing.T) {
+	p := Policy{InitialInterval: 30 * time.Millisecond, MaxElapsedTime: 50 * time.Millisecond}
+
+	attempts := 0
+	err := p.Do(context.Background(), func(ctx context.Context) error {
+		attempts++
+		return errors.New("transient")
+	})
+
+	var retryErr *Error
+	if !errors.As(err, &retryErr) || attempts != 2 {
+		t.Fatalf("expected to give up after 2 attempts, got %d: %v", attempts, err)
+	}
+}
+
+func TestDo_ContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	errTransient := errors.New("transient")
+	p := Policy{InitialInterval: time.Hour}
+
+	attempts := 0
+	done := make(chan error, 1)
+	go func() {
+		done <- p.Do(ctx, func(ctx context.Context) error {
+			attempts++
+			return errTransient
+		})
+	}()
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) || !errors.Is(err, errTransient) || attempts != 1 {
+			t.Fatalf("expected the wait to be cancelled after 1 attempt, got %d: %v", attempts, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the cancellation to stop the wait")
+	}
+}
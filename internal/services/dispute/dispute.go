@@ -0,0 +1,388 @@
+package dispute
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"payment-gateway/db"
+	"payment-gateway/internal/models/common"
+	"payment-gateway/internal/models/postgres"
+	"payment-gateway/internal/money"
+	"payment-gateway/internal/services/gateway/adapter"
+	"payment-gateway/internal/services/ledger"
+	"payment-gateway/internal/services/tx"
+	"payment-gateway/internal/util"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// EventEvidenceDue is the type of the event reminding that the evidence of an opened dispute is due soon
+	EventEvidenceDue = "dispute.evidence_due"
+
+	// maxEvidenceLength caps the evidence recorded on a dispute
+	maxEvidenceLength = 10000
+
+	defaultListLimit = 50
+	maxListLimit     = 200
+
+	// reminderBatchSize is the number of reminders sent per run
+	reminderBatchSize = 100
+)
+
+var (
+	// ErrInvalidDispute is wrapped by the errors returned when a dispute or what is reported about it is invalid
+	ErrInvalidDispute = errors.New("invalid dispute")
+	// ErrIllegalTransition is wrapped by the errors returned when a dispute cannot move to a status from its own
+	ErrIllegalTransition = errors.New("illegal dispute transition")
+)
+
+// transitions lists the statuses a dispute may move to from each status. A gateway may report a dispute won or lost
+// without evidence having been submitted.
+var transitions = map[string][]string{
+	postgres.DisputeOpened:            {postgres.DisputeEvidenceSubmitted, postgres.DisputeWon, postgres.DisputeLost},
+	postgres.DisputeEvidenceSubmitted: {postgres.DisputeWon, postgres.DisputeLost},
+	postgres.DisputeWon:               nil,
+	postgres.DisputeLost:              nil,
+}
+
+// disputableStatuses are the statuses of a deposit whose amount was credited to the user and not refunded in full,
+// only those can be disputed
+var disputableStatuses = map[string]bool{
+	tx.StatusCompleted: true,
+}
+
+type (
+	// DisputeConfig controls the evidence deadlines of disputes and the reminders sent before them
+	DisputeConfig struct {
+		// EvidenceWindow is how long after a dispute is opened its evidence is due, when the gateway sets no deadline
+		EvidenceWindow time.Duration
+		// ReminderInterval is how often disputes due soon are looked for, 0 disables the reminders
+		ReminderInterval time.Duration
+		// ReminderLead is how long before its deadline a dispute is reminded of
+		ReminderLead time.Duration
+		// ReminderRepeat is how long after a reminder a dispute still opened is reminded of again
+		ReminderRepeat time.Duration
+	}
+
+	SvcDispute struct {
+		db  db.Idb
+		cfg DisputeConfig
+	}
+
+	ISvcDispute interface {
+		ProcessDisputeCallback(ctx context.Context, gatewayID int, cb *adapter.Callback) (*postgres.Dispute, error)
+		ImportDisputes(ctx context.Context, file io.Reader) ([]common.DisputeImportResult, error)
+		SubmitDisputeEvidence(ctx context.Context, id int64, evidence string) (*postgres.Dispute, error)
+		Dispute(ctx context.Context, id int64) (*postgres.Dispute, error)
+		Disputes(ctx context.Context, filter common.DisputeFilter) ([]*postgres.Dispute, error)
+		RunDisputeReminders(ctx context.Context)
+	}
+)
+
+// LoadDisputeConfig reads the dispute settings from the environment
+func LoadDisputeConfig() DisputeConfig {
+	return DisputeConfig{
+		EvidenceWindow:   util.GetEnvDuration("DISPUTE_EVIDENCE_WINDOW", 7*24*time.Hour),
+		ReminderInterval: util.GetEnvDuration("DISPUTE_REMINDER_INTERVAL", time.Hour),
+		ReminderLead:     util.GetEnvDuration("DISPUTE_REMINDER_LEAD", 72*time.Hour),
+		ReminderRepeat:   util.GetEnvDuration("DISPUTE_REMINDER_REPEAT", 24*time.Hour),
+	}
+}
+
+func NewSvcDispute(db db.Idb, cfg DisputeConfig) ISvcDispute {
+	return &SvcDispute{db: db, cfg: cfg}
+}
+
+// IsValidStatus tells whether status is a known dispute status
+func IsValidStatus(status string) bool {
+	_, ok := transitions[status]
+	return ok
+}
+
+// allowedFrom returns the statuses a dispute may move to status from
+func allowedFrom(status string) []string {
+	var from []string
+	for s, to := range transitions {
+		for _, t := range to {
+			if t == status {
+				from = append(from, s)
+			}
+		}
+	}
+	sort.Strings(from)
+
+	return from
+}
+
+// ProcessDisputeCallback applies a verified gateway callback reporting a dispute of a tx, the tx must have been
+// processed by that gateway. The dispute is opened the first time the gateway reports it, whatever its status, and
+// then moved to the reported status.
+func (s SvcDispute) ProcessDisputeCallback(ctx context.Context, gatewayID int, cb *adapter.Callback) (*postgres.Dispute, error) {
+	return s.apply(ctx, gatewayID, cb, fmt.Sprintf("callback from gateway %d: %s", gatewayID, cb.ProviderStatus))
+}
+
+// apply opens the dispute reported by cb if it is new and moves it to the reported status, reason being recorded with
+// the changes
+func (s SvcDispute) apply(ctx context.Context, gatewayID int, cb *adapter.Callback, reason string) (*postgres.Dispute, error) {
+	if cb.Dispute == nil || !IsValidStatus(cb.Status) {
+		return nil, fmt.Errorf("%w: status %q does not apply to a dispute", ErrInvalidDispute, cb.Status)
+	}
+
+	t, err := s.db.GetTransaction(ctx, cb.TransactionID)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return nil, err
+		}
+		log.Printf("failed to fetch tx %d: %v", cb.TransactionID, err)
+
+		return nil, errors.New("failed to fetch transaction from database")
+	}
+
+	// a gateway may only report on its own txs, others are answered as if they did not exist
+	if t.GatewayID != gatewayID {
+		return nil, fmt.Errorf("transaction %d of gateway %d %w", cb.TransactionID, gatewayID, db.ErrNotFound)
+	}
+
+	dispute, err := s.newDispute(t, gatewayID, cb.Dispute)
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := s.db.CreateDispute(ctx, dispute, func(d *postgres.Dispute) (*postgres.OutboxEvent, error) {
+		return newDisputeEvent("dispute."+d.Status, d, reason)
+	})
+	if errors.Is(err, db.ErrDisputeExceedsAmount) {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidDispute, err)
+	}
+	if err != nil {
+		log.Printf("failed to save dispute %s of tx %d: %v", cb.Dispute.ProviderRef, t.ID, err)
+
+		return nil, errors.New("failed to save dispute to database")
+	}
+	if created {
+		log.Printf("dispute %d of tx %d opened by gateway %d", dispute.ID, t.ID, gatewayID)
+	}
+
+	if cb.Status == postgres.DisputeOpened && created {
+		return dispute, nil
+	}
+
+	if err = s.transition(ctx, dispute, cb.Status, reason, ""); err != nil {
+		return nil, err
+	}
+
+	return dispute, nil
+}
+
+// newDispute returns the opened dispute of the deposit t reported by a gateway, the whole deposit being disputed when
+// the gateway reports no amount. What is left to dispute once refunds and other disputes are deducted is checked when
+// the dispute is stored.
+func (s SvcDispute) newDispute(t *postgres.Transaction, gatewayID int, reported *adapter.Dispute) (*postgres.Dispute, error) {
+	if t.Type != "deposit" || !disputableStatuses[t.Status] {
+		return nil, fmt.Errorf("%w: transaction %d is a %s %s, only completed deposits can be disputed", ErrInvalidDispute, t.ID, t.Status, t.Type)
+	}
+	if reported.Currency != "" && money.NormalizeCurrency(reported.Currency) != t.Currency {
+		return nil, fmt.Errorf("%w: currency %s is not the %s of transaction %d", ErrInvalidDispute, reported.Currency, t.Currency, t.ID)
+	}
+
+	amount := t.Amount
+	if reported.Amount != "" {
+		m, err := money.FromDecimal(reported.Amount, t.Currency)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid amount: %v", ErrInvalidDispute, err)
+		}
+		if m.Amount <= 0 || m.Amount > t.Amount {
+			return nil, fmt.Errorf("%w: amount %s must be greater than zero and at most the %s of transaction %d",
+				ErrInvalidDispute, reported.Amount, t.Money().Decimal(), t.ID)
+		}
+		amount = m.Amount
+	}
+
+	due := time.Now().Add(s.cfg.EvidenceWindow)
+	if reported.EvidenceDueAt != nil {
+		due = *reported.EvidenceDueAt
+	}
+
+	return &postgres.Dispute{
+		TransactionID: t.ID,
+		UserID:        t.UserID,
+		GatewayID:     gatewayID,
+		ProviderRef:   reported.ProviderRef,
+		Amount:        amount,
+		Currency:      t.Currency,
+		Status:        postgres.DisputeOpened,
+		Reason:        reported.Reason,
+		EvidenceDueAt: due,
+	}, nil
+}
+
+// SubmitDisputeEvidence records the evidence contesting an opened dispute, which must not be past its deadline.
+// Evidence is submitted once.
+func (s SvcDispute) SubmitDisputeEvidence(ctx context.Context, id int64, evidence string) (*postgres.Dispute, error) {
+	evidence = strings.TrimSpace(evidence)
+	if evidence == "" || len(evidence) > maxEvidenceLength {
+		return nil, fmt.Errorf("%w: evidence must be between 1 and %d characters", ErrInvalidDispute, maxEvidenceLength)
+	}
+
+	dispute, err := s.Dispute(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if dispute.Status != postgres.DisputeOpened {
+		return nil, fmt.Errorf("dispute %d is %s, evidence can only be submitted once while it is opened: %w", id, dispute.Status, ErrIllegalTransition)
+	}
+	if time.Now().After(dispute.EvidenceDueAt) {
+		return nil, fmt.Errorf("%w: evidence of dispute %d was due by %s", ErrInvalidDispute, id, dispute.EvidenceDueAt.Format(time.RFC3339))
+	}
+
+	if err = s.transition(ctx, dispute, postgres.DisputeEvidenceSubmitted, "evidence submitted", evidence); err != nil {
+		return nil, err
+	}
+
+	return dispute, nil
+}
+
+// Dispute returns a dispute by ID
+func (s SvcDispute) Dispute(ctx context.Context, id int64) (*postgres.Dispute, error) {
+	dispute, err := s.db.GetDispute(ctx, id)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return nil, err
+		}
+		log.Printf("failed to fetch dispute %d: %v", id, err)
+
+		return nil, errors.New("failed to fetch dispute from database")
+	}
+
+	return dispute, nil
+}
+
+// Disputes lists the disputes matching the filter, newest first, 50 by default and 200 at most
+func (s SvcDispute) Disputes(ctx context.Context, filter common.DisputeFilter) ([]*postgres.Dispute, error) {
+	if filter.Status != "" && !IsValidStatus(filter.Status) {
+		return nil, fmt.Errorf("%w: unknown status %q", ErrInvalidDispute, filter.Status)
+	}
+	if filter.Limit <= 0 {
+		filter.Limit = defaultListLimit
+	}
+	if filter.Limit > maxListLimit {
+		filter.Limit = maxListLimit
+	}
+
+	return s.db.ListDisputes(ctx, filter)
+}
+
+// transition moves the dispute to status if its current status allows it, writing the matching event to the outbox
+// along with the change, and reversing the disputed amount in the ledger when the dispute is lost. Moving a dispute to
+// the status it already has is a no-op.
+func (s SvcDispute) transition(ctx context.Context, dispute *postgres.Dispute, status, reason, evidence string) error {
+	var entry *postgres.JournalEntry
+	if status == postgres.DisputeLost {
+		entry = ledger.DisputeLossEntry(dispute)
+	}
+
+	snapshot := *dispute
+	snapshot.Status, snapshot.Evidence = status, evidence
+	if evidence == "" {
+		snapshot.Evidence = dispute.Evidence
+	}
+	event, err := newDisputeEvent("dispute."+status, &snapshot, reason)
+	if err != nil {
+		return err
+	}
+
+	change := postgres.DisputeStatusChange{DisputeID: dispute.ID, ToStatus: status, Evidence: evidence}
+	err = s.db.UpdateDisputeStatus(ctx, &change, allowedFrom(status), event, entry)
+	switch {
+	case errors.Is(err, db.ErrStatusConflict) && change.FromStatus == status:
+		return nil
+	case errors.Is(err, db.ErrStatusConflict):
+		return fmt.Errorf("dispute %d cannot move from %s to %s: %w", dispute.ID, change.FromStatus, status, ErrIllegalTransition)
+	case err != nil:
+		log.Printf("failed to update status of dispute %d: %v", dispute.ID, err)
+
+		return errors.New("failed to update dispute status in database")
+	}
+
+	dispute.Status, dispute.Evidence, dispute.UpdatedAt = status, snapshot.Evidence, time.Now()
+
+	return nil
+}
+
+// RunDisputeReminders reminds of the opened disputes due soon every interval until ctx is cancelled
+func (s SvcDispute) RunDisputeReminders(ctx context.Context) {
+	if s.cfg.ReminderInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(s.cfg.ReminderInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := s.remind(ctx); err != nil {
+			log.Printf("dispute reminders: %v", err)
+		}
+	}
+}
+
+// remind publishes an evidence_due event for the opened disputes due within the lead time, unless they were reminded
+// of within the repeat interval. A dispute reminded of meanwhile by another instance is skipped.
+func (s SvcDispute) remind(ctx context.Context) error {
+	now := time.Now()
+	remindedBefore := now.Add(-s.cfg.ReminderRepeat)
+
+	disputes, err := s.db.GetDisputesDue(ctx, now.Add(s.cfg.ReminderLead), remindedBefore, reminderBatchSize)
+	if err != nil {
+		return err
+	}
+
+	for _, dispute := range disputes {
+		reason := fmt.Sprintf("evidence due by %s", dispute.EvidenceDueAt.Format(time.RFC3339))
+		event, err := newDisputeEvent(EventEvidenceDue, dispute, reason)
+		if err != nil {
+			return err
+		}
+
+		err = s.db.MarkDisputeReminded(ctx, dispute.ID, remindedBefore, event)
+		if err != nil && !errors.Is(err, db.ErrStatusConflict) {
+			log.Printf("failed to remind of dispute %d: %v", dispute.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// newDisputeEvent builds the outbox event of the given type about the dispute, keyed by its tx
+func newDisputeEvent(eventType string, dispute *postgres.Dispute, reason string) (*postgres.OutboxEvent, error) {
+	payload, err := json.Marshal(common.DisputeEvent{
+		EventType:     eventType,
+		TransactionID: dispute.TransactionID,
+		DisputeID:     dispute.ID,
+		Status:        dispute.Status,
+		Reason:        reason,
+		OccurredAt:    time.Now(),
+		Dispute:       dispute,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal dispute event: %v", err)
+	}
+
+	return &postgres.OutboxEvent{
+		AggregateID: dispute.TransactionID,
+		EventType:   eventType,
+		Payload:     payload,
+		ContentType: "application/json",
+	}, nil
+}
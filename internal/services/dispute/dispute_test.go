@@ -0,0 +1,275 @@
+package dispute
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"payment-gateway/db"
+	"payment-gateway/internal/models/common"
+	"payment-gateway/internal/models/postgres"
+	"payment-gateway/internal/services/gateway/adapter"
+	"testing"
+	"time"
+)
+
+// disputeStore keeps disputes in memory along with the outbox events and ledger entries written for them. Tx 1 is a
+// completed deposit of 100.50 EUR processed by gateway 3, tx 2 a withdrawal.
+type disputeStore struct {
+	disputes []*postgres.Dispute
+	events   []*postgres.OutboxEvent
+	entries  []*postgres.JournalEntry
+}
+
+func newDisputeMockDB(s *disputeStore) *db.MockDB {
+	return &db.MockDB{
+		GetTransactionFunc: func(ctx context.Context, id int64) (*postgres.Transaction, error) {
+			switch id {
+			case 1:
+				return &postgres.Transaction{ID: 1, Type: "deposit", Amount: 10050, Currency: "EUR", UserID: 7, GatewayID: 3, Status: "completed"}, nil
+			case 2:
+				return &postgres.Transaction{ID: 2, Type: "withdrawal", Amount: 5000, Currency: "EUR", UserID: 7, GatewayID: 3, Status: "completed"}, nil
+			}
+			return nil, fmt.Errorf("transaction %d %w", id, db.ErrNotFound)
+		},
+		CreateDisputeFunc: func(ctx context.Context, dispute *postgres.Dispute, event func(*postgres.Dispute) (*postgres.OutboxEvent, error)) (bool, error) {
+			for _, d := range s.disputes {
+				if d.GatewayID == dispute.GatewayID && d.ProviderRef == dispute.ProviderRef {
+					*dispute = *d
+					return false, nil
+				}
+			}
+
+			var disputed int64
+			for _, d := range s.disputes {
+				if d.TransactionID == dispute.TransactionID && d.Status != postgres.DisputeWon {
+					disputed += d.Amount
+				}
+			}
+			if disputed+dispute.Amount > 10050 {
+				return false, fmt.Errorf("%d already disputed: %w", disputed, db.ErrDisputeExceedsAmount)
+			}
+
+			dispute.ID = int64(len(s.disputes) + 1)
+			e, err := event(dispute)
+			if err != nil {
+				return false, err
+			}
+			stored := *dispute
+			s.disputes, s.events = append(s.disputes, &stored), append(s.events, e)
+			return true, nil
+		},
+		UpdateDisputeStatusFunc: func(ctx context.Context, change *postgres.DisputeStatusChange, allowedFrom []string, event *postgres.OutboxEvent, entry *postgres.JournalEntry) error {
+			if change.DisputeID <= 0 || change.DisputeID > int64(len(s.disputes)) {
+				return fmt.Errorf("dispute %d %w", change.DisputeID, db.ErrNotFound)
+			}
+
+			d := s.disputes[change.DisputeID-1]
+			change.FromStatus = d.Status
+			for _, from := range allowedFrom {
+				if from == d.Status {
+					d.Status = change.ToStatus
+					if change.Evidence != "" {
+						d.Evidence = change.Evidence
+					}
+					s.events = append(s.events, event)
+					if entry != nil {
+						s.entries = append(s.entries, entry)
+					}
+					return nil
+				}
+			}
+
+			return fmt.Errorf("dispute %d is %s: %w", d.ID, d.Status, db.ErrStatusConflict)
+		},
+		GetDisputeFunc: func(ctx context.Context, id int64) (*postgres.Dispute, error) {
+			if id <= 0 || id > int64(len(s.disputes)) {
+				return nil, fmt.Errorf("dispute %d %w", id, db.ErrNotFound)
+			}
+			d := *s.disputes[id-1]
+			return &d, nil
+		},
+	}
+}
+
+func disputeCallback(txID int64, providerRef, status string) *adapter.Callback {
+	return &adapter.Callback{TransactionID: txID, ProviderStatus: status, Status: status,
+		Dispute: &adapter.Dispute{ProviderRef: providerRef, Reason: "fraudulent"}}
+}
+
+func TestProcessDisputeCallback(t *testing.T) {
+	s := &disputeStore{}
+	svc := NewSvcDispute(newDisputeMockDB(s), DisputeConfig{EvidenceWindow: 24 * time.Hour})
+
+	d, err := svc.ProcessDisputeCallback(context.Background(), 3, disputeCallback(1, "dp-1", postgres.DisputeOpened))
+	if err != nil {
+		t.Fatalf("expected the dispute to be opened, got %v", err)
+	}
+	if d.Amount != 10050 || d.UserID != 7 || d.Status != postgres.DisputeOpened || time.Until(d.EvidenceDueAt) < 23*time.Hour {
+		t.Errorf("expected an opened dispute of the whole deposit due in a day, got %+v", d)
+	}
+	if len(s.events) != 1 || s.events[0].EventType != "dispute.opened" || s.events[0].AggregateID != 1 {
+		t.Errorf("expected a dispute.opened event keyed by the tx, got %+v", s.events)
+	}
+
+	// a repeated notification is a no-op
+	if _, err = svc.ProcessDisputeCallback(context.Background(), 3, disputeCallback(1, "dp-1", postgres.DisputeOpened)); err != nil || len(s.events) != 1 {
+		t.Errorf("expected a repeated notification to be a no-op, got %d events: %v", len(s.events), err)
+	}
+
+	d, err = svc.ProcessDisputeCallback(context.Background(), 3, disputeCallback(1, "dp-1", postgres.DisputeLost))
+	if err != nil || d.Status != postgres.DisputeLost {
+		t.Fatalf("expected the dispute to be lost, got %+v: %v", d, err)
+	}
+	if len(s.entries) != 1 || s.entries[0].Reference != "dispute:1:lost" || s.entries[0].Postings[0].Amount != -10050 {
+		t.Errorf("expected the deposit to be reversed in the ledger, got %+v", s.entries)
+	}
+
+	if _, err = svc.ProcessDisputeCallback(context.Background(), 3, disputeCallback(1, "dp-1", postgres.DisputeWon)); !errors.Is(err, ErrIllegalTransition) {
+		t.Errorf("expected a lost dispute not to be won, got %v", err)
+	}
+}
+
+func TestProcessDisputeCallback_Rejected(t *testing.T) {
+	s := &disputeStore{}
+	svc := NewSvcDispute(newDisputeMockDB(s), DisputeConfig{})
+
+	partial := disputeCallback(1, "dp-2", postgres.DisputeOpened)
+	partial.Dispute.Amount = "200.00"
+
+	wrongCurrency := disputeCallback(1, "dp-3", postgres.DisputeOpened)
+	wrongCurrency.Dispute.Currency = "USD"
+
+	tests := []struct {
+		name      string
+		gatewayID int
+		cb        *adapter.Callback
+		want      error
+	}{
+		{"tx of another gateway", 4, disputeCallback(1, "dp-1", postgres.DisputeOpened), db.ErrNotFound},
+		{"unknown tx", 3, disputeCallback(9, "dp-1", postgres.DisputeOpened), db.ErrNotFound},
+		{"withdrawal", 3, disputeCallback(2, "dp-1", postgres.DisputeOpened), ErrInvalidDispute},
+		{"unknown status", 3, disputeCallback(1, "dp-1", "refunded"), ErrInvalidDispute},
+		{"amount beyond the deposit", 3, partial, ErrInvalidDispute},
+		{"other currency", 3, wrongCurrency, ErrInvalidDispute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := svc.ProcessDisputeCallback(context.Background(), tt.gatewayID, tt.cb); !errors.Is(err, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+
+	if len(s.disputes) != 0 {
+		t.Errorf("expected no dispute to be opened, got %d", len(s.disputes))
+	}
+}
+
+func TestProcessDisputeCallback_Cap(t *testing.T) {
+	s := &disputeStore{}
+	svc := NewSvcDispute(newDisputeMockDB(s), DisputeConfig{})
+
+	first := disputeCallback(1, "dp-1", postgres.DisputeOpened)
+	first.Dispute.Amount = "60.00"
+	if _, err := svc.ProcessDisputeCallback(context.Background(), 3, first); err != nil {
+		t.Fatalf("expected the dispute to be opened, got %v", err)
+	}
+
+	// the disputes of a deposit never add up to more than its amount
+	second := disputeCallback(1, "dp-2", postgres.DisputeOpened)
+	second.Dispute.Amount = "50.00"
+	if _, err := svc.ProcessDisputeCallback(context.Background(), 3, second); !errors.Is(err, ErrInvalidDispute) {
+		t.Errorf("expected a dispute beyond what is left to dispute to be rejected, got %v", err)
+	}
+
+	second.Dispute.Amount = "40.50"
+	if _, err := svc.ProcessDisputeCallback(context.Background(), 3, second); err != nil {
+		t.Errorf("expected the rest of the deposit to be disputable, got %v", err)
+	}
+	if len(s.disputes) != 2 {
+		t.Errorf("expected 2 disputes, got %d", len(s.disputes))
+	}
+}
+
+func TestSubmitDisputeEvidence(t *testing.T) {
+	s := &disputeStore{}
+	svc := NewSvcDispute(newDisputeMockDB(s), DisputeConfig{EvidenceWindow: time.Hour})
+
+	// two partial disputes of the deposit, the second one overdue
+	current := disputeCallback(1, "dp-1", postgres.DisputeOpened)
+	current.Dispute.Amount = "50.00"
+	due := time.Now().Add(-time.Minute)
+	overdue := disputeCallback(1, "dp-2", postgres.DisputeOpened)
+	overdue.Dispute.Amount, overdue.Dispute.EvidenceDueAt = "50.00", &due
+	for _, cb := range []*adapter.Callback{current, overdue} {
+		if _, err := svc.ProcessDisputeCallback(context.Background(), 3, cb); err != nil {
+			t.Fatalf("expected the dispute to be opened, got %v", err)
+		}
+	}
+
+	d, err := svc.SubmitDisputeEvidence(context.Background(), 1, " signed delivery receipt ")
+	if err != nil || d.Status != postgres.DisputeEvidenceSubmitted || d.Evidence != "signed delivery receipt" {
+		t.Fatalf("expected the evidence to be submitted, got %+v: %v", d, err)
+	}
+	if e := s.events[len(s.events)-1]; e.EventType != "dispute.evidence_submitted" {
+		t.Errorf("expected a dispute.evidence_submitted event, got %s", e.EventType)
+	}
+
+	if _, err = svc.SubmitDisputeEvidence(context.Background(), 1, "more"); !errors.Is(err, ErrIllegalTransition) {
+		t.Errorf("expected evidence to be submitted once, got %v", err)
+	}
+	if _, err = svc.SubmitDisputeEvidence(context.Background(), 2, "late receipt"); !errors.Is(err, ErrInvalidDispute) {
+		t.Errorf("expected evidence past the deadline to be rejected, got %v", err)
+	}
+	if _, err = svc.SubmitDisputeEvidence(context.Background(), 2, "  "); !errors.Is(err, ErrInvalidDispute) {
+		t.Errorf("expected empty evidence to be rejected, got %v", err)
+	}
+	if _, err = svc.SubmitDisputeEvidence(context.Background(), 9, "receipt"); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("expected an unknown dispute to be not found, got %v", err)
+	}
+}
+
+func TestRemind(t *testing.T) {
+	due := time.Now().Add(time.Hour)
+	disputes := []*postgres.Dispute{
+		{ID: 1, TransactionID: 1, Status: postgres.DisputeOpened, EvidenceDueAt: due},
+		{ID: 2, TransactionID: 5, Status: postgres.DisputeOpened, EvidenceDueAt: due},
+	}
+
+	var dueBefore, remindedBefore time.Time
+	var reminded []*postgres.OutboxEvent
+	svc := NewSvcDispute(&db.MockDB{
+		GetDisputesDueFunc: func(ctx context.Context, before, reminded time.Time, limit int) ([]*postgres.Dispute, error) {
+			dueBefore, remindedBefore = before, reminded
+			return disputes, nil
+		},
+		MarkDisputeRemindedFunc: func(ctx context.Context, id int64, before time.Time, event *postgres.OutboxEvent) error {
+			// dispute 2 was reminded of by another instance meanwhile
+			if id == 2 {
+				return fmt.Errorf("dispute %d was reminded of: %w", id, db.ErrStatusConflict)
+			}
+			reminded = append(reminded, event)
+			return nil
+		},
+	}, DisputeConfig{ReminderLead: 72 * time.Hour, ReminderRepeat: 24 * time.Hour}).(*SvcDispute)
+
+	if err := svc.remind(context.Background()); err != nil {
+		t.Fatalf("expected the reminders to be sent, got %v", err)
+	}
+	if d := time.Until(dueBefore); d < 71*time.Hour || d > 72*time.Hour {
+		t.Errorf("expected disputes due within the lead time, got %v", d)
+	}
+	if d := time.Since(remindedBefore); d < 24*time.Hour || d > 25*time.Hour {
+		t.Errorf("expected disputes not reminded of within the repeat interval, got %v", d)
+	}
+
+	if len(reminded) != 1 || reminded[0].EventType != EventEvidenceDue || reminded[0].AggregateID != 1 {
+		t.Fatalf("expected a reminder of dispute 1, got %+v", reminded)
+	}
+	var event common.DisputeEvent
+	if err := json.Unmarshal(reminded[0].Payload, &event); err != nil || event.DisputeID != 1 || event.Status != postgres.DisputeOpened {
+		t.Errorf("expected the reminder to carry the dispute, got %+v: %v", event, err)
+	}
+}
@@ -0,0 +1,118 @@
+package dispute
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"payment-gateway/internal/models/common"
+	"payment-gateway/internal/money"
+	"payment-gateway/internal/services/gateway/adapter"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// importColumns are the columns of a dispute file, the first four are required
+var importColumns = []string{"gateway_id", "transaction_id", "dispute_id", "status", "amount", "currency", "reason", "evidence_due_by"}
+
+// ImportDisputes applies a CSV file of disputes, e.g. a gateway's dispute report, line by line as if each line had
+// been reported by a callback of its gateway. The first line names the columns, in any order: gateway_id,
+// transaction_id, dispute_id (the gateway's ID of the dispute) and status (a dispute status) are required, amount,
+// currency, reason and evidence_due_by (RFC 3339) are optional. A line that cannot be applied does not stop the
+// import, the outcome of every line is returned. Only a file that cannot be read as CSV is an error.
+func (s SvcDispute) ImportDisputes(ctx context.Context, file io.Reader) ([]common.DisputeImportResult, error) {
+	r := csv.NewReader(file)
+	r.TrimLeadingSpace = true
+
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("%w: the file is empty", ErrInvalidDispute)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to read the header: %w", ErrInvalidDispute, err)
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range importColumns[:4] {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("%w: the %s column is missing", ErrInvalidDispute, name)
+		}
+	}
+
+	results := []common.DisputeImportResult{}
+	for line := 2; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+
+		result := common.DisputeImportResult{Line: line}
+		var parseErr *csv.ParseError
+		switch {
+		case errors.As(err, &parseErr) && parseErr.Err == csv.ErrFieldCount:
+			result.Error = "wrong number of fields"
+		case err != nil:
+			return nil, fmt.Errorf("%w: failed to read line %d: %w", ErrInvalidDispute, line, err)
+		default:
+			s.importLine(ctx, record, columns, &result)
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// importLine applies a line of a dispute file and sets its outcome on result
+func (s SvcDispute) importLine(ctx context.Context, record []string, columns map[string]int, result *common.DisputeImportResult) {
+	field := func(name string) string {
+		if i, ok := columns[name]; ok {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	gatewayID, err := strconv.Atoi(field("gateway_id"))
+	if err != nil || gatewayID <= 0 {
+		result.Error = "invalid gateway_id"
+		return
+	}
+	txID, err := strconv.ParseInt(field("transaction_id"), 10, 64)
+	if err != nil || txID <= 0 {
+		result.Error = "invalid transaction_id"
+		return
+	}
+	if field("dispute_id") == "" {
+		result.Error = "dispute_id is missing"
+		return
+	}
+
+	dispute := &adapter.Dispute{
+		ProviderRef: field("dispute_id"),
+		Amount:      money.Decimal(field("amount")),
+		Currency:    field("currency"),
+		Reason:      field("reason"),
+	}
+	if due := field("evidence_due_by"); due != "" {
+		t, err := time.Parse(time.RFC3339, due)
+		if err != nil {
+			result.Error = "invalid evidence_due_by, must be an RFC 3339 date"
+			return
+		}
+		dispute.EvidenceDueAt = &t
+	}
+
+	status := strings.ToLower(field("status"))
+	cb := &adapter.Callback{TransactionID: txID, Dispute: dispute, ProviderStatus: status, Status: status}
+
+	d, err := s.apply(ctx, gatewayID, cb, fmt.Sprintf("imported from a file of gateway %d", gatewayID))
+	if err != nil {
+		result.Error = err.Error()
+		return
+	}
+	result.DisputeID, result.Status = d.ID, d.Status
+}
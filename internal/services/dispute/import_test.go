@@ -0,0 +1,57 @@
+package dispute
+
+import (
+	"context"
+	"errors"
+	"payment-gateway/internal/models/postgres"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestImportDisputes(t *testing.T) {
+	s := &disputeStore{}
+	svc := NewSvcDispute(newDisputeMockDB(s), DisputeConfig{EvidenceWindow: time.Hour})
+
+	file := `gateway_id,transaction_id,dispute_id,status,amount,currency,reason,evidence_due_by
+3,1,dp-1,opened,25.00,EUR,fraudulent,2030-01-08T00:00:00Z
+3,1,dp-1,lost,,,,
+3,x,dp-2,opened,,,,
+4,1,dp-3,opened,,,,
+3,2,dp-4,opened,,,,
+3,1,dp-5,opened
+3,1,dp-6,won,,,,not-a-date
+`
+
+	results, err := svc.ImportDisputes(context.Background(), strings.NewReader(file))
+	if err != nil {
+		t.Fatalf("expected the file to be imported, got %v", err)
+	}
+	if len(results) != 7 {
+		t.Fatalf("expected a result per line, got %+v", results)
+	}
+
+	if r := results[0]; r.Line != 2 || r.DisputeID != 1 || r.Status != postgres.DisputeOpened || r.Error != "" {
+		t.Errorf("expected line 2 to open dispute 1, got %+v", r)
+	}
+	if d := s.disputes[0]; d.Amount != 2500 || d.EvidenceDueAt.Year() != 2030 {
+		t.Errorf("expected the amount and deadline of the file, got %+v", d)
+	}
+	if r := results[1]; r.DisputeID != 1 || r.Status != postgres.DisputeLost || len(s.entries) != 1 {
+		t.Errorf("expected line 3 to lose dispute 1, got %+v", r)
+	}
+	for _, r := range results[2:] {
+		if r.Error == "" || r.DisputeID != 0 {
+			t.Errorf("expected line %d to fail, got %+v", r.Line, r)
+		}
+	}
+	if len(s.disputes) != 1 {
+		t.Errorf("expected a single dispute, got %d", len(s.disputes))
+	}
+
+	for _, file := range []string{"", "gateway_id,transaction_id,status\n3,1,opened\n"} {
+		if _, err = svc.ImportDisputes(context.Background(), strings.NewReader(file)); !errors.Is(err, ErrInvalidDispute) {
+			t.Errorf("expected %q to be rejected, got %v", file, err)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"payment-gateway/db"
+	"payment-gateway/internal/kafka"
+	"payment-gateway/internal/models/common"
+	"payment-gateway/internal/models/postgres"
+	"strconv"
+	"strings"
+
+	kafkago "github.com/segmentio/kafka-go"
+)
+
+type (
+	SvcEvents struct {
+		db db.Idb
+	}
+
+	ISvcEvents interface {
+		HandleTxEvent(ctx context.Context, msg kafkago.Message) error
+	}
+)
+
+func NewSvcEvents(db db.Idb) ISvcEvents {
+	return &SvcEvents{db: db}
+}
+
+// HandleTxEvent records a tx event consumed from the transaction topics. Events are deduplicated on the ID of the
+// outbox event they came from, so redelivered messages are acknowledged without being processed again. Events of other
+// aggregates, published to the transaction topics before they had their own, are skipped. Messages that can't be
+// parsed are reported as poison messages.
+func (e SvcEvents) HandleTxEvent(ctx context.Context, msg kafkago.Message) error {
+	eventID, err := strconv.ParseInt(kafka.Header(msg, kafka.HeaderEventID), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid %s header: %w", kafka.HeaderEventID, kafka.ErrPoisonMessage)
+	}
+
+	if eventType := kafka.Header(msg, kafka.HeaderEventType); eventType != "" && !strings.HasPrefix(eventType, "transaction.") {
+		log.Printf("skipping %s event %d, not a tx event", eventType, eventID)
+		return nil
+	}
+
+	var event common.TransactionEvent
+	if strings.Contains(kafka.Header(msg, kafka.HeaderContentType), "xml") {
+		err = xml.Unmarshal(msg.Value, &event)
+	} else {
+		err = json.Unmarshal(msg.Value, &event)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse event %d: %v: %w", eventID, err, kafka.ErrPoisonMessage)
+	}
+
+	if event.TransactionID == 0 || event.Status == "" {
+		return fmt.Errorf("event %d is missing the tx or status: %w", eventID, kafka.ErrPoisonMessage)
+	}
+
+	recorded, err := e.db.RecordConsumedEvent(ctx, &postgres.ConsumedEvent{
+		EventID:       eventID,
+		TransactionID: event.TransactionID,
+		EventType:     event.EventType,
+		Status:        event.Status,
+		Payload:       msg.Value,
+		OccurredAt:    event.OccurredAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	if !recorded {
+		log.Printf("skipping duplicate event %d of tx %d", eventID, event.TransactionID)
+		return nil
+	}
+
+	log.Printf("consumed %s event %d of tx %d", event.EventType, eventID, event.TransactionID)
+
+	return nil
+}
@@ -0,0 +1,123 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"payment-gateway/db"
+	"payment-gateway/internal/kafka"
+	"payment-gateway/internal/models/postgres"
+	"testing"
+
+	kafkago "github.com/segmentio/kafka-go"
+)
+
+func eventMessage(eventID, contentType, value string) kafkago.Message {
+	return kafkago.Message{
+		Value: []byte(value),
+		Headers: []kafkago.Header{
+			{Key: kafka.HeaderEventID, Value: []byte(eventID)},
+			{Key: kafka.HeaderContentType, Value: []byte(contentType)},
+		},
+	}
+}
+
+func TestHandleTxEvent(t *testing.T) {
+	refundEvent := eventMessage("5", "application/json", `{"event_type":"refund.completed","transaction_id":10,"status":"completed"}`)
+	refundEvent.Headers = append(refundEvent.Headers, kafkago.Header{Key: kafka.HeaderEventType, Value: []byte("refund.completed")})
+
+	tests := []struct {
+		name        string
+		msg         kafkago.Message
+		seen        bool
+		wantPoison  bool
+		wantSkipped bool
+		wantStatus  string
+	}{
+		{
+			name:       "json event",
+			msg:        eventMessage("1", "application/json", `{"event_type":"transaction.completed","transaction_id":10,"status":"completed"}`),
+			wantStatus: "completed",
+		},
+		{
+			name:       "xml event",
+			msg:        eventMessage("2", "text/xml", `<TransactionEvent><event_type>transaction.failed</event_type><transaction_id>10</transaction_id><status>failed</status></TransactionEvent>`),
+			wantStatus: "failed",
+		},
+		{
+			name:       "duplicate event",
+			msg:        eventMessage("1", "application/json", `{"event_type":"transaction.completed","transaction_id":10,"status":"completed"}`),
+			seen:       true,
+			wantStatus: "completed",
+		},
+		{
+			name:       "missing event id",
+			msg:        eventMessage("", "application/json", `{"transaction_id":10,"status":"completed"}`),
+			wantPoison: true,
+		},
+		{
+			name:       "malformed payload",
+			msg:        eventMessage("3", "application/json", `{"transaction_id":`),
+			wantPoison: true,
+		},
+		{
+			name:       "missing tx",
+			msg:        eventMessage("4", "application/json", `{"status":"completed"}`),
+			wantPoison: true,
+		},
+		{
+			name:        "refund event",
+			msg:         refundEvent,
+			wantSkipped: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var recorded *postgres.ConsumedEvent
+			mockDB := &db.MockDB{
+				RecordConsumedEventFunc: func(ctx context.Context, event *postgres.ConsumedEvent) (bool, error) {
+					recorded = event
+					return !tt.seen, nil
+				},
+			}
+
+			err := NewSvcEvents(mockDB).HandleTxEvent(context.Background(), tt.msg)
+			if tt.wantPoison {
+				if !errors.Is(err, kafka.ErrPoisonMessage) {
+					t.Fatalf("expected a poison message error, got %v", err)
+				}
+				if recorded != nil {
+					t.Fatal("expected a poison message not to be recorded")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantSkipped {
+				if recorded != nil {
+					t.Fatalf("expected the event to be skipped, got %+v", recorded)
+				}
+				return
+			}
+			if recorded == nil || recorded.TransactionID != 10 || recorded.Status != tt.wantStatus {
+				t.Fatalf("expected tx 10 to be recorded as %s, got %+v", tt.wantStatus, recorded)
+			}
+		})
+	}
+}
+
+func TestHandleTxEvent_DBError(t *testing.T) {
+	mockDB := &db.MockDB{
+		RecordConsumedEventFunc: func(ctx context.Context, event *postgres.ConsumedEvent) (bool, error) {
+			return false, errors.New("connection refused")
+		},
+	}
+
+	msg := eventMessage("1", "application/json", `{"transaction_id":10,"status":"completed"}`)
+	err := NewSvcEvents(mockDB).HandleTxEvent(context.Background(), msg)
+	if err == nil || errors.Is(err, kafka.ErrPoisonMessage) {
+		t.Fatalf("expected a retryable error, got %v", err)
+	}
+}
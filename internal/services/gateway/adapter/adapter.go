@@ -0,0 +1,221 @@
+package adapter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"payment-gateway/internal/models/common"
+	"payment-gateway/internal/models/postgres"
+	"payment-gateway/internal/money"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxRawPayload caps how much of a provider response is kept in memory
+const maxRawPayload = 1 << 20
+
+// ErrNotSupported is wrapped by the errors returned when the adapter of a gateway does not support an operation
+var ErrNotSupported = errors.New("not supported by the gateway")
+
+type (
+	// Response is the typed outcome of a request sent to a provider. Status is ProviderStatus mapped with the
+	// provider's tx status table, empty when the provider status is unknown: a 2xx response may still decline the tx.
+	Response struct {
+		ProviderRef    string `json:"provider_ref" xml:"provider_ref"`
+		ProviderStatus string `json:"provider_status" xml:"provider_status"`
+		Status         string `json:"status" xml:"status"`
+		Raw            []byte `json:"-" xml:"-"`
+	}
+
+	// Callback is a status update sent by a provider, Status being the provider status mapped to a tx status.
+	// EventID identifies the notification at the provider, empty when the provider does not send one. RefundID is set
+	// when the update is about a refund of the tx rather than the tx itself, Status then being a refund status, and
+	// Dispute when it is about a dispute of the tx, Status then being a dispute status.
+	Callback struct {
+		EventID        string
+		TransactionID  int64
+		RefundID       int64
+		Dispute        *Dispute
+		ProviderRef    string
+		ProviderStatus string
+		Status         string
+	}
+
+	// Dispute is what a provider reports about a dispute (chargeback) of a tx. ProviderRef is the provider's ID of the
+	// dispute; Amount is empty when the whole tx is disputed and EvidenceDueAt nil when the provider sets no deadline.
+	Dispute struct {
+		ProviderRef   string
+		Amount        money.Decimal
+		Currency      string
+		Reason        string
+		EvidenceDueAt *time.Time
+	}
+
+	// IAdapter knows how to build and parse requests for a single provider
+	IAdapter interface {
+		BuildRequest(ctx context.Context, gateway *common.Gateway, tx postgres.Transaction) (*http.Request, error)
+		ParseResponse(body []byte) (*Response, error)
+		// ParseCallback reads a status update posted by the provider in its own format
+		ParseCallback(body []byte) (*Callback, error)
+	}
+
+	// IRefundAdapter is implemented by the adapters of providers accepting refunds, their response is read with
+	// ParseResponse and their callbacks reference the refund with RefundReference
+	IRefundAdapter interface {
+		BuildRefundRequest(ctx context.Context, gateway *common.Gateway, tx postgres.Transaction, refund postgres.Refund) (*http.Request, error)
+	}
+
+	// IVoidAdapter is implemented by the adapters of providers able to void a tx they accepted and did not settle yet,
+	// their response is read with ParseResponse
+	IVoidAdapter interface {
+		BuildVoidRequest(ctx context.Context, gateway *common.Gateway, tx postgres.Transaction) (*http.Request, error)
+	}
+
+	// StatusError is returned when a provider answers with a non-2xx status code
+	StatusError struct {
+		StatusCode int
+		Body       []byte
+	}
+)
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("gateway responded with status %d: %s", e.StatusCode, string(e.Body))
+}
+
+// Send builds the provider request with the given adapter, sends it and parses the response.
+func Send(ctx context.Context, client *http.Client, a IAdapter, gateway *common.Gateway, tx postgres.Transaction) (*Response, error) {
+	if gateway.EndpointURL == "" {
+		return nil, fmt.Errorf("gateway %s has no endpoint configured", gateway.Name)
+	}
+
+	req, err := a.BuildRequest(ctx, gateway, tx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build gateway request: %v", err)
+	}
+
+	return do(client, a, gateway, req)
+}
+
+// SendRefund builds the refund request of the tx with the given adapter, sends it and parses the response. The
+// returned error wraps ErrNotSupported when the adapter does not support refunds.
+func SendRefund(ctx context.Context, client *http.Client, a IAdapter, gateway *common.Gateway, tx postgres.Transaction, refund postgres.Refund) (*Response, error) {
+	ra, ok := a.(IRefundAdapter)
+	if !ok {
+		return nil, fmt.Errorf("refunds are %w %s", ErrNotSupported, gateway.Name)
+	}
+
+	if gateway.EndpointURL == "" {
+		return nil, fmt.Errorf("gateway %s has no endpoint configured", gateway.Name)
+	}
+
+	req, err := ra.BuildRefundRequest(ctx, gateway, tx, refund)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build gateway request: %v", err)
+	}
+
+	return do(client, a, gateway, req)
+}
+
+// SendVoid builds the void request of the tx with the given adapter, sends it and parses the response. The returned
+// error wraps ErrNotSupported when the adapter does not support voids.
+func SendVoid(ctx context.Context, client *http.Client, a IAdapter, gateway *common.Gateway, tx postgres.Transaction) (*Response, error) {
+	va, ok := a.(IVoidAdapter)
+	if !ok {
+		return nil, fmt.Errorf("voids are %w %s", ErrNotSupported, gateway.Name)
+	}
+
+	if gateway.EndpointURL == "" {
+		return nil, fmt.Errorf("gateway %s has no endpoint configured", gateway.Name)
+	}
+
+	req, err := va.BuildVoidRequest(ctx, gateway, tx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build gateway request: %v", err)
+	}
+
+	return do(client, a, gateway, req)
+}
+
+// do sends a request built by the adapter and parses the response
+func do(client *http.Client, a IAdapter, gateway *common.Gateway, req *http.Request) (*Response, error) {
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request to gateway %s: %w", gateway.Name, err)
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxRawPayload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read gateway response: %v", err)
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, &StatusError{StatusCode: res.StatusCode, Body: body}
+	}
+
+	resp, err := a.ParseResponse(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse gateway response: %v", err)
+	}
+	resp.Raw = body
+
+	return resp, nil
+}
+
+// RefundReference returns the reference a refund is sent with, providers send it back in the callbacks of the refund
+func RefundReference(refund postgres.Refund) string {
+	return fmt.Sprintf("%d:refund:%d", refund.TransactionID, refund.ID)
+}
+
+// newResponse builds the response of a provider, mapping its status with the provider's status table
+func newResponse(providerRef, providerStatus string, statuses map[string]string) *Response {
+	return &Response{ProviderRef: providerRef, ProviderStatus: providerStatus, Status: statuses[strings.ToLower(providerStatus)]}
+}
+
+// newCallback builds the callback of a provider, mapping its status with the provider's status table, or with its
+// refund status table when the reference is that of a refund
+func newCallback(eventID, reference, providerRef, providerStatus string, statuses, refundStatuses map[string]string) (*Callback, error) {
+	var refundID int64
+	if txRef, refundRef, ok := strings.Cut(reference, ":refund:"); ok {
+		id, err := strconv.ParseInt(refundRef, 10, 64)
+		if err != nil || id <= 0 {
+			return nil, fmt.Errorf("invalid refund reference %q", reference)
+		}
+		reference, refundID, statuses = txRef, id, refundStatuses
+	}
+
+	txID, err := strconv.ParseInt(reference, 10, 64)
+	if err != nil || txID <= 0 {
+		return nil, fmt.Errorf("invalid transaction reference %q", reference)
+	}
+
+	status, ok := statuses[strings.ToLower(providerStatus)]
+	if !ok {
+		return nil, fmt.Errorf("unknown provider status %q", providerStatus)
+	}
+
+	return &Callback{EventID: eventID, TransactionID: txID, RefundID: refundID, ProviderRef: providerRef, ProviderStatus: providerStatus, Status: status}, nil
+}
+
+// newDisputeCallback builds the callback of a provider reporting a dispute of the tx it references, mapping its status
+// with the provider's dispute status table
+func newDisputeCallback(eventID, reference string, dispute Dispute, providerStatus string, statuses map[string]string) (*Callback, error) {
+	if dispute.ProviderRef == "" {
+		return nil, errors.New("dispute reference is missing")
+	}
+
+	if strings.Contains(reference, ":refund:") {
+		return nil, fmt.Errorf("invalid transaction reference %q", reference)
+	}
+
+	cb, err := newCallback(eventID, reference, dispute.ProviderRef, providerStatus, statuses, nil)
+	if err != nil {
+		return nil, err
+	}
+	cb.Dispute = &dispute
+
+	return cb, nil
+}
@@ -0,0 +1,313 @@
+package adapter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"payment-gateway/internal/models/common"
+	"payment-gateway/internal/models/postgres"
+	"strings"
+	"testing"
+)
+
+func TestSend_REST(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("expected JSON request, got %s", ct)
+		}
+
+		var req restRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("failed to decode request: %v", err)
+		}
+		if req.Reference != "42" || req.Amount != "10.50" || req.Currency != "USD" {
+			t.Errorf("unexpected request payload: %+v", req)
+		}
+
+		w.Write([]byte(`{"id":"rest-123","status":"accepted"}`))
+	}))
+	defer srv.Close()
+
+	gw := &common.Gateway{ID: 1, Name: "rest", DataFormatSupported: "application/json", EndpointURL: srv.URL}
+	a, err := NewRegistry().Resolve(gw)
+	if err != nil {
+		t.Fatalf("failed to resolve adapter: %v", err)
+	}
+
+	res, err := Send(context.Background(), srv.Client(), a, gw, postgres.Transaction{ID: 42, Amount: 1050, Currency: "USD", Type: "deposit"})
+	if err != nil {
+		t.Fatalf("expected success, got error: %v", err)
+	}
+	if res.ProviderRef != "rest-123" || res.ProviderStatus != "accepted" {
+		t.Errorf("unexpected response: %+v", res)
+	}
+	if len(res.Raw) == 0 {
+		t.Errorf("expected raw payload to be kept")
+	}
+}
+
+func TestSend_SOAP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !strings.Contains(string(body), "<Reference>7</Reference>") {
+			t.Errorf("unexpected request payload: %s", body)
+		}
+		if r.Header.Get("SOAPAction") == "" {
+			t.Errorf("expected SOAPAction header")
+		}
+
+		w.Header().Set("Content-Type", "text/xml")
+		w.Write([]byte(`<?xml version="1.0"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/">
+  <soap:Body>
+    <ProcessTransactionResponse>
+      <Reference>soap-7</Reference>
+      <Status>PENDING</Status>
+    </ProcessTransactionResponse>
+  </soap:Body>
+</soap:Envelope>`))
+	}))
+	defer srv.Close()
+
+	gw := &common.Gateway{ID: 2, Name: "soap", DataFormatSupported: "text/xml", EndpointURL: srv.URL}
+	a, err := NewRegistry().Resolve(gw)
+	if err != nil {
+		t.Fatalf("failed to resolve adapter: %v", err)
+	}
+
+	res, err := Send(context.Background(), srv.Client(), a, gw, postgres.Transaction{ID: 7, Type: "withdrawal"})
+	if err != nil {
+		t.Fatalf("expected success, got error: %v", err)
+	}
+	if res.ProviderRef != "soap-7" || res.ProviderStatus != "PENDING" {
+		t.Errorf("unexpected response: %+v", res)
+	}
+}
+
+func TestSend_Failure(t *testing.T) {
+	t.Run("StatusError", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+		}))
+		defer srv.Close()
+
+		gw := &common.Gateway{Name: "rest", EndpointURL: srv.URL}
+		_, err := Send(context.Background(), srv.Client(), NewRESTAdapter(), gw, postgres.Transaction{ID: 1})
+
+		var statusErr *StatusError
+		if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("expected status error 503, got %v", err)
+		}
+	})
+
+	t.Run("SOAPFault", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body><soap:Fault><faultcode>soap:Client</faultcode><faultstring>invalid amount</faultstring></soap:Fault></soap:Body></soap:Envelope>`))
+		}))
+		defer srv.Close()
+
+		gw := &common.Gateway{Name: "soap", EndpointURL: srv.URL}
+		_, err := Send(context.Background(), srv.Client(), NewSOAPAdapter(), gw, postgres.Transaction{ID: 1})
+		if err == nil || !strings.Contains(err.Error(), "invalid amount") {
+			t.Errorf("expected soap fault, got %v", err)
+		}
+	})
+
+	t.Run("UnknownFormat", func(t *testing.T) {
+		_, err := NewRegistry().Resolve(&common.Gateway{Name: "unknown", DataFormatSupported: "text/csv"})
+		if err == nil {
+			t.Errorf("expected resolve error for unsupported format")
+		}
+	})
+}
+
+func TestSendRefund(t *testing.T) {
+	tx := postgres.Transaction{ID: 42, Amount: 1050, Currency: "USD", Type: "deposit", ProviderRef: "psp-42"}
+	refund := postgres.Refund{ID: 5, TransactionID: 42, Amount: 250, Currency: "USD", Reason: "damaged item"}
+
+	tests := []struct {
+		name     string
+		format   string
+		response string
+		contains []string
+	}{
+		{
+			name:     "rest",
+			format:   "application/json",
+			response: `{"id":"refund-1","status":"accepted"}`,
+			contains: []string{`"reference":"42:refund:5"`, `"type":"refund"`, `"original_reference":"42"`, `"provider_ref":"psp-42"`, `"amount":2.50`},
+		},
+		{
+			name:   "soap",
+			format: "text/xml",
+			response: `<?xml version="1.0"?><soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body>` +
+				`<RefundTransactionResponse><Reference>refund-1</Reference><Status>accepted</Status></RefundTransactionResponse>` +
+				`</soap:Body></soap:Envelope>`,
+			contains: []string{"<RefundTransaction", "<Reference>42:refund:5</Reference>", "<OriginalReference>42</OriginalReference>"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				for _, c := range tt.contains {
+					if !strings.Contains(string(body), c) {
+						t.Errorf("expected %s in request payload: %s", c, body)
+					}
+				}
+				w.Write([]byte(tt.response))
+			}))
+			defer srv.Close()
+
+			gw := &common.Gateway{ID: 1, Name: tt.name, DataFormatSupported: tt.format, EndpointURL: srv.URL}
+			a, err := NewRegistry().Resolve(gw)
+			if err != nil {
+				t.Fatalf("failed to resolve adapter: %v", err)
+			}
+
+			res, err := SendRefund(context.Background(), srv.Client(), a, gw, tx, refund)
+			if err != nil {
+				t.Fatalf("expected success, got error: %v", err)
+			}
+			if res.ProviderRef != "refund-1" {
+				t.Errorf("unexpected response: %+v", res)
+			}
+		})
+	}
+}
+
+func TestSendVoid(t *testing.T) {
+	tx := postgres.Transaction{ID: 42, Amount: 1050, Currency: "USD", Type: "withdrawal", ProviderRef: "psp-42"}
+
+	tests := []struct {
+		name     string
+		format   string
+		response string
+		contains []string
+	}{
+		{
+			name:     "rest",
+			format:   "application/json",
+			response: `{"id":"psp-42","status":"voided"}`,
+			contains: []string{`"reference":"42"`, `"type":"void"`, `"provider_ref":"psp-42"`},
+		},
+		{
+			name:   "soap",
+			format: "text/xml",
+			response: `<?xml version="1.0"?><soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body>` +
+				`<VoidTransactionResponse><Reference>psp-42</Reference><Status>voided</Status></VoidTransactionResponse>` +
+				`</soap:Body></soap:Envelope>`,
+			contains: []string{"<VoidTransaction", "<Reference>42</Reference>", "<ProviderReference>psp-42</ProviderReference>"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				for _, c := range tt.contains {
+					if !strings.Contains(string(body), c) {
+						t.Errorf("expected %s in request payload: %s", c, body)
+					}
+				}
+				w.Write([]byte(tt.response))
+			}))
+			defer srv.Close()
+
+			gw := &common.Gateway{ID: 1, Name: tt.name, DataFormatSupported: tt.format, EndpointURL: srv.URL}
+			a, err := NewRegistry().Resolve(gw)
+			if err != nil {
+				t.Fatalf("failed to resolve adapter: %v", err)
+			}
+
+			res, err := SendVoid(context.Background(), srv.Client(), a, gw, tx)
+			if err != nil {
+				t.Fatalf("expected success, got error: %v", err)
+			}
+			if res.ProviderRef != "psp-42" || res.ProviderStatus != "voided" {
+				t.Errorf("unexpected response: %+v", res)
+			}
+		})
+	}
+}
+
+func TestRegistry_ResolveOrder(t *testing.T) {
+	r := NewRegistry()
+	byName := NewSOAPAdapter()
+	byID := NewRESTAdapter()
+	r.RegisterName("acme", byName)
+	r.RegisterID(9, byID)
+
+	a, _ := r.Resolve(&common.Gateway{ID: 1, Name: "acme", DataFormatSupported: "application/json"})
+	if a != byName {
+		t.Errorf("expected name binding to win over data format")
+	}
+
+	a, _ = r.Resolve(&common.Gateway{ID: 9, Name: "acme", DataFormatSupported: "application/json"})
+	if a != byID {
+		t.Errorf("expected ID binding to win over name")
+	}
+}
+
+func TestParseCallback(t *testing.T) {
+	soapBody := func(reference, status string) []byte {
+		return []byte(`<?xml version="1.0"?><soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body>` +
+			`<TransactionNotification><Reference>` + reference + `</Reference><ProviderReference>soap-9</ProviderReference>` +
+			`<Status>` + status + `</Status></TransactionNotification></soap:Body></soap:Envelope>`)
+	}
+
+	tests := []struct {
+		name       string
+		adapter    IAdapter
+		body       []byte
+		wantStatus string
+		wantRefund int64
+		wantErr    bool
+	}{
+		{name: "rest succeeded", adapter: RESTAdapter{}, body: []byte(`{"id":"rest-1","reference":"42","status":"succeeded"}`), wantStatus: "completed"},
+		{name: "rest declined", adapter: RESTAdapter{}, body: []byte(`{"id":"rest-1","reference":"42","status":"Declined"}`), wantStatus: "failed"},
+		{name: "rest unknown status", adapter: RESTAdapter{}, body: []byte(`{"id":"rest-1","reference":"42","status":"weird"}`), wantErr: true},
+		{name: "rest invalid reference", adapter: RESTAdapter{}, body: []byte(`{"id":"rest-1","reference":"abc","status":"paid"}`), wantErr: true},
+		{name: "rest malformed", adapter: RESTAdapter{}, body: []byte(`{`), wantErr: true},
+		{name: "rest refund", adapter: RESTAdapter{}, body: []byte(`{"id":"rest-2","reference":"42:refund:5","status":"refunded"}`), wantStatus: "completed", wantRefund: 5},
+		{name: "rest refund failed", adapter: RESTAdapter{}, body: []byte(`{"id":"rest-2","reference":"42:refund:5","status":"refund_failed"}`), wantStatus: "failed", wantRefund: 5},
+		{name: "rest refunded tx", adapter: RESTAdapter{}, body: []byte(`{"id":"rest-1","reference":"42","status":"refunded"}`), wantErr: true},
+		{name: "soap refund", adapter: SOAPAdapter{}, body: soapBody("42:refund:5", "REFUNDED"), wantStatus: "completed", wantRefund: 5},
+		{name: "soap refunded tx", adapter: SOAPAdapter{}, body: soapBody("42", "REFUNDED"), wantErr: true},
+		{name: "rest invalid refund reference", adapter: RESTAdapter{}, body: []byte(`{"id":"rest-2","reference":"42:refund:x","status":"refunded"}`), wantErr: true},
+		{name: "rest dispute", adapter: RESTAdapter{}, body: []byte(`{"type":"dispute","id":"dp-1","reference":"42","status":"needs_response","amount":10.50,"currency":"EUR","reason":"fraudulent","evidence_due_by":"2024-01-08T00:00:00Z"}`), wantStatus: "opened"},
+		{name: "rest dispute without id", adapter: RESTAdapter{}, body: []byte(`{"type":"dispute","reference":"42","status":"lost"}`), wantErr: true},
+		{name: "rest dispute of a refund", adapter: RESTAdapter{}, body: []byte(`{"type":"dispute","id":"dp-1","reference":"42:refund:5","status":"lost"}`), wantErr: true},
+		{name: "soap dispute", adapter: SOAPAdapter{}, body: []byte(`<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body>` +
+			`<DisputeNotification><Reference>42</Reference><DisputeReference>cb-9</DisputeReference><Status>ACCEPTED</Status>` +
+			`<Amount>10.50</Amount><Currency>EUR</Currency></DisputeNotification></soap:Body></soap:Envelope>`), wantStatus: "lost"},
+		{name: "soap settled", adapter: SOAPAdapter{}, body: soapBody("42", "SETTLED"), wantStatus: "completed"},
+		{name: "soap voided", adapter: SOAPAdapter{}, body: soapBody("42", "VOIDED"), wantStatus: "cancelled"},
+		{name: "soap missing notification", adapter: SOAPAdapter{}, body: []byte(`<Envelope><Body></Body></Envelope>`), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cb, err := tt.adapter.ParseCallback(tt.body)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", cb)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected success, got error: %v", err)
+			}
+			if cb.TransactionID != 42 || cb.RefundID != tt.wantRefund || cb.Status != tt.wantStatus || cb.ProviderRef == "" {
+				t.Errorf("unexpected callback: %+v", cb)
+			}
+			if cb.Dispute != nil && (cb.Dispute.Amount != "10.50" || cb.Dispute.ProviderRef != cb.ProviderRef) {
+				t.Errorf("unexpected dispute: %+v", cb.Dispute)
+			}
+		})
+	}
+}
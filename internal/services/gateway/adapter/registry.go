@@ -0,0 +1,76 @@
+package adapter
+
+import (
+	"fmt"
+	"payment-gateway/internal/models/common"
+	"sync"
+)
+
+// Registry resolves the adapter responsible for a gateway.
+// Lookups go by gateway ID, then gateway name, then the gateway's supported data format.
+type Registry struct {
+	mu       sync.RWMutex
+	byID     map[int]IAdapter
+	byName   map[string]IAdapter
+	byFormat map[string]IAdapter
+}
+
+// NewRegistry creates a registry with the generic REST/JSON and SOAP/XML adapters registered by data format
+func NewRegistry() *Registry {
+	r := &Registry{
+		byID:     make(map[int]IAdapter),
+		byName:   make(map[string]IAdapter),
+		byFormat: make(map[string]IAdapter),
+	}
+
+	rest := NewRESTAdapter()
+	r.RegisterFormat("application/json", rest)
+
+	soap := NewSOAPAdapter()
+	r.RegisterFormat("text/xml", soap)
+	r.RegisterFormat("application/xml", soap)
+	r.RegisterFormat("application/soap+xml", soap)
+
+	return r
+}
+
+// RegisterID binds an adapter to a specific gateway ID
+func (r *Registry) RegisterID(gatewayID int, a IAdapter) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.byID[gatewayID] = a
+}
+
+// RegisterName binds an adapter to a gateway name
+func (r *Registry) RegisterName(name string, a IAdapter) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.byName[name] = a
+}
+
+// RegisterFormat binds an adapter to a data format (e.g. application/json)
+func (r *Registry) RegisterFormat(format string, a IAdapter) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.byFormat[format] = a
+}
+
+// Resolve returns the adapter for the given gateway
+func (r *Registry) Resolve(gateway *common.Gateway) (IAdapter, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if a, ok := r.byID[gateway.ID]; ok {
+		return a, nil
+	}
+
+	if a, ok := r.byName[gateway.Name]; ok {
+		return a, nil
+	}
+
+	if a, ok := r.byFormat[gateway.DataFormatSupported]; ok {
+		return a, nil
+	}
+
+	return nil, fmt.Errorf("no adapter registered for gateway %s (format %q)", gateway.Name, gateway.DataFormatSupported)
+}
@@ -0,0 +1,201 @@
+package adapter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"payment-gateway/internal/models/common"
+	"payment-gateway/internal/models/postgres"
+	"payment-gateway/internal/money"
+	"strconv"
+	"time"
+)
+
+type (
+	// RESTAdapter talks to providers exposing a plain JSON over HTTP API
+	RESTAdapter struct{}
+
+	restRequest struct {
+		Reference string        `json:"reference"`
+		Type      string        `json:"type"`
+		Amount    money.Decimal `json:"amount"`
+		Currency  string        `json:"currency"`
+		UserID    int           `json:"user_id"`
+		CountryID int           `json:"country_id"`
+	}
+
+	// restRefundRequest refunds a tx the provider processed, OriginalReference and ProviderRef identify the tx
+	restRefundRequest struct {
+		Reference         string        `json:"reference"`
+		Type              string        `json:"type"`
+		OriginalReference string        `json:"original_reference"`
+		ProviderRef       string        `json:"provider_ref"`
+		Amount            money.Decimal `json:"amount"`
+		Currency          string        `json:"currency"`
+		Reason            string        `json:"reason,omitempty"`
+	}
+
+	// restVoidRequest voids a tx the provider accepted, Reference and ProviderRef identify the tx
+	restVoidRequest struct {
+		Reference   string `json:"reference"`
+		Type        string `json:"type"`
+		ProviderRef string `json:"provider_ref"`
+	}
+
+	restResponse struct {
+		ID        string `json:"id"`
+		Reference string `json:"reference"`
+		Status    string `json:"status"`
+	}
+
+	// restCallback is the notification REST providers post when a tx changes status, or when a dispute of the tx is
+	// opened or changes status, Type being "dispute" and ID the ID of the dispute
+	restCallback struct {
+		EventID       string        `json:"event_id"`
+		Type          string        `json:"type"`
+		ID            string        `json:"id"`
+		Reference     string        `json:"reference"`
+		Status        string        `json:"status"`
+		Amount        money.Decimal `json:"amount"`
+		Currency      string        `json:"currency"`
+		Reason        string        `json:"reason"`
+		EvidenceDueBy *time.Time    `json:"evidence_due_by"`
+	}
+)
+
+// restDisputeStatuses maps the dispute statuses of REST providers, lower cased, to dispute statuses
+var restDisputeStatuses = map[string]string{
+	"opened":                 "opened",
+	"needs_response":         "opened",
+	"warning_needs_response": "opened",
+	"under_review":           "evidence_submitted",
+	"evidence_submitted":     "evidence_submitted",
+	"won":                    "won",
+	"lost":                   "lost",
+}
+
+// restStatuses maps the statuses of REST providers, lower cased, to tx statuses
+var restStatuses = map[string]string{
+	"accepted":   "processing",
+	"pending":    "processing",
+	"processing": "processing",
+	"succeeded":  "completed",
+	"completed":  "completed",
+	"paid":       "completed",
+	"failed":     "failed",
+	"declined":   "failed",
+	"rejected":   "failed",
+	"canceled":   "cancelled",
+	"cancelled":  "cancelled",
+	"expired":    "expired",
+}
+
+// restRefundStatuses maps the statuses REST providers report for a refund, lower cased, to refund statuses
+var restRefundStatuses = map[string]string{
+	"accepted":      "processing",
+	"pending":       "processing",
+	"processing":    "processing",
+	"succeeded":     "completed",
+	"completed":     "completed",
+	"refunded":      "completed",
+	"failed":        "failed",
+	"declined":      "failed",
+	"rejected":      "failed",
+	"canceled":      "failed",
+	"cancelled":     "failed",
+	"expired":       "failed",
+	"refund_failed": "failed",
+}
+
+func NewRESTAdapter() IAdapter {
+	return &RESTAdapter{}
+}
+
+func (a RESTAdapter) BuildRequest(ctx context.Context, gateway *common.Gateway, tx postgres.Transaction) (*http.Request, error) {
+	return newJSONRequest(ctx, gateway, restRequest{
+		Reference: strconv.FormatInt(tx.ID, 10),
+		Type:      tx.Type,
+		Amount:    tx.Money().Decimal(),
+		Currency:  tx.Currency,
+		UserID:    tx.UserID,
+		CountryID: tx.CountryID,
+	})
+}
+
+// BuildRefundRequest posts the refund to the gateway's endpoint, like a tx of type refund
+func (a RESTAdapter) BuildRefundRequest(ctx context.Context, gateway *common.Gateway, tx postgres.Transaction, refund postgres.Refund) (*http.Request, error) {
+	return newJSONRequest(ctx, gateway, restRefundRequest{
+		Reference:         RefundReference(refund),
+		Type:              "refund",
+		OriginalReference: strconv.FormatInt(tx.ID, 10),
+		ProviderRef:       tx.ProviderRef,
+		Amount:            refund.Money().Decimal(),
+		Currency:          refund.Currency,
+		Reason:            refund.Reason,
+	})
+}
+
+// BuildVoidRequest posts the void of the tx to the gateway's endpoint, like a tx of type void
+func (a RESTAdapter) BuildVoidRequest(ctx context.Context, gateway *common.Gateway, tx postgres.Transaction) (*http.Request, error) {
+	return newJSONRequest(ctx, gateway, restVoidRequest{
+		Reference:   strconv.FormatInt(tx.ID, 10),
+		Type:        "void",
+		ProviderRef: tx.ProviderRef,
+	})
+}
+
+// newJSONRequest returns a request posting body as JSON to the gateway's endpoint
+func newJSONRequest(ctx context.Context, gateway *common.Gateway, body interface{}) (*http.Request, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, gateway.EndpointURL, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	return req, nil
+}
+
+func (a RESTAdapter) ParseResponse(body []byte) (*Response, error) {
+	var res restResponse
+	if err := json.Unmarshal(body, &res); err != nil {
+		return nil, err
+	}
+
+	// providers either return their own id or echo back a reference
+	ref := res.ID
+	if ref == "" {
+		ref = res.Reference
+	}
+
+	if ref == "" || res.Status == "" {
+		return nil, errors.New("response is missing reference or status")
+	}
+
+	return newResponse(ref, res.Status, restStatuses), nil
+}
+
+// ParseCallback reads a JSON notification, e.g. {"event_id": "evt-1", "id": "rest-123", "reference": "42", "status": "succeeded"},
+// the reference of a refund being the one it was sent with, e.g. "42:refund:7". A dispute notification has the type
+// "dispute", e.g. {"type": "dispute", "id": "dp-1", "reference": "42", "status": "needs_response", "amount": 10.50,
+// "currency": "EUR", "reason": "fraudulent", "evidence_due_by": "2024-01-08T00:00:00Z"}.
+func (a RESTAdapter) ParseCallback(body []byte) (*Callback, error) {
+	var cb restCallback
+	if err := json.Unmarshal(body, &cb); err != nil {
+		return nil, err
+	}
+
+	if cb.Type == "dispute" {
+		dispute := Dispute{ProviderRef: cb.ID, Amount: cb.Amount, Currency: cb.Currency, Reason: cb.Reason, EvidenceDueAt: cb.EvidenceDueBy}
+		return newDisputeCallback(cb.EventID, cb.Reference, dispute, cb.Status, restDisputeStatuses)
+	}
+
+	return newCallback(cb.EventID, cb.Reference, cb.ID, cb.Status, restStatuses, restRefundStatuses)
+}
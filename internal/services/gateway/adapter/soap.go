@@ -0,0 +1,241 @@
+package adapter
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"payment-gateway/internal/models/common"
+	"payment-gateway/internal/models/postgres"
+	"payment-gateway/internal/money"
+	"strconv"
+	"time"
+)
+
+const soapEnvelopeNS = "http://schemas.xmlsoap.org/soap/envelope/"
+
+type (
+	// SOAPAdapter talks to providers exposing a SOAP 1.1 API
+	SOAPAdapter struct{}
+
+	soapEnvelope struct {
+		XMLName xml.Name `xml:"soap:Envelope"`
+		SoapNS  string   `xml:"xmlns:soap,attr"`
+		Body    soapBody `xml:"soap:Body"`
+	}
+
+	soapBody struct {
+		Content interface{}
+	}
+
+	soapTxRequest struct {
+		XMLName   xml.Name      `xml:"ProcessTransaction"`
+		Reference string        `xml:"Reference"`
+		Type      string        `xml:"Type"`
+		Amount    money.Decimal `xml:"Amount"`
+		Currency  string        `xml:"Currency"`
+		UserID    int           `xml:"UserID"`
+		CountryID int           `xml:"CountryID"`
+	}
+
+	// soapRefundRequest refunds a tx the provider processed, OriginalReference and ProviderReference identify the tx
+	soapRefundRequest struct {
+		XMLName           xml.Name      `xml:"RefundTransaction"`
+		Reference         string        `xml:"Reference"`
+		OriginalReference string        `xml:"OriginalReference"`
+		ProviderReference string        `xml:"ProviderReference"`
+		Amount            money.Decimal `xml:"Amount"`
+		Currency          string        `xml:"Currency"`
+		Reason            string        `xml:"Reason,omitempty"`
+	}
+
+	// soapVoidRequest voids a tx the provider accepted, Reference and ProviderReference identify the tx
+	soapVoidRequest struct {
+		XMLName           xml.Name `xml:"VoidTransaction"`
+		Reference         string   `xml:"Reference"`
+		ProviderReference string   `xml:"ProviderReference"`
+	}
+
+	soapResponse struct {
+		Reference string `xml:"Reference"`
+		Status    string `xml:"Status"`
+	}
+
+	soapResponseEnvelope struct {
+		XMLName xml.Name `xml:"Envelope"`
+		Body    struct {
+			Fault *struct {
+				Code   string `xml:"faultcode"`
+				String string `xml:"faultstring"`
+			} `xml:"Fault"`
+			Response       *soapResponse `xml:"ProcessTransactionResponse"`
+			RefundResponse *soapResponse `xml:"RefundTransactionResponse"`
+			VoidResponse   *soapResponse `xml:"VoidTransactionResponse"`
+		} `xml:"Body"`
+	}
+
+	// soapCallbackEnvelope is the notification SOAP providers post when a tx changes status, or when a dispute of the
+	// tx is opened or changes status
+	soapCallbackEnvelope struct {
+		XMLName xml.Name `xml:"Envelope"`
+		Body    struct {
+			Notification *struct {
+				EventID           string `xml:"EventId"`
+				Reference         string `xml:"Reference"`
+				ProviderReference string `xml:"ProviderReference"`
+				Status            string `xml:"Status"`
+			} `xml:"TransactionNotification"`
+			Dispute *struct {
+				EventID          string        `xml:"EventId"`
+				Reference        string        `xml:"Reference"`
+				DisputeReference string        `xml:"DisputeReference"`
+				Status           string        `xml:"Status"`
+				Amount           money.Decimal `xml:"Amount"`
+				Currency         string        `xml:"Currency"`
+				ReasonCode       string        `xml:"ReasonCode"`
+				EvidenceDueDate  *time.Time    `xml:"EvidenceDueDate"`
+			} `xml:"DisputeNotification"`
+		} `xml:"Body"`
+	}
+)
+
+// soapDisputeStatuses maps the dispute statuses of SOAP providers, lower cased, to dispute statuses
+var soapDisputeStatuses = map[string]string{
+	"open":               "opened",
+	"opened":             "opened",
+	"chargeback":         "opened",
+	"evidence_submitted": "evidence_submitted",
+	"representment":      "evidence_submitted",
+	"won":                "won",
+	"reversed":           "won",
+	"lost":               "lost",
+	"accepted":           "lost",
+}
+
+// soapStatuses maps the statuses of SOAP providers, lower cased, to tx statuses
+var soapStatuses = map[string]string{
+	"accepted":  "processing",
+	"pending":   "processing",
+	"settled":   "completed",
+	"completed": "completed",
+	"failed":    "failed",
+	"declined":  "failed",
+	"cancelled": "cancelled",
+	"voided":    "cancelled",
+	"expired":   "expired",
+}
+
+// soapRefundStatuses maps the statuses SOAP providers report for a refund, lower cased, to refund statuses
+var soapRefundStatuses = map[string]string{
+	"accepted":      "processing",
+	"pending":       "processing",
+	"settled":       "completed",
+	"completed":     "completed",
+	"refunded":      "completed",
+	"failed":        "failed",
+	"declined":      "failed",
+	"cancelled":     "failed",
+	"voided":        "failed",
+	"expired":       "failed",
+	"refund_failed": "failed",
+}
+
+func NewSOAPAdapter() IAdapter {
+	return &SOAPAdapter{}
+}
+
+func (a SOAPAdapter) BuildRequest(ctx context.Context, gateway *common.Gateway, tx postgres.Transaction) (*http.Request, error) {
+	return newSOAPRequest(ctx, gateway, "ProcessTransaction", soapTxRequest{
+		Reference: strconv.FormatInt(tx.ID, 10),
+		Type:      tx.Type,
+		Amount:    tx.Money().Decimal(),
+		Currency:  tx.Currency,
+		UserID:    tx.UserID,
+		CountryID: tx.CountryID,
+	})
+}
+
+// BuildRefundRequest calls the RefundTransaction action of the gateway, answered with a RefundTransactionResponse
+func (a SOAPAdapter) BuildRefundRequest(ctx context.Context, gateway *common.Gateway, tx postgres.Transaction, refund postgres.Refund) (*http.Request, error) {
+	return newSOAPRequest(ctx, gateway, "RefundTransaction", soapRefundRequest{
+		Reference:         RefundReference(refund),
+		OriginalReference: strconv.FormatInt(tx.ID, 10),
+		ProviderReference: tx.ProviderRef,
+		Amount:            refund.Money().Decimal(),
+		Currency:          refund.Currency,
+		Reason:            refund.Reason,
+	})
+}
+
+// BuildVoidRequest calls the VoidTransaction action of the gateway, answered with a VoidTransactionResponse
+func (a SOAPAdapter) BuildVoidRequest(ctx context.Context, gateway *common.Gateway, tx postgres.Transaction) (*http.Request, error) {
+	return newSOAPRequest(ctx, gateway, "VoidTransaction", soapVoidRequest{
+		Reference:         strconv.FormatInt(tx.ID, 10),
+		ProviderReference: tx.ProviderRef,
+	})
+}
+
+// newSOAPRequest returns a request calling action of the gateway with content as the body of the envelope
+func newSOAPRequest(ctx context.Context, gateway *common.Gateway, action string, content interface{}) (*http.Request, error) {
+	body, err := xml.Marshal(soapEnvelope{SoapNS: soapEnvelopeNS, Body: soapBody{Content: content}})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, gateway.EndpointURL, bytes.NewReader(append([]byte(xml.Header), body...)))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "text/xml; charset=utf-8")
+	req.Header.Set("SOAPAction", action)
+
+	return req, nil
+}
+
+func (a SOAPAdapter) ParseResponse(body []byte) (*Response, error) {
+	var env soapResponseEnvelope
+	if err := xml.Unmarshal(body, &env); err != nil {
+		return nil, err
+	}
+
+	if f := env.Body.Fault; f != nil {
+		return nil, fmt.Errorf("soap fault %s: %s", f.Code, f.String)
+	}
+
+	res := env.Body.Response
+	if res == nil {
+		res = env.Body.RefundResponse
+	}
+	if res == nil {
+		res = env.Body.VoidResponse
+	}
+	if res == nil || res.Reference == "" || res.Status == "" {
+		return nil, errors.New("response is missing reference or status")
+	}
+
+	return newResponse(res.Reference, res.Status, soapStatuses), nil
+}
+
+// ParseCallback reads a SOAP notification whose body is a TransactionNotification with the EventId of the
+// notification, the Reference we sent, the ProviderReference and the Status, or a DisputeNotification with the
+// Reference of the disputed tx, the DisputeReference, Status, Amount, Currency, ReasonCode and EvidenceDueDate
+func (a SOAPAdapter) ParseCallback(body []byte) (*Callback, error) {
+	var env soapCallbackEnvelope
+	if err := xml.Unmarshal(body, &env); err != nil {
+		return nil, err
+	}
+
+	if d := env.Body.Dispute; d != nil {
+		dispute := Dispute{ProviderRef: d.DisputeReference, Amount: d.Amount, Currency: d.Currency, Reason: d.ReasonCode, EvidenceDueAt: d.EvidenceDueDate}
+		return newDisputeCallback(d.EventID, d.Reference, dispute, d.Status, soapDisputeStatuses)
+	}
+
+	n := env.Body.Notification
+	if n == nil {
+		return nil, errors.New("notification is missing")
+	}
+
+	return newCallback(n.EventID, n.Reference, n.ProviderReference, n.Status, soapStatuses, soapRefundStatuses)
+}
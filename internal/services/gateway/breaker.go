@@ -0,0 +1,283 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"payment-gateway/internal/models/common"
+	"payment-gateway/internal/services/gateway/adapter"
+	"payment-gateway/internal/util"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+// maxWindowOutcomes bounds the memory used by a gateway's sliding window
+const maxWindowOutcomes = 1000
+
+type (
+	// BreakerConfig controls when a gateway's circuit breaker trips and how it recovers
+	BreakerConfig struct {
+		Window           time.Duration
+		MinRequests      int
+		MaxErrorRate     float64
+		MaxTimeoutRate   float64
+		MaxP95Latency    time.Duration
+		OpenTimeout      time.Duration
+		HalfOpenRequests uint32
+	}
+
+	// Breakers holds one circuit breaker per gateway, fed by the outcomes of the sends to that gateway
+	Breakers struct {
+		cfg       BreakerConfig
+		mu        sync.Mutex
+		byGateway map[int]*gatewayBreaker
+	}
+
+	gatewayBreaker struct {
+		cb     *gobreaker.TwoStepCircuitBreaker
+		window *slidingWindow
+	}
+
+	// slidingWindow keeps the send outcomes of a gateway over the last window duration
+	slidingWindow struct {
+		mu       sync.Mutex
+		length   time.Duration
+		outcomes []sendOutcome
+	}
+
+	sendOutcome struct {
+		at      time.Time
+		latency time.Duration
+		failed  bool
+		timeout bool
+	}
+
+	windowStats struct {
+		requests    int
+		errorRate   float64
+		timeoutRate float64
+		p95Latency  time.Duration
+	}
+)
+
+// LoadBreakerConfig reads the gateway circuit breaker settings from the environment
+func LoadBreakerConfig() BreakerConfig {
+	return BreakerConfig{
+		Window:           util.GetEnvDuration("GATEWAY_BREAKER_WINDOW", time.Minute),
+		MinRequests:      util.GetEnvInt("GATEWAY_BREAKER_MIN_REQUESTS", 10),
+		MaxErrorRate:     util.GetEnvFloat("GATEWAY_BREAKER_MAX_ERROR_RATE", 0.5),
+		MaxTimeoutRate:   util.GetEnvFloat("GATEWAY_BREAKER_MAX_TIMEOUT_RATE", 0.2),
+		MaxP95Latency:    util.GetEnvDuration("GATEWAY_BREAKER_MAX_P95_LATENCY", 10*time.Second),
+		OpenTimeout:      util.GetEnvDuration("GATEWAY_BREAKER_OPEN_TIMEOUT", 30*time.Second),
+		HalfOpenRequests: uint32(util.GetEnvInt("GATEWAY_BREAKER_HALF_OPEN_REQUESTS", 3)),
+	}
+}
+
+func NewBreakers(cfg BreakerConfig) *Breakers {
+	return &Breakers{cfg: cfg, byGateway: make(map[int]*gatewayBreaker)}
+}
+
+// Allow reports whether traffic may be sent to the gateway, i.e. its breaker is closed or half-open
+func (b *Breakers) Allow(gateway *common.Gateway) bool {
+	return b.get(gateway).cb.State() != gobreaker.StateOpen
+}
+
+// Execute runs send through the gateway's breaker and feeds the outcome into its sliding window.
+// Slow successes are reported as failures to the breaker once the window's p95 latency is above the limit.
+func (b *Breakers) Execute(gateway *common.Gateway, send func() (*adapter.Response, error)) (*adapter.Response, error) {
+	gb := b.get(gateway)
+
+	done, err := gb.cb.Allow()
+	if err != nil {
+		return nil, fmt.Errorf("gateway %s unavailable: %w", gateway.Name, err)
+	}
+
+	start := time.Now()
+	res, err := send()
+	latency := time.Since(start)
+
+	failed := isBreakerFailure(err)
+	gb.window.add(sendOutcome{at: start, latency: latency, failed: failed, timeout: isTimeout(err)})
+
+	if !failed && b.cfg.MaxP95Latency > 0 && latency > b.cfg.MaxP95Latency {
+		failed = gb.window.stats(time.Now()).p95Latency > b.cfg.MaxP95Latency
+	}
+	done(!failed)
+
+	return res, err
+}
+
+// States returns the breaker state of every gateway that has seen traffic
+func (b *Breakers) States() []common.GatewayBreaker {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	states := make([]common.GatewayBreaker, 0, len(b.byGateway))
+	for gatewayID, gb := range b.byGateway {
+		stats := gb.window.stats(now)
+		states = append(states, common.GatewayBreaker{
+			GatewayID:   gatewayID,
+			GatewayName: gb.cb.Name(),
+			State:       gb.cb.State().String(),
+			Requests:    stats.requests,
+			ErrorRate:   stats.errorRate,
+			TimeoutRate: stats.timeoutRate,
+			P95Latency:  stats.p95Latency.Milliseconds(),
+		})
+	}
+
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].GatewayID < states[j].GatewayID
+	})
+
+	return states
+}
+
+func (b *Breakers) get(gateway *common.Gateway) *gatewayBreaker {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if gb, ok := b.byGateway[gateway.ID]; ok {
+		return gb
+	}
+
+	window := &slidingWindow{length: b.cfg.Window}
+	gb := &gatewayBreaker{
+		window: window,
+		cb: gobreaker.NewTwoStepCircuitBreaker(gobreaker.Settings{
+			Name:        gateway.Name,
+			MaxRequests: b.cfg.HalfOpenRequests,
+			Timeout:     b.cfg.OpenTimeout,
+			ReadyToTrip: func(gobreaker.Counts) bool {
+				return b.shouldTrip(window.stats(time.Now()))
+			},
+			OnStateChange: func(name string, from gobreaker.State, to gobreaker.State) {
+				log.Printf("gateway %s circuit breaker changed from %s to %s", name, from, to)
+
+				// start over once the gateway is back, so stale failures don't trip it again
+				if to == gobreaker.StateClosed {
+					window.reset()
+				}
+			},
+		}),
+	}
+	b.byGateway[gateway.ID] = gb
+
+	return gb
+}
+
+func (b *Breakers) shouldTrip(stats windowStats) bool {
+	if stats.requests < b.cfg.MinRequests {
+		return false
+	}
+
+	return stats.errorRate > b.cfg.MaxErrorRate ||
+		stats.timeoutRate > b.cfg.MaxTimeoutRate ||
+		(b.cfg.MaxP95Latency > 0 && stats.p95Latency > b.cfg.MaxP95Latency)
+}
+
+func (w *slidingWindow) add(o sendOutcome) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.outcomes = append(w.outcomes, o)
+	if len(w.outcomes) > maxWindowOutcomes {
+		w.outcomes = w.outcomes[len(w.outcomes)-maxWindowOutcomes:]
+	}
+}
+
+func (w *slidingWindow) reset() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.outcomes = nil
+}
+
+// stats drops the outcomes older than the window and summarises the remaining ones
+func (w *slidingWindow) stats(now time.Time) windowStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	cutoff := now.Add(-w.length)
+	i := 0
+	for i < len(w.outcomes) && w.outcomes[i].at.Before(cutoff) {
+		i++
+	}
+	w.outcomes = w.outcomes[i:]
+
+	n := len(w.outcomes)
+	if n == 0 {
+		return windowStats{}
+	}
+
+	var failures, timeouts int
+	latencies := make([]time.Duration, n)
+	for j, o := range w.outcomes {
+		latencies[j] = o.latency
+		if o.failed {
+			failures++
+		}
+		if o.timeout {
+			timeouts++
+		}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	return windowStats{
+		requests:    n,
+		errorRate:   float64(failures) / float64(n),
+		timeoutRate: float64(timeouts) / float64(n),
+		p95Latency:  latencies[(n*95+99)/100-1],
+	}
+}
+
+// isBreakerFailure tells whether a send error says something about the gateway's health.
+// Requests rejected by the provider (4xx) are the caller's problem and don't count against the gateway.
+func isBreakerFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var statusErr *adapter.StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= http.StatusInternalServerError
+	}
+
+	return true
+}
+
+// IsRetryableSendError reports whether a failed send is worth retrying against the same gateway. Rejections (4xx
+// other than 429) and unsupported operations won't succeed on a retry and an open breaker refuses the send anyway, they
+// fail over straight away.
+func IsRetryableSendError(err error) bool {
+	if errors.Is(err, gobreaker.ErrOpenState) || errors.Is(err, gobreaker.ErrTooManyRequests) || errors.Is(err, adapter.ErrNotSupported) {
+		return false
+	}
+
+	var statusErr *adapter.StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= http.StatusInternalServerError || statusErr.StatusCode == http.StatusTooManyRequests
+	}
+
+	return true
+}
+
+func isTimeout(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
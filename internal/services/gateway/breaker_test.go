@@ -0,0 +1,81 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"payment-gateway/internal/models/common"
+	"payment-gateway/internal/services/gateway/adapter"
+	"testing"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+func TestBreakers_TripAndRecover(t *testing.T) {
+	b := NewBreakers(BreakerConfig{
+		Window:           time.Minute,
+		MinRequests:      4,
+		MaxErrorRate:     0.5,
+		MaxTimeoutRate:   1,
+		OpenTimeout:      50 * time.Millisecond,
+		HalfOpenRequests: 1,
+	})
+	gw := &common.Gateway{ID: 1, Name: "flaky"}
+
+	fail := func() (*adapter.Response, error) { return nil, errors.New("connection refused") }
+	ok := func() (*adapter.Response, error) { return &adapter.Response{ProviderRef: "ok"}, nil }
+	rejected := func() (*adapter.Response, error) { return nil, &adapter.StatusError{StatusCode: 400} }
+
+	// provider rejections don't count against the gateway
+	for i := 0; i < 3; i++ {
+		b.Execute(gw, rejected)
+	}
+	if !b.Allow(gw) {
+		t.Fatalf("expected 4xx rejections to keep the breaker closed")
+	}
+
+	for i := 0; i < 5; i++ {
+		b.Execute(gw, fail)
+	}
+	if b.Allow(gw) {
+		t.Fatalf("expected breaker to open once the error rate is above the limit")
+	}
+	if _, err := b.Execute(gw, ok); err == nil {
+		t.Errorf("expected open breaker to reject traffic")
+	}
+
+	// after the open timeout a trial request is let through and closes the breaker
+	time.Sleep(60 * time.Millisecond)
+	if !b.Allow(gw) {
+		t.Fatalf("expected breaker to be half-open after the open timeout")
+	}
+	if _, err := b.Execute(gw, ok); err != nil {
+		t.Fatalf("expected trial request to go through, got %v", err)
+	}
+	if s := b.States(); len(s) != 1 || s[0].State != "closed" || s[0].Requests != 0 {
+		t.Errorf("expected closed breaker with a fresh window, got %+v", s)
+	}
+}
+
+func TestIsRetryableSendError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "network error", err: errors.New("connection refused"), want: true},
+		{name: "server error", err: &adapter.StatusError{StatusCode: http.StatusBadGateway}, want: true},
+		{name: "rate limited", err: &adapter.StatusError{StatusCode: http.StatusTooManyRequests}, want: true},
+		{name: "rejected", err: &adapter.StatusError{StatusCode: http.StatusBadRequest}, want: false},
+		{name: "breaker open", err: fmt.Errorf("gateway psp unavailable: %w", gobreaker.ErrOpenState), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRetryableSendError(tt.err); got != tt.want {
+				t.Errorf("expected %t, got %t", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package gateway
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"payment-gateway/internal/models/common"
+	"payment-gateway/internal/services/gateway/adapter"
+	"payment-gateway/internal/util"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrCallbackUnauthorized is wrapped by the errors returned when a callback is not signed by the gateway
+	ErrCallbackUnauthorized = errors.New("callback unauthorized")
+	// ErrCallbackForbidden is wrapped by the errors returned when a callback comes from an IP the gateway does not use
+	ErrCallbackForbidden = errors.New("callback forbidden")
+	// ErrInvalidCallback is wrapped by the errors returned when a signed callback cannot be parsed
+	ErrInvalidCallback = errors.New("invalid callback")
+)
+
+type (
+	// CallbackConfig controls how signed callbacks are verified
+	CallbackConfig struct {
+		// Tolerance is how far the timestamp of a callback may be from now, callbacks replayed later are rejected
+		Tolerance time.Duration
+	}
+
+	// CallbackRequest is a callback posted by a gateway, along with the headers authenticating it
+	CallbackRequest struct {
+		Body      []byte
+		Signature string
+		Timestamp string
+		RemoteIP  string
+	}
+)
+
+// LoadCallbackConfig reads the callback verification settings from the environment
+func LoadCallbackConfig() CallbackConfig {
+	return CallbackConfig{
+		Tolerance: util.GetEnvDuration("CALLBACK_SIGNATURE_TOLERANCE", 5*time.Minute),
+	}
+}
+
+// VerifyCallback authenticates a callback posted by a gateway. The sender must be in the gateway's IP allow-list,
+// when it has one, and the signature must be the hex HMAC-SHA256 of "<timestamp>.<body>" keyed with the gateway's
+// callback secret, the timestamp being in unix seconds and within the tolerance of now.
+func (g SvcGateway) VerifyCallback(ctx context.Context, gatewayID int, req CallbackRequest) error {
+	gateway, err := g.db.GetGatewayByID(ctx, gatewayID)
+	if err != nil {
+		return err
+	}
+
+	if gateway.CallbackSecret == "" {
+		return fmt.Errorf("%w: gateway %d has no callback secret", ErrCallbackUnauthorized, gatewayID)
+	}
+
+	if !callbackIPAllowed(gateway, req.RemoteIP) {
+		return fmt.Errorf("%w: %s is not allowed to post callbacks for gateway %d", ErrCallbackForbidden, req.RemoteIP, gatewayID)
+	}
+
+	if err = verifySignature(gateway.CallbackSecret, req, g.callbacks.Tolerance, time.Now()); err != nil {
+		return fmt.Errorf("%w: %v", ErrCallbackUnauthorized, err)
+	}
+
+	return nil
+}
+
+// ParseCallback parses the body of a callback of the gateway with the gateway's adapter
+func (g SvcGateway) ParseCallback(ctx context.Context, gatewayID int, body []byte) (*adapter.Callback, error) {
+	gateway, err := g.db.GetGatewayByID(ctx, gatewayID)
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := g.adapters.Resolve(gateway)
+	if err != nil {
+		return nil, err
+	}
+
+	cb, err := a.ParseCallback(body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCallback, err)
+	}
+
+	return cb, nil
+}
+
+// SignCallback returns the signature of a callback body sent at timestamp, as gateways compute it
+func SignCallback(secret, timestamp string, body []byte) string {
+	return util.SignPayload(secret, timestamp, body)
+}
+
+// verifySignature checks the timestamp is within tolerance of now and the signature, optionally prefixed by
+// "sha256=", matches the body
+func verifySignature(secret string, req CallbackRequest, tolerance time.Duration, now time.Time) error {
+	if req.Signature == "" || req.Timestamp == "" {
+		return errors.New("signature or timestamp is missing")
+	}
+
+	seconds, err := strconv.ParseInt(req.Timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp %q", req.Timestamp)
+	}
+
+	age := now.Sub(time.Unix(seconds, 0))
+	if tolerance > 0 && (age > tolerance || age < -tolerance) {
+		return fmt.Errorf("timestamp is outside of the %s tolerance", tolerance)
+	}
+
+	signature, err := hex.DecodeString(strings.TrimPrefix(req.Signature, "sha256="))
+	if err != nil {
+		return errors.New("signature is not hex encoded")
+	}
+
+	expected, _ := hex.DecodeString(SignCallback(secret, req.Timestamp, req.Body))
+	if !hmac.Equal(signature, expected) {
+		return errors.New("signature mismatch")
+	}
+
+	return nil
+}
+
+// callbackIPAllowed reports whether ip is one of the IPs or in one of the CIDRs of the gateway's allow-list
+func callbackIPAllowed(gateway *common.Gateway, ip string) bool {
+	if len(gateway.CallbackAllowedIPs) == 0 {
+		return true
+	}
+
+	remote := net.ParseIP(ip)
+	if remote == nil {
+		return false
+	}
+
+	for _, allowed := range gateway.CallbackAllowedIPs {
+		if _, network, err := net.ParseCIDR(allowed); err == nil {
+			if network.Contains(remote) {
+				return true
+			}
+			continue
+		}
+
+		if allowedIP := net.ParseIP(allowed); allowedIP != nil && allowedIP.Equal(remote) {
+			return true
+		}
+	}
+
+	return false
+}
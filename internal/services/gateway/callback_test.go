@@ -0,0 +1,77 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"payment-gateway/db"
+	"payment-gateway/internal/models/common"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVerifyCallback(t *testing.T) {
+	mockDB := &db.MockDB{
+		GetGatewayByIDFunc: func(ctx context.Context, gatewayID int) (*common.Gateway, error) {
+			switch gatewayID {
+			case 1:
+				return &common.Gateway{ID: 1, Name: "rest", DataFormatSupported: "application/json", CallbackSecret: "s3cret",
+					CallbackAllowedIPs: []string{"10.0.0.0/24", "192.168.1.7"}}, nil
+			case 2:
+				return &common.Gateway{ID: 2, Name: "unsigned", DataFormatSupported: "application/json"}, nil
+			default:
+				return nil, fmt.Errorf("gateway %d %w", gatewayID, db.ErrNotFound)
+			}
+		},
+	}
+	svc := NewSvcGateway(mockDB, time.Second)
+
+	body := []byte(`{"id":"rest-1","reference":"42","status":"succeeded"}`)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+
+	tests := []struct {
+		name      string
+		gatewayID int
+		req       CallbackRequest
+		wantErr   error
+	}{
+		{name: "valid", gatewayID: 1, req: CallbackRequest{Body: body, Timestamp: now, Signature: SignCallback("s3cret", now, body), RemoteIP: "10.0.0.12"}},
+		{name: "prefixed signature", gatewayID: 1, req: CallbackRequest{Body: body, Timestamp: now, Signature: "sha256=" + SignCallback("s3cret", now, body), RemoteIP: "192.168.1.7"}},
+		{name: "unknown gateway", gatewayID: 3, req: CallbackRequest{Body: body}, wantErr: db.ErrNotFound},
+		{name: "no secret", gatewayID: 2, req: CallbackRequest{Body: body, Timestamp: now, Signature: SignCallback("", now, body)}, wantErr: ErrCallbackUnauthorized},
+		{name: "ip not allowed", gatewayID: 1, req: CallbackRequest{Body: body, Timestamp: now, Signature: SignCallback("s3cret", now, body), RemoteIP: "10.0.1.12"}, wantErr: ErrCallbackForbidden},
+		{name: "missing signature", gatewayID: 1, req: CallbackRequest{Body: body, Timestamp: now, RemoteIP: "10.0.0.12"}, wantErr: ErrCallbackUnauthorized},
+		{name: "wrong secret", gatewayID: 1, req: CallbackRequest{Body: body, Timestamp: now, Signature: SignCallback("other", now, body), RemoteIP: "10.0.0.12"}, wantErr: ErrCallbackUnauthorized},
+		{name: "tampered body", gatewayID: 1, req: CallbackRequest{Body: []byte(`{"id":"rest-1","reference":"43","status":"succeeded"}`), Timestamp: now, Signature: SignCallback("s3cret", now, body), RemoteIP: "10.0.0.12"}, wantErr: ErrCallbackUnauthorized},
+		{name: "replayed", gatewayID: 1, req: CallbackRequest{Body: body, Timestamp: stale, Signature: SignCallback("s3cret", stale, body), RemoteIP: "10.0.0.12"}, wantErr: ErrCallbackUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := svc.VerifyCallback(context.Background(), tt.gatewayID, tt.req)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestParseCallback(t *testing.T) {
+	mockDB := &db.MockDB{
+		GetGatewayByIDFunc: func(ctx context.Context, gatewayID int) (*common.Gateway, error) {
+			return &common.Gateway{ID: gatewayID, Name: "rest", DataFormatSupported: "application/json"}, nil
+		},
+	}
+	svc := NewSvcGateway(mockDB, time.Second)
+
+	cb, err := svc.ParseCallback(context.Background(), 1, []byte(`{"event_id":"evt-1","id":"rest-1","reference":"42","status":"succeeded"}`))
+	if err != nil || cb.EventID != "evt-1" || cb.TransactionID != 42 || cb.Status != "completed" {
+		t.Fatalf("unexpected callback %+v: %v", cb, err)
+	}
+
+	if _, err = svc.ParseCallback(context.Background(), 1, []byte(`{}`)); !errors.Is(err, ErrInvalidCallback) {
+		t.Errorf("expected an invalid callback, got %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package gateway
+
+import (
+	"fmt"
+	"log"
+	"math/big"
+	"payment-gateway/internal/models/common"
+	"payment-gateway/internal/money"
+	"sort"
+	"strings"
+)
+
+const (
+	// StrategyPriority keeps the candidates in routing order
+	StrategyPriority = "priority"
+	// StrategyLeastCost moves the candidates with the lowest expected fee first
+	StrategyLeastCost = "least_cost"
+)
+
+// loadStrategy validates the configured gateway selection strategy, falling back to priority
+func loadStrategy(v string) string {
+	switch v {
+	case "", StrategyPriority:
+		return StrategyPriority
+	case StrategyLeastCost:
+		return StrategyLeastCost
+	default:
+		log.Printf("unknown gateway selection strategy %q, using %s", v, StrategyPriority)
+		return StrategyPriority
+	}
+}
+
+// priceGateways sets the expected fee of each gateway for the route from the most specific matching fee schedule
+func priceGateways(gateways []*common.Gateway, schedules []*common.FeeSchedule, route common.RouteRequest) {
+	for _, gateway := range gateways {
+		if schedule := feeScheduleFor(schedules, gateway.ID, route); schedule != nil {
+			fee, err := expectedFee(schedule, route.Money())
+			if err != nil {
+				log.Printf("ignoring fee schedule of gateway %d: %v", gateway.ID, err)
+				continue
+			}
+			gateway.ExpectedFee = &fee
+		}
+	}
+}
+
+// sortByFee stably orders gateways by ascending expected fee, gateways without a fee schedule go last
+func sortByFee(gateways []*common.Gateway) {
+	sort.SliceStable(gateways, func(i, j int) bool {
+		a, b := gateways[i].ExpectedFee, gateways[j].ExpectedFee
+		if a == nil || b == nil {
+			return a != nil && b == nil
+		}
+
+		return *a < *b
+	})
+}
+
+// feeScheduleFor returns the schedule of the gateway matching the route's currency and type, a schedule for a
+// specific currency beats a schedule for a specific type which beats a catch-all schedule
+func feeScheduleFor(schedules []*common.FeeSchedule, gatewayID int, route common.RouteRequest) *common.FeeSchedule {
+	var best *common.FeeSchedule
+	bestScore := -1
+	for _, schedule := range schedules {
+		if schedule.GatewayID != gatewayID {
+			continue
+		}
+
+		score := 0
+		if schedule.Currency != "" {
+			if !strings.EqualFold(schedule.Currency, route.Currency) {
+				continue
+			}
+			score += 2
+		}
+		if schedule.TransactionType != "" {
+			if schedule.TransactionType != route.TransactionType {
+				continue
+			}
+			score++
+		}
+
+		if score > bestScore {
+			best, bestScore = schedule, score
+		}
+	}
+
+	return best
+}
+
+// expectedFee is the fixed fee plus the percentage of the amount, computed exactly and rounded to the minor unit
+func expectedFee(schedule *common.FeeSchedule, amount money.Money) (int64, error) {
+	pct, err := schedule.PercentageFee.Rat()
+	if err != nil {
+		return 0, fmt.Errorf("percentage fee: %v", err)
+	}
+
+	fee := new(big.Rat).Mul(amount.Rat(), pct)
+	fee.Quo(fee, big.NewRat(100, 1))
+
+	return schedule.FixedFee + money.RoundToMinor(fee, amount.Currency), nil
+}
@@ -0,0 +1,55 @@
+package gateway
+
+import (
+	"context"
+	"payment-gateway/db"
+	"payment-gateway/internal/models/common"
+	"testing"
+	"time"
+)
+
+func TestExplainRoute_LeastCost(t *testing.T) {
+	mockDB := &db.MockDB{
+		GetSupportedGatewaysByCountryFunc: func(ctx context.Context, countryID int) ([]*common.Gateway, error) {
+			return []*common.Gateway{
+				{ID: 1, Name: "expensive", Priority: 1, Weight: 100},
+				{ID: 2, Name: "cheap", Priority: 2, Weight: 100},
+				{ID: 3, Name: "unpriced", Priority: 3, Weight: 100},
+			}, nil
+		},
+		GetRoutingRulesFunc: func(ctx context.Context) ([]*common.RoutingRule, error) {
+			return nil, nil
+		},
+		GetGatewayFeesFunc: func(ctx context.Context, gatewayIDs []int) ([]*common.FeeSchedule, error) {
+			return []*common.FeeSchedule{
+				{GatewayID: 1, FixedFee: 30, PercentageFee: "2.9"},
+				{GatewayID: 2, FixedFee: 500, PercentageFee: "0"},
+				// the EUR withdrawal schedule is more specific than the catch-all one above
+				{GatewayID: 2, Currency: "EUR", TransactionType: "withdrawal", FixedFee: 100, PercentageFee: "0.5"},
+			}, nil
+		},
+	}
+
+	svc := NewSvcGateway(mockDB, time.Second).(*SvcGateway)
+	svc.strategy = StrategyLeastCost
+
+	explanation, err := svc.ExplainRoute(context.Background(), common.RouteRequest{CountryID: 1, Currency: "EUR", Amount: 10000, TransactionType: "withdrawal"})
+	if err != nil {
+		t.Fatalf("expected success, got error: %v", err)
+	}
+
+	c := explanation.Candidates
+	if len(c) != 3 || c[0].ID != 2 || c[1].ID != 1 || c[2].ID != 3 {
+		t.Fatalf("expected candidates ordered [2 1 3], got %v", c)
+	}
+	if *c[0].ExpectedFee != 150 || *c[1].ExpectedFee != 320 || c[2].ExpectedFee != nil {
+		t.Errorf("unexpected fees: %v, %v, %v", c[0].ExpectedFee, c[1].ExpectedFee, c[2].ExpectedFee)
+	}
+
+	// priority strategy keeps the routing order but still prices the candidates
+	svc.strategy = StrategyPriority
+	explanation, _ = svc.ExplainRoute(context.Background(), common.RouteRequest{CountryID: 1, Currency: "USD", Amount: 10000, TransactionType: "deposit"})
+	if c = explanation.Candidates; c[0].ID != 1 || *c[1].ExpectedFee != 500 {
+		t.Errorf("expected priority order with catch-all fee, got %v", c)
+	}
+}
@@ -1,33 +1,97 @@
 package gateway
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"log"
+	"net/http"
+	"os"
 	"payment-gateway/db"
 	"payment-gateway/internal/models/common"
 	"payment-gateway/internal/models/postgres"
+	"payment-gateway/internal/services/gateway/adapter"
+	"payment-gateway/internal/util"
+	"time"
 )
 
 type (
 	SvcGateway struct {
-		db db.Idb
+		db        db.Idb
+		adapters  *adapter.Registry
+		client    *http.Client
+		health    *HealthMonitor
+		breakers  *Breakers
+		strategy  string
+		callbacks CallbackConfig
+		// sendTimeout bounds a single request to a gateway, on top of the deadline of the caller's ctx
+		sendTimeout time.Duration
 	}
 
 	ISvcGateway interface {
-		SelectGateway(countryID int) (*common.Gateway, error)
-		SendTxToGateway(tx postgres.Transaction) (interface{}, error)
+		SelectGateway(ctx context.Context, route common.RouteRequest) (*common.Gateway, error)
+		SelectGateways(ctx context.Context, route common.RouteRequest) ([]*common.Gateway, error)
+		ExplainRoute(ctx context.Context, route common.RouteRequest) (*common.RouteExplanation, error)
+		RoutingRules(ctx context.Context) ([]*common.RoutingRule, error)
+		CreateRoutingRule(ctx context.Context, rule *common.RoutingRule) error
+		SendTxToGateway(ctx context.Context, tx postgres.Transaction) (*adapter.Response, error)
+		SendRefundToGateway(ctx context.Context, tx postgres.Transaction, refund postgres.Refund) (*adapter.Response, error)
+		SendVoidToGateway(ctx context.Context, tx postgres.Transaction) (*adapter.Response, error)
+		MonitorHealth(ctx context.Context)
+		GatewayHealth() []common.GatewayHealth
+		GatewayBreakers() []common.GatewayBreaker
+		VerifyCallback(ctx context.Context, gatewayID int, req CallbackRequest) error
+		ParseCallback(ctx context.Context, gatewayID int, body []byte) (*adapter.Callback, error)
 	}
 )
 
-func NewSvcGateway(db db.Idb) ISvcGateway {
-	return &SvcGateway{db: db}
+func NewSvcGateway(db db.Idb, sendTimeout time.Duration) ISvcGateway {
+	return &SvcGateway{
+		db:          db,
+		adapters:    adapter.NewRegistry(),
+		client:      &http.Client{},
+		health:      NewHealthMonitor(db, LoadHealthConfig()),
+		breakers:    NewBreakers(LoadBreakerConfig()),
+		strategy:    loadStrategy(os.Getenv("GATEWAY_SELECTION_STRATEGY")),
+		callbacks:   LoadCallbackConfig(),
+		sendTimeout: sendTimeout,
+	}
+}
+
+// SelectGateway chooses a payment gateway dynamically according to routing rules, priority, weight and country.
+func (g SvcGateway) SelectGateway(ctx context.Context, route common.RouteRequest) (*common.Gateway, error) {
+	gateways, err := g.SelectGateways(ctx, route)
+	if err != nil {
+		return nil, err
+	}
+
+	return gateways[0], nil
+}
+
+// SelectGateways returns the healthy gateways for a tx, the first one being the preferred gateway and the rest the
+// failover candidates. The first routing rule matching the tx decides the order, otherwise gateways of the country
+// are ordered by priority and gateways sharing a priority are split by their country weights, using the routing key
+// (e.g. the tx ID) to keep the order deterministic. Gateways whose circuit breaker is open are skipped.
+// With the least cost strategy the cheapest candidates come first.
+func (g SvcGateway) SelectGateways(ctx context.Context, route common.RouteRequest) ([]*common.Gateway, error) {
+	explanation, err := g.ExplainRoute(ctx, route)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(explanation.Candidates) == 0 {
+		return nil, errors.New("gateways are unhealthy/unavailable")
+	}
+
+	return explanation.Candidates, nil
 }
 
-// SelectGateway chooses a payment gateway dynamically according to priority and country.
-func (g SvcGateway) SelectGateway(countryID int) (*common.Gateway, error) {
-	gateways, err := g.db.GetSupportedGatewaysByCountry(countryID)
+// ExplainRoute runs gateway selection for the route request and reports which rule matched and why gateways were
+// left out, without sending anything.
+func (g SvcGateway) ExplainRoute(ctx context.Context, route common.RouteRequest) (*common.RouteExplanation, error) {
+	gateways, err := g.db.GetSupportedGatewaysByCountry(ctx, route.CountryID)
 	if err != nil {
-		fmt.Printf("failed to query gateways: %v\n", err)
+		log.Printf("failed to query gateways of country %d: %v", route.CountryID, err)
 
 		return nil, err
 	}
@@ -36,34 +100,180 @@ func (g SvcGateway) SelectGateway(countryID int) (*common.Gateway, error) {
 		return nil, errors.New("no gateways available for the specified country")
 	}
 
-	sortGatewaysASC(gateways)
+	rules, err := g.db.GetRoutingRules(ctx)
+	if err != nil {
+		return nil, err
+	}
 
-	for _, gateway := range gateways {
-		if isGatewayHealthy(gateway) {
-			return gateway, nil
+	explanation := &common.RouteExplanation{Strategy: g.strategy, Rules: []common.RuleEvaluation{}}
+	if needsUserSegment(rules) {
+		if explanation.UserSegment, err = g.db.GetUserSegment(ctx, route.UserID); err != nil {
+			return nil, err
+		}
+	}
+
+	ordered := gateways
+	for _, rule := range rules {
+		matched, reason := matchRule(rule, route, explanation.UserSegment)
+		if matched {
+			if candidates := ruleCandidates(rule, gateways); len(candidates) > 0 {
+				explanation.Rules = append(explanation.Rules, common.RuleEvaluation{RuleID: rule.ID, Name: rule.Name, Matched: true})
+				explanation.MatchedRule = rule
+				ordered = candidates
+				break
+			}
+			reason = "none of the rule's gateways support the country"
 		}
+		explanation.Rules = append(explanation.Rules, common.RuleEvaluation{RuleID: rule.ID, Name: rule.Name, Reason: reason})
 	}
 
-	return nil, errors.New("gateways are unhealthy/unavailable")
+	if explanation.MatchedRule == nil {
+		orderGateways(ordered, route.RoutingKey)
+	}
+
+	explanation.Candidates = []*common.Gateway{}
+	for _, gateway := range ordered {
+		switch {
+		case !supportsCurrency(gateway, route.Currency):
+			explanation.Excluded = append(explanation.Excluded, common.ExcludedGateway{GatewayID: gateway.ID, Reason: "currency not supported"})
+		case !g.health.IsHealthy(gateway.ID):
+			explanation.Excluded = append(explanation.Excluded, common.ExcludedGateway{GatewayID: gateway.ID, Reason: "unhealthy"})
+		case !g.breakers.Allow(gateway):
+			explanation.Excluded = append(explanation.Excluded, common.ExcludedGateway{GatewayID: gateway.ID, Reason: "circuit breaker open"})
+		default:
+			explanation.Candidates = append(explanation.Candidates, gateway)
+		}
+	}
+
+	if len(explanation.Candidates) == 0 {
+		return explanation, nil
+	}
+
+	ids := make([]int, 0, len(explanation.Candidates))
+	for _, gateway := range explanation.Candidates {
+		ids = append(ids, gateway.ID)
+	}
+
+	schedules, err := g.db.GetGatewayFees(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	priceGateways(explanation.Candidates, schedules, route)
+	if g.strategy == StrategyLeastCost {
+		sortByFee(explanation.Candidates)
+	}
+
+	return explanation, nil
 }
 
-func (g SvcGateway) SendTxToGateway(tx postgres.Transaction) (interface{}, error) {
-	// having gateway_id in tx struct, we will select the required gateway and send tx for processing.
-	return nil, nil
+// RoutingRules returns the enabled routing rules in evaluation order
+func (g SvcGateway) RoutingRules(ctx context.Context) ([]*common.RoutingRule, error) {
+	return g.db.GetRoutingRules(ctx)
 }
 
-// sortGatewaysASC sort gateways in ascending order by priority
-func sortGatewaysASC(gateways []*common.Gateway) {
-	for i := 0; i < len(gateways); i++ {
-		for j := i + 1; j < len(gateways); j++ {
-			if gateways[i].Priority > gateways[j].Priority {
-				gateways[i], gateways[j] = gateways[j], gateways[i]
-			}
-		}
+// CreateRoutingRule validates and stores a routing rule
+func (g SvcGateway) CreateRoutingRule(ctx context.Context, rule *common.RoutingRule) error {
+	if rule.Name == "" {
+		return errors.New("invalid rule, name is required")
+	}
+
+	if len(rule.GatewayIDs) == 0 {
+		return errors.New("invalid rule, at least one gateway is required")
+	}
+
+	if rule.MinAmount != nil && rule.MaxAmount != nil && *rule.MinAmount > *rule.MaxAmount {
+		return errors.New("invalid rule, min_amount is greater than max_amount")
 	}
+
+	return g.db.CreateRoutingRule(ctx, rule)
+}
+
+// SendTxToGateway sends the tx to the gateway referenced by tx.GatewayID through the gateway's adapter and circuit breaker.
+func (g SvcGateway) SendTxToGateway(ctx context.Context, tx postgres.Transaction) (*adapter.Response, error) {
+	gateway, err := g.db.GetGatewayByID(ctx, tx.GatewayID)
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := g.adapters.Resolve(gateway)
+	if err != nil {
+		return nil, err
+	}
+
+	return g.breakers.Execute(gateway, func() (*adapter.Response, error) {
+		ctx, cancel := util.WithTimeout(ctx, g.sendTimeout)
+		defer cancel()
+
+		return adapter.Send(ctx, g.client, a, gateway, tx)
+	})
+}
+
+// SendRefundToGateway sends the refund of the tx to the gateway that processed the tx, referenced by refund.GatewayID,
+// through the gateway's adapter and circuit breaker. The returned error wraps adapter.ErrNotSupported when the adapter
+// does not support refunds.
+func (g SvcGateway) SendRefundToGateway(ctx context.Context, tx postgres.Transaction, refund postgres.Refund) (*adapter.Response, error) {
+	gateway, err := g.db.GetGatewayByID(ctx, refund.GatewayID)
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := g.adapters.Resolve(gateway)
+	if err != nil {
+		return nil, err
+	}
+
+	// an unsupported refund is not a failure of the gateway, it does not go through the breaker
+	if _, ok := a.(adapter.IRefundAdapter); !ok {
+		return nil, fmt.Errorf("refunds are %w %s", adapter.ErrNotSupported, gateway.Name)
+	}
+
+	return g.breakers.Execute(gateway, func() (*adapter.Response, error) {
+		ctx, cancel := util.WithTimeout(ctx, g.sendTimeout)
+		defer cancel()
+
+		return adapter.SendRefund(ctx, g.client, a, gateway, tx, refund)
+	})
+}
+
+// SendVoidToGateway voids the tx at the gateway that accepted it, referenced by tx.GatewayID, through the gateway's
+// adapter and circuit breaker. The returned error wraps adapter.ErrNotSupported when the adapter does not support
+// voids.
+func (g SvcGateway) SendVoidToGateway(ctx context.Context, tx postgres.Transaction) (*adapter.Response, error) {
+	gateway, err := g.db.GetGatewayByID(ctx, tx.GatewayID)
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := g.adapters.Resolve(gateway)
+	if err != nil {
+		return nil, err
+	}
+
+	// an unsupported void is not a failure of the gateway, it does not go through the breaker
+	if _, ok := a.(adapter.IVoidAdapter); !ok {
+		return nil, fmt.Errorf("voids are %w %s", adapter.ErrNotSupported, gateway.Name)
+	}
+
+	return g.breakers.Execute(gateway, func() (*adapter.Response, error) {
+		ctx, cancel := util.WithTimeout(ctx, g.sendTimeout)
+		defer cancel()
+
+		return adapter.SendVoid(ctx, g.client, a, gateway, tx)
+	})
+}
+
+// MonitorHealth runs the active health checks until ctx is cancelled
+func (g SvcGateway) MonitorHealth(ctx context.Context) {
+	g.health.Run(ctx)
+}
+
+// GatewayBreakers returns the circuit breaker state of the gateways that have seen traffic
+func (g SvcGateway) GatewayBreakers() []common.GatewayBreaker {
+	return g.breakers.States()
 }
 
-func isGatewayHealthy(gateway *common.Gateway) bool {
-	// check gateway health
-	return true
+// GatewayHealth returns the cached health status of the probed gateways
+func (g SvcGateway) GatewayHealth() []common.GatewayHealth {
+	return g.health.Statuses()
 }
@@ -0,0 +1,46 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"payment-gateway/db"
+	"payment-gateway/internal/models/common"
+	"payment-gateway/internal/models/postgres"
+	"testing"
+	"time"
+)
+
+func TestSendTxToGateway_Deadlines(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	mockDB := &db.MockDB{
+		GetGatewayByIDFunc: func(ctx context.Context, gatewayID int) (*common.Gateway, error) {
+			return &common.Gateway{ID: gatewayID, Name: "slow", DataFormatSupported: "application/json", EndpointURL: srv.URL}, nil
+		},
+	}
+	tx := postgres.Transaction{ID: 1, GatewayID: 1, Amount: 1000, Currency: "USD"}
+
+	// the send timeout bounds the request
+	svc := NewSvcGateway(mockDB, 50*time.Millisecond)
+	if _, err := svc.SendTxToGateway(context.Background(), tx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the send timeout to expire, got %v", err)
+	}
+
+	// cancelling the caller's ctx cancels the request in flight
+	svc = NewSvcGateway(mockDB, time.Minute)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if _, err := svc.SendTxToGateway(ctx, tx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the send to be cancelled with the caller's ctx, got %v", err)
+	}
+}
@@ -0,0 +1,202 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"payment-gateway/db"
+	"payment-gateway/internal/models/common"
+	"payment-gateway/internal/util"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	HealthStatusUnknown   = "unknown"
+	HealthStatusHealthy   = "healthy"
+	HealthStatusUnhealthy = "unhealthy"
+
+	// maxHealthTransitions is the number of status transitions kept per gateway
+	maxHealthTransitions = 20
+)
+
+type (
+	// HealthConfig controls how often gateways are probed and when their status flips
+	HealthConfig struct {
+		Interval           time.Duration
+		Timeout            time.Duration
+		HealthyThreshold   int
+		UnhealthyThreshold int
+	}
+
+	// HealthMonitor periodically probes the health URL of every gateway and caches the result,
+	// so gateway selection never blocks on a probe.
+	HealthMonitor struct {
+		db     db.Idb
+		client *http.Client
+		cfg    HealthConfig
+
+		mu     sync.RWMutex
+		states map[int]*common.GatewayHealth
+	}
+)
+
+// LoadHealthConfig reads the health check settings from the environment
+func LoadHealthConfig() HealthConfig {
+	return HealthConfig{
+		Interval:           util.GetEnvDuration("GATEWAY_HEALTH_INTERVAL", 15*time.Second),
+		Timeout:            util.GetEnvDuration("GATEWAY_HEALTH_TIMEOUT", 3*time.Second),
+		HealthyThreshold:   util.GetEnvInt("GATEWAY_HEALTHY_THRESHOLD", 2),
+		UnhealthyThreshold: util.GetEnvInt("GATEWAY_UNHEALTHY_THRESHOLD", 3),
+	}
+}
+
+func NewHealthMonitor(db db.Idb, cfg HealthConfig) *HealthMonitor {
+	return &HealthMonitor{
+		db:     db,
+		client: &http.Client{Timeout: cfg.Timeout},
+		cfg:    cfg,
+		states: make(map[int]*common.GatewayHealth),
+	}
+}
+
+// Run probes all gateways every interval until ctx is cancelled
+func (h *HealthMonitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(h.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		h.CheckAll(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CheckAll probes every gateway having a health URL once
+func (h *HealthMonitor) CheckAll(ctx context.Context) {
+	gateways, err := h.db.GetGateways(ctx)
+	if err != nil {
+		log.Printf("health monitor failed to load gateways: %v", err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, gateway := range gateways {
+		if gateway.HealthCheckURL == "" {
+			continue
+		}
+
+		wg.Add(1)
+		go func(gateway *common.Gateway) {
+			defer wg.Done()
+			h.record(gateway, h.probe(ctx, gateway))
+		}(gateway)
+	}
+	wg.Wait()
+}
+
+// IsHealthy reports the cached health of a gateway.
+// Gateways without a health URL, or not probed yet, are treated as healthy.
+func (h *HealthMonitor) IsHealthy(gatewayID int) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	state, ok := h.states[gatewayID]
+	if !ok {
+		return true
+	}
+
+	return state.Status != HealthStatusUnhealthy
+}
+
+// Statuses returns a snapshot of the cached health of all probed gateways
+func (h *HealthMonitor) Statuses() []common.GatewayHealth {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	statuses := make([]common.GatewayHealth, 0, len(h.states))
+	for _, state := range h.states {
+		s := *state
+		s.Transitions = append([]common.GatewayHealthTransition(nil), state.Transitions...)
+		statuses = append(statuses, s)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].GatewayID < statuses[j].GatewayID
+	})
+
+	return statuses
+}
+
+func (h *HealthMonitor) probe(ctx context.Context, gateway *common.Gateway) error {
+	ctx, cancel := context.WithTimeout(ctx, h.cfg.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, gateway.HealthCheckURL, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("health check returned status %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+// record applies a probe result to the gateway's counters and flips its status once a threshold is reached
+func (h *HealthMonitor) record(gateway *common.Gateway, probeErr error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	state, ok := h.states[gateway.ID]
+	if !ok {
+		state = &common.GatewayHealth{GatewayID: gateway.ID, Status: HealthStatusUnknown}
+		h.states[gateway.ID] = state
+	}
+	state.GatewayName = gateway.Name
+	state.LastCheckedAt = time.Now()
+
+	next := state.Status
+	if probeErr == nil {
+		state.ConsecutiveSuccesses++
+		state.ConsecutiveFailures = 0
+		state.LastError = ""
+		if state.ConsecutiveSuccesses >= h.cfg.HealthyThreshold {
+			next = HealthStatusHealthy
+		}
+	} else {
+		state.ConsecutiveFailures++
+		state.ConsecutiveSuccesses = 0
+		state.LastError = probeErr.Error()
+		if state.ConsecutiveFailures >= h.cfg.UnhealthyThreshold {
+			next = HealthStatusUnhealthy
+		}
+	}
+
+	if next == state.Status {
+		return
+	}
+
+	log.Printf("gateway %s (id %d) health changed from %s to %s", gateway.Name, gateway.ID, state.Status, next)
+
+	state.Transitions = append(state.Transitions, common.GatewayHealthTransition{From: state.Status, To: next, At: state.LastCheckedAt})
+	if len(state.Transitions) > maxHealthTransitions {
+		state.Transitions = state.Transitions[len(state.Transitions)-maxHealthTransitions:]
+	}
+	state.Status = next
+}
@@ -0,0 +1,86 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"payment-gateway/db"
+	"payment-gateway/internal/models/common"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealthMonitor_Thresholds(t *testing.T) {
+	var up atomic.Bool
+	up.Store(true)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	mockDB := &db.MockDB{
+		GetGatewaysFunc: func(ctx context.Context) ([]*common.Gateway, error) {
+			return []*common.Gateway{{ID: 1, Name: "probed", HealthCheckURL: srv.URL}}, nil
+		},
+	}
+	h := NewHealthMonitor(mockDB, HealthConfig{Interval: time.Second, Timeout: time.Second, HealthyThreshold: 2, UnhealthyThreshold: 2})
+	ctx := context.Background()
+
+	// never probed gateways are considered healthy
+	if !h.IsHealthy(1) {
+		t.Fatalf("expected unprobed gateway to be healthy")
+	}
+
+	h.CheckAll(ctx)
+	h.CheckAll(ctx)
+	if s := h.Statuses(); len(s) != 1 || s[0].Status != HealthStatusHealthy {
+		t.Fatalf("expected gateway to be healthy, got %+v", s)
+	}
+
+	up.Store(false)
+	h.CheckAll(ctx)
+	if !h.IsHealthy(1) {
+		t.Errorf("expected a single failure to stay below the unhealthy threshold")
+	}
+
+	h.CheckAll(ctx)
+	if h.IsHealthy(1) {
+		t.Errorf("expected gateway to be unhealthy after reaching the threshold")
+	}
+
+	s := h.Statuses()[0]
+	if len(s.Transitions) != 2 || s.Transitions[1].To != HealthStatusUnhealthy {
+		t.Errorf("unexpected transitions: %+v", s.Transitions)
+	}
+}
+
+func TestSelectGateway_SkipsUnhealthy(t *testing.T) {
+	mockDB := &db.MockDB{
+		GetSupportedGatewaysByCountryFunc: func(ctx context.Context, countryID int) ([]*common.Gateway, error) {
+			return []*common.Gateway{
+				{ID: 1, Name: "primary", Priority: 1},
+				{ID: 2, Name: "secondary", Priority: 2},
+			}, nil
+		},
+		GetRoutingRulesFunc: func(ctx context.Context) ([]*common.RoutingRule, error) {
+			return nil, nil
+		},
+		GetGatewayFeesFunc: func(ctx context.Context, gatewayIDs []int) ([]*common.FeeSchedule, error) {
+			return nil, nil
+		},
+	}
+
+	svc := NewSvcGateway(mockDB, time.Second).(*SvcGateway)
+	svc.health.states[1] = &common.GatewayHealth{GatewayID: 1, Status: HealthStatusUnhealthy}
+
+	gateway, err := svc.SelectGateway(context.Background(), common.RouteRequest{CountryID: 840, RoutingKey: "1"})
+	if err != nil {
+		t.Fatalf("expected success, got error: %v", err)
+	}
+	if gateway.ID != 2 {
+		t.Errorf("expected secondary gateway, got %d", gateway.ID)
+	}
+}
@@ -1,20 +1,77 @@
 package gateway
 
 import (
+	"context"
 	"payment-gateway/internal/models/common"
 	"payment-gateway/internal/models/postgres"
+	"payment-gateway/internal/services/gateway/adapter"
 )
 
 // MockGatewayProcessor implements the GatewayProcessor interface for testing.
 type MockGatewayProcessor struct {
-	SelectGatewayFunc   func(countryID int) (*common.Gateway, error)
-	SendTxToGatewayFunc func(tx postgres.Transaction) (interface{}, error)
+	SelectGatewayFunc       func(ctx context.Context, route common.RouteRequest) (*common.Gateway, error)
+	SelectGatewaysFunc      func(ctx context.Context, route common.RouteRequest) ([]*common.Gateway, error)
+	ExplainRouteFunc        func(ctx context.Context, route common.RouteRequest) (*common.RouteExplanation, error)
+	RoutingRulesFunc        func(ctx context.Context) ([]*common.RoutingRule, error)
+	CreateRoutingRuleFunc   func(ctx context.Context, rule *common.RoutingRule) error
+	SendTxToGatewayFunc     func(ctx context.Context, tx postgres.Transaction) (*adapter.Response, error)
+	SendRefundToGatewayFunc func(ctx context.Context, tx postgres.Transaction, refund postgres.Refund) (*adapter.Response, error)
+	SendVoidToGatewayFunc   func(ctx context.Context, tx postgres.Transaction) (*adapter.Response, error)
+	MonitorHealthFunc       func(ctx context.Context)
+	GatewayHealthFunc       func() []common.GatewayHealth
+	GatewayBreakersFunc     func() []common.GatewayBreaker
+	VerifyCallbackFunc      func(ctx context.Context, gatewayID int, req CallbackRequest) error
+	ParseCallbackFunc       func(ctx context.Context, gatewayID int, body []byte) (*adapter.Callback, error)
 }
 
-func (m *MockGatewayProcessor) SelectGateway(countryID int) (*common.Gateway, error) {
-	return m.SelectGatewayFunc(countryID)
+func (m *MockGatewayProcessor) SelectGateway(ctx context.Context, route common.RouteRequest) (*common.Gateway, error) {
+	return m.SelectGatewayFunc(ctx, route)
 }
 
-func (m *MockGatewayProcessor) SendTxToGateway(tx postgres.Transaction) (interface{}, error) {
-	return m.SendTxToGatewayFunc(tx)
+func (m *MockGatewayProcessor) SelectGateways(ctx context.Context, route common.RouteRequest) ([]*common.Gateway, error) {
+	return m.SelectGatewaysFunc(ctx, route)
+}
+
+func (m *MockGatewayProcessor) ExplainRoute(ctx context.Context, route common.RouteRequest) (*common.RouteExplanation, error) {
+	return m.ExplainRouteFunc(ctx, route)
+}
+
+func (m *MockGatewayProcessor) RoutingRules(ctx context.Context) ([]*common.RoutingRule, error) {
+	return m.RoutingRulesFunc(ctx)
+}
+
+func (m *MockGatewayProcessor) CreateRoutingRule(ctx context.Context, rule *common.RoutingRule) error {
+	return m.CreateRoutingRuleFunc(ctx, rule)
+}
+
+func (m *MockGatewayProcessor) SendTxToGateway(ctx context.Context, tx postgres.Transaction) (*adapter.Response, error) {
+	return m.SendTxToGatewayFunc(ctx, tx)
+}
+
+func (m *MockGatewayProcessor) SendRefundToGateway(ctx context.Context, tx postgres.Transaction, refund postgres.Refund) (*adapter.Response, error) {
+	return m.SendRefundToGatewayFunc(ctx, tx, refund)
+}
+
+func (m *MockGatewayProcessor) SendVoidToGateway(ctx context.Context, tx postgres.Transaction) (*adapter.Response, error) {
+	return m.SendVoidToGatewayFunc(ctx, tx)
+}
+
+func (m *MockGatewayProcessor) MonitorHealth(ctx context.Context) {
+	m.MonitorHealthFunc(ctx)
+}
+
+func (m *MockGatewayProcessor) GatewayHealth() []common.GatewayHealth {
+	return m.GatewayHealthFunc()
+}
+
+func (m *MockGatewayProcessor) GatewayBreakers() []common.GatewayBreaker {
+	return m.GatewayBreakersFunc()
+}
+
+func (m *MockGatewayProcessor) VerifyCallback(ctx context.Context, gatewayID int, req CallbackRequest) error {
+	return m.VerifyCallbackFunc(ctx, gatewayID, req)
+}
+
+func (m *MockGatewayProcessor) ParseCallback(ctx context.Context, gatewayID int, body []byte) (*adapter.Callback, error) {
+	return m.ParseCallbackFunc(ctx, gatewayID, body)
 }
@@ -0,0 +1,61 @@
+package gateway
+
+import (
+	"hash/fnv"
+	"math"
+	"payment-gateway/internal/models/common"
+	"sort"
+	"strconv"
+)
+
+// orderGateways sorts gateways in ascending order by priority. Gateways sharing a priority are ordered by a weighted
+// draw seeded with the routing key, so each gateway comes first for a share of the traffic proportional to its
+// weight while the same routing key always yields the same order. Gateways with a zero weight get no traffic of
+// their own and only serve as failover candidates.
+func orderGateways(gateways []*common.Gateway, routingKey string) {
+	scores := make(map[int]float64, len(gateways))
+	for _, gateway := range gateways {
+		scores[gateway.ID] = routingScore(routingKey, gateway)
+	}
+
+	sort.SliceStable(gateways, func(i, j int) bool {
+		a, b := gateways[i], gateways[j]
+		if a.Priority != b.Priority {
+			return a.Priority < b.Priority
+		}
+		if scores[a.ID] != scores[b.ID] {
+			return scores[a.ID] < scores[b.ID]
+		}
+
+		return a.ID < b.ID
+	})
+}
+
+// routingScore is the weighted rendezvous hash score of a gateway for the routing key, lower scores win.
+// For weights w1..wn the gateway i gets the lowest score for wi/(w1+...+wn) of the keys.
+func routingScore(routingKey string, gateway *common.Gateway) float64 {
+	if gateway.Weight <= 0 {
+		return math.Inf(1)
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(routingKey))
+	h.Write([]byte{':'})
+	h.Write([]byte(strconv.Itoa(gateway.ID)))
+
+	// map the hash to a uniform value in (0, 1]
+	u := float64(mix64(h.Sum64())>>11+1) / float64(uint64(1)<<53)
+
+	return -math.Log(u) / float64(gateway.Weight)
+}
+
+// mix64 is the splitmix64 finalizer, FNV alone leaves the high bits of keys differing in their last bytes correlated
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+
+	return x
+}
@@ -0,0 +1,101 @@
+package gateway
+
+import (
+	"context"
+	"math"
+	"payment-gateway/db"
+	"payment-gateway/internal/models/common"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestOrderGateways_WeightedSplit(t *testing.T) {
+	newGateways := func() []*common.Gateway {
+		return []*common.Gateway{
+			{ID: 3, Name: "fallback", Priority: 2, Weight: 100},
+			{ID: 1, Name: "incumbent", Priority: 1, Weight: 70},
+			{ID: 2, Name: "newcomer", Priority: 1, Weight: 30},
+			{ID: 4, Name: "disabled", Priority: 1, Weight: 0},
+		}
+	}
+
+	const total = 20000
+	first := make(map[int]int)
+	for i := 0; i < total; i++ {
+		gateways := newGateways()
+		orderGateways(gateways, strconv.Itoa(i))
+		first[gateways[0].ID]++
+
+		if gateways[2].ID != 4 || gateways[3].ID != 3 {
+			t.Fatalf("expected zero weight gateway last in its priority group, got %d, %d", gateways[2].ID, gateways[3].ID)
+		}
+	}
+
+	share := float64(first[1]) / total
+	if math.Abs(share-0.7) > 0.02 {
+		t.Errorf("expected ~70%% of traffic on gateway 1, got %.3f", share)
+	}
+	if first[1]+first[2] != total {
+		t.Errorf("expected only weighted gateways of the top priority to be preferred, got %v", first)
+	}
+
+	// the same routing key always yields the same order
+	a, b := newGateways(), newGateways()
+	orderGateways(a, "12345")
+	orderGateways(b, "12345")
+	for i := range a {
+		if a[i].ID != b[i].ID {
+			t.Fatalf("expected deterministic order for the same routing key")
+		}
+	}
+}
+
+func TestExplainRoute_Rules(t *testing.T) {
+	minAmount := int64(100000)
+	mockDB := &db.MockDB{
+		GetSupportedGatewaysByCountryFunc: func(ctx context.Context, countryID int) ([]*common.Gateway, error) {
+			return []*common.Gateway{
+				{ID: 1, Name: "primary", Priority: 1, Weight: 100},
+				{ID: 2, Name: "secondary", Priority: 2, Weight: 100},
+				{ID: 3, Name: "high-value", Priority: 3, Weight: 100},
+			}, nil
+		},
+		GetRoutingRulesFunc: func(ctx context.Context) ([]*common.RoutingRule, error) {
+			return []*common.RoutingRule{
+				{ID: 1, Name: "vip", Priority: 1, UserSegments: []string{"vip"}, GatewayIDs: []int{2}},
+				{ID: 2, Name: "big eur withdrawals", Priority: 2, Currencies: []string{"EUR", "GBP"}, MinAmount: &minAmount, TransactionType: "withdrawal", GatewayIDs: []int{3, 9, 1}},
+			}, nil
+		},
+		GetUserSegmentFunc: func(ctx context.Context, userID int) (string, error) {
+			return "retail", nil
+		},
+		GetGatewayFeesFunc: func(ctx context.Context, gatewayIDs []int) ([]*common.FeeSchedule, error) {
+			return nil, nil
+		},
+	}
+	svc := NewSvcGateway(mockDB, time.Second)
+
+	explanation, err := svc.ExplainRoute(context.Background(), common.RouteRequest{CountryID: 1, Currency: "eur", Amount: 500000, TransactionType: "withdrawal", UserID: 1})
+	if err != nil {
+		t.Fatalf("expected success, got error: %v", err)
+	}
+	if explanation.MatchedRule == nil || explanation.MatchedRule.ID != 2 {
+		t.Fatalf("expected rule 2 to match, got %+v", explanation.Rules)
+	}
+	if explanation.Rules[0].Matched || explanation.Rules[0].Reason == "" {
+		t.Errorf("expected rule 1 to be rejected with a reason, got %+v", explanation.Rules[0])
+	}
+	if len(explanation.Candidates) != 2 || explanation.Candidates[0].ID != 3 || explanation.Candidates[1].ID != 1 {
+		t.Errorf("expected candidates [3 1] from the rule, got %v", explanation.Candidates)
+	}
+
+	// no rule matches small deposits, country priority applies
+	gateways, err := svc.SelectGateways(context.Background(), common.RouteRequest{CountryID: 1, Currency: "EUR", Amount: 1000, TransactionType: "deposit", UserID: 1})
+	if err != nil {
+		t.Fatalf("expected success, got error: %v", err)
+	}
+	if len(gateways) != 3 || gateways[0].ID != 1 {
+		t.Errorf("expected default priority order, got %v", gateways)
+	}
+}
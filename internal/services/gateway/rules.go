@@ -0,0 +1,80 @@
+package gateway
+
+import (
+	"fmt"
+	"payment-gateway/internal/models/common"
+	"strings"
+)
+
+// matchRule tells whether a tx satisfies every condition of a routing rule, and which condition failed otherwise
+func matchRule(rule *common.RoutingRule, route common.RouteRequest, userSegment string) (bool, string) {
+	if rule.CountryID != 0 && rule.CountryID != route.CountryID {
+		return false, fmt.Sprintf("country %d is not %d", route.CountryID, rule.CountryID)
+	}
+
+	if len(rule.Currencies) > 0 && !containsFold(rule.Currencies, route.Currency) {
+		return false, fmt.Sprintf("currency %q not in %v", route.Currency, rule.Currencies)
+	}
+
+	if rule.MinAmount != nil && route.Amount < *rule.MinAmount {
+		return false, fmt.Sprintf("amount %d below %d", route.Amount, *rule.MinAmount)
+	}
+
+	if rule.MaxAmount != nil && route.Amount > *rule.MaxAmount {
+		return false, fmt.Sprintf("amount %d above %d", route.Amount, *rule.MaxAmount)
+	}
+
+	if rule.TransactionType != "" && rule.TransactionType != route.TransactionType {
+		return false, fmt.Sprintf("type %q is not %q", route.TransactionType, rule.TransactionType)
+	}
+
+	if len(rule.UserSegments) > 0 && !containsFold(rule.UserSegments, userSegment) {
+		return false, fmt.Sprintf("user segment %q not in %v", userSegment, rule.UserSegments)
+	}
+
+	return true, ""
+}
+
+// ruleCandidates returns the gateways of the rule, in the rule's order, that support the country
+func ruleCandidates(rule *common.RoutingRule, gateways []*common.Gateway) []*common.Gateway {
+	byID := make(map[int]*common.Gateway, len(gateways))
+	for _, gateway := range gateways {
+		byID[gateway.ID] = gateway
+	}
+
+	var candidates []*common.Gateway
+	for _, id := range rule.GatewayIDs {
+		if gateway, ok := byID[id]; ok {
+			candidates = append(candidates, gateway)
+			delete(byID, id)
+		}
+	}
+
+	return candidates
+}
+
+// supportsCurrency tells whether the gateway accepts the currency, gateways without supported currencies accept any
+func supportsCurrency(gateway *common.Gateway, currency string) bool {
+	return len(gateway.SupportedCurrencies) == 0 || containsFold(gateway.SupportedCurrencies, currency)
+}
+
+// needsUserSegment tells whether any rule conditions on the user segment, to avoid looking it up otherwise
+func needsUserSegment(rules []*common.RoutingRule) bool {
+	for _, rule := range rules {
+		if len(rule.UserSegments) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsFold(values []string, v string) bool {
+	for _, value := range values {
+		if strings.EqualFold(value, v) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,198 @@
+package inbox
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"payment-gateway/db"
+	"payment-gateway/internal/models/common"
+	"payment-gateway/internal/models/postgres"
+	"payment-gateway/internal/services/dispute"
+	svcGateway "payment-gateway/internal/services/gateway"
+	"payment-gateway/internal/services/gateway/adapter"
+	"payment-gateway/internal/services/tx"
+	"payment-gateway/internal/util"
+	"time"
+)
+
+const (
+	// maxEventIDLength is the size of the event_id column, longer provider event IDs are hashed
+	maxEventIDLength = 255
+
+	defaultListLimit = 50
+	maxListLimit     = 200
+)
+
+type (
+	// InboxConfig controls how long a callback being applied is protected from duplicates and reprocessing
+	InboxConfig struct {
+		// LockTimeout is how long a callback stays received before a duplicate or a reprocess may take it over
+		LockTimeout time.Duration
+	}
+
+	SvcInbox struct {
+		db      db.Idb
+		gateway svcGateway.ISvcGateway
+		tx      tx.ISvcTx
+		dispute dispute.ISvcDispute
+		cfg     InboxConfig
+	}
+
+	ISvcInbox interface {
+		ReceiveCallback(ctx context.Context, gatewayID int, eventID string, req svcGateway.CallbackRequest) (*postgres.InboxCallback, bool, error)
+		ReprocessCallback(ctx context.Context, id int64) (*postgres.InboxCallback, error)
+		InboxCallbacks(ctx context.Context, filter common.CallbackFilter) ([]*postgres.InboxCallback, error)
+	}
+)
+
+// LoadInboxConfig reads the callback inbox settings from the environment
+func LoadInboxConfig() InboxConfig {
+	return InboxConfig{
+		LockTimeout: util.GetEnvDuration("CALLBACK_INBOX_LOCK_TIMEOUT", time.Minute),
+	}
+}
+
+func NewSvcInbox(db db.Idb, gateway svcGateway.ISvcGateway, tx tx.ISvcTx, dispute dispute.ISvcDispute, cfg InboxConfig) ISvcInbox {
+	return &SvcInbox{db: db, gateway: gateway, tx: tx, dispute: dispute, cfg: cfg}
+}
+
+// ReceiveCallback authenticates a gateway callback, stores it in the inbox and applies it to its tx. Callbacks failing
+// authentication are not stored. The provider's event ID is taken from the body, then from eventID (e.g. a header),
+// and defaults to the hash of the body. A callback already received is not applied again and is returned as a
+// duplicate, unless its previous try failed. The stored callback is returned along with the error of applying it.
+func (s SvcInbox) ReceiveCallback(ctx context.Context, gatewayID int, eventID string, req svcGateway.CallbackRequest) (*postgres.InboxCallback, bool, error) {
+	if err := s.gateway.VerifyCallback(ctx, gatewayID, req); err != nil {
+		return nil, false, err
+	}
+
+	parsed, parseErr := s.gateway.ParseCallback(ctx, gatewayID, req.Body)
+	if parsed != nil && parsed.EventID != "" {
+		eventID = parsed.EventID
+	}
+
+	cb := &postgres.InboxCallback{GatewayID: gatewayID, EventID: inboxEventID(eventID, req.Body), Payload: req.Body}
+	setParsed(cb, parsed)
+
+	claimed, err := s.db.ClaimInboxCallback(ctx, cb, s.cfg.LockTimeout)
+	if err != nil {
+		return nil, false, err
+	}
+	if !claimed {
+		return cb, true, nil
+	}
+	// a failed callback taken over is filled with what was stored, it is applied as received this time
+	setParsed(cb, parsed)
+
+	err = s.apply(ctx, cb, parsed, parseErr)
+	if recordErr := s.record(cb); recordErr != nil && err == nil {
+		return nil, false, recordErr
+	}
+
+	return cb, false, err
+}
+
+// ReprocessCallback parses and applies a stored callback again, e.g. once the cause of its rejection was fixed. The
+// outcome is recorded on the returned callback, only errors reading or storing it are returned.
+func (s SvcInbox) ReprocessCallback(ctx context.Context, id int64) (*postgres.InboxCallback, error) {
+	cb, err := s.db.ReclaimInboxCallback(ctx, id, s.cfg.LockTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, parseErr := s.gateway.ParseCallback(ctx, cb.GatewayID, cb.Payload)
+	setParsed(cb, parsed)
+
+	s.apply(ctx, cb, parsed, parseErr)
+	if err = s.record(cb); err != nil {
+		return nil, err
+	}
+
+	return cb, nil
+}
+
+// InboxCallbacks lists the stored callbacks matching the filter, newest first, 50 by default and 200 at most
+func (s SvcInbox) InboxCallbacks(ctx context.Context, filter common.CallbackFilter) ([]*postgres.InboxCallback, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = defaultListLimit
+	}
+	if filter.Limit > maxListLimit {
+		filter.Limit = maxListLimit
+	}
+
+	return s.db.ListInboxCallbacks(ctx, filter)
+}
+
+// apply applies a claimed callback to its tx, or to the dispute of the tx it reports, unless it could not be parsed,
+// and sets its result
+func (s SvcInbox) apply(ctx context.Context, cb *postgres.InboxCallback, parsed *adapter.Callback, err error) error {
+	switch {
+	case err != nil:
+	case parsed.Dispute != nil:
+		_, err = s.dispute.ProcessDisputeCallback(ctx, cb.GatewayID, parsed)
+	default:
+		err = s.tx.ProcessGatewayCallback(ctx, cb.GatewayID, parsed)
+	}
+
+	cb.Result, cb.Error = result(err), ""
+	if err != nil {
+		cb.Error = err.Error()
+	}
+
+	return err
+}
+
+// record stores the result of a callback. It is stored even when the request was cancelled, so the callback does not
+// stay received until its lock times out.
+func (s SvcInbox) record(cb *postgres.InboxCallback) error {
+	if err := s.db.CompleteInboxCallback(context.Background(), cb); err != nil {
+		log.Printf("failed to record result of callback %d: %v", cb.ID, err)
+
+		return err
+	}
+
+	return nil
+}
+
+// result classifies the error of applying a callback, errors that would happen again are rejections
+func result(err error) string {
+	var validationErr *tx.ValidationError
+	var transitionErr *tx.IllegalTransitionError
+	switch {
+	case err == nil:
+		return postgres.CallbackProcessed
+	case errors.Is(err, svcGateway.ErrInvalidCallback), errors.Is(err, db.ErrNotFound),
+		errors.As(err, &validationErr), errors.As(err, &transitionErr),
+		errors.Is(err, dispute.ErrInvalidDispute), errors.Is(err, dispute.ErrIllegalTransition):
+		return postgres.CallbackRejected
+	default:
+		return postgres.CallbackFailed
+	}
+}
+
+// setParsed copies what was parsed from a callback to its inbox entry
+func setParsed(cb *postgres.InboxCallback, parsed *adapter.Callback) {
+	if parsed == nil {
+		return
+	}
+
+	txID := parsed.TransactionID
+	cb.TransactionID, cb.ProviderStatus, cb.Status = &txID, parsed.ProviderStatus, parsed.Status
+}
+
+// inboxEventID returns the event ID a callback is deduplicated with, the hash of the body when the provider sent
+// none
+func inboxEventID(eventID string, body []byte) string {
+	if eventID == "" {
+		sum := sha256.Sum256(body)
+		return "sha256:" + hex.EncodeToString(sum[:])
+	}
+
+	if len(eventID) > maxEventIDLength {
+		sum := sha256.Sum256([]byte(eventID))
+		return "sha256:" + hex.EncodeToString(sum[:])
+	}
+
+	return eventID
+}
@@ -0,0 +1,207 @@
+package inbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"payment-gateway/db"
+	"payment-gateway/internal/models/postgres"
+	"payment-gateway/internal/services/dispute"
+	svcGateway "payment-gateway/internal/services/gateway"
+	"payment-gateway/internal/services/gateway/adapter"
+	"payment-gateway/internal/services/tx"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newInboxMockDB serves the txs of gateway 1 from statuses, updating the status of tx 13 fails
+func newInboxMockDB(statuses map[int64]string) *db.MockDB {
+	return &db.MockDB{
+		GetTransactionFunc: func(ctx context.Context, id int64) (*postgres.Transaction, error) {
+			status, ok := statuses[id]
+			if !ok {
+				return nil, fmt.Errorf("transaction %d %w", id, db.ErrNotFound)
+			}
+			return &postgres.Transaction{ID: id, Type: "deposit", Amount: 1000, Currency: "EUR", GatewayID: 1, Status: status}, nil
+		},
+		UpdateTxStatusFunc: func(ctx context.Context, change *postgres.TransactionStatusChange, allowedFrom []string, event *postgres.OutboxEvent, entry *postgres.JournalEntry) error {
+			if change.TransactionID == 13 {
+				return errors.New("connection reset")
+			}
+			change.FromStatus = statuses[change.TransactionID]
+			for _, s := range allowedFrom {
+				if s == change.FromStatus {
+					statuses[change.TransactionID] = change.ToStatus
+					return nil
+				}
+			}
+			return fmt.Errorf("transaction %d is %s: %w", change.TransactionID, change.FromStatus, db.ErrStatusConflict)
+		},
+	}
+}
+
+// newInboxGateway accepts callbacks signed "ok" whose body is "<event id>:<tx id>:<status>", the event ID being
+// optional
+func newInboxGateway() *svcGateway.MockGatewayProcessor {
+	return &svcGateway.MockGatewayProcessor{
+		VerifyCallbackFunc: func(ctx context.Context, gatewayID int, req svcGateway.CallbackRequest) error {
+			if req.Signature != "ok" {
+				return svcGateway.ErrCallbackUnauthorized
+			}
+			return nil
+		},
+		ParseCallbackFunc: func(ctx context.Context, gatewayID int, body []byte) (*adapter.Callback, error) {
+			parts := strings.Split(string(body), ":")
+			if len(parts) != 3 {
+				return nil, fmt.Errorf("%w: malformed body", svcGateway.ErrInvalidCallback)
+			}
+			var txID int64
+			fmt.Sscan(parts[1], &txID)
+			return &adapter.Callback{EventID: parts[0], TransactionID: txID, ProviderStatus: parts[2], Status: parts[2]}, nil
+		},
+	}
+}
+
+func TestReceiveCallback(t *testing.T) {
+	statuses := map[int64]string{42: tx.StatusProcessing, 43: tx.StatusProcessing, 13: tx.StatusProcessing}
+	var claimed, completed []postgres.InboxCallback
+	mockDB := newInboxMockDB(statuses)
+	mockDB.ClaimInboxCallbackFunc = func(ctx context.Context, cb *postgres.InboxCallback, lockTimeout time.Duration) (bool, error) {
+		claimed = append(claimed, *cb)
+		cb.ID = int64(len(claimed))
+		return true, nil
+	}
+	mockDB.CompleteInboxCallbackFunc = func(ctx context.Context, cb *postgres.InboxCallback) error {
+		completed = append(completed, *cb)
+		return nil
+	}
+	svc := NewSvcInbox(mockDB, newInboxGateway(), tx.NewSvcTx(mockDB, tx.PoolConfig{}), dispute.NewSvcDispute(mockDB, dispute.DisputeConfig{}), InboxConfig{LockTimeout: time.Minute})
+	receive := func(eventID, body, signature string) (*postgres.InboxCallback, error) {
+		cb, _, err := svc.ReceiveCallback(context.Background(), 1, eventID, svcGateway.CallbackRequest{Body: []byte(body), Signature: signature})
+		return cb, err
+	}
+
+	// unauthenticated callbacks are not stored
+	if _, err := receive("", "evt-1:42:completed", "forged"); !errors.Is(err, svcGateway.ErrCallbackUnauthorized) || len(claimed) != 0 {
+		t.Fatalf("expected an unauthorized callback not to be stored, got %v", err)
+	}
+
+	cb, err := receive("header-id", "evt-1:42:completed", "ok")
+	if err != nil || cb.EventID != "evt-1" || cb.Result != postgres.CallbackProcessed || *cb.TransactionID != 42 || statuses[42] != tx.StatusCompleted {
+		t.Fatalf("expected the callback to be processed with the event ID of the body, got %+v, %v", cb, err)
+	}
+
+	// the event ID of the header is used when the body has none, the hash of the body otherwise
+	if cb, _ = receive("header-id", ":43:completed", "ok"); cb.EventID != "header-id" {
+		t.Errorf("expected the event ID of the header, got %q", cb.EventID)
+	}
+	cb, err = receive("", "garbage", "ok")
+	if !errors.Is(err, svcGateway.ErrInvalidCallback) || !strings.HasPrefix(cb.EventID, "sha256:") || cb.Result != postgres.CallbackRejected || cb.TransactionID != nil {
+		t.Errorf("expected an unparseable callback to be stored as rejected, got %+v, %v", cb, err)
+	}
+
+	cb, err = receive("", "evt-4:42:pending", "ok")
+	var transitionErr *tx.IllegalTransitionError
+	if !errors.As(err, &transitionErr) || cb.Result != postgres.CallbackRejected {
+		t.Errorf("expected an illegal transition to be rejected, got %+v, %v", cb, err)
+	}
+
+	if cb, err = receive("", "evt-5:13:completed", "ok"); err == nil || cb.Result != postgres.CallbackFailed || cb.Error == "" {
+		t.Errorf("expected a failed callback, got %+v, %v", cb, err)
+	}
+
+	if len(completed) != 5 {
+		t.Errorf("expected the results of 5 callbacks to be recorded, got %d", len(completed))
+	}
+}
+
+func TestReceiveCallback_Dispute(t *testing.T) {
+	statuses := map[int64]string{42: tx.StatusCompleted, 43: tx.StatusProcessing}
+	var disputes []*postgres.Dispute
+	mockDB := newInboxMockDB(statuses)
+	mockDB.ClaimInboxCallbackFunc = func(ctx context.Context, cb *postgres.InboxCallback, lockTimeout time.Duration) (bool, error) {
+		return true, nil
+	}
+	mockDB.CompleteInboxCallbackFunc = func(ctx context.Context, cb *postgres.InboxCallback) error {
+		return nil
+	}
+	mockDB.CreateDisputeFunc = func(ctx context.Context, d *postgres.Dispute, event func(*postgres.Dispute) (*postgres.OutboxEvent, error)) (bool, error) {
+		d.ID = int64(len(disputes) + 1)
+		disputes = append(disputes, d)
+		_, err := event(d)
+		return true, err
+	}
+
+	gateway := newInboxGateway()
+	parse := gateway.ParseCallbackFunc
+	gateway.ParseCallbackFunc = func(ctx context.Context, gatewayID int, body []byte) (*adapter.Callback, error) {
+		cb, err := parse(ctx, gatewayID, body)
+		if err == nil {
+			cb.Dispute = &adapter.Dispute{ProviderRef: "dp-" + cb.EventID}
+		}
+		return cb, err
+	}
+	svc := NewSvcInbox(mockDB, gateway, tx.NewSvcTx(mockDB, tx.PoolConfig{}), dispute.NewSvcDispute(mockDB, dispute.DisputeConfig{}), InboxConfig{})
+	receive := func(body string) (*postgres.InboxCallback, error) {
+		cb, _, err := svc.ReceiveCallback(context.Background(), 1, "", svcGateway.CallbackRequest{Body: []byte(body), Signature: "ok"})
+		return cb, err
+	}
+
+	cb, err := receive("evt-1:42:opened")
+	if err != nil || cb.Result != postgres.CallbackProcessed || len(disputes) != 1 || statuses[42] != tx.StatusCompleted {
+		t.Fatalf("expected the dispute to be opened without changing the tx, got %+v, %v", cb, err)
+	}
+
+	// a tx that did not complete cannot be disputed
+	if cb, err = receive("evt-2:43:opened"); !errors.Is(err, dispute.ErrInvalidDispute) || cb.Result != postgres.CallbackRejected {
+		t.Errorf("expected the dispute to be rejected, got %+v, %v", cb, err)
+	}
+}
+
+func TestReceiveCallback_Duplicate(t *testing.T) {
+	stored := postgres.InboxCallback{ID: 3, GatewayID: 1, EventID: "evt-1", Result: postgres.CallbackProcessed}
+	mockDB := newInboxMockDB(map[int64]string{})
+	mockDB.ClaimInboxCallbackFunc = func(ctx context.Context, cb *postgres.InboxCallback, lockTimeout time.Duration) (bool, error) {
+		*cb = stored
+		return false, nil
+	}
+	svc := NewSvcInbox(mockDB, newInboxGateway(), tx.NewSvcTx(mockDB, tx.PoolConfig{}), dispute.NewSvcDispute(mockDB, dispute.DisputeConfig{}), InboxConfig{})
+
+	cb, duplicate, err := svc.ReceiveCallback(context.Background(), 1, "", svcGateway.CallbackRequest{Body: []byte("evt-1:42:completed"), Signature: "ok"})
+	if err != nil || !duplicate || cb.ID != 3 {
+		t.Errorf("expected the stored callback as a duplicate, got %+v, %v, %v", cb, duplicate, err)
+	}
+}
+
+func TestReprocessCallback(t *testing.T) {
+	statuses := map[int64]string{}
+	var completed *postgres.InboxCallback
+	mockDB := newInboxMockDB(statuses)
+	mockDB.ReclaimInboxCallbackFunc = func(ctx context.Context, id int64, lockTimeout time.Duration) (*postgres.InboxCallback, error) {
+		if id != 3 {
+			return nil, fmt.Errorf("callback %d %w", id, db.ErrNotFound)
+		}
+		return &postgres.InboxCallback{ID: 3, GatewayID: 1, Payload: []byte("evt-1:42:completed"), Result: postgres.CallbackReceived}, nil
+	}
+	mockDB.CompleteInboxCallbackFunc = func(ctx context.Context, cb *postgres.InboxCallback) error {
+		completed = cb
+		return nil
+	}
+	svc := NewSvcInbox(mockDB, newInboxGateway(), tx.NewSvcTx(mockDB, tx.PoolConfig{}), dispute.NewSvcDispute(mockDB, dispute.DisputeConfig{}), InboxConfig{})
+
+	// the outcome of applying the callback is recorded, not returned
+	cb, err := svc.ReprocessCallback(context.Background(), 3)
+	if err != nil || cb.Result != postgres.CallbackRejected || completed == nil || completed.Error == "" {
+		t.Errorf("expected the callback of an unknown tx to be rejected, got %+v, %v", cb, err)
+	}
+
+	statuses[42] = tx.StatusProcessing
+	if cb, err = svc.ReprocessCallback(context.Background(), 3); err != nil || cb.Result != postgres.CallbackProcessed || cb.Error != "" {
+		t.Errorf("expected the callback to be processed once the tx exists, got %+v, %v", cb, err)
+	}
+
+	if _, err = svc.ReprocessCallback(context.Background(), 4); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("expected an unknown callback to be not found, got %v", err)
+	}
+}
@@ -0,0 +1,218 @@
+package ledger
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"payment-gateway/db"
+	"payment-gateway/internal/models/common"
+	"payment-gateway/internal/models/postgres"
+	"payment-gateway/internal/util"
+	"time"
+)
+
+// Account types of the ledger. User accounts hold what the service owes a user and is available to withdraw, user
+// hold accounts what is held for the user's withdrawals in flight, gateway accounts what went through a gateway and
+// the fees account what the gateways charged.
+const (
+	AccountUser     = "user"
+	AccountUserHold = "user_hold"
+	AccountGateway  = "gateway"
+	AccountFees     = "fees"
+)
+
+type (
+	// LedgerConfig controls the periodic consistency check of the ledger
+	LedgerConfig struct {
+		// CheckInterval is how often the ledger is checked, 0 disables the periodic check
+		CheckInterval time.Duration
+	}
+
+	SvcLedger struct {
+		db  db.Idb
+		cfg LedgerConfig
+	}
+
+	ISvcLedger interface {
+		Balances(ctx context.Context, userID int) ([]*postgres.LedgerAccount, error)
+		CheckLedger(ctx context.Context) (*common.LedgerCheck, error)
+		RunLedgerChecker(ctx context.Context)
+	}
+)
+
+// LoadLedgerConfig reads the ledger settings from the environment
+func LoadLedgerConfig() LedgerConfig {
+	return LedgerConfig{
+		CheckInterval: util.GetEnvDuration("LEDGER_CHECK_INTERVAL", time.Hour),
+	}
+}
+
+func NewSvcLedger(db db.Idb, cfg LedgerConfig) ISvcLedger {
+	return &SvcLedger{db: db, cfg: cfg}
+}
+
+// TransactionEntry returns the entry posted when a tx completes. Amounts are signed: a deposit adds its amount to the
+// user's account and takes it, less the gateway's fee, from the gateway's account; a withdrawal takes its amount from
+// the funds held for it and adds it, plus the fee, to the gateway's account. The fee is taken from the fees account.
+func TransactionEntry(tx *postgres.Transaction) (*postgres.JournalEntry, error) {
+	entry := newTransactionEntry(tx, "completed")
+	switch tx.Type {
+	case "deposit":
+		addPosting(entry, AccountUser, int64(tx.UserID), tx.Amount)
+		addPosting(entry, AccountGateway, int64(tx.GatewayID), tx.Fee-tx.Amount)
+	case "withdrawal":
+		addPosting(entry, AccountUserHold, int64(tx.UserID), -tx.Amount)
+		addPosting(entry, AccountGateway, int64(tx.GatewayID), tx.Amount+tx.Fee)
+	default:
+		return nil, fmt.Errorf("no ledger entry for tx %d of type %q", tx.ID, tx.Type)
+	}
+	addPosting(entry, AccountFees, 0, -tx.Fee)
+
+	return entry, nil
+}
+
+// HoldEntry returns the entry holding the amount of a withdrawal, moving it from the user's available funds to the
+// user's held funds. It is posted with the tx, which is not created when the user's available balance is too low.
+func HoldEntry(tx *postgres.Transaction) *postgres.JournalEntry {
+	entry := newTransactionEntry(tx, "hold")
+	entry.Postings = holdPostings(tx.UserID, tx.Amount)
+
+	return entry
+}
+
+// ReleaseEntry returns the entry giving the amount held for a withdrawal back to the user, posted when it does not
+// complete
+func ReleaseEntry(tx *postgres.Transaction) *postgres.JournalEntry {
+	entry := newTransactionEntry(tx, "release")
+	addPosting(entry, AccountUserHold, int64(tx.UserID), -tx.Amount)
+	addPosting(entry, AccountUser, int64(tx.UserID), tx.Amount)
+
+	return entry
+}
+
+// RefundHoldEntry returns the entry holding the amount of a refund from the user's available funds, like a
+// withdrawal. It is posted with the refund, which is not created when the user's available balance is too low.
+func RefundHoldEntry(refund *postgres.Refund) *postgres.JournalEntry {
+	entry := newRefundEntry(refund, "hold")
+	entry.Postings = holdPostings(refund.UserID, refund.Amount)
+
+	return entry
+}
+
+// RefundEntry returns the entry posted when a refund completes, the amount held for it goes back through the gateway.
+// The fee of the deposit is not refunded.
+func RefundEntry(refund *postgres.Refund) *postgres.JournalEntry {
+	entry := newRefundEntry(refund, "completed")
+	addPosting(entry, AccountUserHold, int64(refund.UserID), -refund.Amount)
+	addPosting(entry, AccountGateway, int64(refund.GatewayID), refund.Amount)
+
+	return entry
+}
+
+// RefundReleaseEntry returns the entry giving the amount held for a refund back to the user, posted when it fails
+func RefundReleaseEntry(refund *postgres.Refund) *postgres.JournalEntry {
+	entry := newRefundEntry(refund, "release")
+	addPosting(entry, AccountUserHold, int64(refund.UserID), -refund.Amount)
+	addPosting(entry, AccountUser, int64(refund.UserID), refund.Amount)
+
+	return entry
+}
+
+// DisputeLossEntry returns the entry reversing a deposit whose dispute was lost: the gateway takes the disputed amount
+// back and it is taken from the user's account, which may go negative as the funds are gone either way. The fee of the
+// deposit is not returned.
+func DisputeLossEntry(dispute *postgres.Dispute) *postgres.JournalEntry {
+	txID := dispute.TransactionID
+	entry := &postgres.JournalEntry{
+		Reference:     fmt.Sprintf("dispute:%d:lost", dispute.ID),
+		TransactionID: &txID,
+		Currency:      dispute.Currency,
+		Description:   fmt.Sprintf("dispute %d of transaction %d lost", dispute.ID, dispute.TransactionID),
+	}
+	addPosting(entry, AccountUser, int64(dispute.UserID), -dispute.Amount)
+	addPosting(entry, AccountGateway, int64(dispute.GatewayID), dispute.Amount)
+
+	return entry
+}
+
+// holdPostings returns the postings moving amount from the user's available funds, which must cover it, to the user's
+// held funds
+func holdPostings(userID int, amount int64) []*postgres.Posting {
+	return []*postgres.Posting{
+		{AccountType: AccountUser, OwnerID: int64(userID), Amount: -amount, RequireFunds: true},
+		{AccountType: AccountUserHold, OwnerID: int64(userID), Amount: amount},
+	}
+}
+
+// newTransactionEntry returns an entry without postings for an event of the tx, its reference is unique per event
+func newTransactionEntry(tx *postgres.Transaction, event string) *postgres.JournalEntry {
+	txID := tx.ID
+
+	return &postgres.JournalEntry{
+		Reference:     fmt.Sprintf("transaction:%d:%s", tx.ID, event),
+		TransactionID: &txID,
+		Currency:      tx.Currency,
+		Description:   fmt.Sprintf("%s %d %s", tx.Type, tx.ID, event),
+	}
+}
+
+// newRefundEntry returns an entry without postings for an event of the refund, its reference is unique per event
+func newRefundEntry(refund *postgres.Refund, event string) *postgres.JournalEntry {
+	txID := refund.TransactionID
+
+	return &postgres.JournalEntry{
+		Reference:     fmt.Sprintf("refund:%d:%s", refund.ID, event),
+		TransactionID: &txID,
+		Currency:      refund.Currency,
+		Description:   fmt.Sprintf("refund %d of transaction %d %s", refund.ID, refund.TransactionID, event),
+	}
+}
+
+// addPosting adds a posting to the entry, zero amounts are left out
+func addPosting(entry *postgres.JournalEntry, accountType string, ownerID, amount int64) {
+	if amount == 0 {
+		return
+	}
+
+	entry.Postings = append(entry.Postings, &postgres.Posting{AccountType: accountType, OwnerID: ownerID, Amount: amount})
+}
+
+// Balances returns the available and held funds accounts of the user, in every currency the user has one in
+func (l SvcLedger) Balances(ctx context.Context, userID int) ([]*postgres.LedgerAccount, error) {
+	return l.db.GetLedgerAccounts(ctx, int64(userID), AccountUser, AccountUserHold)
+}
+
+// CheckLedger verifies every entry, and therefore every currency, sums to zero and every balance is the sum of the
+// postings of its account
+func (l SvcLedger) CheckLedger(ctx context.Context) (*common.LedgerCheck, error) {
+	return l.db.CheckLedger(ctx)
+}
+
+// RunLedgerChecker checks the ledger every interval until ctx is cancelled, discrepancies are logged
+func (l SvcLedger) RunLedgerChecker(ctx context.Context) {
+	if l.cfg.CheckInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(l.cfg.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		check, err := l.CheckLedger(ctx)
+		if err != nil {
+			log.Printf("ledger check: %v", err)
+			continue
+		}
+
+		if !check.Consistent() {
+			log.Printf("ledger check: inconsistent ledger, unbalanced entries %v, unbalanced currencies %v, balance mismatches in accounts %v",
+				check.UnbalancedEntries, check.UnbalancedCurrencies, check.BalanceMismatches)
+		}
+	}
+}
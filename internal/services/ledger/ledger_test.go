@@ -0,0 +1,113 @@
+package ledger
+
+import (
+	"payment-gateway/internal/models/postgres"
+	"testing"
+)
+
+func TestTransactionEntry(t *testing.T) {
+	tests := []struct {
+		name     string
+		tx       postgres.Transaction
+		balances map[string]int64
+	}{
+		{
+			name:     "deposit",
+			tx:       postgres.Transaction{ID: 1, Type: "deposit", Amount: 10050, Fee: 25, Currency: "EUR", UserID: 7, GatewayID: 3},
+			balances: map[string]int64{AccountUser: 10050, AccountGateway: -10025, AccountFees: -25},
+		},
+		{
+			name:     "withdrawal",
+			tx:       postgres.Transaction{ID: 2, Type: "withdrawal", Amount: 5000, Fee: 100, Currency: "EUR", UserID: 7, GatewayID: 3},
+			balances: map[string]int64{AccountUserHold: -5000, AccountGateway: 5100, AccountFees: -100},
+		},
+		{
+			name:     "no fee",
+			tx:       postgres.Transaction{ID: 3, Type: "deposit", Amount: 500, Currency: "JPY", UserID: 7, GatewayID: 3},
+			balances: map[string]int64{AccountUser: 500, AccountGateway: -500},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry, err := TransactionEntry(&tt.tx)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if entry.Currency != tt.tx.Currency || *entry.TransactionID != tt.tx.ID {
+				t.Errorf("unexpected entry %+v", entry)
+			}
+
+			var sum int64
+			got := map[string]int64{}
+			for _, p := range entry.Postings {
+				sum += p.Amount
+				got[p.AccountType] += p.Amount
+			}
+
+			if sum != 0 {
+				t.Errorf("expected the entry to sum to zero, got %d", sum)
+			}
+			if len(got) != len(tt.balances) {
+				t.Errorf("expected postings %v, got %v", tt.balances, got)
+			}
+			for account, amount := range tt.balances {
+				if got[account] != amount {
+					t.Errorf("expected %d on the %s account, got %d", amount, account, got[account])
+				}
+			}
+		})
+	}
+
+	if _, err := TransactionEntry(&postgres.Transaction{ID: 4, Type: "transfer"}); err == nil {
+		t.Error("expected an unknown tx type to be rejected")
+	}
+}
+
+func TestHoldAndReleaseEntries(t *testing.T) {
+	tx := &postgres.Transaction{ID: 2, Type: "withdrawal", Amount: 5000, Currency: "EUR", UserID: 7}
+
+	hold, release := HoldEntry(tx), ReleaseEntry(tx)
+	if hold.Reference != "transaction:2:hold" || release.Reference != "transaction:2:release" {
+		t.Errorf("unexpected references %s and %s", hold.Reference, release.Reference)
+	}
+
+	// the hold only spends available funds and the release gives them back
+	net := map[string]int64{}
+	for _, p := range hold.Postings {
+		if p.AccountType == AccountUser && (p.Amount != -5000 || !p.RequireFunds) {
+			t.Errorf("expected the hold to require 5000 available, got %+v", p)
+		}
+		net[p.AccountType] += p.Amount
+	}
+	for _, p := range release.Postings {
+		if p.RequireFunds {
+			t.Errorf("expected the release not to require funds, got %+v", p)
+		}
+		net[p.AccountType] += p.Amount
+	}
+
+	if net[AccountUser] != 0 || net[AccountUserHold] != 0 || len(net) != 2 {
+		t.Errorf("expected the release to undo the hold, got %v", net)
+	}
+}
+
+func TestDisputeLossEntry(t *testing.T) {
+	entry := DisputeLossEntry(&postgres.Dispute{ID: 3, TransactionID: 1, UserID: 7, GatewayID: 2, Amount: 2500, Currency: "EUR"})
+	if entry.Reference != "dispute:3:lost" || entry.Currency != "EUR" || *entry.TransactionID != 1 {
+		t.Errorf("unexpected entry %+v", entry)
+	}
+
+	// the user gives the disputed amount back to the gateway, even without the funds
+	want := map[string]int64{AccountUser: -2500, AccountGateway: 2500}
+	for _, p := range entry.Postings {
+		if p.Amount != want[p.AccountType] || p.RequireFunds {
+			t.Errorf("unexpected posting %+v", p)
+		}
+		delete(want, p.AccountType)
+	}
+	if len(want) != 0 {
+		t.Errorf("missing postings to %v", want)
+	}
+}
@@ -0,0 +1,99 @@
+package outbox
+
+import (
+	"context"
+	"log"
+	"payment-gateway/db"
+	"payment-gateway/internal/kafka"
+	"payment-gateway/internal/util"
+	"time"
+)
+
+type (
+	// RelayConfig controls how often the outbox is drained and how many events are published per batch
+	RelayConfig struct {
+		Interval  time.Duration
+		BatchSize int
+	}
+
+	SvcOutbox struct {
+		db       db.Idb
+		producer kafka.IProducer
+		cfg      RelayConfig
+	}
+
+	ISvcOutbox interface {
+		RelayOutbox(ctx context.Context)
+		DrainOutbox(ctx context.Context) (int, error)
+	}
+)
+
+// LoadRelayConfig reads the outbox relay settings from the environment
+func LoadRelayConfig() RelayConfig {
+	return RelayConfig{
+		Interval:  util.GetEnvDuration("OUTBOX_RELAY_INTERVAL", time.Second),
+		BatchSize: util.GetEnvInt("OUTBOX_RELAY_BATCH_SIZE", 100),
+	}
+}
+
+func NewSvcOutbox(db db.Idb, producer kafka.IProducer, cfg RelayConfig) ISvcOutbox {
+	return &SvcOutbox{db: db, producer: producer, cfg: cfg}
+}
+
+// RelayOutbox drains the outbox into Kafka every interval until ctx is cancelled
+func (o SvcOutbox) RelayOutbox(ctx context.Context) {
+	ticker := time.NewTicker(o.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := o.DrainOutbox(ctx); err != nil {
+			log.Printf("outbox relay: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DrainOutbox publishes pending events in the order they were written until the outbox is empty or a publish fails,
+// and returns the number of events published. Stopping at the first failure keeps the events of a tx in order, the
+// failed event is retried first on the next run. Only one instance drains the outbox at a time.
+func (o SvcOutbox) DrainOutbox(ctx context.Context) (int, error) {
+	published := 0
+
+	_, err := o.db.WithAdvisoryLock(ctx, db.OutboxRelayLock, func() error {
+		for ctx.Err() == nil {
+			events, err := o.db.GetPendingOutboxEvents(ctx, o.cfg.BatchSize)
+			if err != nil {
+				return err
+			}
+
+			for _, event := range events {
+				if err = o.producer.PubEvent(ctx, event); err != nil {
+					if markErr := o.db.MarkOutboxEventFailed(ctx, event.ID, err.Error()); markErr != nil {
+						log.Printf("failed to record failure of outbox event %d: %v", event.ID, markErr)
+					}
+
+					return err
+				}
+
+				// a failure here republishes the event on the next run, consumers see it at least once
+				if err = o.db.MarkOutboxEventPublished(ctx, event.ID); err != nil {
+					return err
+				}
+				published++
+			}
+
+			if len(events) < o.cfg.BatchSize {
+				return nil
+			}
+		}
+
+		return nil
+	})
+
+	return published, err
+}
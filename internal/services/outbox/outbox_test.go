@@ -0,0 +1,84 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"payment-gateway/db"
+	"payment-gateway/internal/models/postgres"
+	"testing"
+	"time"
+)
+
+// flakyProducer records the published events and fails the first publish of failOnce
+type flakyProducer struct {
+	published []string
+	failOnce  map[string]bool
+}
+
+func (p *flakyProducer) PubEvent(ctx context.Context, event *postgres.OutboxEvent) error {
+	msg := string(event.Payload)
+	if p.failOnce[msg] {
+		delete(p.failOnce, msg)
+		return errors.New("broker unavailable")
+	}
+
+	p.published = append(p.published, msg)
+	return nil
+}
+
+func (p *flakyProducer) Close() error {
+	return nil
+}
+
+func TestDrainOutbox_KeepsOrderAcrossFailures(t *testing.T) {
+	events := []*postgres.OutboxEvent{
+		{ID: 1, AggregateID: 10, Payload: []byte("10-processing"), ContentType: "application/json"},
+		{ID: 2, AggregateID: 10, Payload: []byte("10-completed"), ContentType: "application/json"},
+		{ID: 3, AggregateID: 20, Payload: []byte("20-processing"), ContentType: "application/json"},
+	}
+
+	var failures int
+	mockDB := &db.MockDB{
+		WithAdvisoryLockFunc: func(ctx context.Context, key int64, fn func() error) (bool, error) {
+			return true, fn()
+		},
+		GetPendingOutboxEventsFunc: func(ctx context.Context, limit int) ([]*postgres.OutboxEvent, error) {
+			var pending []*postgres.OutboxEvent
+			for _, e := range events {
+				if e.PublishedAt == nil && len(pending) < limit {
+					pending = append(pending, e)
+				}
+			}
+			return pending, nil
+		},
+		MarkOutboxEventPublishedFunc: func(ctx context.Context, id int64) error {
+			now := time.Now()
+			events[id-1].PublishedAt = &now
+			return nil
+		},
+		MarkOutboxEventFailedFunc: func(ctx context.Context, id int64, reason string) error {
+			failures++
+			return nil
+		},
+	}
+
+	producer := &flakyProducer{failOnce: map[string]bool{"10-completed": true}}
+	svc := NewSvcOutbox(mockDB, producer, RelayConfig{BatchSize: 2})
+
+	published, err := svc.DrainOutbox(context.Background())
+	if err == nil || published != 1 || failures != 1 {
+		t.Fatalf("expected the drain to stop at the failed event, got %d published: %v", published, err)
+	}
+
+	published, err = svc.DrainOutbox(context.Background())
+	if err != nil || published != 2 {
+		t.Fatalf("expected the remaining events to be published, got %d: %v", published, err)
+	}
+
+	want := []string{"10-processing", "10-completed", "20-processing"}
+	for i, msg := range want {
+		if i >= len(producer.published) || producer.published[i] != msg {
+			t.Fatalf("expected events %v in order, got %v", want, producer.published)
+		}
+	}
+}
@@ -1,11 +1,21 @@
 package services
 
 import (
+	"payment-gateway/internal/services/dispute"
 	"payment-gateway/internal/services/gateway"
+	"payment-gateway/internal/services/inbox"
+	"payment-gateway/internal/services/ledger"
+	"payment-gateway/internal/services/outbox"
 	"payment-gateway/internal/services/tx"
+	"payment-gateway/internal/services/webhook"
 )
 
 type Service struct {
 	gateway.ISvcGateway
 	tx.ISvcTx
+	outbox.ISvcOutbox
+	inbox.ISvcInbox
+	webhook.ISvcWebhook
+	ledger.ISvcLedger
+	dispute.ISvcDispute
 }
@@ -0,0 +1,112 @@
+package tx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"payment-gateway/internal/models/postgres"
+	svcGateway "payment-gateway/internal/services/gateway"
+	"payment-gateway/internal/services/gateway/adapter"
+	"strings"
+)
+
+// ErrVoidRefused is wrapped by the error returned when the gateway that accepted a tx refuses to void it, the tx is not
+// cancelled
+var ErrVoidRefused = errors.New("the gateway did not void the transaction")
+
+// CancelTransaction cancels a tx whose status still allows it. A tx waiting to be sent is cancelled straight away and
+// won't be sent; a tx a gateway accepted is voided at the gateway first, and stays as it is when the gateway does not
+// support voids or refuses to void it. The void is attempted once within ctx, a void that may succeed on a retry is
+// queued for the workers and the returned tx is still processing, it is cancelled once the gateway voided it. The
+// amount held for a withdrawal is released with the cancellation, and the transaction.cancelled event is written to
+// the outbox along with it.
+func (t SvcTx) CancelTransaction(ctx context.Context, txID int64, reason string, iSvcGateway svcGateway.ISvcGateway) (*postgres.Transaction, error) {
+	tx, err := t.GetTransaction(ctx, txID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !CanTransition(tx.Status, StatusCancelled) {
+		return nil, &IllegalTransitionError{TxID: tx.ID, From: tx.Status, To: StatusCancelled}
+	}
+
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		reason = "cancelled on request"
+	}
+
+	if tx.Status == StatusProcessing {
+		_, err = iSvcGateway.SendVoidToGateway(ctx, *tx)
+		switch {
+		case errors.Is(err, adapter.ErrNotSupported):
+			return nil, newValidationError(fmt.Sprintf("tx %d cannot be cancelled, gateway %d does not support voids", tx.ID, tx.GatewayID))
+		case err != nil && !svcGateway.IsRetryableSendError(err):
+			log.Printf("gateway %d refused to void tx %d: %v", tx.GatewayID, tx.ID, err)
+
+			return nil, fmt.Errorf("%w: %v", ErrVoidRefused, err)
+		case err != nil:
+			log.Printf("gateway %d failed to void tx %d, queuing a retry: %v", tx.GatewayID, tx.ID, err)
+
+			if !t.pool.reserve() {
+				return nil, ErrQueueFull
+			}
+			t.pool.enqueue(submission{tx: *tx, void: true, reason: reason, iSvcGateway: iSvcGateway})
+
+			return tx, nil
+		}
+
+		// the cancellation of a voided tx is recorded even if the client went away meanwhile
+		ctx = context.Background()
+		reason = fmt.Sprintf("%s, voided by gateway %d", reason, tx.GatewayID)
+	}
+
+	err = t.transition(ctx, tx.ID, StatusCancelled, reason, tx)
+
+	var transitionErr *IllegalTransitionError
+	switch {
+	case errors.As(err, &transitionErr):
+		// e.g. a callback completed the tx meanwhile
+		return nil, err
+	case err != nil:
+		log.Printf("failed to cancel tx %d: %v", tx.ID, err)
+
+		return nil, errors.New("failed to update transaction status in database")
+	}
+	tx.Status = StatusCancelled
+
+	return tx, nil
+}
+
+// submitVoid retries the void of a tx whose cancellation was requested and cancels the tx once the gateway voided it.
+// The tx stays processing when the gateway still fails to void it, and a tx no longer processing, e.g. completed by a
+// callback meanwhile, is skipped.
+func (t SvcTx) submitVoid(ctx context.Context, job submission) {
+	tx := job.tx
+
+	if current, err := t.db.GetTransaction(ctx, tx.ID); err != nil {
+		log.Printf("failed to fetch status of tx %d, voiding it: %v", tx.ID, err)
+	} else if current.Status != StatusProcessing {
+		log.Printf("tx %d is %s, not voiding it", tx.ID, current.Status)
+		return
+	}
+
+	if err := t.void(ctx, &tx, job.iSvcGateway); err != nil {
+		log.Printf("gateway %d failed to void tx %d, it stays processing: %v", tx.GatewayID, tx.ID, err)
+		return
+	}
+
+	reason := fmt.Sprintf("%s, voided by gateway %d", job.reason, tx.GatewayID)
+	if err := t.transition(ctx, tx.ID, StatusCancelled, reason, &tx); err != nil {
+		log.Printf("failed to cancel tx %d: %v", tx.ID, err)
+	}
+}
+
+// void voids the tx at the gateway that accepted it. The returned error wraps adapter.ErrNotSupported when the
+// gateway's adapter does not support voids.
+func (t SvcTx) void(ctx context.Context, tx *postgres.Transaction, iSvcGateway svcGateway.ISvcGateway) error {
+	return gatewaySendPolicy.Do(ctx, func(ctx context.Context) error {
+		_, err := iSvcGateway.SendVoidToGateway(ctx, *tx)
+		return err
+	})
+}
@@ -0,0 +1,234 @@
+package tx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"payment-gateway/db"
+	"payment-gateway/internal/models/common"
+	"payment-gateway/internal/models/postgres"
+	"payment-gateway/internal/retry"
+	"payment-gateway/internal/services/gateway"
+	"payment-gateway/internal/services/gateway/adapter"
+	"strings"
+	"testing"
+	"time"
+)
+
+// cancelStore keeps the status of tx 1, a withdrawal of 50.00 EUR sent to gateway 3, in memory along with the events
+// and ledger entries written with its status changes
+type cancelStore struct {
+	status  string
+	events  []*postgres.OutboxEvent
+	entries []*postgres.JournalEntry
+}
+
+func newCancelMockDB(s *cancelStore) *db.MockDB {
+	mockDB := newStatusMockDB(1, &s.status)
+
+	update := mockDB.UpdateTxStatusFunc
+	mockDB.UpdateTxStatusFunc = func(ctx context.Context, change *postgres.TransactionStatusChange, allowedFrom []string, event *postgres.OutboxEvent, entry *postgres.JournalEntry) error {
+		if err := update(ctx, change, allowedFrom, event, entry); err != nil {
+			return err
+		}
+		s.events, s.entries = append(s.events, event), append(s.entries, entry)
+		return nil
+	}
+	mockDB.GetTransactionFunc = func(ctx context.Context, id int64) (*postgres.Transaction, error) {
+		if id != 1 {
+			return nil, fmt.Errorf("transaction %d %w", id, db.ErrNotFound)
+		}
+		return &postgres.Transaction{ID: 1, Type: "withdrawal", Amount: 5000, Currency: "EUR", UserID: 7, GatewayID: 3, ProviderRef: "psp-1", Status: s.status}, nil
+	}
+	mockDB.ClaimTransactionFunc = func(ctx context.Context, id int64) (bool, error) {
+		return id == 1 && s.status == StatusPending, nil
+	}
+
+	return mockDB
+}
+
+// voidingGateway voids every tx and counts the voids
+func voidingGateway(voids *int) *gateway.MockGatewayProcessor {
+	return &gateway.MockGatewayProcessor{
+		SendVoidToGatewayFunc: func(ctx context.Context, tx postgres.Transaction) (*adapter.Response, error) {
+			*voids++
+			return &adapter.Response{ProviderRef: tx.ProviderRef, ProviderStatus: "voided"}, nil
+		},
+	}
+}
+
+func TestCancelTransaction(t *testing.T) {
+	for _, status := range []string{StatusPending, StatusProcessing} {
+		t.Run(status, func(t *testing.T) {
+			s := &cancelStore{status: status}
+			svc := NewSvcTx(newCancelMockDB(s), testPool)
+
+			var voids int
+			tx, err := svc.CancelTransaction(context.Background(), 1, " changed my mind ", voidingGateway(&voids))
+			if err != nil || tx.Status != StatusCancelled || s.status != StatusCancelled {
+				t.Fatalf("expected the tx to be cancelled, got %+v: %v", tx, err)
+			}
+
+			// only a tx a gateway accepted is voided
+			if wantVoids := map[string]int{StatusPending: 0, StatusProcessing: 1}[status]; voids != wantVoids {
+				t.Errorf("expected %d voids, got %d", wantVoids, voids)
+			}
+			if len(s.entries) != 1 || s.entries[0].Reference != "transaction:1:release" {
+				t.Errorf("expected the withdrawal's hold to be released, got %+v", s.entries)
+			}
+
+			var event common.TransactionEvent
+			if len(s.events) != 1 || s.events[0].EventType != "transaction.cancelled" {
+				t.Fatalf("expected a transaction.cancelled event, got %+v", s.events)
+			}
+			if err = json.Unmarshal(s.events[0].Payload, &event); err != nil || !strings.HasPrefix(event.Reason, "changed my mind") {
+				t.Errorf("expected the event to carry the reason, got %+v: %v", event, err)
+			}
+		})
+	}
+}
+
+func TestCancelTransaction_Rejected(t *testing.T) {
+	defer func(policy retry.Policy) { gatewaySendPolicy = policy }(gatewaySendPolicy)
+	gatewaySendPolicy.MaxAttempts = 1
+
+	unsupported := &gateway.MockGatewayProcessor{
+		SendVoidToGatewayFunc: func(ctx context.Context, tx postgres.Transaction) (*adapter.Response, error) {
+			return nil, fmt.Errorf("voids are %w", adapter.ErrNotSupported)
+		},
+	}
+	refusing := &gateway.MockGatewayProcessor{
+		SendVoidToGatewayFunc: func(ctx context.Context, tx postgres.Transaction) (*adapter.Response, error) {
+			return nil, &adapter.StatusError{StatusCode: 409, Body: []byte("already settled")}
+		},
+	}
+
+	var validationErr *ValidationError
+	var transitionErr *IllegalTransitionError
+	var voids int
+	tests := []struct {
+		name    string
+		txID    int64
+		status  string
+		gateway gateway.ISvcGateway
+		is      func(error) bool
+	}{
+		{"completed", 1, StatusCompleted, voidingGateway(&voids), func(err error) bool { return errors.As(err, &transitionErr) }},
+		{"already cancelled", 1, StatusCancelled, voidingGateway(&voids), func(err error) bool { return errors.As(err, &transitionErr) }},
+		{"voids not supported", 1, StatusProcessing, unsupported, func(err error) bool { return errors.As(err, &validationErr) }},
+		{"void refused", 1, StatusProcessing, refusing, func(err error) bool { return errors.Is(err, ErrVoidRefused) }},
+		{"unknown tx", 2, StatusPending, voidingGateway(&voids), func(err error) bool { return errors.Is(err, db.ErrNotFound) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &cancelStore{status: tt.status}
+			svc := NewSvcTx(newCancelMockDB(s), testPool)
+
+			if _, err := svc.CancelTransaction(context.Background(), tt.txID, "", tt.gateway); !tt.is(err) {
+				t.Errorf("expected the cancellation to be rejected, got %v", err)
+			}
+			if s.status != tt.status || len(s.entries) != 0 {
+				t.Errorf("expected the tx to stay %s, got %s", tt.status, s.status)
+			}
+		})
+	}
+
+	if voids != 0 {
+		t.Errorf("expected no void, got %d", voids)
+	}
+}
+
+func TestCancelTransaction_VoidRetried(t *testing.T) {
+	defer func(policy retry.Policy) { gatewaySendPolicy = policy }(gatewaySendPolicy)
+	gatewaySendPolicy.InitialInterval, gatewaySendPolicy.MaxInterval = time.Millisecond, time.Millisecond
+
+	s := &cancelStore{status: StatusProcessing}
+	svc := NewSvcTx(newCancelMockDB(s), testPool).(*SvcTx)
+
+	// the gateway times out on the void sent with the request, then voids the tx
+	var attempts int
+	flaky := &gateway.MockGatewayProcessor{
+		SendVoidToGatewayFunc: func(ctx context.Context, tx postgres.Transaction) (*adapter.Response, error) {
+			if attempts++; attempts == 1 {
+				return nil, &adapter.StatusError{StatusCode: 504, Body: []byte("timeout")}
+			}
+			return &adapter.Response{ProviderRef: tx.ProviderRef, ProviderStatus: "voided"}, nil
+		},
+	}
+
+	tx, err := svc.CancelTransaction(context.Background(), 1, "changed my mind", flaky)
+	if err != nil || tx.Status != StatusProcessing || s.status != StatusProcessing {
+		t.Fatalf("expected the tx to stay processing until the void is retried, got %+v: %v", tx, err)
+	}
+	if attempts != 1 {
+		t.Errorf("expected a single void attempt with the request, got %d", attempts)
+	}
+
+	// a worker retries the void and cancels the tx
+	svc.work(context.Background(), nextSubmission(t, svc))
+	if attempts != 2 || s.status != StatusCancelled {
+		t.Fatalf("expected the tx to be cancelled once voided, got %d attempts and status %s", attempts, s.status)
+	}
+	if len(s.entries) != 1 || s.entries[0].Reference != "transaction:1:release" {
+		t.Errorf("expected the withdrawal's hold to be released, got %+v", s.entries)
+	}
+
+	// a tx that is no longer processing when the worker takes the void, e.g. completed by a callback, is not voided
+	s.status = StatusProcessing
+	if _, err = svc.CancelTransaction(context.Background(), 1, "", &gateway.MockGatewayProcessor{
+		SendVoidToGatewayFunc: func(ctx context.Context, tx postgres.Transaction) (*adapter.Response, error) {
+			attempts++
+			return nil, context.DeadlineExceeded
+		},
+	}); err != nil {
+		t.Fatalf("expected the void to be queued, got %v", err)
+	}
+	s.status = StatusCompleted
+	svc.work(context.Background(), nextSubmission(t, svc))
+	if attempts != 3 || s.status != StatusCompleted {
+		t.Errorf("expected the completed tx not to be voided, got %d attempts and status %s", attempts, s.status)
+	}
+}
+
+func TestSubmit_Cancelled(t *testing.T) {
+	s := &cancelStore{status: StatusCancelled}
+	mockDB := newCancelMockDB(s)
+
+	var sent, voids int
+	gw := voidingGateway(&voids)
+	gw.SendTxToGatewayFunc = func(ctx context.Context, tx postgres.Transaction) (*adapter.Response, error) {
+		sent++
+		return &adapter.Response{ProviderRef: "psp-1", ProviderStatus: "accepted", Status: StatusProcessing}, nil
+	}
+	mockDB.UpdateTxGatewayFunc = func(ctx context.Context, tx *postgres.Transaction) error {
+		return nil
+	}
+	mockDB.CreateTxAttemptFunc = func(ctx context.Context, attempt *postgres.TransactionAttempt) error {
+		return nil
+	}
+
+	svc := NewSvcTx(mockDB, testPool).(*SvcTx)
+	job := submission{
+		tx:          postgres.Transaction{ID: 1, Type: "withdrawal", Amount: 5000, Currency: "EUR", Status: StatusPending},
+		gateways:    []*common.Gateway{{ID: 3, Name: "Mock Gateway"}},
+		iSvcGateway: gw,
+		queuedAt:    time.Now(),
+	}
+
+	// a tx cancelled while queued is not sent
+	svc.submit(context.Background(), job)
+	if sent != 0 || voids != 0 {
+		t.Errorf("expected the cancelled tx not to be sent, got %d sends and %d voids", sent, voids)
+	}
+
+	// a tx cancelled while being sent is voided once the gateway accepted it
+	mockDB.ClaimTransactionFunc = func(ctx context.Context, id int64) (bool, error) {
+		return true, nil
+	}
+	svc.submit(context.Background(), job)
+	if sent != 1 || voids != 1 || s.status != StatusCancelled {
+		t.Errorf("expected the cancelled tx to be voided, got %d sends, %d voids and status %s", sent, voids, s.status)
+	}
+}
@@ -0,0 +1,28 @@
+package tx
+
+// ValidationError is returned when a tx request is rejected before being processed
+type ValidationError struct {
+	msg string
+}
+
+func (e *ValidationError) Error() string {
+	return e.msg
+}
+
+func newValidationError(msg string) error {
+	return &ValidationError{msg: msg}
+}
+
+// RoutingError is returned when a tx was stored but no gateway could be selected for it, the tx is failed
+type RoutingError struct {
+	TxID int64
+	Err  error
+}
+
+func (e *RoutingError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RoutingError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,152 @@
+package tx
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"payment-gateway/internal/models/common"
+	"payment-gateway/internal/models/postgres"
+	"payment-gateway/internal/money"
+)
+
+// Page sizes of ListTransactions
+const (
+	defaultListLimit = 50
+	maxListLimit     = 200
+)
+
+type (
+	// TransactionPage is a page of txs, NextCursor is empty on the last page
+	TransactionPage struct {
+		Transactions []*postgres.Transaction
+		NextCursor   string
+	}
+
+	// pageCursor is the opaque cursor handed to clients, it carries the sort it was made for so that it can't be
+	// reused with another one
+	pageCursor struct {
+		SortBy    string `json:"sort_by"`
+		SortOrder string `json:"sort_order"`
+		common.TransactionCursor
+	}
+)
+
+// GetTransaction returns the tx with the given ID, the error wraps db.ErrNotFound when it does not exist
+func (t SvcTx) GetTransaction(ctx context.Context, id int64) (*postgres.Transaction, error) {
+	return t.db.GetTransaction(ctx, id)
+}
+
+// ListTransactions returns a page of the txs matching the filter, cursor being the NextCursor of the previous page
+// or empty for the first one. By default the newest txs come first.
+func (t SvcTx) ListTransactions(ctx context.Context, filter common.TransactionFilter, cursor string) (*TransactionPage, error) {
+	if err := normalizeFilter(&filter); err != nil {
+		return nil, err
+	}
+
+	if cursor != "" {
+		after, err := decodeCursor(cursor, filter)
+		if err != nil {
+			return nil, err
+		}
+		filter.After = after
+	}
+
+	// one more tx than asked tells whether there is a next page
+	limit := filter.Limit
+	filter.Limit++
+
+	txs, err := t.db.ListTransactions(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &TransactionPage{Transactions: txs}
+	if len(txs) > limit {
+		page.Transactions = txs[:limit]
+		page.NextCursor = encodeCursor(page.Transactions[limit-1], filter)
+	}
+
+	return page, nil
+}
+
+// normalizeFilter validates the filter and fills in the default sort and page size
+func normalizeFilter(filter *common.TransactionFilter) error {
+	if filter.Status != "" && !IsValidStatus(filter.Status) {
+		return newValidationError(fmt.Sprintf("unknown status %q", filter.Status))
+	}
+
+	if filter.Type != "" && filter.Type != "deposit" && filter.Type != "withdrawal" {
+		return newValidationError(fmt.Sprintf("unknown type %q", filter.Type))
+	}
+
+	if filter.Currency != "" {
+		filter.Currency = money.NormalizeCurrency(filter.Currency)
+	}
+
+	if filter.From != nil && filter.To != nil && !filter.From.Before(*filter.To) {
+		return newValidationError("invalid date range, from must be before to")
+	}
+
+	switch filter.SortBy {
+	case "":
+		filter.SortBy = "created_at"
+	case "created_at":
+	case "amount":
+		// amounts are in minor units of their currency, they only compare within one
+		if filter.Currency == "" {
+			return newValidationError("sorting by amount requires a currency filter")
+		}
+	default:
+		return newValidationError(fmt.Sprintf("invalid sort %q, must be created_at or amount", filter.SortBy))
+	}
+
+	switch filter.SortOrder {
+	case "":
+		filter.SortOrder = "desc"
+	case "asc", "desc":
+	default:
+		return newValidationError(fmt.Sprintf("invalid order %q, must be asc or desc", filter.SortOrder))
+	}
+
+	switch {
+	case filter.Limit == 0:
+		filter.Limit = defaultListLimit
+	case filter.Limit < 0 || filter.Limit > maxListLimit:
+		return newValidationError(fmt.Sprintf("invalid limit, must be between 1 and %d", maxListLimit))
+	}
+
+	return nil
+}
+
+func encodeCursor(last *postgres.Transaction, filter common.TransactionFilter) string {
+	c := pageCursor{SortBy: filter.SortBy, SortOrder: filter.SortOrder, TransactionCursor: common.TransactionCursor{ID: last.ID}}
+	if filter.SortBy == "amount" {
+		c.Amount = last.Amount
+	} else {
+		c.CreatedAt = last.CreatedAt
+	}
+
+	// a struct of strings, numbers and a time always marshals
+	b, _ := json.Marshal(c)
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(cursor string, filter common.TransactionFilter) (*common.TransactionCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, newValidationError("invalid cursor")
+	}
+
+	var c pageCursor
+	if err = json.Unmarshal(b, &c); err != nil || c.ID <= 0 {
+		return nil, newValidationError("invalid cursor")
+	}
+
+	if c.SortBy != filter.SortBy || c.SortOrder != filter.SortOrder {
+		return nil, newValidationError("invalid cursor, it was made for another sort")
+	}
+
+	return &c.TransactionCursor, nil
+}
@@ -0,0 +1,157 @@
+package tx
+
+import (
+	"context"
+	"errors"
+	"payment-gateway/db"
+	"payment-gateway/internal/models/common"
+	"payment-gateway/internal/models/postgres"
+	"sort"
+	"testing"
+	"time"
+)
+
+// newListMockDB serves stored txs the way ListTransactions does: filtered by user, sorted and after the cursor
+func newListMockDB(stored []*postgres.Transaction) *db.MockDB {
+	return &db.MockDB{
+		ListTransactionsFunc: func(ctx context.Context, filter common.TransactionFilter) ([]*postgres.Transaction, error) {
+			less := func(a, b *postgres.Transaction) bool {
+				if filter.SortBy == "amount" && a.Amount != b.Amount {
+					return a.Amount < b.Amount
+				}
+				if filter.SortBy != "amount" && !a.CreatedAt.Equal(b.CreatedAt) {
+					return a.CreatedAt.Before(b.CreatedAt)
+				}
+				return a.ID < b.ID
+			}
+			if filter.SortOrder == "desc" {
+				asc := less
+				less = func(a, b *postgres.Transaction) bool { return asc(b, a) }
+			}
+
+			var txs []*postgres.Transaction
+			for _, tx := range stored {
+				if filter.UserID != 0 && tx.UserID != filter.UserID {
+					continue
+				}
+				if filter.After != nil {
+					last := &postgres.Transaction{ID: filter.After.ID, Amount: filter.After.Amount, CreatedAt: filter.After.CreatedAt}
+					if !less(last, tx) {
+						continue
+					}
+				}
+				txs = append(txs, tx)
+			}
+			sort.Slice(txs, func(i, j int) bool { return less(txs[i], txs[j]) })
+
+			if len(txs) > filter.Limit {
+				txs = txs[:filter.Limit]
+			}
+			return txs, nil
+		},
+	}
+}
+
+func TestListTransactions_Pagination(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var stored []*postgres.Transaction
+	for i := 1; i <= 7; i++ {
+		// txs 4 and 5 share a timestamp, the ID breaks the tie
+		created := start.Add(time.Duration(i) * time.Minute)
+		if i == 5 {
+			created = stored[3].CreatedAt
+		}
+		stored = append(stored, &postgres.Transaction{ID: int64(i), UserID: 1, Amount: int64(100 * (8 - i)), CreatedAt: created})
+	}
+	stored = append(stored, &postgres.Transaction{ID: 8, UserID: 2, CreatedAt: start})
+
+	tests := []struct {
+		name   string
+		filter common.TransactionFilter
+		want   []int64
+	}{
+		{name: "newest first", filter: common.TransactionFilter{UserID: 1, Limit: 3}, want: []int64{7, 6, 5, 4, 3, 2, 1}},
+		{name: "oldest first", filter: common.TransactionFilter{UserID: 1, SortOrder: "asc", Limit: 2}, want: []int64{1, 2, 3, 4, 5, 6, 7}},
+		{name: "by amount", filter: common.TransactionFilter{UserID: 1, Currency: "EUR", SortBy: "amount", SortOrder: "asc", Limit: 4}, want: []int64{7, 6, 5, 4, 3, 2, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := NewSvcTx(newListMockDB(stored), testPool)
+
+			var got []int64
+			cursor := ""
+			for pages := 0; ; pages++ {
+				if pages > len(stored) {
+					t.Fatal("pagination did not end")
+				}
+
+				page, err := svc.ListTransactions(context.Background(), tt.filter, cursor)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if len(page.Transactions) > tt.filter.Limit {
+					t.Fatalf("expected at most %d txs per page, got %d", tt.filter.Limit, len(page.Transactions))
+				}
+				for _, tx := range page.Transactions {
+					got = append(got, tx.ID)
+				}
+
+				if page.NextCursor == "" {
+					break
+				}
+				cursor = page.NextCursor
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected txs %v, got %v", tt.want, got)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("expected txs %v, got %v", tt.want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestListTransactions_Validation(t *testing.T) {
+	svc := NewSvcTx(newListMockDB(nil), testPool)
+
+	page, err := svc.ListTransactions(context.Background(), common.TransactionFilter{Currency: "eur", SortBy: "amount"}, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if page.NextCursor != "" {
+		t.Fatalf("expected no next page, got %q", page.NextCursor)
+	}
+
+	otherSortCursor := encodeCursor(&postgres.Transaction{ID: 1}, common.TransactionFilter{SortBy: "amount", SortOrder: "desc"})
+
+	from := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(-time.Hour)
+	tests := []struct {
+		name   string
+		filter common.TransactionFilter
+		cursor string
+	}{
+		{name: "unknown status", filter: common.TransactionFilter{Status: "done"}},
+		{name: "unknown type", filter: common.TransactionFilter{Type: "transfer"}},
+		{name: "invalid sort", filter: common.TransactionFilter{SortBy: "user_id"}},
+		{name: "amount sort without currency", filter: common.TransactionFilter{SortBy: "amount"}},
+		{name: "invalid order", filter: common.TransactionFilter{SortOrder: "up"}},
+		{name: "limit too large", filter: common.TransactionFilter{Limit: maxListLimit + 1}},
+		{name: "reversed date range", filter: common.TransactionFilter{From: &from, To: &to}},
+		{name: "malformed cursor", cursor: "not-a-cursor"},
+		{name: "cursor of another sort", cursor: otherSortCursor},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var validationErr *ValidationError
+			if _, err := svc.ListTransactions(context.Background(), tt.filter, tt.cursor); !errors.As(err, &validationErr) {
+				t.Fatalf("expected a validation error, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,298 @@
+package tx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"payment-gateway/db"
+	"payment-gateway/internal/models/common"
+	"payment-gateway/internal/models/postgres"
+	"payment-gateway/internal/models/request"
+	"payment-gateway/internal/money"
+	svcGateway "payment-gateway/internal/services/gateway"
+	"payment-gateway/internal/services/gateway/adapter"
+	"payment-gateway/internal/services/ledger"
+	"sort"
+	"time"
+)
+
+// refundTransitions lists the statuses a refund may move to from each status. A gateway may report a refund
+// completed or failed before its answer to the refund request was recorded.
+var refundTransitions = map[string][]string{
+	postgres.RefundPending:    {postgres.RefundProcessing, postgres.RefundCompleted, postgres.RefundFailed},
+	postgres.RefundProcessing: {postgres.RefundCompleted, postgres.RefundFailed},
+	postgres.RefundCompleted:  nil,
+	postgres.RefundFailed:     nil,
+}
+
+// IsValidRefundStatus tells whether status is a known refund status
+func IsValidRefundStatus(status string) bool {
+	_, ok := refundTransitions[status]
+	return ok
+}
+
+// refundAllowedFrom returns the statuses a refund may move to status from
+func refundAllowedFrom(status string) []string {
+	var from []string
+	for s, to := range refundTransitions {
+		for _, t := range to {
+			if t == status {
+				from = append(from, s)
+			}
+		}
+	}
+	sort.Strings(from)
+
+	return from
+}
+
+// CreateRefund refunds a completed deposit through the gateway that processed it, the whole amount left to refund
+// when req has no amount. The amount is held from the user's available balance until the refund completes or fails,
+// the refunds of a deposit never exceeding its amount. The returned refund is pending, a worker sends it to the
+// gateway and its outcome is then reported by the gateway's callbacks.
+func (t SvcTx) CreateRefund(ctx context.Context, txID int64, req request.Refund, iSvcGateway svcGateway.ISvcGateway) (*postgres.Refund, error) {
+	tx, err := t.GetTransaction(ctx, txID)
+	if err != nil {
+		return nil, err
+	}
+
+	if tx.Type != "deposit" {
+		return nil, newValidationError(fmt.Sprintf("only deposits can be refunded, tx %d is a %s", tx.ID, tx.Type))
+	}
+	if tx.Status != StatusCompleted {
+		return nil, &IllegalTransitionError{TxID: tx.ID, From: tx.Status, To: StatusRefunded}
+	}
+
+	refund := &postgres.Refund{TransactionID: tx.ID, Status: postgres.RefundPending, Reason: req.Reason}
+	if req.Amount != "" {
+		if r, err := req.Amount.Rat(); err != nil || r.Sign() <= 0 {
+			return nil, newValidationError("invalid amount, must be greater than zero")
+		}
+
+		amount, err := money.FromDecimal(req.Amount, tx.Currency)
+		if err != nil {
+			return nil, newValidationError(fmt.Sprintf("invalid amount: %v", err))
+		}
+		refund.Amount = amount.Amount
+	}
+
+	// the refund is stored once it is sure to fit in the queue, like a tx
+	if !t.pool.reserve() {
+		return nil, ErrQueueFull
+	}
+
+	err = t.db.CreateRefund(ctx, refund, ledger.RefundHoldEntry)
+	if err != nil {
+		t.pool.release()
+	}
+	switch {
+	case errors.Is(err, db.ErrRefundExceedsAmount):
+		return nil, newValidationError(fmt.Sprintf("invalid amount, %v", err))
+	case errors.Is(err, db.ErrInsufficientFunds):
+		return nil, newValidationError(fmt.Sprintf("insufficient funds, the available %s balance is lower than the refund", tx.Currency))
+	case errors.Is(err, db.ErrNotFound):
+		return nil, err
+	case err != nil:
+		log.Printf("failed to save refund of tx %d: %v", tx.ID, err)
+
+		return nil, errors.New("failed to save refund to database")
+	}
+
+	// the worker updates its own copy of the refund
+	queued := *refund
+	t.pool.enqueue(submission{tx: *tx, refund: &queued, iSvcGateway: iSvcGateway})
+
+	return refund, nil
+}
+
+// submitRefund sends a queued refund to the gateway that processed its tx, and moves it to processing once the
+// gateway accepted it or to failed when it refused it. A refund queued for longer than the TTL fails instead, and a
+// refund no longer pending is skipped.
+func (t SvcTx) submitRefund(ctx context.Context, job submission) {
+	refund := job.refund
+
+	if ttl := t.pool.cfg.PendingTTL; ttl > 0 && time.Since(job.queuedAt) > ttl {
+		if err := t.refundTransition(ctx, refund, postgres.RefundFailed, "not sent to a gateway in time", ""); err != nil {
+			log.Printf("failed to fail refund %d: %v", refund.ID, err)
+		}
+		return
+	}
+
+	if current, err := t.db.GetRefund(ctx, refund.ID); err != nil {
+		log.Printf("failed to fetch status of refund %d, sending it: %v", refund.ID, err)
+	} else if current.Status != postgres.RefundPending {
+		log.Printf("refund %d is %s, not sending it", refund.ID, current.Status)
+		return
+	}
+
+	var res *adapter.Response
+	err := gatewaySendPolicy.Do(ctx, func(ctx context.Context) error {
+		var err error
+		res, err = job.iSvcGateway.SendRefundToGateway(ctx, job.tx, *refund)
+		return err
+	})
+	if err != nil {
+		log.Printf("gateway %d failed to accept refund %d of tx %d: %v", refund.GatewayID, refund.ID, refund.TransactionID, err)
+
+		if err = t.refundTransition(ctx, refund, postgres.RefundFailed, fmt.Sprintf("refused by gateway %d: %v", refund.GatewayID, err), ""); err != nil {
+			log.Printf("failed to update status of refund %d: %v", refund.ID, err)
+		}
+		return
+	}
+
+	if err = t.refundTransition(ctx, refund, postgres.RefundProcessing, fmt.Sprintf("accepted by gateway %d", refund.GatewayID), res.ProviderRef); err != nil {
+		log.Printf("failed to update status of refund %d: %v", refund.ID, err)
+	}
+}
+
+// Refunds returns the refunds of a tx, oldest first
+func (t SvcTx) Refunds(ctx context.Context, txID int64) ([]*postgres.Refund, error) {
+	if _, err := t.GetTransaction(ctx, txID); err != nil {
+		return nil, err
+	}
+
+	refunds, err := t.db.GetRefunds(ctx, txID)
+	if err != nil {
+		log.Printf("failed to fetch refunds of tx %d: %v", txID, err)
+
+		return nil, errors.New("failed to fetch refunds from database")
+	}
+
+	return refunds, nil
+}
+
+// processRefundCallback applies the status of a verified gateway callback to the refund it references, the refund
+// must have been sent to that gateway
+func (t SvcTx) processRefundCallback(ctx context.Context, gatewayID int, cb *adapter.Callback) error {
+	refund, err := t.db.GetRefund(ctx, cb.RefundID)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return err
+		}
+		log.Printf("failed to fetch refund %d: %v", cb.RefundID, err)
+
+		return errors.New("failed to fetch refund from database")
+	}
+
+	// a gateway may only report on its own refunds, others are answered as if they did not exist
+	if refund.TransactionID != cb.TransactionID || refund.GatewayID != gatewayID {
+		return fmt.Errorf("refund %d of transaction %d of gateway %d %w", cb.RefundID, cb.TransactionID, gatewayID, db.ErrNotFound)
+	}
+
+	// a refund is pending until sent, a gateway can only report it further along
+	if !IsValidRefundStatus(cb.Status) || cb.Status == postgres.RefundPending {
+		return newValidationError(fmt.Sprintf("status %q does not apply to a refund", cb.Status))
+	}
+
+	reason := fmt.Sprintf("callback from gateway %d: %s", gatewayID, cb.ProviderStatus)
+	err = t.refundTransition(ctx, refund, cb.Status, reason, cb.ProviderRef)
+
+	var transitionErr *IllegalTransitionError
+	if err == nil || errors.As(err, &transitionErr) {
+		return err
+	}
+
+	log.Printf("failed to update status of refund %d: %v", refund.ID, err)
+
+	return errors.New("failed to update refund status in database")
+}
+
+// refundTransition moves the refund to status if its current status allows it, like transition does for txs: the
+// matching event is written to the outbox and the matching ledger entry is posted along with the change. The tx is
+// refunded once its refunds completed for its whole amount, which is checked again when a completed refund is
+// reported completed again. Moving a refund to the status it already has is otherwise a no-op.
+func (t SvcTx) refundTransition(ctx context.Context, refund *postgres.Refund, status, reason, providerRef string) error {
+	var entry *postgres.JournalEntry
+	switch status {
+	case postgres.RefundCompleted:
+		entry = ledger.RefundEntry(refund)
+	case postgres.RefundFailed:
+		entry = ledger.RefundReleaseEntry(refund)
+	}
+
+	change := postgres.RefundStatusChange{RefundID: refund.ID, ToStatus: status, Reason: reason, ProviderRef: providerRef}
+	event, err := newRefundEvent(change, refund)
+	if err != nil {
+		return err
+	}
+
+	err = t.db.UpdateRefundStatus(ctx, &change, refundAllowedFrom(status), event, entry)
+	switch {
+	case errors.Is(err, db.ErrStatusConflict) && change.FromStatus != status:
+		return &IllegalTransitionError{TxID: refund.TransactionID, RefundID: refund.ID, From: change.FromStatus, To: status}
+	case errors.Is(err, db.ErrStatusConflict):
+		// the refund already had the status, the tx may not have been refunded in full yet
+	case err != nil:
+		return err
+	default:
+		refund.Status, refund.Reason, refund.UpdatedAt = status, reason, time.Now()
+		if providerRef != "" {
+			refund.ProviderRef = providerRef
+		}
+	}
+
+	if status != postgres.RefundCompleted {
+		return nil
+	}
+
+	return t.refundInFull(ctx, refund.TransactionID)
+}
+
+// refundInFull moves the tx to refunded if its completed refunds add up to its amount
+func (t SvcTx) refundInFull(ctx context.Context, txID int64) error {
+	tx, err := t.db.GetTransaction(ctx, txID)
+	if err != nil {
+		return err
+	}
+
+	refunds, err := t.db.GetRefunds(ctx, txID)
+	if err != nil {
+		return err
+	}
+
+	var refunded int64
+	for _, r := range refunds {
+		if r.Status == postgres.RefundCompleted {
+			refunded += r.Amount
+		}
+	}
+
+	if refunded < tx.Amount {
+		return nil
+	}
+
+	return t.transition(ctx, txID, StatusRefunded, "refunded in full", tx)
+}
+
+// newRefundEvent builds the outbox event announcing a status change of a refund, keyed by its tx
+func newRefundEvent(change postgres.RefundStatusChange, refund *postgres.Refund) (*postgres.OutboxEvent, error) {
+	eventType := "refund." + change.ToStatus
+
+	snapshot := *refund
+	snapshot.Status, snapshot.Reason = change.ToStatus, change.Reason
+	if change.ProviderRef != "" {
+		snapshot.ProviderRef = change.ProviderRef
+	}
+
+	payload, err := json.Marshal(common.RefundEvent{
+		EventType:     eventType,
+		TransactionID: refund.TransactionID,
+		RefundID:      refund.ID,
+		Status:        change.ToStatus,
+		Reason:        change.Reason,
+		OccurredAt:    time.Now(),
+		Refund:        &snapshot,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal refund event: %v", err)
+	}
+
+	return &postgres.OutboxEvent{
+		AggregateID: refund.TransactionID,
+		EventType:   eventType,
+		Payload:     payload,
+		ContentType: "application/json",
+	}, nil
+}
@@ -0,0 +1,243 @@
+package tx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"payment-gateway/db"
+	"payment-gateway/internal/models/postgres"
+	"payment-gateway/internal/models/request"
+	"payment-gateway/internal/money"
+	"payment-gateway/internal/services/gateway"
+	"payment-gateway/internal/services/gateway/adapter"
+	"testing"
+)
+
+// refundStore keeps the refunds of tx 1, a completed deposit of 100.50 EUR, in memory along with the ledger entries
+// posted for them
+type refundStore struct {
+	txStatus string
+	refunds  []*postgres.Refund
+	entries  []*postgres.JournalEntry
+}
+
+func newRefundMockDB(s *refundStore) *db.MockDB {
+	mockDB := newStatusMockDB(1, &s.txStatus)
+
+	mockDB.CreateRefundFunc = func(ctx context.Context, refund *postgres.Refund, hold func(*postgres.Refund) *postgres.JournalEntry) error {
+		refund.UserID, refund.GatewayID, refund.Currency = 7, 3, "EUR"
+
+		var refunded int64
+		for _, r := range s.refunds {
+			if r.Status != postgres.RefundFailed {
+				refunded += r.Amount
+			}
+		}
+		if refund.Amount == 0 {
+			refund.Amount = 10050 - refunded
+		}
+		if refund.Amount <= 0 || refunded+refund.Amount > 10050 {
+			return fmt.Errorf("%d already refunded: %w", refunded, db.ErrRefundExceedsAmount)
+		}
+
+		refund.ID = int64(len(s.refunds) + 1)
+		stored := *refund
+		s.refunds = append(s.refunds, &stored)
+		s.entries = append(s.entries, hold(refund))
+		return nil
+	}
+	mockDB.UpdateRefundStatusFunc = func(ctx context.Context, change *postgres.RefundStatusChange, allowedFrom []string, event *postgres.OutboxEvent, entry *postgres.JournalEntry) error {
+		r := s.refunds[change.RefundID-1]
+		change.FromStatus = r.Status
+		for _, from := range allowedFrom {
+			if from == r.Status {
+				r.Status = change.ToStatus
+				s.entries = append(s.entries, entry)
+				return nil
+			}
+		}
+
+		return fmt.Errorf("refund %d is %s: %w", r.ID, r.Status, db.ErrStatusConflict)
+	}
+	mockDB.GetRefundFunc = func(ctx context.Context, id int64) (*postgres.Refund, error) {
+		if id <= 0 || id > int64(len(s.refunds)) {
+			return nil, fmt.Errorf("refund %d %w", id, db.ErrNotFound)
+		}
+		r := *s.refunds[id-1]
+		return &r, nil
+	}
+	mockDB.GetRefundsFunc = func(ctx context.Context, txID int64) ([]*postgres.Refund, error) {
+		return s.refunds, nil
+	}
+
+	return mockDB
+}
+
+// acceptingGateway accepts every refund
+var acceptingGateway = &gateway.MockGatewayProcessor{
+	SendRefundToGatewayFunc: func(ctx context.Context, tx postgres.Transaction, refund postgres.Refund) (*adapter.Response, error) {
+		return &adapter.Response{ProviderRef: "refund-1", ProviderStatus: "accepted"}, nil
+	},
+}
+
+func TestCreateRefund(t *testing.T) {
+	s := &refundStore{txStatus: StatusCompleted}
+	svc := NewSvcTx(newRefundMockDB(s), testPool).(*SvcTx)
+
+	refund, err := svc.CreateRefund(context.Background(), 1, request.Refund{Amount: "25.00", Reason: "damaged item"}, acceptingGateway)
+	if err != nil {
+		t.Fatalf("expected the refund to be created, got %v", err)
+	}
+	if refund.Amount != 2500 || refund.Status != postgres.RefundPending {
+		t.Errorf("expected a pending refund of 2500, got %+v", refund)
+	}
+
+	// the refund is sent to the gateway by a worker
+	svc.work(context.Background(), nextSubmission(t, svc))
+	if s.refunds[0].Status != postgres.RefundProcessing {
+		t.Errorf("expected the refund accepted by the gateway to be processing, got %s", s.refunds[0].Status)
+	}
+	if s.entries[0].Reference != "refund:1:hold" || s.entries[1] != nil {
+		t.Errorf("expected the refund amount to be held until it completes, got %+v", s.entries)
+	}
+
+	var validationErr *ValidationError
+	if _, err = svc.CreateRefund(context.Background(), 1, request.Refund{Amount: "80.00"}, acceptingGateway); !errors.As(err, &validationErr) {
+		t.Errorf("expected refunds beyond the tx amount to be rejected, got %v", err)
+	}
+	if _, err = svc.CreateRefund(context.Background(), 1, request.Refund{Amount: "-1"}, acceptingGateway); !errors.As(err, &validationErr) {
+		t.Errorf("expected a negative amount to be rejected, got %v", err)
+	}
+
+	// without an amount the rest of the tx is refunded
+	refund, err = svc.CreateRefund(context.Background(), 1, request.Refund{}, acceptingGateway)
+	if err != nil || refund.Amount != 7550 {
+		t.Fatalf("expected a refund of the remaining 7550, got %+v: %v", refund, err)
+	}
+	svc.work(context.Background(), nextSubmission(t, svc))
+
+	if _, err = svc.CreateRefund(context.Background(), 2, request.Refund{}, acceptingGateway); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("expected an unknown tx to be not found, got %v", err)
+	}
+}
+
+func TestCreateRefund_NotCompleted(t *testing.T) {
+	s := &refundStore{txStatus: StatusProcessing}
+	svc := NewSvcTx(newRefundMockDB(s), testPool)
+
+	var transitionErr *IllegalTransitionError
+	if _, err := svc.CreateRefund(context.Background(), 1, request.Refund{}, acceptingGateway); !errors.As(err, &transitionErr) {
+		t.Errorf("expected a tx that did not complete to be refused a refund, got %v", err)
+	}
+	if len(s.refunds) != 0 {
+		t.Errorf("expected no refund to be created, got %d", len(s.refunds))
+	}
+}
+
+func TestCreateRefund_QueueFull(t *testing.T) {
+	s := &refundStore{txStatus: StatusCompleted}
+	svc := NewSvcTx(newRefundMockDB(s), PoolConfig{Workers: 1, QueueSize: 1})
+
+	if _, err := svc.CreateRefund(context.Background(), 1, request.Refund{Amount: "25.00"}, acceptingGateway); err != nil {
+		t.Fatalf("expected the refund to be queued, got %v", err)
+	}
+	if _, err := svc.CreateRefund(context.Background(), 1, request.Refund{Amount: "25.00"}, acceptingGateway); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("expected a full queue, got %v", err)
+	}
+	if len(s.refunds) != 1 {
+		t.Errorf("expected no refund to be created without room in the queue, got %d", len(s.refunds))
+	}
+}
+
+func TestCreateRefund_RefusedByGateway(t *testing.T) {
+	s := &refundStore{txStatus: StatusCompleted}
+	svc := NewSvcTx(newRefundMockDB(s), testPool).(*SvcTx)
+
+	refusing := &gateway.MockGatewayProcessor{
+		SendRefundToGatewayFunc: func(ctx context.Context, tx postgres.Transaction, refund postgres.Refund) (*adapter.Response, error) {
+			return nil, fmt.Errorf("refunds are %w", adapter.ErrNotSupported)
+		},
+	}
+
+	if _, err := svc.CreateRefund(context.Background(), 1, request.Refund{}, refusing); err != nil {
+		t.Fatalf("expected the refund to be created, got %v", err)
+	}
+	svc.work(context.Background(), nextSubmission(t, svc))
+	if s.refunds[0].Status != postgres.RefundFailed {
+		t.Fatalf("expected a failed refund, got %s", s.refunds[0].Status)
+	}
+	if len(s.entries) != 2 || s.entries[1].Reference != "refund:1:release" {
+		t.Errorf("expected the held amount to be released, got %+v", s.entries)
+	}
+
+	// a failed refund does not count towards the refunded amount
+	if refund, err := svc.CreateRefund(context.Background(), 1, request.Refund{}, acceptingGateway); err != nil || refund.Amount != 10050 {
+		t.Errorf("expected the whole amount to be refundable again, got %+v: %v", refund, err)
+	}
+}
+
+func TestProcessGatewayCallback_Refund(t *testing.T) {
+	s := &refundStore{txStatus: StatusCompleted}
+	svc := NewSvcTx(newRefundMockDB(s), testPool).(*SvcTx)
+
+	for _, amount := range []money.Decimal{"50.00", "50.50"} {
+		if _, err := svc.CreateRefund(context.Background(), 1, request.Refund{Amount: amount}, acceptingGateway); err != nil {
+			t.Fatalf("expected the refund to be created, got %v", err)
+		}
+		svc.work(context.Background(), nextSubmission(t, svc))
+	}
+
+	cb := &adapter.Callback{TransactionID: 1, RefundID: 1, ProviderStatus: "refunded", Status: postgres.RefundCompleted}
+	if err := svc.ProcessGatewayCallback(context.Background(), 3, cb); err != nil {
+		t.Fatalf("expected the refund to complete, got %v", err)
+	}
+	if s.refunds[0].Status != postgres.RefundCompleted || s.entries[len(s.entries)-1].Reference != "refund:1:completed" {
+		t.Errorf("expected a completed refund posted to the ledger, got %+v", s.refunds[0])
+	}
+	if s.txStatus != StatusCompleted {
+		t.Errorf("expected a partially refunded tx to stay completed, got %s", s.txStatus)
+	}
+
+	// another gateway cannot update the refund
+	cb = &adapter.Callback{TransactionID: 1, RefundID: 2, ProviderStatus: "refunded", Status: postgres.RefundCompleted}
+	if err := svc.ProcessGatewayCallback(context.Background(), 4, cb); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("expected a refund of another gateway to be not found, got %v", err)
+	}
+
+	if err := svc.ProcessGatewayCallback(context.Background(), 3, cb); err != nil {
+		t.Fatalf("expected the refund to complete, got %v", err)
+	}
+	if s.txStatus != StatusRefunded {
+		t.Errorf("expected the tx to be refunded once its refunds completed, got %s", s.txStatus)
+	}
+
+	cb = &adapter.Callback{TransactionID: 1, RefundID: 2, ProviderStatus: "failed", Status: postgres.RefundFailed}
+	var transitionErr *IllegalTransitionError
+	if err := svc.ProcessGatewayCallback(context.Background(), 3, cb); !errors.As(err, &transitionErr) || transitionErr.RefundID != 2 {
+		t.Errorf("expected an illegal transition of a completed refund, got %v", err)
+	}
+}
+
+func TestSubmitRefund_NotPending(t *testing.T) {
+	s := &refundStore{txStatus: StatusCompleted}
+	svc := NewSvcTx(newRefundMockDB(s), testPool).(*SvcTx)
+
+	var sent int
+	counting := &gateway.MockGatewayProcessor{
+		SendRefundToGatewayFunc: func(ctx context.Context, tx postgres.Transaction, refund postgres.Refund) (*adapter.Response, error) {
+			sent++
+			return &adapter.Response{ProviderRef: "refund-1", ProviderStatus: "accepted"}, nil
+		},
+	}
+
+	if _, err := svc.CreateRefund(context.Background(), 1, request.Refund{}, counting); err != nil {
+		t.Fatalf("expected the refund to be created, got %v", err)
+	}
+
+	// a refund failed while queued, e.g. by the sweep of stale pending refunds, is not sent
+	s.refunds[0].Status = postgres.RefundFailed
+	svc.work(context.Background(), nextSubmission(t, svc))
+	if sent != 0 || s.refunds[0].Status != postgres.RefundFailed {
+		t.Errorf("expected the failed refund not to be sent, got %d sends and status %s", sent, s.refunds[0].Status)
+	}
+}
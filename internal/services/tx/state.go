@@ -0,0 +1,186 @@
+package tx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"payment-gateway/db"
+	"payment-gateway/internal/models/common"
+	"payment-gateway/internal/models/postgres"
+	"payment-gateway/internal/services/ledger"
+	"sort"
+	"time"
+)
+
+// Transaction statuses. A tx starts pending, is processing once a gateway accepted it and ends up completed, failed,
+// cancelled or expired. A completed deposit is refunded once its refunds completed for its whole amount, the refunds
+// themselves have their own statuses.
+const (
+	StatusPending    = "pending"
+	StatusProcessing = "processing"
+	StatusCompleted  = "completed"
+	StatusFailed     = "failed"
+	StatusCancelled  = "cancelled"
+	StatusExpired    = "expired"
+	StatusRefunded   = "refunded"
+)
+
+// transitions lists the statuses a tx may move to from each status
+var transitions = map[string][]string{
+	StatusPending:    {StatusProcessing, StatusFailed, StatusCancelled, StatusExpired},
+	StatusProcessing: {StatusCompleted, StatusFailed, StatusCancelled, StatusExpired},
+	StatusCompleted:  {StatusRefunded},
+	StatusFailed:     nil,
+	StatusCancelled:  nil,
+	StatusExpired:    nil,
+	StatusRefunded:   nil,
+}
+
+// IllegalTransitionError is returned when a tx, or a refund of the tx when RefundID is set, cannot move from its
+// current status to the requested one
+type IllegalTransitionError struct {
+	TxID     int64
+	RefundID int64
+	From     string
+	To       string
+}
+
+func (e *IllegalTransitionError) Error() string {
+	if e.RefundID != 0 {
+		return fmt.Sprintf("illegal status transition of refund %d of tx %d from %s to %s", e.RefundID, e.TxID, e.From, e.To)
+	}
+
+	return fmt.Sprintf("illegal status transition of tx %d from %s to %s", e.TxID, e.From, e.To)
+}
+
+// IsValidStatus tells whether status is a known tx status
+func IsValidStatus(status string) bool {
+	_, ok := transitions[status]
+	return ok
+}
+
+// isReportableStatus tells whether a gateway callback may move a tx to status. A tx is only refunded through its
+// refunds, once they completed for its whole amount (see refundInFull), as only those reverse it in the ledger.
+func isReportableStatus(status string) bool {
+	return IsValidStatus(status) && status != StatusRefunded
+}
+
+// CanTransition tells whether a tx may move from one status to the other
+func CanTransition(from, to string) bool {
+	for _, s := range transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// allowedFrom returns the statuses a tx may move to status from
+func allowedFrom(status string) []string {
+	var from []string
+	for s := range transitions {
+		if CanTransition(s, status) {
+			from = append(from, s)
+		}
+	}
+	sort.Strings(from)
+
+	return from
+}
+
+// transition moves the tx to status if its current status allows it. The check and the update are a single
+// conditional update, so concurrent changes (e.g. a callback racing a timeout) cannot both win. The matching event is
+// written to the outbox along with the change, snapshot is the tx to include in it if known, and the matching ledger
+// entry is posted. Moving a tx to the status it already has is a no-op.
+func (t SvcTx) transition(ctx context.Context, txID int64, status, reason string, snapshot *postgres.Transaction) error {
+	if !IsValidStatus(status) {
+		return newValidationError(fmt.Sprintf("unknown status %q", status))
+	}
+
+	// the ledger entry of the change needs the tx as stored
+	var entry *postgres.JournalEntry
+	var err error
+	if postsToLedger(status) {
+		if snapshot == nil {
+			if snapshot, err = t.db.GetTransaction(ctx, txID); err != nil {
+				return err
+			}
+		}
+
+		if entry, err = ledgerEntry(snapshot, status); err != nil {
+			return err
+		}
+	}
+
+	change := postgres.TransactionStatusChange{TransactionID: txID, ToStatus: status, Reason: reason}
+	event, err := newStatusEvent(change, snapshot)
+	if err != nil {
+		return err
+	}
+
+	err = t.db.UpdateTxStatus(ctx, &change, allowedFrom(status), event, entry)
+	if errors.Is(err, db.ErrStatusConflict) {
+		if change.FromStatus == status {
+			return nil
+		}
+
+		return &IllegalTransitionError{TxID: txID, From: change.FromStatus, To: status}
+	}
+
+	return err
+}
+
+// postsToLedger tells whether moving a tx to status may post an entry to the ledger
+func postsToLedger(status string) bool {
+	switch status {
+	case StatusCompleted, StatusFailed, StatusCancelled, StatusExpired:
+		return true
+	default:
+		return false
+	}
+}
+
+// ledgerEntry returns the ledger entry posted when the tx moves to status, if any: a completed tx is posted and the
+// funds held for a withdrawal that won't complete are given back to the user
+func ledgerEntry(tx *postgres.Transaction, status string) (*postgres.JournalEntry, error) {
+	switch {
+	case status == StatusCompleted:
+		return ledger.TransactionEntry(tx)
+	case tx.Type == "withdrawal" && postsToLedger(status):
+		return ledger.ReleaseEntry(tx), nil
+	default:
+		return nil, nil
+	}
+}
+
+// newStatusEvent builds the outbox event announcing a status change
+func newStatusEvent(change postgres.TransactionStatusChange, snapshot *postgres.Transaction) (*postgres.OutboxEvent, error) {
+	eventType := "transaction." + change.ToStatus
+
+	if snapshot != nil {
+		tx := *snapshot
+		tx.Status = change.ToStatus
+		snapshot = &tx
+	}
+
+	payload, err := json.Marshal(common.TransactionEvent{
+		EventType:     eventType,
+		TransactionID: change.TransactionID,
+		Status:        change.ToStatus,
+		Reason:        change.Reason,
+		OccurredAt:    time.Now(),
+		Transaction:   snapshot,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal tx event: %v", err)
+	}
+
+	return &postgres.OutboxEvent{
+		AggregateID: change.TransactionID,
+		EventType:   eventType,
+		Payload:     payload,
+		ContentType: "application/json",
+	}, nil
+}
@@ -0,0 +1,196 @@
+package tx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"payment-gateway/db"
+	"payment-gateway/internal/models/postgres"
+	"payment-gateway/internal/services/gateway/adapter"
+	"strings"
+	"testing"
+)
+
+func TestCanTransition(t *testing.T) {
+	cases := []struct {
+		from, to string
+		want     bool
+	}{
+		{StatusPending, StatusProcessing, true},
+		{StatusProcessing, StatusCompleted, true},
+		{StatusProcessing, StatusFailed, true},
+		{StatusCompleted, StatusRefunded, true},
+		{StatusCompleted, StatusPending, false},
+		{StatusFailed, StatusCompleted, false},
+		{StatusPending, StatusRefunded, false},
+		{StatusRefunded, StatusCompleted, false},
+		{StatusCompleted, "refund_pending", false},
+	}
+
+	for _, c := range cases {
+		if got := CanTransition(c.from, c.to); got != c.want {
+			t.Errorf("CanTransition(%s, %s) = %v, want %v", c.from, c.to, got, c.want)
+		}
+	}
+}
+
+// newStatusMockDB keeps the status of a single tx in memory and applies conditional updates like the database does
+func newStatusMockDB(txID int64, status *string) *db.MockDB {
+	return &db.MockDB{
+		UpdateTxStatusFunc: func(ctx context.Context, change *postgres.TransactionStatusChange, allowedFrom []string, event *postgres.OutboxEvent, entry *postgres.JournalEntry) error {
+			if change.TransactionID != txID {
+				return fmt.Errorf("transaction %d %w", change.TransactionID, db.ErrNotFound)
+			}
+
+			change.FromStatus = *status
+			for _, s := range allowedFrom {
+				if s == *status {
+					*status = change.ToStatus
+					return nil
+				}
+			}
+
+			return fmt.Errorf("transaction %d is %s: %w", txID, *status, db.ErrStatusConflict)
+		},
+		GetTransactionFunc: func(ctx context.Context, id int64) (*postgres.Transaction, error) {
+			if id != txID {
+				return nil, fmt.Errorf("transaction %d %w", id, db.ErrNotFound)
+			}
+			return &postgres.Transaction{ID: id, Type: "deposit", Amount: 10050, Currency: "EUR", UserID: 7, GatewayID: 3, Status: *status}, nil
+		},
+	}
+}
+
+func TestProcessCallBack(t *testing.T) {
+	status := StatusProcessing
+	svc := NewSvcTx(newStatusMockDB(1, &status), testPool)
+
+	if err := svc.ProcessCallBack(context.Background(), 1, StatusCompleted); err != nil || status != StatusCompleted {
+		t.Fatalf("expected tx to be completed, got %s: %v", status, err)
+	}
+
+	// a duplicate callback is a no-op
+	if err := svc.ProcessCallBack(context.Background(), 1, StatusCompleted); err != nil {
+		t.Errorf("expected a repeated status to be accepted, got %v", err)
+	}
+
+	var transitionErr *IllegalTransitionError
+	if err := svc.ProcessCallBack(context.Background(), 1, StatusPending); !errors.As(err, &transitionErr) || transitionErr.From != StatusCompleted {
+		t.Errorf("expected an illegal transition from completed, got %v", err)
+	}
+
+	var validationErr *ValidationError
+	if err := svc.ProcessCallBack(context.Background(), 1, "complete"); !errors.As(err, &validationErr) {
+		t.Errorf("expected an unknown status to be rejected, got %v", err)
+	}
+
+	if err := svc.ProcessCallBack(context.Background(), 2, StatusCompleted); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("expected an unknown tx to be not found, got %v", err)
+	}
+
+	if status != StatusCompleted {
+		t.Errorf("expected tx to stay completed, got %s", status)
+	}
+}
+
+func TestProcessGatewayCallback(t *testing.T) {
+	status := StatusProcessing
+	var reason string
+	mockDB := newStatusMockDB(1, &status)
+	update := mockDB.UpdateTxStatusFunc
+	mockDB.UpdateTxStatusFunc = func(ctx context.Context, change *postgres.TransactionStatusChange, allowedFrom []string, event *postgres.OutboxEvent, entry *postgres.JournalEntry) error {
+		reason = change.Reason
+		return update(ctx, change, allowedFrom, event, entry)
+	}
+	mockDB.GetTransactionFunc = func(ctx context.Context, id int64) (*postgres.Transaction, error) {
+		if id != 1 {
+			return nil, fmt.Errorf("transaction %d %w", id, db.ErrNotFound)
+		}
+		return &postgres.Transaction{ID: 1, Type: "deposit", Amount: 10050, Currency: "EUR", GatewayID: 3, Status: status}, nil
+	}
+	svc := NewSvcTx(mockDB, testPool)
+
+	cb := &adapter.Callback{TransactionID: 1, ProviderStatus: "SETTLED", Status: StatusCompleted}
+	if err := svc.ProcessGatewayCallback(context.Background(), 3, cb); err != nil || status != StatusCompleted {
+		t.Fatalf("expected tx to be completed, got %s: %v", status, err)
+	}
+	if !strings.Contains(reason, "gateway 3: SETTLED") {
+		t.Errorf("expected the reason to name the gateway and provider status, got %q", reason)
+	}
+
+	// another gateway cannot update the tx
+	cb = &adapter.Callback{TransactionID: 1, ProviderStatus: "PENDING", Status: StatusProcessing}
+	if err := svc.ProcessGatewayCallback(context.Background(), 4, cb); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("expected a tx of another gateway to be not found, got %v", err)
+	}
+
+	// a tx is only refunded through its refunds
+	cb = &adapter.Callback{TransactionID: 1, ProviderStatus: "refunded", Status: StatusRefunded}
+	var validationErr *ValidationError
+	if err := svc.ProcessGatewayCallback(context.Background(), 3, cb); !errors.As(err, &validationErr) || status != StatusCompleted {
+		t.Errorf("expected a refunded callback to be rejected, got %s: %v", status, err)
+	}
+	if err := svc.ProcessCallBack(context.Background(), 1, StatusRefunded); !errors.As(err, &validationErr) || status != StatusCompleted {
+		t.Errorf("expected a refunded callback to be rejected, got %s: %v", status, err)
+	}
+
+	cb = &adapter.Callback{TransactionID: 1, ProviderStatus: "PENDING", Status: StatusProcessing}
+	var transitionErr *IllegalTransitionError
+	if err := svc.ProcessGatewayCallback(context.Background(), 3, cb); !errors.As(err, &transitionErr) {
+		t.Errorf("expected an illegal transition from completed, got %v", err)
+	}
+
+	cb = &adapter.Callback{TransactionID: 2, ProviderStatus: "SETTLED", Status: StatusCompleted}
+	if err := svc.ProcessGatewayCallback(context.Background(), 3, cb); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("expected an unknown tx to be not found, got %v", err)
+	}
+}
+
+func TestCompletionPostsLedgerEntry(t *testing.T) {
+	status := StatusProcessing
+	var entries []*postgres.JournalEntry
+	mockDB := newStatusMockDB(1, &status)
+	update := mockDB.UpdateTxStatusFunc
+	mockDB.UpdateTxStatusFunc = func(ctx context.Context, change *postgres.TransactionStatusChange, allowedFrom []string, event *postgres.OutboxEvent, entry *postgres.JournalEntry) error {
+		entries = append(entries, entry)
+		return update(ctx, change, allowedFrom, event, entry)
+	}
+	svc := NewSvcTx(mockDB, testPool)
+
+	if err := svc.ProcessCallBack(context.Background(), 1, StatusCompleted); err != nil {
+		t.Fatalf("expected tx to be completed, got %v", err)
+	}
+	if entries[0] == nil || entries[0].Reference != "transaction:1:completed" || entries[0].Postings[0].Amount != 10050 {
+		t.Errorf("expected the completion to be posted to the ledger, got %+v", entries[0])
+	}
+
+	status = StatusProcessing
+	if err := svc.ProcessCallBack(context.Background(), 1, StatusFailed); err != nil {
+		t.Fatalf("expected tx to fail, got %v", err)
+	}
+	if entries[1] != nil {
+		t.Errorf("expected no ledger entry for a failed tx, got %+v", entries[1])
+	}
+}
+
+func TestUnfinishedWithdrawalReleasesHold(t *testing.T) {
+	for _, status := range []string{StatusFailed, StatusCancelled, StatusExpired} {
+		current := StatusProcessing
+		var entry *postgres.JournalEntry
+		mockDB := newStatusMockDB(1, &current)
+		update := mockDB.UpdateTxStatusFunc
+		mockDB.UpdateTxStatusFunc = func(ctx context.Context, change *postgres.TransactionStatusChange, allowedFrom []string, event *postgres.OutboxEvent, e *postgres.JournalEntry) error {
+			entry = e
+			return update(ctx, change, allowedFrom, event, e)
+		}
+		svc := NewSvcTx(mockDB, testPool).(*SvcTx)
+
+		withdrawal := &postgres.Transaction{ID: 1, Type: "withdrawal", Amount: 5000, Currency: "EUR", UserID: 7, Status: current}
+		if err := svc.transition(context.Background(), 1, status, "test", withdrawal); err != nil {
+			t.Fatalf("expected the withdrawal to be %s, got %v", status, err)
+		}
+		if entry == nil || entry.Reference != "transaction:1:release" {
+			t.Errorf("expected a %s withdrawal to release its hold, got %+v", status, entry)
+		}
+	}
+}
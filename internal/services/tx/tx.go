@@ -2,116 +2,315 @@ package tx
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"payment-gateway/db"
-	"payment-gateway/internal/kafka"
+	"payment-gateway/internal/models/common"
 	"payment-gateway/internal/models/postgres"
 	"payment-gateway/internal/models/request"
 	"payment-gateway/internal/models/response"
+	"payment-gateway/internal/money"
+	"payment-gateway/internal/retry"
 	svcGateway "payment-gateway/internal/services/gateway"
-	"payment-gateway/internal/util"
+	"payment-gateway/internal/services/gateway/adapter"
+	"payment-gateway/internal/services/ledger"
+	"strconv"
 	"time"
 )
 
+// gatewaySendPolicy controls the tries against a single gateway before failing over to the next one. Rejections and
+// open circuit breakers fail over straight away.
+var gatewaySendPolicy = retry.Policy{
+	MaxAttempts:     3,
+	InitialInterval: 500 * time.Millisecond,
+	MaxInterval:     5 * time.Second,
+	Multiplier:      2,
+	Jitter:          0.2,
+	MaxElapsedTime:  30 * time.Second,
+	Retryable:       svcGateway.IsRetryableSendError,
+}
+
 type (
 	SvcTx struct {
-		db            db.Idb
-		kafkaProducer kafka.IProducer
+		db   db.Idb
+		pool *workerPool
 	}
 
 	ISvcTx interface {
-		ProcessTransaction(req request.Transaction, iSvcGateway svcGateway.ISvcGateway, transactionType string) (response.APIResponse, error)
-		ProcessCallBack(txId int64, status string) error
+		ProcessTransaction(ctx context.Context, req request.Transaction, iSvcGateway svcGateway.ISvcGateway, transactionType string) (response.APIResponse, error)
+		ProcessCallBack(ctx context.Context, txId int64, status string) error
+		ProcessGatewayCallback(ctx context.Context, gatewayID int, cb *adapter.Callback) error
+		GetTransaction(ctx context.Context, id int64) (*postgres.Transaction, error)
+		ListTransactions(ctx context.Context, filter common.TransactionFilter, cursor string) (*TransactionPage, error)
+		CreateRefund(ctx context.Context, txID int64, req request.Refund, iSvcGateway svcGateway.ISvcGateway) (*postgres.Refund, error)
+		Refunds(ctx context.Context, txID int64) ([]*postgres.Refund, error)
+		CancelTransaction(ctx context.Context, txID int64, reason string, iSvcGateway svcGateway.ISvcGateway) (*postgres.Transaction, error)
+		RunWorkers(ctx context.Context, iSvcGateway svcGateway.ISvcGateway)
 	}
 )
 
-func NewSvcTx(db db.Idb, kafkaProducer kafka.IProducer) ISvcTx {
-	return &SvcTx{db: db, kafkaProducer: kafkaProducer}
+func NewSvcTx(db db.Idb, cfg PoolConfig) ISvcTx {
+	return &SvcTx{db: db, pool: newWorkerPool(cfg)}
 }
 
-// ProcessTransaction handles deposit or withdrawal transactions.
-func (t SvcTx) ProcessTransaction(req request.Transaction, iSvcGateway svcGateway.ISvcGateway, transactionType string) (response.APIResponse, error) {
-	if req.Amount <= 0 {
-		return response.APIResponse{}, errors.New("invalid amount, must be greater than zero")
+// ProcessTransaction handles deposit or withdrawal transactions. The tx is stored and queued for the workers, which
+// send it to the gateways, so the response only tells the tx was accepted. ErrQueueFull is returned when the queue
+// is full, and a RoutingError when no gateway could be selected for the stored tx, which is then failed.
+func (t SvcTx) ProcessTransaction(ctx context.Context, req request.Transaction, iSvcGateway svcGateway.ISvcGateway, transactionType string) (response.APIResponse, error) {
+	if r, err := req.Amount.Rat(); err != nil || r.Sign() <= 0 {
+		return response.APIResponse{}, newValidationError("invalid amount, must be greater than zero")
 	}
 
 	if req.UserID <= 0 {
-		return response.APIResponse{}, errors.New("invalid user_id, must be a positive integer")
+		return response.APIResponse{}, newValidationError("invalid user_id, must be a positive integer")
 	}
 
-	// Step 1: select gateway dynamically based on country_id
-	gateway, err := iSvcGateway.SelectGateway(req.CountryID)
+	currency, err := t.validateCurrency(ctx, req.Currency, req.CountryID)
 	if err != nil {
 		return response.APIResponse{}, err
 	}
 
-	// Step 2: prepare tx data for processing
+	// amounts are kept in minor units from here on, so they must be exactly representable in the currency
+	amount, err := money.FromDecimal(req.Amount, currency)
+	if err != nil {
+		return response.APIResponse{}, newValidationError(fmt.Sprintf("invalid amount: %v", err))
+	}
+
+	// Step 1: prepare tx data for processing
 	tx := postgres.Transaction{
 		UserID:    req.UserID,
-		Amount:    req.Amount,
-		GatewayID: gateway.ID,
+		Amount:    amount.Amount,
+		Currency:  currency,
 		CountryID: req.CountryID,
-		Status:    "pending",
+		Status:    StatusPending,
 		Type:      transactionType,
 	}
 
-	// Step 3: save tx to the database
-	err = t.db.CreateTransaction(&tx)
-	if err != nil {
-		return response.APIResponse{}, errors.New("failed to save tx to database")
+	// Step 2: save tx to the database once it is sure to fit in the queue, the gateway is assigned once it accepts
+	// the tx
+	if !t.pool.reserve() {
+		return response.APIResponse{}, ErrQueueFull
 	}
 
-	// Step 4: send tx to selected gateway using retry mechanism
-	if err = util.RetryOperation(func() error {
-		_, err = iSvcGateway.SendTxToGateway(tx)
-		return err
-	}, 5); err != nil {
-
-		if err = t.db.UpdateTxStatus(tx.ID, "failed"); err != nil {
-			return response.APIResponse{}, errors.New("failed to update tx status to db")
-		}
-
-		return response.APIResponse{}, errors.New("failed to send tx to gateway")
+	// a withdrawal holds its amount from the user's available balance until it completes or fails
+	var hold func(tx *postgres.Transaction) *postgres.JournalEntry
+	if transactionType == "withdrawal" {
+		hold = ledger.HoldEntry
 	}
 
-	// Step 5: publish the tx to Kafka with Circuit Breaker
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	txMsg, err := json.Marshal(tx)
+	err = t.db.CreateTransaction(ctx, &tx, hold)
 	if err != nil {
-		log.Printf("failed to marshal tx for Kafka: %v", err)
+		t.pool.release()
 
-		return response.APIResponse{}, errors.New("failed to marshal tx")
+		if errors.Is(err, db.ErrInsufficientFunds) {
+			return response.APIResponse{}, newValidationError(fmt.Sprintf("insufficient funds, the available %s balance is lower than the amount", tx.Currency))
+		}
+		log.Printf("failed to save tx: %v", err)
+
+		return response.APIResponse{}, errors.New("failed to save tx to database")
 	}
 
-	dataFormat := "application/json" // Defaulting to JSON format
-	err = t.kafkaProducer.PubTx(ctx, tx.ID, txMsg, dataFormat)
+	// Step 3: select gateways dynamically based on routing rules and country_id, the first one is preferred and the
+	// rest are failovers
+	gateways, err := iSvcGateway.SelectGateways(ctx, routeRequest(&tx))
 	if err != nil {
-		log.Printf("failed to publish tx to Kafka: %v", err)
+		t.pool.release()
 
-		return response.APIResponse{}, errors.New("failed to publish tx to Kafka")
+		// the tx is marked failed even if the client went away meanwhile
+		if updateErr := t.transition(context.Background(), tx.ID, StatusFailed, "no gateway selected", &tx); updateErr != nil {
+			log.Printf("failed to update status of tx %d: %v", tx.ID, updateErr)
+		}
+
+		return response.APIResponse{}, &RoutingError{TxID: tx.ID, Err: err}
 	}
 
-	// Step 6: Prepare and return response
+	// Step 4: queue the tx for the workers
+	t.pool.enqueue(submission{tx: tx, gateways: gateways, iSvcGateway: iSvcGateway})
+
+	// Step 5: Prepare and return response
 	return response.APIResponse{
-		StatusCode: http.StatusOK,
-		Message:    "tx under processing",
+		StatusCode: http.StatusAccepted,
+		Message:    "tx accepted for processing",
 		Data: map[string]interface{}{
 			"transaction_id": tx.ID,
-			"gateway_id":     tx.GatewayID,
+			"amount":         tx.Money().Decimal(),
+			"currency":       tx.Currency,
 			"status":         tx.Status,
 		},
 	}, nil
 }
 
-func (t SvcTx) ProcessCallBack(txId int64, status string) error {
-	if err := t.db.UpdateTxStatus(txId, status); err != nil {
-		return errors.New("failed to update transaction status in database")
+// validateCurrency normalizes the currency and checks it is an ISO 4217 code allowed in the country
+func (t SvcTx) validateCurrency(ctx context.Context, currency string, countryID int) (string, error) {
+	currency = money.NormalizeCurrency(currency)
+	if !money.IsValidCurrency(currency) {
+		return "", newValidationError("invalid currency, must be an ISO 4217 code")
+	}
+
+	allowed, err := t.db.GetCountryCurrencies(ctx, countryID)
+	if errors.Is(err, db.ErrNotFound) {
+		return "", newValidationError("invalid country_id, country not found")
+	}
+	if err != nil {
+		log.Printf("failed to fetch currencies of country %d: %v", countryID, err)
+
+		return "", errors.New("failed to fetch country currencies")
+	}
+
+	for _, c := range allowed {
+		if money.NormalizeCurrency(c) == currency {
+			return currency, nil
+		}
+	}
+
+	return "", newValidationError(fmt.Sprintf("currency %s is not allowed in country %d", currency, countryID))
+}
+
+// routeRequest is what gateway selection knows about a stored tx, the tx ID is the routing key so that weighted splits
+// are reproducible per tx
+func routeRequest(tx *postgres.Transaction) common.RouteRequest {
+	return common.RouteRequest{
+		CountryID:       tx.CountryID,
+		Currency:        tx.Currency,
+		Amount:          tx.Amount,
+		TransactionType: tx.Type,
+		UserID:          tx.UserID,
+		RoutingKey:      strconv.FormatInt(tx.ID, 10),
+	}
+}
+
+// sendWithFailover sends the tx to each gateway in turn, retrying every gateway before cascading to the next one. A
+// gateway declining the tx, even with a 2xx response, is not retried and the next one is tried. Every attempt is
+// recorded and tx.GatewayID is updated to the gateway that accepted the tx.
+func (t SvcTx) sendWithFailover(ctx context.Context, tx *postgres.Transaction, gateways []*common.Gateway, iSvcGateway svcGateway.ISvcGateway) (*adapter.Response, error) {
+	var lastErr error
+	for _, gateway := range gateways {
+		tx.GatewayID = gateway.ID
+
+		var gatewayRes *adapter.Response
+		err := gatewaySendPolicy.Do(ctx, func(ctx context.Context) error {
+			var err error
+			start := time.Now()
+			gatewayRes, err = iSvcGateway.SendTxToGateway(ctx, *tx)
+			if err == nil {
+				err = checkAccepted(gatewayRes)
+			}
+			t.recordAttempt(ctx, *tx, gatewayRes, err, time.Since(start))
+
+			if err != nil {
+				lastErr = err
+			}
+			return err
+		})
+		if err != nil {
+			log.Printf("gateway %s failed to accept tx %d, failing over: %v", gateway.Name, tx.ID, lastErr)
+			continue
+		}
+
+		tx.ProviderRef = gatewayRes.ProviderRef
+		if gateway.ExpectedFee != nil {
+			tx.Fee = *gateway.ExpectedFee
+		}
+
+		if err = t.db.UpdateTxGateway(ctx, tx); err != nil {
+			log.Printf("failed to update gateway of tx %d: %v", tx.ID, err)
+		}
+
+		return gatewayRes, nil
+	}
+
+	return nil, fmt.Errorf("all gateways failed, last error: %v", lastErr)
+}
+
+// checkAccepted returns a permanent error when the provider answered but did not accept the tx: it declined it, or
+// answered with a status it is not known to use, which can't be taken for an acceptance
+func checkAccepted(res *adapter.Response) error {
+	switch res.Status {
+	case StatusPending, StatusProcessing, StatusCompleted:
+		return nil
+	case "":
+		return retry.Permanent(fmt.Errorf("gateway answered with unknown status %q", res.ProviderStatus))
+	default:
+		return retry.Permanent(fmt.Errorf("gateway declined the tx with status %q", res.ProviderStatus))
+	}
+}
+
+// recordAttempt stores the outcome of a single send to a gateway, a failure to store it does not fail the tx
+func (t SvcTx) recordAttempt(ctx context.Context, tx postgres.Transaction, gatewayRes *adapter.Response, sendErr error, latency time.Duration) {
+	attempt := postgres.TransactionAttempt{
+		TransactionID: tx.ID,
+		GatewayID:     tx.GatewayID,
+		LatencyMs:     latency.Milliseconds(),
+	}
+	if sendErr != nil {
+		attempt.Error = sendErr.Error()
+	}
+	if gatewayRes != nil {
+		attempt.ProviderRef = gatewayRes.ProviderRef
+	}
+
+	if err := t.db.CreateTxAttempt(ctx, &attempt); err != nil {
+		log.Printf("failed to record attempt of tx %d on gateway %d: %v", tx.ID, tx.GatewayID, err)
+	}
+}
+
+// ProcessCallBack applies the status reported by a gateway, unknown statuses and illegal transitions are rejected
+func (t SvcTx) ProcessCallBack(ctx context.Context, txId int64, status string) error {
+	if IsValidStatus(status) && !isReportableStatus(status) {
+		return newValidationError(fmt.Sprintf("status %q cannot be reported by a gateway", status))
+	}
+
+	err := t.transition(ctx, txId, status, "gateway callback", nil)
+
+	var validationErr *ValidationError
+	var transitionErr *IllegalTransitionError
+	if err == nil || errors.As(err, &validationErr) || errors.As(err, &transitionErr) || errors.Is(err, db.ErrNotFound) {
+		return err
 	}
 
-	return nil
+	log.Printf("failed to update status of tx %d: %v", txId, err)
+
+	return errors.New("failed to update transaction status in database")
+}
+
+// ProcessGatewayCallback applies the status of a verified gateway callback, the tx must have been sent to that gateway.
+// The callbacks of a refund are applied to the refund.
+func (t SvcTx) ProcessGatewayCallback(ctx context.Context, gatewayID int, cb *adapter.Callback) error {
+	if cb.RefundID != 0 {
+		return t.processRefundCallback(ctx, gatewayID, cb)
+	}
+
+	tx, err := t.db.GetTransaction(ctx, cb.TransactionID)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return err
+		}
+		log.Printf("failed to fetch tx %d: %v", cb.TransactionID, err)
+
+		return errors.New("failed to fetch transaction from database")
+	}
+
+	// a gateway may only report on its own txs, others are answered as if they did not exist
+	if tx.GatewayID != gatewayID {
+		return fmt.Errorf("transaction %d of gateway %d %w", cb.TransactionID, gatewayID, db.ErrNotFound)
+	}
+
+	if !isReportableStatus(cb.Status) {
+		return newValidationError(fmt.Sprintf("status %q cannot be reported by a gateway", cb.Status))
+	}
+
+	reason := fmt.Sprintf("callback from gateway %d: %s", gatewayID, cb.ProviderStatus)
+	err = t.transition(ctx, cb.TransactionID, cb.Status, reason, tx)
+
+	var validationErr *ValidationError
+	var transitionErr *IllegalTransitionError
+	if err == nil || errors.As(err, &validationErr) || errors.As(err, &transitionErr) {
+		return err
+	}
+
+	log.Printf("failed to update status of tx %d: %v", cb.TransactionID, err)
+
+	return errors.New("failed to update transaction status in database")
 }
@@ -1,36 +1,97 @@
 package tx
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"payment-gateway/db"
-	"payment-gateway/internal/kafka"
 	"payment-gateway/internal/models/common"
 	"payment-gateway/internal/models/postgres"
 	"payment-gateway/internal/models/request"
+	"payment-gateway/internal/money"
+	"payment-gateway/internal/retry"
 	"payment-gateway/internal/services/gateway"
+	"payment-gateway/internal/services/gateway/adapter"
+	"strings"
 	"testing"
+	"time"
 )
 
+// testPool is the worker pool of the tx services under test, their submissions are run by the tests
+var testPool = PoolConfig{Workers: 1, QueueSize: 10}
+
+// nextSubmission returns the submission queued by the last call to ProcessTransaction
+func nextSubmission(t *testing.T, svc *SvcTx) submission {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	job, ok := svc.pool.next(ctx)
+	if !ok {
+		t.Fatalf("expected a queued submission")
+	}
+
+	return job
+}
+
+// newMockPSP starts a stand-in provider that accepts every transaction
+func newMockPSP(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"psp-1","status":"accepted"}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
 func TestProcessTransaction_Success(t *testing.T) {
+	psp := newMockPSP(t)
+
+	var events []*postgres.OutboxEvent
+
 	// Mock database implementation
 	mockDB := &db.MockDB{
-		GetSupportedGatewaysByCountryFunc: func(countryID int) ([]*common.Gateway, error) {
+		GetCountryCurrenciesFunc: func(ctx context.Context, countryID int) ([]string, error) {
+			return []string{"USD"}, nil
+		},
+		GetSupportedGatewaysByCountryFunc: func(ctx context.Context, countryID int) ([]*common.Gateway, error) {
 			return []*common.Gateway{
 				{ID: 1, Name: "Mock Gateway", Priority: 1},
 			}, nil
 		},
-		CreateTransactionFunc: func(tx *postgres.Transaction) error {
+		GetRoutingRulesFunc: func(ctx context.Context) ([]*common.RoutingRule, error) {
+			return nil, nil
+		},
+		GetGatewayFeesFunc: func(ctx context.Context, gatewayIDs []int) ([]*common.FeeSchedule, error) {
+			return nil, nil
+		},
+		GetGatewayByIDFunc: func(ctx context.Context, gatewayID int) (*common.Gateway, error) {
+			return &common.Gateway{ID: gatewayID, Name: "Mock Gateway", DataFormatSupported: "application/json", EndpointURL: psp.URL}, nil
+		},
+		CreateTransactionFunc: func(ctx context.Context, tx *postgres.Transaction, hold func(tx *postgres.Transaction) *postgres.JournalEntry) error {
 			tx.ID = 12345
 			return nil
 		},
-		UpdateTxStatusFunc: func(txID int64, status string) error {
+		ClaimTransactionFunc: func(ctx context.Context, id int64) (bool, error) {
+			return true, nil
+		},
+		UpdateTxStatusFunc: func(ctx context.Context, change *postgres.TransactionStatusChange, allowedFrom []string, event *postgres.OutboxEvent, entry *postgres.JournalEntry) error {
+			events = append(events, event)
+			return nil
+		},
+		UpdateTxGatewayFunc: func(ctx context.Context, tx *postgres.Transaction) error {
+			return nil
+		},
+		CreateTxAttemptFunc: func(ctx context.Context, attempt *postgres.TransactionAttempt) error {
 			return nil
 		},
 	}
 
 	// Test data
 	requestPayload := request.Transaction{
-		Amount:    100.0,
+		Amount:    "100.00",
 		UserID:    1,
 		GatewayID: 101,
 		CountryID: 840,
@@ -38,20 +99,29 @@ func TestProcessTransaction_Success(t *testing.T) {
 	}
 
 	// Call the function
-	response, err := NewSvcTx(mockDB, &kafka.MockKafkaProducer{}).ProcessTransaction(requestPayload, gateway.NewSvcGateway(mockDB), "deposit")
+	svc := NewSvcTx(mockDB, testPool).(*SvcTx)
+	response, err := svc.ProcessTransaction(context.Background(), requestPayload, gateway.NewSvcGateway(mockDB, time.Second), "deposit")
 	if err != nil {
 		t.Fatalf("expected success, got error: %v", err)
 	}
 
 	// Assertions
-	if response.StatusCode != 200 {
-		t.Errorf("expected status code 200, got %d", response.StatusCode)
+	if response.StatusCode != http.StatusAccepted {
+		t.Errorf("expected status code 202, got %d", response.StatusCode)
 	}
-	if response.Message != "tx under processing" {
+	if response.Message != "tx accepted for processing" {
 		t.Errorf("unexpected message: %s", response.Message)
 	}
-	if response.Data["transaction_id"] != int64(12345) {
-		t.Errorf("unexpected transaction ID: %v", response.Data["transaction_id"])
+	if response.Data["transaction_id"] != int64(12345) || response.Data["status"] != StatusPending {
+		t.Errorf("unexpected response data: %v", response.Data)
+	}
+
+	// the tx is sent to the gateway by a worker
+	svc.submit(context.Background(), nextSubmission(t, svc))
+
+	// the event is written to the outbox with the status change instead of being published directly
+	if len(events) != 1 || events[0].EventType != "transaction.processing" || events[0].AggregateID != 12345 {
+		t.Errorf("expected a processing event in the outbox, got %+v", events)
 	}
 }
 
@@ -61,21 +131,28 @@ func TestProcessTransaction_Failure(t *testing.T) {
 	t.Run("NoAvailableGateways", func(t *testing.T) {
 		// Mock database
 		mockDB := &db.MockDB{
-			GetSupportedGatewaysByCountryFunc: func(countryID int) ([]*common.Gateway, error) {
+			GetCountryCurrenciesFunc: func(ctx context.Context, countryID int) ([]string, error) {
+				return []string{"USD"}, nil
+			},
+			GetSupportedGatewaysByCountryFunc: func(ctx context.Context, countryID int) ([]*common.Gateway, error) {
 				return nil, errors.New("no gateways available")
 			},
-			CreateTransactionFunc: func(tx *postgres.Transaction) error { return nil },
-			UpdateTxStatusFunc:    func(txID int64, status string) error { return nil },
+			CreateTransactionFunc: func(ctx context.Context, tx *postgres.Transaction, hold func(tx *postgres.Transaction) *postgres.JournalEntry) error {
+				return nil
+			},
+			UpdateTxStatusFunc: func(ctx context.Context, change *postgres.TransactionStatusChange, allowedFrom []string, event *postgres.OutboxEvent, entry *postgres.JournalEntry) error {
+				return nil
+			},
 		}
 
 		requestPayload := request.Transaction{
-			Amount:    100.0,
+			Amount:    "100.00",
 			UserID:    1,
 			CountryID: 840,
 			Currency:  "USD",
 		}
 
-		_, err := NewSvcTx(mockDB, &kafka.MockKafkaProducer{}).ProcessTransaction(requestPayload, gateway.NewSvcGateway(mockDB), "deposit")
+		_, err := NewSvcTx(mockDB, testPool).ProcessTransaction(context.Background(), requestPayload, gateway.NewSvcGateway(mockDB, time.Second), "deposit")
 		if err == nil || err.Error() != "no gateways available" {
 			t.Errorf("expected error 'no gateways available', got %v", err)
 		}
@@ -85,23 +162,28 @@ func TestProcessTransaction_Failure(t *testing.T) {
 	t.Run("CreateTransactionFailure", func(t *testing.T) {
 		// Mock database
 		mockDB := &db.MockDB{
-			GetSupportedGatewaysByCountryFunc: func(countryID int) ([]*common.Gateway, error) {
+			GetCountryCurrenciesFunc: func(ctx context.Context, countryID int) ([]string, error) {
+				return []string{"USD"}, nil
+			},
+			GetSupportedGatewaysByCountryFunc: func(ctx context.Context, countryID int) ([]*common.Gateway, error) {
 				return []*common.Gateway{{ID: 1, Name: "Mock Gateway", Priority: 1}}, nil
 			},
-			CreateTransactionFunc: func(tx *postgres.Transaction) error {
+			CreateTransactionFunc: func(ctx context.Context, tx *postgres.Transaction, hold func(tx *postgres.Transaction) *postgres.JournalEntry) error {
 				return errors.New("failed to save tx to database")
 			},
-			UpdateTxStatusFunc: func(txID int64, status string) error { return nil },
+			UpdateTxStatusFunc: func(ctx context.Context, change *postgres.TransactionStatusChange, allowedFrom []string, event *postgres.OutboxEvent, entry *postgres.JournalEntry) error {
+				return nil
+			},
 		}
 
 		requestPayload := request.Transaction{
-			Amount:    100.0,
+			Amount:    "100.00",
 			UserID:    1,
 			CountryID: 840,
 			Currency:  "USD",
 		}
 
-		_, err := NewSvcTx(mockDB, &kafka.MockKafkaProducer{}).ProcessTransaction(requestPayload, gateway.NewSvcGateway(mockDB), "deposit")
+		_, err := NewSvcTx(mockDB, testPool).ProcessTransaction(context.Background(), requestPayload, gateway.NewSvcGateway(mockDB, time.Second), "deposit")
 		if err == nil || err.Error() != "failed to save tx to database" {
 			t.Errorf("expected error 'failed to save tx to database', got %v", err)
 		}
@@ -111,38 +193,379 @@ func TestProcessTransaction_Failure(t *testing.T) {
 	t.Run("GatewayFailure", func(t *testing.T) {
 		// Mock database
 		mockDB := &db.MockDB{
-			GetSupportedGatewaysByCountryFunc: func(countryID int) ([]*common.Gateway, error) {
+			GetCountryCurrenciesFunc: func(ctx context.Context, countryID int) ([]string, error) {
+				return []string{"USD"}, nil
+			},
+			GetSupportedGatewaysByCountryFunc: func(ctx context.Context, countryID int) ([]*common.Gateway, error) {
 				return []*common.Gateway{{ID: 1, Name: "Mock Gateway", Priority: 1}}, nil
 			},
-			CreateTransactionFunc: func(tx *postgres.Transaction) error {
+			CreateTransactionFunc: func(ctx context.Context, tx *postgres.Transaction, hold func(tx *postgres.Transaction) *postgres.JournalEntry) error {
 				tx.ID = 12345
 				return nil
 			},
-			UpdateTxStatusFunc: func(txID int64, status string) error {
+			UpdateTxStatusFunc: func(ctx context.Context, change *postgres.TransactionStatusChange, allowedFrom []string, event *postgres.OutboxEvent, entry *postgres.JournalEntry) error {
 				return errors.New("failed to update transaction status")
 			},
 		}
 
 		// Mock gateway processing
 		mockGatewayProcessor := &gateway.MockGatewayProcessor{
-			SendTxToGatewayFunc: func(tx postgres.Transaction) (interface{}, error) {
+			SendTxToGatewayFunc: func(ctx context.Context, tx postgres.Transaction) (*adapter.Response, error) {
 				return nil, errors.New("gateway error")
 			},
-			SelectGatewayFunc: func(countryID int) (*common.Gateway, error) {
+			SelectGatewayFunc: func(ctx context.Context, route common.RouteRequest) (*common.Gateway, error) {
+				return nil, errors.New("gateway error")
+			},
+			SelectGatewaysFunc: func(ctx context.Context, route common.RouteRequest) ([]*common.Gateway, error) {
 				return nil, errors.New("gateway error")
 			},
 		}
 
 		requestPayload := request.Transaction{
-			Amount:    100.0,
+			Amount:    "100.00",
 			UserID:    1,
 			CountryID: 840,
 			Currency:  "USD",
 		}
 
-		_, err := NewSvcTx(mockDB, &kafka.MockKafkaProducer{}).ProcessTransaction(requestPayload, mockGatewayProcessor, "deposit")
+		_, err := NewSvcTx(mockDB, testPool).ProcessTransaction(context.Background(), requestPayload, mockGatewayProcessor, "deposit")
 		if err == nil || err.Error() != "gateway error" {
 			t.Errorf("expected error 'gateway error', got %v", err)
 		}
 	})
 }
+
+// TestProcessTransaction_Failover tests cascading to the next gateway when the preferred one is down or declines the
+// tx, even with a 2xx response
+func TestProcessTransaction_Failover(t *testing.T) {
+	tests := []struct {
+		name        string
+		primary     http.HandlerFunc
+		maxAttempts int
+		// wantAttempts is the number of tries on the primary
+		wantAttempts int
+	}{
+		{
+			name: "primary down",
+			primary: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			},
+			maxAttempts:  2,
+			wantAttempts: 2,
+		},
+		{
+			// a decline is not retried on the same gateway, whatever the policy allows
+			name: "primary declines with a 2xx",
+			primary: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"id":"psp-0","status":"declined"}`))
+			},
+			maxAttempts:  3,
+			wantAttempts: 1,
+		},
+		{
+			name: "primary answers an unknown status",
+			primary: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"id":"psp-0","status":"on_hold"}`))
+			},
+			maxAttempts:  3,
+			wantAttempts: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(policy retry.Policy) { gatewaySendPolicy = policy }(gatewaySendPolicy)
+			gatewaySendPolicy.MaxAttempts, gatewaySendPolicy.InitialInterval = tt.maxAttempts, 0
+
+			primary := httptest.NewServer(tt.primary)
+			defer primary.Close()
+			psp := newMockPSP(t)
+
+			gateways := map[int]*common.Gateway{
+				1: {ID: 1, Name: "primary", Priority: 1, DataFormatSupported: "application/json", EndpointURL: primary.URL},
+				2: {ID: 2, Name: "secondary", Priority: 2, DataFormatSupported: "application/json", EndpointURL: psp.URL},
+			}
+
+			var attempts []postgres.TransactionAttempt
+			var acceptedBy int
+			var status string
+			mockDB := &db.MockDB{
+				GetCountryCurrenciesFunc: func(ctx context.Context, countryID int) ([]string, error) {
+					return []string{"USD"}, nil
+				},
+				GetSupportedGatewaysByCountryFunc: func(ctx context.Context, countryID int) ([]*common.Gateway, error) {
+					return []*common.Gateway{gateways[2], gateways[1]}, nil
+				},
+				GetRoutingRulesFunc: func(ctx context.Context) ([]*common.RoutingRule, error) {
+					return nil, nil
+				},
+				GetGatewayFeesFunc: func(ctx context.Context, gatewayIDs []int) ([]*common.FeeSchedule, error) {
+					return nil, nil
+				},
+				GetGatewayByIDFunc: func(ctx context.Context, gatewayID int) (*common.Gateway, error) {
+					return gateways[gatewayID], nil
+				},
+				CreateTransactionFunc: func(ctx context.Context, tx *postgres.Transaction, hold func(tx *postgres.Transaction) *postgres.JournalEntry) error {
+					tx.ID = 12345
+					return nil
+				},
+				ClaimTransactionFunc: func(ctx context.Context, id int64) (bool, error) {
+					return true, nil
+				},
+				UpdateTxStatusFunc: func(ctx context.Context, change *postgres.TransactionStatusChange, allowedFrom []string, event *postgres.OutboxEvent, entry *postgres.JournalEntry) error {
+					status = change.ToStatus
+					return nil
+				},
+				UpdateTxGatewayFunc: func(ctx context.Context, tx *postgres.Transaction) error {
+					acceptedBy = tx.GatewayID
+					return nil
+				},
+				CreateTxAttemptFunc: func(ctx context.Context, attempt *postgres.TransactionAttempt) error {
+					attempts = append(attempts, *attempt)
+					return nil
+				},
+			}
+
+			requestPayload := request.Transaction{
+				Amount:    "100.00",
+				UserID:    1,
+				CountryID: 840,
+				Currency:  "USD",
+			}
+
+			svc := NewSvcTx(mockDB, testPool).(*SvcTx)
+			if _, err := svc.ProcessTransaction(context.Background(), requestPayload, gateway.NewSvcGateway(mockDB, time.Second), "deposit"); err != nil {
+				t.Fatalf("expected success, got error: %v", err)
+			}
+			svc.submit(context.Background(), nextSubmission(t, svc))
+
+			if acceptedBy != 2 || status != StatusProcessing {
+				t.Errorf("expected tx to be accepted by gateway 2, got %d and status %s", acceptedBy, status)
+			}
+
+			if len(attempts) != tt.wantAttempts+1 {
+				t.Fatalf("expected %d attempts on the primary and 1 on the secondary, got %+v", tt.wantAttempts, attempts)
+			}
+			for _, attempt := range attempts[:tt.wantAttempts] {
+				if attempt.GatewayID != 1 || attempt.Error == "" {
+					t.Errorf("expected a failed attempt on gateway 1, got %+v", attempt)
+				}
+			}
+			if last := attempts[tt.wantAttempts]; last.GatewayID != 2 || last.Error != "" {
+				t.Errorf("expected gateway 2 to accept the tx, got %+v", last)
+			}
+		})
+	}
+}
+
+// TestProcessTransaction_InvalidCurrency tests that currencies are checked against ISO 4217 and the country
+func TestProcessTransaction_InvalidCurrency(t *testing.T) {
+	mockDB := &db.MockDB{
+		GetCountryCurrenciesFunc: func(ctx context.Context, countryID int) ([]string, error) {
+			return []string{"USD"}, nil
+		},
+	}
+
+	for _, currency := range []string{"", "XYZ", "EUR"} {
+		requestPayload := request.Transaction{
+			Amount:    "100.00",
+			UserID:    1,
+			CountryID: 840,
+			Currency:  currency,
+		}
+
+		_, err := NewSvcTx(mockDB, testPool).ProcessTransaction(context.Background(), requestPayload, gateway.NewSvcGateway(mockDB, time.Second), "deposit")
+
+		var validationErr *ValidationError
+		if !errors.As(err, &validationErr) {
+			t.Errorf("expected validation error for currency %q, got %v", currency, err)
+		}
+	}
+}
+
+func TestProcessTransaction_InvalidAmount(t *testing.T) {
+	mockDB := &db.MockDB{
+		GetCountryCurrenciesFunc: func(ctx context.Context, countryID int) ([]string, error) {
+			return []string{"USD", "JPY"}, nil
+		},
+	}
+
+	cases := []struct {
+		amount   money.Decimal
+		currency string
+	}{
+		{"0", "USD"},
+		{"-5.00", "USD"},
+		{"10.001", "USD"},
+		{"100.5", "JPY"},
+		{"99999999999999999999", "USD"},
+	}
+
+	for _, c := range cases {
+		requestPayload := request.Transaction{
+			Amount:    c.amount,
+			UserID:    1,
+			CountryID: 840,
+			Currency:  c.currency,
+		}
+
+		_, err := NewSvcTx(mockDB, testPool).ProcessTransaction(context.Background(), requestPayload, gateway.NewSvcGateway(mockDB, time.Second), "deposit")
+
+		var validationErr *ValidationError
+		if !errors.As(err, &validationErr) {
+			t.Errorf("expected validation error for %s %s, got %v", c.amount, c.currency, err)
+		}
+	}
+}
+
+// TestProcessTransaction_QueueFull tests that txs are refused without being stored when the queue is full
+func TestProcessTransaction_QueueFull(t *testing.T) {
+	var created int
+	mockDB := &db.MockDB{
+		GetCountryCurrenciesFunc: func(ctx context.Context, countryID int) ([]string, error) {
+			return []string{"USD"}, nil
+		},
+		CreateTransactionFunc: func(ctx context.Context, tx *postgres.Transaction, hold func(tx *postgres.Transaction) *postgres.JournalEntry) error {
+			created++
+			tx.ID = int64(created)
+			return nil
+		},
+	}
+
+	mockGatewayProcessor := &gateway.MockGatewayProcessor{
+		SelectGatewaysFunc: func(ctx context.Context, route common.RouteRequest) ([]*common.Gateway, error) {
+			return []*common.Gateway{{ID: 1, Name: "Mock Gateway", Priority: 1}}, nil
+		},
+	}
+
+	requestPayload := request.Transaction{
+		Amount:    "100.00",
+		UserID:    1,
+		CountryID: 840,
+		Currency:  "USD",
+	}
+
+	svc := NewSvcTx(mockDB, PoolConfig{Workers: 1, QueueSize: 1})
+	if _, err := svc.ProcessTransaction(context.Background(), requestPayload, mockGatewayProcessor, "deposit"); err != nil {
+		t.Fatalf("expected the first tx to be queued, got %v", err)
+	}
+
+	if _, err := svc.ProcessTransaction(context.Background(), requestPayload, mockGatewayProcessor, "deposit"); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("expected the queue to be full, got %v", err)
+	}
+	if created != 1 {
+		t.Errorf("expected a single tx to be stored, got %d", created)
+	}
+}
+
+// TestProcessTransaction_InsufficientFunds tests that a withdrawal holds its amount and is refused when the user's
+// available balance does not cover it
+func TestProcessTransaction_InsufficientFunds(t *testing.T) {
+	var holds []*postgres.JournalEntry
+	mockDB := &db.MockDB{
+		GetCountryCurrenciesFunc: func(ctx context.Context, countryID int) ([]string, error) {
+			return []string{"USD"}, nil
+		},
+		CreateTransactionFunc: func(ctx context.Context, tx *postgres.Transaction, hold func(tx *postgres.Transaction) *postgres.JournalEntry) error {
+			tx.ID = 12345
+			holds = append(holds, hold(tx))
+			return fmt.Errorf("user account 1 has less than 10000 USD available: %w", db.ErrInsufficientFunds)
+		},
+	}
+
+	requestPayload := request.Transaction{
+		Amount:    "100.00",
+		UserID:    1,
+		CountryID: 840,
+		Currency:  "USD",
+	}
+
+	svc := NewSvcTx(mockDB, PoolConfig{Workers: 1, QueueSize: 1}).(*SvcTx)
+	_, err := svc.ProcessTransaction(context.Background(), requestPayload, &gateway.MockGatewayProcessor{}, "withdrawal")
+
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) || !strings.Contains(err.Error(), "insufficient funds") {
+		t.Fatalf("expected insufficient funds, got %v", err)
+	}
+	if len(holds) != 1 || holds[0].Reference != "transaction:12345:hold" || !holds[0].Postings[0].RequireFunds {
+		t.Errorf("expected the withdrawal to hold its amount, got %+v", holds)
+	}
+	if !svc.pool.reserve() {
+		t.Error("expected the queue slot to be released")
+	}
+}
+
+// TestSubmit_ExpiresStaleSubmission tests that a tx waiting in the queue longer than the TTL is not sent
+func TestSubmit_ExpiresStaleSubmission(t *testing.T) {
+	var changes []postgres.TransactionStatusChange
+	mockDB := &db.MockDB{
+		UpdateTxStatusFunc: func(ctx context.Context, change *postgres.TransactionStatusChange, allowedFrom []string, event *postgres.OutboxEvent, entry *postgres.JournalEntry) error {
+			changes = append(changes, *change)
+			return nil
+		},
+	}
+	mockGatewayProcessor := &gateway.MockGatewayProcessor{
+		SendTxToGatewayFunc: func(ctx context.Context, tx postgres.Transaction) (*adapter.Response, error) {
+			t.Fatal("expected the stale tx not to be sent")
+			return nil, nil
+		},
+	}
+
+	svc := NewSvcTx(mockDB, PoolConfig{Workers: 1, QueueSize: 1, PendingTTL: time.Minute}).(*SvcTx)
+	svc.submit(context.Background(), submission{
+		tx:          postgres.Transaction{ID: 1, Type: "deposit", Amount: 10000, Currency: "USD", Status: StatusPending},
+		gateways:    []*common.Gateway{{ID: 1, Name: "Mock Gateway"}},
+		iSvcGateway: mockGatewayProcessor,
+		queuedAt:    time.Now().Add(-2 * time.Minute),
+	})
+
+	if len(changes) != 1 || changes[0].ToStatus != StatusExpired {
+		t.Errorf("expected the tx to expire, got %+v", changes)
+	}
+}
+
+// TestRequeueUnsent tests that the pending txs no worker claimed are queued again with their gateways and creation
+// time, and that those no gateway can be selected for fail
+func TestRequeueUnsent(t *testing.T) {
+	createdAt := time.Now().Add(-time.Minute)
+	var changes []postgres.TransactionStatusChange
+	mockDB := &db.MockDB{
+		GetUnsentTransactionsFunc: func(ctx context.Context, createdBefore time.Time, afterID int64, limit int) ([]*postgres.Transaction, error) {
+			if afterID != 0 {
+				return nil, nil
+			}
+			return []*postgres.Transaction{
+				{ID: 1, Type: "deposit", Amount: 10000, Currency: "USD", CountryID: 840, Status: StatusPending, CreatedAt: createdAt},
+				{ID: 2, Type: "deposit", Amount: 10000, Currency: "JPY", CountryID: 392, Status: StatusPending, CreatedAt: createdAt},
+			}, nil
+		},
+		UpdateTxStatusFunc: func(ctx context.Context, change *postgres.TransactionStatusChange, allowedFrom []string, event *postgres.OutboxEvent, entry *postgres.JournalEntry) error {
+			changes = append(changes, *change)
+			return nil
+		},
+	}
+	mockGatewayProcessor := &gateway.MockGatewayProcessor{
+		SelectGatewaysFunc: func(ctx context.Context, route common.RouteRequest) ([]*common.Gateway, error) {
+			if route.CountryID != 840 {
+				return nil, errors.New("no gateway for country")
+			}
+			return []*common.Gateway{{ID: 1, Name: "Mock Gateway"}}, nil
+		},
+	}
+
+	svc := NewSvcTx(mockDB, testPool).(*SvcTx)
+	svc.requeueUnsent(context.Background(), mockGatewayProcessor)
+
+	job := nextSubmission(t, svc)
+	if job.tx.ID != 1 || len(job.gateways) != 1 || !job.queuedAt.Equal(createdAt) {
+		t.Errorf("expected tx 1 to be queued with its gateway since its creation, got %+v", job)
+	}
+	if n := len(svc.pool.jobs); n != 0 {
+		t.Errorf("expected a single tx to be queued again, got %d more", n)
+	}
+	if len(changes) != 1 || changes[0].TransactionID != 2 || changes[0].ToStatus != StatusFailed {
+		t.Errorf("expected tx 2 to fail, got %+v", changes)
+	}
+}
@@ -0,0 +1,307 @@
+package tx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"payment-gateway/internal/models/common"
+	"payment-gateway/internal/models/postgres"
+	svcGateway "payment-gateway/internal/services/gateway"
+	"payment-gateway/internal/util"
+	"sync"
+	"time"
+)
+
+// ErrQueueFull is returned when the submission queue has no room left, the tx is not created
+var ErrQueueFull = errors.New("too many transactions in progress, retry later")
+
+type (
+	// PoolConfig sizes the worker pool submitting txs to the gateways
+	PoolConfig struct {
+		Workers   int
+		QueueSize int
+		// PendingTTL is how long a tx may wait to be sent before it expires, 0 disables expiry
+		PendingTTL time.Duration
+	}
+
+	// submission is a stored tx waiting to be sent to its gateways, or a stored refund of the tx waiting to be sent
+	// to the gateway that processed it when refund is set. When void is set the tx is to be voided at the gateway
+	// that accepted it and then cancelled with reason.
+	submission struct {
+		tx          postgres.Transaction
+		refund      *postgres.Refund
+		void        bool
+		reason      string
+		gateways    []*common.Gateway
+		iSvcGateway svcGateway.ISvcGateway
+		queuedAt    time.Time
+	}
+
+	// workerPool is a bounded queue of submissions. A slot is reserved before the tx or refund is stored, so neither
+	// is stored without room to queue it.
+	workerPool struct {
+		cfg   PoolConfig
+		slots chan struct{}
+		jobs  chan submission
+	}
+)
+
+// LoadPoolConfig reads the worker pool settings from the environment
+func LoadPoolConfig() PoolConfig {
+	return PoolConfig{
+		Workers:    util.GetEnvInt("TX_WORKERS", 10),
+		QueueSize:  util.GetEnvInt("TX_QUEUE_SIZE", 100),
+		PendingTTL: util.GetEnvDuration("TX_PENDING_TTL", 15*time.Minute),
+	}
+}
+
+func newWorkerPool(cfg PoolConfig) *workerPool {
+	return &workerPool{
+		cfg:   cfg,
+		slots: make(chan struct{}, cfg.QueueSize),
+		jobs:  make(chan submission, cfg.QueueSize),
+	}
+}
+
+// reserve takes a queue slot without blocking, it reports false when the queue is full
+func (p *workerPool) reserve() bool {
+	select {
+	case p.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// release gives back a slot reserved for a submission that won't be queued
+func (p *workerPool) release() {
+	<-p.slots
+}
+
+// reserveWait takes a queue slot, waiting for one to be freed when the queue is full, it reports false when ctx is
+// cancelled first
+func (p *workerPool) reserveWait(ctx context.Context) bool {
+	select {
+	case p.slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// enqueue queues a submission in a reserved slot, a submission queued again keeps the time it was first queued at
+func (p *workerPool) enqueue(job submission) {
+	if job.queuedAt.IsZero() {
+		job.queuedAt = time.Now()
+	}
+	p.jobs <- job
+}
+
+// next waits for a submission and frees its slot
+func (p *workerPool) next(ctx context.Context) (submission, bool) {
+	select {
+	case <-ctx.Done():
+		return submission{}, false
+	case job := <-p.jobs:
+		p.release()
+		return job, true
+	}
+}
+
+// RunWorkers submits the queued txs and refunds to their gateways with the configured number of workers until ctx is
+// cancelled. A worker finishes the submission it is working on before stopping. The pending txs no worker took, e.g.
+// because they were queued when the service stopped, are queued again on start with the gateways iSvcGateway selects.
+// Pending txs and refunds that were never sent are expired meanwhile.
+func (t SvcTx) RunWorkers(ctx context.Context, iSvcGateway svcGateway.ISvcGateway) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		t.requeueUnsent(ctx, iSvcGateway)
+	}()
+
+	if t.pool.cfg.PendingTTL > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			t.expirePending(ctx)
+		}()
+	}
+
+	for i := 0; i < t.pool.cfg.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				job, ok := t.pool.next(ctx)
+				if !ok {
+					return
+				}
+				// the submission is finished even if ctx gets cancelled meanwhile, it would stay pending otherwise
+				t.work(context.Background(), job)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := len(t.pool.jobs); n > 0 {
+		log.Printf("tx workers stopped with %d submissions still pending", n)
+	}
+}
+
+// requeueUnsent queues the pending txs created before it started that no worker claimed, waiting for room in the queue.
+// They keep their creation time as queue time so that the TTL still applies. A tx another instance has queued may be
+// queued here too, only the worker that claims it sends it.
+func (t SvcTx) requeueUnsent(ctx context.Context, iSvcGateway svcGateway.ISvcGateway) {
+	const batchSize = 100
+
+	startedAt := time.Now()
+	var afterID int64
+	queued := 0
+	for {
+		txs, err := t.db.GetUnsentTransactions(ctx, startedAt, afterID, batchSize)
+		if err != nil {
+			log.Printf("failed to list unsent txs, %d queued again: %v", queued, err)
+			return
+		}
+
+		for _, tx := range txs {
+			afterID = tx.ID
+
+			if !t.pool.reserveWait(ctx) {
+				return
+			}
+
+			gateways, err := iSvcGateway.SelectGateways(ctx, routeRequest(tx))
+			if err != nil {
+				t.pool.release()
+
+				if err = t.transition(ctx, tx.ID, StatusFailed, "no gateway selected", tx); err != nil {
+					log.Printf("failed to update status of tx %d: %v", tx.ID, err)
+				}
+				continue
+			}
+
+			t.pool.enqueue(submission{tx: *tx, gateways: gateways, iSvcGateway: iSvcGateway, queuedAt: tx.CreatedAt})
+			queued++
+		}
+
+		if len(txs) < batchSize {
+			if queued > 0 {
+				log.Printf("queued %d unsent txs again", queued)
+			}
+			return
+		}
+	}
+}
+
+// expirePending expires the txs pending for more than twice the TTL every minute until ctx is cancelled, and fails the
+// refunds pending as long. Submissions still queued expire when a worker takes them after the TTL, the sweep only
+// finds those no worker will send, and the extra TTL keeps it clear of a submission a worker is sending.
+func (t SvcTx) expirePending(ctx context.Context) {
+	interval := time.Minute
+	if t.pool.cfg.PendingTTL < interval {
+		interval = t.pool.cfg.PendingTTL
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		cutoff := time.Now().Add(-2 * t.pool.cfg.PendingTTL)
+		txs, err := t.db.ListTransactions(ctx, common.TransactionFilter{Status: StatusPending, To: &cutoff, SortOrder: "asc", Limit: 100})
+		if err != nil {
+			log.Printf("failed to list stale pending txs: %v", err)
+			continue
+		}
+
+		for _, tx := range txs {
+			if err = t.transition(ctx, tx.ID, StatusExpired, "not sent to a gateway in time", tx); err != nil {
+				log.Printf("failed to expire tx %d: %v", tx.ID, err)
+			}
+		}
+
+		refunds, err := t.db.GetPendingRefunds(ctx, cutoff, 100)
+		if err != nil {
+			log.Printf("failed to list stale pending refunds: %v", err)
+			continue
+		}
+
+		for _, refund := range refunds {
+			if err = t.refundTransition(ctx, refund, postgres.RefundFailed, "not sent to a gateway in time", ""); err != nil {
+				log.Printf("failed to fail refund %d: %v", refund.ID, err)
+			}
+		}
+	}
+}
+
+// work runs a submission taken from the queue
+func (t SvcTx) work(ctx context.Context, job submission) {
+	switch {
+	case job.refund != nil:
+		t.submitRefund(ctx, job)
+	case job.void:
+		t.submitVoid(ctx, job)
+	default:
+		t.submit(ctx, job)
+	}
+}
+
+// submit sends the tx to its gateways in order until one accepts it and updates the tx status accordingly. A tx
+// queued for longer than the TTL expires instead, and a tx no longer pending, e.g. cancelled, or already claimed is
+// skipped.
+func (t SvcTx) submit(ctx context.Context, job submission) {
+	tx := job.tx
+
+	if ttl := t.pool.cfg.PendingTTL; ttl > 0 && time.Since(job.queuedAt) > ttl {
+		if err := t.transition(ctx, tx.ID, StatusExpired, "not sent to a gateway in time", &tx); err != nil {
+			log.Printf("failed to expire tx %d: %v", tx.ID, err)
+		}
+		return
+	}
+
+	// a tx cancelled while queued, or claimed by another worker, is not sent. A tx that can't be claimed is left pending
+	// rather than risking a second send, it expires if it is not queued again.
+	claimed, err := t.db.ClaimTransaction(ctx, tx.ID)
+	if err != nil {
+		log.Printf("failed to claim tx %d, not sending it: %v", tx.ID, err)
+		return
+	}
+	if !claimed {
+		log.Printf("tx %d is no longer pending or already being sent, not sending it", tx.ID)
+		return
+	}
+
+	if _, err := t.sendWithFailover(ctx, &tx, job.gateways, job.iSvcGateway); err != nil {
+		log.Printf("failed to send tx %d to any gateway: %v", tx.ID, err)
+
+		if err = t.transition(ctx, tx.ID, StatusFailed, "all gateways failed", &tx); err != nil {
+			log.Printf("failed to update status of tx %d: %v", tx.ID, err)
+		}
+		return
+	}
+
+	// the event announcing the tx is written to the outbox in the same DB transaction and published to Kafka by the
+	// outbox relay
+	err = t.transition(ctx, tx.ID, StatusProcessing, fmt.Sprintf("accepted by gateway %d", tx.GatewayID), &tx)
+
+	// a tx cancelled while it was being sent must not go on at the gateway
+	var transitionErr *IllegalTransitionError
+	if errors.As(err, &transitionErr) && transitionErr.From == StatusCancelled {
+		if err = t.void(ctx, &tx, job.iSvcGateway); err != nil {
+			log.Printf("tx %d was cancelled while being sent, gateway %d failed to void it: %v", tx.ID, tx.GatewayID, err)
+		}
+		return
+	}
+	if err != nil {
+		log.Printf("failed to update status of tx %d: %v", tx.ID, err)
+	}
+}
@@ -0,0 +1,416 @@
+package webhook
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"payment-gateway/db"
+	"payment-gateway/internal/models/common"
+	"payment-gateway/internal/models/postgres"
+	"payment-gateway/internal/retry"
+	"payment-gateway/internal/services/dispute"
+	"payment-gateway/internal/services/tx"
+	"payment-gateway/internal/util"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Headers of a webhook request. The signature is the hex HMAC-SHA256 of "<timestamp>.<body>" keyed with the
+// endpoint's secret, and the event ID is the same for every endpoint and redelivery of an event.
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	TimestampHeader = "X-Webhook-Timestamp"
+	EventIDHeader   = "X-Webhook-Event-ID"
+	EventTypeHeader = "X-Webhook-Event-Type"
+	DeliveryHeader  = "X-Webhook-Delivery-ID"
+
+	// maxErrorBodyBytes bounds how much of a failed response is kept in the delivery log
+	maxErrorBodyBytes = 512
+
+	defaultListLimit = 50
+	maxListLimit     = 200
+)
+
+// ErrInvalidEndpoint is wrapped by the errors returned when a webhook endpoint is rejected
+var ErrInvalidEndpoint = errors.New("invalid webhook endpoint")
+
+type (
+	// WebhookConfig controls how webhook deliveries are sent and retried
+	WebhookConfig struct {
+		Interval  time.Duration
+		BatchSize int
+		// Timeout bounds a single request to an endpoint
+		Timeout time.Duration
+		// MaxAttempts is the number of tries of a delivery before it is given up
+		MaxAttempts int
+		// InitialBackoff is the delay before the second try, it doubles after every failure up to MaxBackoff
+		InitialBackoff time.Duration
+		MaxBackoff     time.Duration
+		// DisableAfter is the number of consecutive failed requests after which an endpoint is disabled, 0 never
+		// disables endpoints
+		DisableAfter int
+		// AllowPrivateTargets lets endpoints be on loopback, private or link-local addresses, e.g. in development.
+		// Otherwise they are refused when registered and when connected to, so webhooks can't reach internal services.
+		AllowPrivateTargets bool
+	}
+
+	SvcWebhook struct {
+		db     db.Idb
+		client *http.Client
+		cfg    WebhookConfig
+	}
+
+	ISvcWebhook interface {
+		RegisterWebhookEndpoint(ctx context.Context, endpoint *common.WebhookEndpoint) error
+		WebhookEndpoints(ctx context.Context) ([]*common.WebhookEndpoint, error)
+		SetWebhookEndpointEnabled(ctx context.Context, id int, enabled bool) (*common.WebhookEndpoint, error)
+		WebhookDeliveries(ctx context.Context, filter common.WebhookDeliveryFilter) ([]*postgres.WebhookDelivery, error)
+		WebhookDelivery(ctx context.Context, id int64) (*postgres.WebhookDelivery, []*postgres.WebhookAttempt, error)
+		RedeliverWebhook(ctx context.Context, id int64) (*postgres.WebhookDelivery, error)
+		RunDispatcher(ctx context.Context)
+		DispatchWebhooks(ctx context.Context) (int, error)
+	}
+)
+
+// LoadWebhookConfig reads the webhook delivery settings from the environment
+func LoadWebhookConfig() WebhookConfig {
+	return WebhookConfig{
+		Interval:       util.GetEnvDuration("WEBHOOK_DISPATCH_INTERVAL", time.Second),
+		BatchSize:      util.GetEnvInt("WEBHOOK_DISPATCH_BATCH_SIZE", 50),
+		Timeout:        util.GetEnvDuration("WEBHOOK_TIMEOUT", 10*time.Second),
+		MaxAttempts:    util.GetEnvInt("WEBHOOK_MAX_ATTEMPTS", 10),
+		InitialBackoff: util.GetEnvDuration("WEBHOOK_INITIAL_BACKOFF", 30*time.Second),
+		MaxBackoff:     util.GetEnvDuration("WEBHOOK_MAX_BACKOFF", 6*time.Hour),
+		DisableAfter:   util.GetEnvInt("WEBHOOK_DISABLE_AFTER_FAILURES", 20),
+
+		AllowPrivateTargets: util.GetEnvBool("WEBHOOK_ALLOW_PRIVATE_TARGETS", false),
+	}
+}
+
+func NewSvcWebhook(db db.Idb, cfg WebhookConfig) ISvcWebhook {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if !cfg.AllowPrivateTargets {
+		// checked on the resolved address of every connection, a public host name may resolve to a private address
+		dialer.Control = func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+				return fmt.Errorf("%s is not a public address", host)
+			}
+			return nil
+		}
+	}
+
+	return &SvcWebhook{
+		db: db,
+		client: &http.Client{
+			// no proxy, the connections are made to the endpoints themselves so their addresses can be checked
+			Transport: &http.Transport{
+				DialContext:         dialer.DialContext,
+				ForceAttemptHTTP2:   true,
+				MaxIdleConns:        100,
+				IdleConnTimeout:     90 * time.Second,
+				TLSHandshakeTimeout: 10 * time.Second,
+			},
+			// a redirect is answered like a failure, the endpoint's URL has to be updated
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		cfg: cfg,
+	}
+}
+
+// isPublicIP tells whether ip may be the address of an endpoint: loopback, private (RFC 1918 and unique local),
+// link-local (e.g. the cloud metadata service at 169.254.169.254), multicast and unspecified addresses may not
+func isPublicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() && !ip.IsMulticast() && !ip.IsUnspecified()
+}
+
+// checkHost refuses the host of an endpoint when it is, or resolves to, an address that isn't public. A host that
+// can't be resolved yet is accepted, its addresses are checked again on every connection.
+func (s SvcWebhook) checkHost(ctx context.Context, host string) error {
+	if s.cfg.AllowPrivateTargets {
+		return nil
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		if !isPublicIP(ip) {
+			return fmt.Errorf("%w: %s is not a public address", ErrInvalidEndpoint, host)
+		}
+		return nil
+	}
+
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("%w: %s is not a public host", ErrInvalidEndpoint, host)
+	}
+
+	ctx, cancel := util.WithTimeout(ctx, s.cfg.Timeout)
+	defer cancel()
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		if !isPublicIP(addr.IP) {
+			return fmt.Errorf("%w: %s resolves to %s, which is not a public address", ErrInvalidEndpoint, host, addr.IP)
+		}
+	}
+
+	return nil
+}
+
+// isValidEventType tells whether eventType is the type of the events of a tx, refund or dispute status, e.g.
+// transaction.completed or refund.failed, or of the reminders of disputes
+func isValidEventType(eventType string) bool {
+	if eventType == dispute.EventEvidenceDue {
+		return true
+	}
+	if status, ok := strings.CutPrefix(eventType, "transaction."); ok {
+		return tx.IsValidStatus(status)
+	}
+	if status, ok := strings.CutPrefix(eventType, "refund."); ok {
+		return tx.IsValidRefundStatus(status)
+	}
+	if status, ok := strings.CutPrefix(eventType, "dispute."); ok {
+		return dispute.IsValidStatus(status)
+	}
+
+	return false
+}
+
+// RegisterWebhookEndpoint validates and stores an endpoint, a secret is generated when none is given. The endpoint
+// is notified of the status changes of its owner's txs happening from now on.
+func (s SvcWebhook) RegisterWebhookEndpoint(ctx context.Context, endpoint *common.WebhookEndpoint) error {
+	if endpoint.OwnerID <= 0 {
+		return fmt.Errorf("%w: owner_id must be the ID of the user whose transactions are notified", ErrInvalidEndpoint)
+	}
+
+	u, err := url.Parse(endpoint.URL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Hostname() == "" {
+		return fmt.Errorf("%w: url must be an absolute http or https URL", ErrInvalidEndpoint)
+	}
+	if err = s.checkHost(ctx, u.Hostname()); err != nil {
+		return err
+	}
+
+	for _, eventType := range endpoint.EventTypes {
+		if !isValidEventType(eventType) {
+			return fmt.Errorf("%w: unknown event type %q", ErrInvalidEndpoint, eventType)
+		}
+	}
+
+	if endpoint.Secret == "" {
+		secret := make([]byte, 32)
+		if _, err = rand.Read(secret); err != nil {
+			return fmt.Errorf("failed to generate webhook secret: %v", err)
+		}
+		endpoint.Secret = hex.EncodeToString(secret)
+	}
+	if len(endpoint.Secret) < 16 {
+		return fmt.Errorf("%w: secret must be at least 16 characters", ErrInvalidEndpoint)
+	}
+
+	if endpoint.EventTypes == nil {
+		endpoint.EventTypes = []string{}
+	}
+
+	return s.db.CreateWebhookEndpoint(ctx, endpoint)
+}
+
+func (s SvcWebhook) WebhookEndpoints(ctx context.Context) ([]*common.WebhookEndpoint, error) {
+	return s.db.GetWebhookEndpoints(ctx)
+}
+
+// SetWebhookEndpointEnabled enables or disables an endpoint, e.g. to resume the deliveries of an endpoint that was
+// disabled after failing repeatedly
+func (s SvcWebhook) SetWebhookEndpointEnabled(ctx context.Context, id int, enabled bool) (*common.WebhookEndpoint, error) {
+	return s.db.SetWebhookEndpointEnabled(ctx, id, enabled, "disabled manually")
+}
+
+// WebhookDeliveries lists the deliveries matching the filter, newest first, 50 by default and 200 at most
+func (s SvcWebhook) WebhookDeliveries(ctx context.Context, filter common.WebhookDeliveryFilter) ([]*postgres.WebhookDelivery, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = defaultListLimit
+	}
+	if filter.Limit > maxListLimit {
+		filter.Limit = maxListLimit
+	}
+
+	return s.db.ListWebhookDeliveries(ctx, filter)
+}
+
+// WebhookDelivery returns a delivery with the log of its attempts
+func (s SvcWebhook) WebhookDelivery(ctx context.Context, id int64) (*postgres.WebhookDelivery, []*postgres.WebhookAttempt, error) {
+	delivery, err := s.db.GetWebhookDelivery(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	attempts, err := s.db.GetWebhookAttempts(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return delivery, attempts, nil
+}
+
+// RedeliverWebhook queues a delivery again with a fresh set of attempts, it is sent on the next dispatch if its
+// endpoint is enabled
+func (s SvcWebhook) RedeliverWebhook(ctx context.Context, id int64) (*postgres.WebhookDelivery, error) {
+	return s.db.RedeliverWebhook(ctx, id)
+}
+
+// RunDispatcher sends the due webhook deliveries every interval until ctx is cancelled
+func (s SvcWebhook) RunDispatcher(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.DispatchWebhooks(ctx); err != nil {
+			log.Printf("webhook dispatcher: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DispatchWebhooks sends a batch of due deliveries concurrently and returns the number delivered. Deliveries are
+// claimed with a lease, so several instances can dispatch at the same time; a failed delivery is retried with
+// exponential backoff until it runs out of attempts.
+func (s SvcWebhook) DispatchWebhooks(ctx context.Context) (int, error) {
+	// the lease outlives the request, the delivery is only picked up again if this dispatcher died
+	deliveries, err := s.db.ClaimWebhookDeliveries(ctx, s.cfg.BatchSize, s.cfg.Timeout+time.Minute)
+	if err != nil || len(deliveries) == 0 {
+		return 0, err
+	}
+
+	endpoints, err := s.db.GetWebhookEndpoints(ctx)
+	if err != nil {
+		return 0, err
+	}
+	byID := make(map[int]*common.WebhookEndpoint, len(endpoints))
+	for _, endpoint := range endpoints {
+		byID[endpoint.ID] = endpoint
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	delivered := 0
+	for _, delivery := range deliveries {
+		endpoint, ok := byID[delivery.EndpointID]
+		if !ok {
+			continue
+		}
+
+		wg.Add(1)
+		go func(delivery *postgres.WebhookDelivery, endpoint *common.WebhookEndpoint) {
+			defer wg.Done()
+
+			if s.deliver(ctx, delivery, endpoint) {
+				mu.Lock()
+				delivered++
+				mu.Unlock()
+			}
+		}(delivery, endpoint)
+	}
+	wg.Wait()
+
+	return delivered, nil
+}
+
+// deliver sends a delivery to its endpoint, records the attempt and reports whether it was delivered
+func (s SvcWebhook) deliver(ctx context.Context, delivery *postgres.WebhookDelivery, endpoint *common.WebhookEndpoint) bool {
+	start := time.Now()
+	statusCode, err := s.send(ctx, delivery, endpoint)
+
+	delivery.Attempts++
+	attempt := &postgres.WebhookAttempt{
+		DeliveryID: delivery.ID,
+		Attempt:    delivery.Attempts,
+		StatusCode: statusCode,
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+
+	now := time.Now()
+	switch {
+	case err == nil:
+		delivery.Status, delivery.LastError, delivery.DeliveredAt = postgres.WebhookDelivered, "", &now
+	case delivery.Attempts >= s.cfg.MaxAttempts:
+		attempt.Error = err.Error()
+		delivery.Status, delivery.LastError = postgres.WebhookFailed, attempt.Error
+	default:
+		attempt.Error = err.Error()
+		delivery.LastError = attempt.Error
+		delivery.NextAttemptAt = now.Add(s.backoff(delivery.Attempts))
+	}
+
+	// recorded even when ctx is cancelled, the request was made
+	disabled, recordErr := s.db.CompleteWebhookAttempt(context.Background(), delivery, attempt, s.cfg.DisableAfter)
+	if recordErr != nil {
+		log.Printf("failed to record attempt %d of webhook delivery %d: %v", attempt.Attempt, delivery.ID, recordErr)
+	}
+	if disabled {
+		log.Printf("webhook endpoint %d disabled after %d consecutive failures", endpoint.ID, s.cfg.DisableAfter)
+	}
+
+	return err == nil
+}
+
+// send posts the payload of a delivery to its endpoint, any response but a 2xx is a failure
+func (s SvcWebhook) send(ctx context.Context, delivery *postgres.WebhookDelivery, endpoint *common.WebhookEndpoint) (int, error) {
+	ctx, cancel := util.WithTimeout(ctx, s.cfg.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, strings.NewReader(string(delivery.Payload)))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", delivery.ContentType)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, "sha256="+util.SignPayload(endpoint.Secret, timestamp, delivery.Payload))
+	req.Header.Set(EventIDHeader, strconv.FormatInt(delivery.EventID, 10))
+	req.Header.Set(EventTypeHeader, delivery.EventType)
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(delivery.ID, 10))
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(res.Body, maxErrorBodyBytes))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("endpoint answered %d: %s", res.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return res.StatusCode, nil
+}
+
+// backoff returns the delay before the next try of a delivery that failed attempts times
+func (s SvcWebhook) backoff(attempts int) time.Duration {
+	policy := retry.Policy{InitialInterval: s.cfg.InitialBackoff, MaxInterval: s.cfg.MaxBackoff, Multiplier: 2, Jitter: 0.2}
+
+	return policy.Backoff(attempts)
+}
@@ -0,0 +1,236 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"payment-gateway/db"
+	"payment-gateway/internal/models/common"
+	"payment-gateway/internal/models/postgres"
+	"payment-gateway/internal/util"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testSecret = "0123456789abcdef"
+
+var testConfig = WebhookConfig{
+	BatchSize:      10,
+	Timeout:        time.Second,
+	MaxAttempts:    3,
+	InitialBackoff: time.Minute,
+	MaxBackoff:     time.Hour,
+	DisableAfter:   5,
+	// the test servers listen on the loopback address
+	AllowPrivateTargets: true,
+}
+
+// newDispatchMockDB serves deliveries to the endpoint at url and records the completed attempts
+func newDispatchMockDB(url string, deliveries []*postgres.WebhookDelivery) (*db.MockDB, *[]postgres.WebhookDelivery, *[]postgres.WebhookAttempt) {
+	var mu sync.Mutex
+	var completed []postgres.WebhookDelivery
+	var attempts []postgres.WebhookAttempt
+
+	return &db.MockDB{
+		ClaimWebhookDeliveriesFunc: func(ctx context.Context, limit int, lease time.Duration) ([]*postgres.WebhookDelivery, error) {
+			return deliveries, nil
+		},
+		GetWebhookEndpointsFunc: func(ctx context.Context) ([]*common.WebhookEndpoint, error) {
+			return []*common.WebhookEndpoint{{ID: 1, URL: url, Secret: testSecret, Enabled: true}}, nil
+		},
+		CompleteWebhookAttemptFunc: func(ctx context.Context, delivery *postgres.WebhookDelivery, attempt *postgres.WebhookAttempt, disableAfter int) (bool, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			completed = append(completed, *delivery)
+			attempts = append(attempts, *attempt)
+			return false, nil
+		},
+	}, &completed, &attempts
+}
+
+func newDelivery(id int64, attempts int) *postgres.WebhookDelivery {
+	return &postgres.WebhookDelivery{
+		ID:          id,
+		EndpointID:  1,
+		EventID:     100 + id,
+		EventType:   "transaction.completed",
+		Payload:     []byte(`{"event_type":"transaction.completed"}`),
+		ContentType: "application/json",
+		Status:      postgres.WebhookPending,
+		Attempts:    attempts,
+	}
+}
+
+func TestDispatchWebhooksSignsRequests(t *testing.T) {
+	var got *http.Request
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	mockDB, completed, attempts := newDispatchMockDB(server.URL, []*postgres.WebhookDelivery{newDelivery(1, 0)})
+	svc := NewSvcWebhook(mockDB, testConfig)
+
+	delivered, err := svc.DispatchWebhooks(context.Background())
+	if err != nil || delivered != 1 {
+		t.Fatalf("expected 1 delivery, got %d (%v)", delivered, err)
+	}
+
+	timestamp := got.Header.Get(TimestampHeader)
+	if want := "sha256=" + util.SignPayload(testSecret, timestamp, body); got.Header.Get(SignatureHeader) != want {
+		t.Errorf("expected signature %s, got %s", want, got.Header.Get(SignatureHeader))
+	}
+	if got.Header.Get(EventIDHeader) != "101" || got.Header.Get(EventTypeHeader) != "transaction.completed" {
+		t.Errorf("unexpected event headers %v", got.Header)
+	}
+
+	if (*completed)[0].Status != postgres.WebhookDelivered || (*completed)[0].DeliveredAt == nil {
+		t.Errorf("expected the delivery to be delivered, got %+v", (*completed)[0])
+	}
+	if a := (*attempts)[0]; a.Attempt != 1 || a.StatusCode != http.StatusNoContent || a.Error != "" {
+		t.Errorf("unexpected attempt %+v", a)
+	}
+}
+
+func TestDispatchWebhooksRetries(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "maintenance", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	// the first delivery has tries left, the second runs out of attempts
+	deliveries := []*postgres.WebhookDelivery{newDelivery(1, 0), newDelivery(2, testConfig.MaxAttempts-1)}
+	mockDB, completed, attempts := newDispatchMockDB(server.URL, deliveries)
+	svc := NewSvcWebhook(mockDB, testConfig)
+
+	before := time.Now()
+	delivered, err := svc.DispatchWebhooks(context.Background())
+	if err != nil || delivered != 0 {
+		t.Fatalf("expected no delivery, got %d (%v)", delivered, err)
+	}
+
+	if len(*completed) != 2 {
+		t.Fatalf("expected 2 recorded attempts, got %d", len(*completed))
+	}
+	for i, d := range *completed {
+		a := (*attempts)[i]
+		if a.StatusCode != http.StatusServiceUnavailable || !strings.Contains(a.Error, "503") || !strings.Contains(a.Error, "maintenance") {
+			t.Errorf("unexpected attempt %+v", a)
+		}
+
+		switch d.ID {
+		case 1:
+			if d.Status != postgres.WebhookPending || d.Attempts != 1 {
+				t.Errorf("expected delivery 1 to stay pending, got %+v", d)
+			}
+			// the first retry waits the initial backoff, give or take the jitter
+			if wait := d.NextAttemptAt.Sub(before); wait < 45*time.Second || wait > 75*time.Second {
+				t.Errorf("expected the retry in about a minute, got %s", wait)
+			}
+		case 2:
+			if d.Status != postgres.WebhookFailed || d.Attempts != testConfig.MaxAttempts || d.LastError == "" {
+				t.Errorf("expected delivery 2 to be given up, got %+v", d)
+			}
+		}
+	}
+}
+
+func TestDispatchWebhooksDoesNotFollowRedirects(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "https://example.com/elsewhere", http.StatusFound)
+	}))
+	defer server.Close()
+
+	mockDB, completed, _ := newDispatchMockDB(server.URL, []*postgres.WebhookDelivery{newDelivery(1, 0)})
+	svc := NewSvcWebhook(mockDB, testConfig)
+
+	if delivered, _ := svc.DispatchWebhooks(context.Background()); delivered != 0 {
+		t.Fatalf("expected a redirect not to be a delivery")
+	}
+	if (*completed)[0].Status != postgres.WebhookPending {
+		t.Errorf("expected the delivery to be retried, got %+v", (*completed)[0])
+	}
+}
+
+func TestDispatchWebhooksRefusesPrivateAddresses(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer server.Close()
+
+	cfg := testConfig
+	cfg.AllowPrivateTargets = false
+	mockDB, _, attempts := newDispatchMockDB(server.URL, []*postgres.WebhookDelivery{newDelivery(1, 0)})
+	svc := NewSvcWebhook(mockDB, cfg)
+
+	if delivered, _ := svc.DispatchWebhooks(context.Background()); delivered != 0 || requests != 0 {
+		t.Fatalf("expected no request to a loopback address, got %d deliveries and %d requests", delivered, requests)
+	}
+	if !strings.Contains((*attempts)[0].Error, "not a public address") {
+		t.Errorf("expected the attempt to be refused, got %+v", (*attempts)[0])
+	}
+}
+
+func TestRegisterWebhookEndpoint(t *testing.T) {
+	var stored *common.WebhookEndpoint
+	svc := NewSvcWebhook(&db.MockDB{
+		CreateWebhookEndpointFunc: func(ctx context.Context, endpoint *common.WebhookEndpoint) error {
+			stored = endpoint
+			return nil
+		},
+	}, WebhookConfig{Timeout: time.Second})
+
+	tests := []struct {
+		name     string
+		endpoint common.WebhookEndpoint
+		valid    bool
+	}{
+		{"all events", common.WebhookEndpoint{OwnerID: 42, URL: "https://merchant.example.com/hooks"}, true},
+		{"subscribed events", common.WebhookEndpoint{OwnerID: 42, URL: "http://merchant.example.com", EventTypes: []string{"transaction.completed", "transaction.failed"}}, true},
+		{"refund events", common.WebhookEndpoint{OwnerID: 42, URL: "https://merchant.example.com", EventTypes: []string{"refund.completed", "refund.failed"}}, true},
+		{"unknown event", common.WebhookEndpoint{OwnerID: 42, URL: "https://merchant.example.com", EventTypes: []string{"transaction.exploded"}}, false},
+		{"unknown refund event", common.WebhookEndpoint{OwnerID: 42, URL: "https://merchant.example.com", EventTypes: []string{"refund.refunded"}}, false},
+		{"dispute events", common.WebhookEndpoint{OwnerID: 42, URL: "https://merchant.example.com", EventTypes: []string{"dispute.opened", "dispute.lost", "dispute.evidence_due"}}, true},
+		{"unknown dispute event", common.WebhookEndpoint{OwnerID: 42, URL: "https://merchant.example.com", EventTypes: []string{"dispute.closed"}}, false},
+		{"event without prefix", common.WebhookEndpoint{OwnerID: 42, URL: "https://merchant.example.com", EventTypes: []string{"completed"}}, false},
+		{"relative url", common.WebhookEndpoint{OwnerID: 42, URL: "/hooks"}, false},
+		{"ftp url", common.WebhookEndpoint{OwnerID: 42, URL: "ftp://merchant.example.com"}, false},
+		{"short secret", common.WebhookEndpoint{OwnerID: 42, URL: "https://merchant.example.com", Secret: "short"}, false},
+		{"no owner", common.WebhookEndpoint{URL: "https://merchant.example.com"}, false},
+		{"public address", common.WebhookEndpoint{OwnerID: 42, URL: "https://203.0.113.10/hooks"}, true},
+		{"metadata service", common.WebhookEndpoint{OwnerID: 42, URL: "http://169.254.169.254/latest/meta-data"}, false},
+		{"private address", common.WebhookEndpoint{OwnerID: 42, URL: "http://10.0.0.5:8080/hooks"}, false},
+		{"loopback address", common.WebhookEndpoint{OwnerID: 42, URL: "http://[::1]/hooks"}, false},
+		{"localhost", common.WebhookEndpoint{OwnerID: 42, URL: "http://localhost:8080/hooks"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stored = nil
+			endpoint := tt.endpoint
+			err := svc.RegisterWebhookEndpoint(context.Background(), &endpoint)
+
+			if !tt.valid {
+				if !errors.Is(err, ErrInvalidEndpoint) || stored != nil {
+					t.Fatalf("expected the endpoint to be rejected, got %v", err)
+				}
+				return
+			}
+
+			if err != nil || stored == nil {
+				t.Fatalf("expected the endpoint to be stored, got %v", err)
+			}
+			if len(stored.Secret) != 64 || stored.EventTypes == nil {
+				t.Errorf("expected a generated secret and event types, got %+v", stored)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package util
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// GetEnvInt reads an integer environment variable, falling back to def when unset or invalid
+func GetEnvInt(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("invalid value %q for %s, using default %d", v, key, def)
+		return def
+	}
+
+	return n
+}
+
+// GetEnvDuration reads a duration environment variable (e.g. 10s), falling back to def when unset or invalid
+func GetEnvDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("invalid value %q for %s, using default %s", v, key, def)
+		return def
+	}
+
+	return d
+}
+
+// GetEnvFloat reads a float environment variable, falling back to def when unset or invalid
+func GetEnvFloat(key string, def float64) float64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		log.Printf("invalid value %q for %s, using default %g", v, key, def)
+		return def
+	}
+
+	return f
+}
+
+// GetEnvString reads a string environment variable, falling back to def when unset
+func GetEnvString(key string, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+
+	return def
+}
+
+// GetEnvBool reads a boolean environment variable (e.g. true, 1), falling back to def when unset or invalid
+func GetEnvBool(key string, def bool) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Printf("invalid value %q for %s, using default %t", v, key, def)
+		return def
+	}
+
+	return b
+}
@@ -5,11 +5,10 @@ import (
 	"encoding/xml"
 	"fmt"
 	"net/http"
-	"payment-gateway/internal/models/request"
 	"strings"
 )
 
-func DecodeRequest(r *http.Request, request *request.Transaction) error {
+func DecodeRequest(r *http.Request, request interface{}) error {
 	contentType := r.Header.Get("Content-Type")
 
 	switch contentType {
@@ -43,7 +42,33 @@ func SendEncodedResponse(w http.ResponseWriter, response interface{}, statusCode
 		if err := xml.NewEncoder(w).Encode(response); err != nil {
 			http.Error(w, "failed to encode response in SOAP", http.StatusInternalServerError)
 		}
+	} else if strings.Contains(ct, "application/xml") || strings.Contains(ct, "text/xml") {
+		w.Header().Set("Content-Type", ct)
+		w.WriteHeader(statusCode)
+
+		if err := xml.NewEncoder(w).Encode(response); err != nil {
+			http.Error(w, "failed to encode response in XML", http.StatusInternalServerError)
+		}
 	} else {
 		http.Error(w, "Content-Type is not supported.", http.StatusUnsupportedMediaType)
 	}
 }
+
+// NegotiateResponse sets the content type of the response from the Accept header of the request: XML when an XML
+// type is listed before JSON, JSON otherwise. Quality values are not weighed, the first supported type wins. It
+// reports false when none of the accepted types is supported.
+func NegotiateResponse(w http.ResponseWriter, r *http.Request) bool {
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType := strings.TrimSpace(strings.SplitN(accepted, ";", 2)[0])
+		switch mediaType {
+		case "", "*/*", "application/*", "application/json":
+			w.Header().Set("Content-Type", "application/json")
+			return true
+		case "application/xml", "text/xml", "application/soap+xml":
+			w.Header().Set("Content-Type", mediaType)
+			return true
+		}
+	}
+
+	return false
+}
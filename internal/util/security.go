@@ -1,7 +1,10 @@
 package util
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 )
 
@@ -18,3 +21,13 @@ func UnmaskData(maskedData string) ([]byte, error) {
 	}
 	return decodedData, nil
 }
+
+// SignPayload returns the hex HMAC-SHA256 of "<timestamp>.<body>" keyed with secret, signing the timestamp along with
+// the body keeps a signed payload from being replayed later under a new timestamp
+func SignPayload(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
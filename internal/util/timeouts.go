@@ -0,0 +1,35 @@
+package util
+
+import (
+	"context"
+	"time"
+)
+
+// Timeouts are the deadlines of the stages of a request. Each stage gets the ctx of the request bounded by its own
+// timeout, so a client going away cancels the stage in progress and a slow stage can't hold the request forever.
+type Timeouts struct {
+	// DB bounds a single query or DB transaction
+	DB time.Duration
+	// Gateway bounds a single request to a payment gateway
+	Gateway time.Duration
+	// Publish bounds the publish of a message to Kafka, retries included
+	Publish time.Duration
+}
+
+// LoadTimeouts reads the stage timeouts from the environment
+func LoadTimeouts() Timeouts {
+	return Timeouts{
+		DB:      GetEnvDuration("DB_QUERY_TIMEOUT", 5*time.Second),
+		Gateway: GetEnvDuration("GATEWAY_SEND_TIMEOUT", 30*time.Second),
+		Publish: GetEnvDuration("KAFKA_PUBLISH_TIMEOUT", 5*time.Second),
+	}
+}
+
+// WithTimeout derives a ctx bounded by timeout from ctx, a timeout of zero only inherits the deadline of ctx
+func WithTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}